@@ -0,0 +1,353 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// affectedPlaceholders are the variables a verify.affected command narrows with.
+var affectedPlaceholders = []string{"{{changedFiles}}", "{{changedPackages}}", "{{changedTestFiles}}"}
+
+// goModuleFiles change the build of every package, so touching one affects them all.
+var goModuleFiles = map[string]bool{"go.mod": true, "go.sum": true, "go.work": true, "go.work.sum": true}
+
+// validateAffected checks that every verify.affected entry narrows a configured command.
+func validateAffected(v *VerifyConfig) error {
+	for _, cmd := range sortedKeys(v.Affected) {
+		known := false
+		for _, c := range v.Default {
+			known = known || c == cmd
+		}
+		for _, cmds := range v.ByTag {
+			for _, c := range cmds {
+				known = known || c == cmd
+			}
+		}
+		if !known {
+			return fmt.Errorf("verify.affected: %q is not a verify.default or verify.byTag command", cmd)
+		}
+		uses := false
+		for _, p := range affectedPlaceholders {
+			uses = uses || strings.Contains(v.Affected[cmd], p)
+		}
+		if !uses {
+			return fmt.Errorf("verify.affected[%q] must use %s", cmd, strings.Join(affectedPlaceholders, ", "))
+		}
+	}
+	return nil
+}
+
+// storyChanges are the files a story changed since the commit it started from, with the
+// placeholder values derived from them computed on first use.
+type storyChanges struct {
+	root  string
+	files []string // changed paths relative to root, including deleted ones
+
+	packages    string
+	packagesErr error
+	packagesSet bool
+}
+
+// readStoryChanges lists files that differ between base and the working tree, plus
+// untracked files, so it covers both committed and uncommitted work.
+func readStoryChanges(root, base string) (*storyChanges, error) {
+	git := NewGitOps(root)
+	diff, err := git.run("diff", "--name-only", base)
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := git.run("ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+	c := &storyChanges{root: root}
+	seen := make(map[string]bool)
+	for _, f := range strings.Split(diff+"\n"+untracked, "\n") {
+		// Ralph's own state commits are not part of the story's work
+		if f = strings.TrimSpace(f); f != "" && !seen[f] && !strings.HasPrefix(f, ".ralph/") {
+			seen[f] = true
+			c.files = append(c.files, f)
+		}
+	}
+	sort.Strings(c.files)
+	return c, nil
+}
+
+// existing returns the changed files that are still on disk.
+func (c *storyChanges) existing() []string {
+	var files []string
+	for _, f := range c.files {
+		if _, err := os.Stat(filepath.Join(c.root, f)); err == nil {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// testFiles returns changed test files, plus the test files that go with changed source
+// files by name: foo.py → test_foo.py or foo_test.py, foo.ts → foo.test.ts or foo.spec.ts,
+// looked up in the source's directory and in test directories.
+func (c *storyChanges) testFiles() ([]string, error) {
+	out, err := NewGitOps(c.root).run("ls-files")
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string][]string)
+	for _, f := range strings.Split(strings.TrimSpace(out), "\n") {
+		if isTestFile(f) {
+			byName[path.Base(f)] = append(byName[path.Base(f)], f)
+		}
+	}
+
+	seen := make(map[string]bool)
+	var tests []string
+	add := func(f string) {
+		if !seen[f] {
+			seen[f] = true
+			tests = append(tests, f)
+		}
+	}
+	for _, f := range c.existing() {
+		if isTestFile(f) {
+			add(f)
+			continue
+		}
+		ext := path.Ext(f)
+		if ext == "" {
+			continue
+		}
+		stem := strings.TrimSuffix(path.Base(f), ext)
+		for _, name := range []string{"test_" + stem + ext, stem + "_test" + ext, stem + ".test" + ext, stem + ".spec" + ext} {
+			for _, t := range byName[name] {
+				if path.Dir(t) == path.Dir(f) || isInTestDir(t) {
+					add(t)
+				}
+			}
+		}
+	}
+	sort.Strings(tests)
+	return tests, nil
+}
+
+// goPackages returns the Go packages in the project that changed files belong to, plus
+// every package that imports one of them, directly or through its tests. Any change to
+// go.mod or go.sum affects every package.
+func (c *storyChanges) goPackages() (string, error) {
+	if c.packagesSet {
+		return c.packages, c.packagesErr
+	}
+	c.packagesSet = true
+	c.packages, c.packagesErr = c.listGoPackages()
+	return c.packages, c.packagesErr
+}
+
+func (c *storyChanges) listGoPackages() (string, error) {
+	for _, f := range c.files {
+		if goModuleFiles[path.Base(f)] {
+			return "./...", nil
+		}
+	}
+
+	cmd := exec.Command("go", "list", "-e", "-f", `{{.ImportPath}}{{"\t"}}{{.Dir}}{{"\t"}}{{join .Deps " "}}{{"\t"}}{{join .TestImports " "}} {{join .XTestImports " "}}`, "./...")
+	cmd.Dir = c.root
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("go list failed: %w", err)
+	}
+
+	type goPackage struct {
+		importPath string
+		dir        string
+		deps       []string
+		testDeps   []string
+	}
+	var pkgs []goPackage
+	byPath := make(map[string]*goPackage)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 4 {
+			continue
+		}
+		dir, err := filepath.Rel(c.root, fields[1])
+		if err != nil {
+			continue
+		}
+		pkgs = append(pkgs, goPackage{importPath: fields[0], dir: filepath.ToSlash(dir), deps: strings.Fields(fields[2]), testDeps: strings.Fields(fields[3])})
+	}
+	for i := range pkgs {
+		byPath[pkgs[i].importPath] = &pkgs[i]
+	}
+
+	// A changed file belongs to the package in its nearest enclosing directory, so
+	// testdata and embedded files count for the package that reads them
+	changed := make(map[string]bool)
+	for _, f := range c.files {
+		best, bestLen := -1, -1
+		for i, p := range pkgs {
+			if p.dir == "." && bestLen < 0 {
+				best, bestLen = i, 0
+			} else if strings.HasPrefix(f, p.dir+"/") && len(p.dir) > bestLen {
+				best, bestLen = i, len(p.dir)
+			}
+		}
+		if best >= 0 {
+			changed[pkgs[best].importPath] = true
+		}
+	}
+
+	dependsOnChange := func(p *goPackage) bool {
+		if changed[p.importPath] {
+			return true
+		}
+		for _, d := range p.deps {
+			if changed[d] {
+				return true
+			}
+		}
+		return false
+	}
+	var affected []string
+	for i := range pkgs {
+		p := &pkgs[i]
+		hit := dependsOnChange(p)
+		for _, t := range p.testDeps {
+			if hit {
+				break
+			}
+			if dep, ok := byPath[t]; ok {
+				hit = dependsOnChange(dep)
+			}
+		}
+		if hit {
+			affected = append(affected, p.importPath)
+		}
+	}
+	sort.Strings(affected)
+	return strings.Join(affected, " "), nil
+}
+
+// expand fills the placeholders of a verify.affected command. ok is false when a
+// placeholder has nothing to narrow to, so the command has nothing to check.
+func (c *storyChanges) expand(template string) (cmd string, ok bool, err error) {
+	values := make(map[string]string)
+	if strings.Contains(template, "{{changedFiles}}") {
+		values["{{changedFiles}}"] = shellJoin(c.existing())
+	}
+	if strings.Contains(template, "{{changedTestFiles}}") {
+		tests, err := c.testFiles()
+		if err != nil {
+			return "", false, err
+		}
+		values["{{changedTestFiles}}"] = shellJoin(tests)
+	}
+	if strings.Contains(template, "{{changedPackages}}") {
+		pkgs, err := c.goPackages()
+		if err != nil {
+			return "", false, err
+		}
+		values["{{changedPackages}}"] = pkgs
+	}
+	cmd = template
+	for p, v := range values {
+		if v == "" {
+			return "", false, nil
+		}
+		cmd = strings.ReplaceAll(cmd, p, v)
+	}
+	return cmd, true, nil
+}
+
+// narrowCommands applies verify.affected to cmds for what changed since base. The result
+// is parallel to cmds: a narrowed command, the command itself when it has no affected
+// form or can't be narrowed, or "" when nothing it checks was affected. With no base
+// every command runs in full. Warnings explain commands that fell back to a full run.
+func narrowCommands(cfg *ResolvedConfig, cmds []string, base string) (narrowed []string, warnings []string) {
+	narrowed = append([]string{}, cmds...)
+	affected := cfg.Config.Verify.Affected
+	if base == "" || len(affected) == 0 {
+		return narrowed, nil
+	}
+
+	var changes *storyChanges
+	var changesErr error
+	for i, cmd := range cmds {
+		template, ok := affected[cmd]
+		if !ok {
+			continue
+		}
+		if changes == nil && changesErr == nil {
+			changes, changesErr = readStoryChanges(cfg.ProjectRoot, base)
+		}
+		if changesErr != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: running in full, could not read changes since %s: %v", cmd, shortHash(base), changesErr))
+			continue
+		}
+		expanded, ok, err := changes.expand(template)
+		switch {
+		case err != nil:
+			warnings = append(warnings, fmt.Sprintf("%s: running in full, could not narrow it: %v", cmd, err))
+		case !ok:
+			narrowed[i] = ""
+		default:
+			narrowed[i] = expanded
+		}
+	}
+	return narrowed, warnings
+}
+
+// narrowedBaseline files baseline failures under the narrowed commands that replace the
+// full ones, so a narrowed run is compared against its full command's baseline.
+func narrowedBaseline(b *VerifyBaseline, full map[string]string) *VerifyBaseline {
+	if b == nil || len(full) == 0 {
+		return b
+	}
+	nb := *b
+	nb.Failures = append([]BaselineFailure{}, b.Failures...)
+	for narrowed, cmd := range full {
+		if f := b.FailureFor(cmd); f != nil {
+			renamed := *f
+			renamed.Command = narrowed
+			nb.Failures = append(nb.Failures, renamed)
+		}
+	}
+	return &nb
+}
+
+// isTestFile reports whether a path looks like a test file: Go (_test.go), JS/TS
+// (.test./.spec.), Jest (__tests__/), and pytest (test_*.py).
+func isTestFile(f string) bool {
+	lower := strings.ToLower(f)
+	return strings.Contains(lower, "_test.") ||
+		strings.Contains(lower, ".test.") ||
+		strings.Contains(lower, ".spec.") ||
+		strings.Contains(lower, "__tests__/") ||
+		strings.HasPrefix(path.Base(lower), "test_")
+}
+
+// isInTestDir reports whether a path is under a tests directory.
+func isInTestDir(f string) bool {
+	for _, dir := range strings.Split(path.Dir(f), "/") {
+		if dir == "test" || dir == "tests" || dir == "__tests__" {
+			return true
+		}
+	}
+	return false
+}
+
+// shellJoin quotes paths for a shell command line, leaving plain paths as they are.
+func shellJoin(paths []string) string {
+	quoted := make([]string, len(paths))
+	for i, p := range paths {
+		if strings.Trim(p, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-./@+=:,") == "" {
+			quoted[i] = p
+		} else {
+			quoted[i] = "'" + strings.ReplaceAll(p, "'", `'\''`) + "'"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// commitFiles writes files into a test repo and commits them.
+func commitFiles(t *testing.T, dir string, git *GitOps, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := git.run("add", "-A"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := git.run("commit", "-m", "change"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateAffected(t *testing.T) {
+	v := &VerifyConfig{
+		Default:  []string{"go test ./..."},
+		ByTag:    map[string][]string{"web": {"npx jest"}},
+		Affected: map[string]string{"go test ./...": "go test {{changedPackages}}", "npx jest": "npx jest --findRelatedTests {{changedFiles}}"},
+	}
+	if err := validateAffected(v); err != nil {
+		t.Errorf("expected valid, got %v", err)
+	}
+
+	v.Affected = map[string]string{"pytest": "pytest {{changedTestFiles}}"}
+	if err := validateAffected(v); err == nil || !strings.Contains(err.Error(), `"pytest" is not a verify.default or verify.byTag command`) {
+		t.Errorf("expected an unknown command error, got %v", err)
+	}
+
+	v.Affected = map[string]string{"go test ./...": "go test ./internal/..."}
+	if err := validateAffected(v); err == nil || !strings.Contains(err.Error(), "must use {{changedFiles}}") {
+		t.Errorf("expected a missing placeholder error, got %v", err)
+	}
+}
+
+func TestIsTestFile(t *testing.T) {
+	for _, f := range []string{"auth/login_test.go", "src/Login.test.tsx", "src/api.spec.ts", "src/__tests__/api.js", "tests/test_users.py"} {
+		if !isTestFile(f) {
+			t.Errorf("%s should be a test file", f)
+		}
+	}
+	for _, f := range []string{"auth/login.go", "src/testing.ts", "tests/conftest.py"} {
+		if isTestFile(f) {
+			t.Errorf("%s should not be a test file", f)
+		}
+	}
+}
+
+func TestShellJoin(t *testing.T) {
+	got := shellJoin([]string{"src/app.ts", "docs/my notes.md", "it's.txt"})
+	want := `src/app.ts 'docs/my notes.md' 'it'\''s.txt'`
+	if got != want {
+		t.Errorf("shellJoin() = %s, want %s", got, want)
+	}
+}
+
+func TestStoryChanges_Files(t *testing.T) {
+	dir, git := initTestRepo(t)
+	commitFiles(t, dir, git, map[string]string{
+		"src/users.py":            "x = 1\n",
+		"src/orders.py":           "y = 1\n",
+		"src/test_orders.py":      "",
+		"tests/test_users.py":     "",
+		"other/tests/test_old.py": "",
+		"web/cart.ts":             "",
+		"web/cart.test.ts":        "",
+	})
+	base := git.GetLastCommit()
+
+	commitFiles(t, dir, git, map[string]string{
+		"src/users.py":                  "x = 2\n",
+		"web/cart.ts":                   "export {}\n",
+		".ralph/feat/run-state.json":    "{}",
+		"docs/notes about cart.md":      "",
+		"other/tests/test_unrelated.py": "",
+	})
+	if err := os.Remove(filepath.Join(dir, "src/orders.py")); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, "src/new.py"), []byte(""), 0644) // untracked
+
+	c, err := readStoryChanges(dir, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "docs/notes about cart.md|other/tests/test_unrelated.py|src/new.py|src/orders.py|src/users.py|web/cart.ts"
+	if got := strings.Join(c.files, "|"); got != want {
+		t.Errorf("files = %s, want %s", got, want)
+	}
+
+	cmd, ok, err := c.expand("npx vitest related --run {{changedFiles}}")
+	if err != nil || !ok {
+		t.Fatalf("expand() = %v, %v", ok, err)
+	}
+	if cmd != "npx vitest related --run 'docs/notes about cart.md' other/tests/test_unrelated.py src/new.py src/users.py web/cart.ts" {
+		t.Errorf("deleted files should be left out, got %s", cmd)
+	}
+
+	cmd, _, err = c.expand("pytest {{changedTestFiles}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd != "pytest other/tests/test_unrelated.py tests/test_users.py web/cart.test.ts" {
+		t.Errorf("expected changed and mapped test files, got %s", cmd)
+	}
+}
+
+func TestStoryChanges_NothingAffected(t *testing.T) {
+	dir, git := initTestRepo(t)
+	commitFiles(t, dir, git, map[string]string{"src/app.py": ""})
+	base := git.GetLastCommit()
+	commitFiles(t, dir, git, map[string]string{"README.md": "# docs only"})
+
+	c, err := readStoryChanges(dir, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := c.expand("pytest {{changedTestFiles}}"); ok || err != nil {
+		t.Errorf("expected nothing to test, got ok=%v err=%v", ok, err)
+	}
+}
+
+func TestStoryChanges_GoPackages(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not in PATH")
+	}
+	dir, git := initTestRepo(t)
+	commitFiles(t, dir, git, map[string]string{
+		"go.mod":                   "module example.com/app\n\ngo 1.21\n",
+		"store/store.go":           "package store\n\nfunc Get() int { return 1 }\n",
+		"store/testdata/rows.json": "[]",
+		"api/api.go":               "package api\n\nimport \"example.com/app/store\"\n\nfunc Handle() int { return store.Get() }\n",
+		"cli/cli.go":               "package cli\n\nimport \"example.com/app/api\"\n\nfunc Run() int { return api.Handle() }\n",
+		"report/report.go":         "package report\n",
+		"report/report_test.go":    "package report\n\nimport (\n\t\"testing\"\n\n\t\"example.com/app/store\"\n)\n\nfunc TestX(t *testing.T) { store.Get() }\n",
+		"util/util.go":             "package util\n",
+	})
+	base := git.GetLastCommit()
+
+	commitFiles(t, dir, git, map[string]string{"store/testdata/rows.json": "[1]"})
+	c, err := readStoryChanges(dir, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.goPackages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "example.com/app/api example.com/app/cli example.com/app/report example.com/app/store" {
+		t.Errorf("expected store and its importers, got %q", got)
+	}
+
+	commitFiles(t, dir, git, map[string]string{"go.mod": "module example.com/app\n\ngo 1.22\n"})
+	c, _ = readStoryChanges(dir, base)
+	if got, _ := c.goPackages(); got != "./..." {
+		t.Errorf("a go.mod change should affect every package, got %q", got)
+	}
+}
+
+func TestNarrowCommands(t *testing.T) {
+	dir, git := initTestRepo(t)
+	commitFiles(t, dir, git, map[string]string{"web/cart.ts": "", "web/cart.test.ts": ""})
+	base := git.GetLastCommit()
+	commitFiles(t, dir, git, map[string]string{"web/cart.ts": "export {}\n"})
+
+	cfg := &ResolvedConfig{ProjectRoot: dir, Config: RalphConfig{Verify: VerifyConfig{
+		Default: []string{"npm run lint", "npx jest", "pytest"},
+		Affected: map[string]string{
+			"npx jest": "npx jest --findRelatedTests {{changedFiles}}",
+			"pytest":   "pytest {{changedTestFiles}} -k py",
+		},
+	}}}
+
+	got, warnings := narrowCommands(cfg, cfg.Config.Verify.Default, base)
+	want := []string{"npm run lint", "npx jest --findRelatedTests web/cart.ts", "pytest web/cart.test.ts -k py"}
+	if strings.Join(got, "|") != strings.Join(want, "|") || len(warnings) != 0 {
+		t.Errorf("narrowCommands() = %q %v, want %q", got, warnings, want)
+	}
+
+	if got, _ := narrowCommands(cfg, cfg.Config.Verify.Default, ""); strings.Join(got, "|") != strings.Join(cfg.Config.Verify.Default, "|") {
+		t.Errorf("without a base every command should run in full, got %q", got)
+	}
+
+	got, warnings = narrowCommands(cfg, cfg.Config.Verify.Default, "0000000000000000000000000000000000000000")
+	if strings.Join(got, "|") != strings.Join(cfg.Config.Verify.Default, "|") || len(warnings) != 2 {
+		t.Errorf("unreadable changes should fall back to full runs with a warning each, got %q %v", got, warnings)
+	}
+}
+
+func TestRunStoryVerification_Affected(t *testing.T) {
+	dir, git := initTestRepo(t)
+	commitFiles(t, dir, git, map[string]string{"src/app.ts": ""})
+	base := git.GetLastCommit()
+	commitFiles(t, dir, git, map[string]string{"src/app.ts": "export {}\n"})
+
+	logger, err := NewRunLogger(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	cfg := &ResolvedConfig{ProjectRoot: dir, Config: RalphConfig{Verify: VerifyConfig{
+		Default: []string{"exit 1", "echo full", "echo tests"},
+		Affected: map[string]string{
+			"exit 1":     "echo {{changedFiles}}; exit 1",
+			"echo tests": "echo {{changedTestFiles}}",
+		},
+		Timeout: 30,
+	}}}
+	baseline := &VerifyBaseline{Failures: []BaselineFailure{{Command: "exit 1", Failure: FailureVerify}}}
+
+	result, err := runStoryVerification(cfg, nil, &StoryDefinition{ID: "US-001"}, nil, baseline, base, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.passed {
+		t.Errorf("the narrowed command should be compared against its full command's baseline: %s", result.reason)
+	}
+	if len(result.commands) != 3 {
+		t.Fatalf("expected a result per command, got %+v", result.commands)
+	}
+	if c := result.commands[0]; c.cmd != "echo src/app.ts; exit 1" || !c.preExisting {
+		t.Errorf("expected the narrowed command to run, got %+v", c)
+	}
+	if c := result.commands[1]; c.cmd != "echo full" || !c.passed {
+		t.Errorf("commands without an affected form should run in full, got %+v", c)
+	}
+	if c := result.commands[2]; c.cmd != "echo tests" || !c.skipped {
+		t.Errorf("a command with nothing affected should be skipped, got %+v", c)
+	}
+}
+
+func TestRunFullAffectedVerification(t *testing.T) {
+	logger, err := NewRunLogger(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	cfg := &ResolvedConfig{ProjectRoot: t.TempDir(), Config: RalphConfig{Verify: VerifyConfig{
+		Default:  []string{"exit 3", "echo lint; exit 1"},
+		Affected: map[string]string{"exit 3": "exit 3 {{changedFiles}}"},
+		Timeout:  30,
+	}}}
+	def := &PRDDefinition{UserStories: []StoryDefinition{{ID: "US-001"}}}
+
+	err = runFullAffectedVerification(cfg, nil, def, nil, logger)
+	if err == nil || err.Error() != "full verification failed: exit 3" {
+		t.Errorf("expected only the narrowed command to run in full and fail, got %v", err)
+	}
+
+	baseline := &VerifyBaseline{Failures: []BaselineFailure{{Command: "exit 3", Failure: FailureVerify}}}
+	if err := runFullAffectedVerification(cfg, nil, def, baseline, logger); err != nil {
+		t.Errorf("pre-existing failures should not fail the run, got %v", err)
+	}
+
+	cfg.Config.Verify.Affected = nil
+	if err := runFullAffectedVerification(cfg, nil, def, nil, logger); err != nil {
+		t.Errorf("nothing to run without verify.affected, got %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// cmdAnswer answers questions providers asked with a QUESTION marker. An answered story
+// is ready again, and its next attempt gets the answer in the prompt.
+func cmdAnswer(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: ralph answer <feature> [story] [\"answer\"]")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Without an answer, asks for one interactively (every waiting story")
+		fmt.Fprintln(os.Stderr, "when no story is given). An empty line leaves the story waiting.")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Examples:")
+		fmt.Fprintln(os.Stderr, "  ralph answer auth                                  # Answer each open question")
+		fmt.Fprintln(os.Stderr, "  ralph answer auth US-003 \"Use bcrypt, cost 12\"     # Answer one story's question")
+		os.Exit(1)
+	}
+
+	projectRoot := GetProjectRoot()
+	cfg, err := LoadConfig(projectRoot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	featureDir, err := FindFeatureDir(projectRoot, args[0], false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if !featureDir.HasPrdJson {
+		fmt.Fprintf(os.Stderr, "Error: %s has no finalized PRD\n", featureDir.Feature)
+		os.Exit(1)
+	}
+
+	// A running loop saves its own copy of run state and would drop the answer
+	if lock, _ := ReadLockStatus(projectRoot); lock != nil && isProcessAlive(lock.PID) && lock.Feature == featureDir.Feature {
+		fmt.Fprintf(os.Stderr, "Error: ralph is running %s (PID %d). Answer once the run stops; it stops by itself when every remaining story is waiting for input.\n", featureDir.Feature, lock.PID)
+		os.Exit(1)
+	}
+
+	def, err := LoadPRDDefinition(featureDir.PrdJsonPath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	statePath := featureDir.RunStatePath()
+	state, err := LoadRunState(statePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var answered []string
+	if len(args) >= 3 {
+		storyID := args[1]
+		if err := answerStory(def, state, storyID, strings.Join(args[2:], " ")); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		answered = append(answered, storyID)
+	} else {
+		ids := GetAwaitingInput(def, state)
+		if len(args) == 2 {
+			if GetStoryByID(def, args[1]) == nil {
+				fmt.Fprintf(os.Stderr, "Error: story %s not found\n", args[1])
+				os.Exit(1)
+			}
+			ids = nil
+			if state.IsAwaitingInput(args[1]) {
+				ids = []string{args[1]}
+			}
+		}
+		if len(ids) == 0 {
+			fmt.Println("No questions are waiting for an answer.")
+			return
+		}
+		answered = promptAnswers(bufio.NewReader(os.Stdin), def, state, ids)
+	}
+	if len(answered) == 0 {
+		fmt.Println("No answers recorded.")
+		return
+	}
+
+	if err := SaveRunState(statePath, state); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to save state: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.Config.Commits.PrdChanges {
+		if err := commitPrdOnly(projectRoot, statePath, fmt.Sprintf("ralph: answer %s", strings.Join(answered, ", "))); err != nil {
+			fmt.Printf("Warning: failed to commit state: %v\n", err)
+		}
+	}
+
+	for _, id := range answered {
+		if state.IsAwaitingInput(id) {
+			fmt.Printf("Answered %s (%d more question(s) open)\n", id, len(state.PendingQuestions(id)))
+		} else {
+			fmt.Printf("✓ Answered %s\n", id)
+		}
+	}
+	fmt.Printf("\nRun 'ralph run %s' to continue.\n", featureDir.Feature)
+}
+
+// answerStory answers the oldest open question of a story.
+func answerStory(def *PRDDefinition, state *RunState, storyID, answer string) error {
+	if GetStoryByID(def, storyID) == nil {
+		return fmt.Errorf("story %s not found", storyID)
+	}
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return fmt.Errorf("answer must not be empty")
+	}
+	return state.AnswerQuestion(storyID, answer)
+}
+
+// promptAnswers asks for an answer to each open question of the given stories, oldest
+// first, one line each. An empty line leaves the rest of that story's questions open.
+// Returns the stories that got at least one answer.
+func promptAnswers(reader *bufio.Reader, def *PRDDefinition, state *RunState, ids []string) []string {
+	var answered []string
+	for _, id := range ids {
+		story := GetStoryByID(def, id)
+		for _, q := range state.PendingQuestions(id) {
+			fmt.Printf("\n%s: %s\n", story.ID, story.Title)
+			fmt.Printf("  ? %s\n", q.Question)
+			fmt.Print("Answer (empty to skip): ")
+			input, err := reader.ReadString('\n')
+			input = strings.TrimSpace(input)
+			if input != "" {
+				state.AnswerQuestion(id, input)
+				if len(answered) == 0 || answered[len(answered)-1] != id {
+					answered = append(answered, id)
+				}
+			}
+			if err != nil {
+				fmt.Println()
+				return answered
+			}
+			if input == "" {
+				break
+			}
+		}
+	}
+	return answered
+}
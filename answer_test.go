@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestAnswerStory(t *testing.T) {
+	def := &PRDDefinition{UserStories: []StoryDefinition{{ID: "US-001"}, {ID: "US-002"}}}
+	state := NewRunState()
+	state.AskQuestion("US-001", "Soft or hard delete?")
+
+	if err := answerStory(def, state, "US-009", "soft"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected story not found, got %v", err)
+	}
+	if err := answerStory(def, state, "US-001", "  "); err == nil {
+		t.Error("expected an error for an empty answer")
+	}
+	if err := answerStory(def, state, "US-002", "soft"); err == nil {
+		t.Error("expected an error for a story without a question")
+	}
+	if err := answerStory(def, state, "US-001", " soft "); err != nil {
+		t.Fatal(err)
+	}
+	if q := state.GetQuestions("US-001")[0]; q.Answer != "soft" {
+		t.Errorf("answer = %q", q.Answer)
+	}
+}
+
+func TestPromptAnswers(t *testing.T) {
+	def := &PRDDefinition{UserStories: []StoryDefinition{{ID: "US-001", Title: "Delete"}, {ID: "US-002", Title: "Export"}}}
+	state := NewRunState()
+	state.AskQuestion("US-001", "Soft or hard delete?")
+	state.AskQuestion("US-001", "Keep audit rows?")
+	state.AskQuestion("US-002", "CSV or JSON?")
+
+	// Skipping US-001's first question leaves its second one open too
+	reader := bufio.NewReader(strings.NewReader("\nCSV\n"))
+	answered := promptAnswers(reader, def, state, GetAwaitingInput(def, state))
+
+	if len(answered) != 1 || answered[0] != "US-002" {
+		t.Errorf("answered = %v", answered)
+	}
+	if len(state.PendingQuestions("US-001")) != 2 || state.IsAwaitingInput("US-002") {
+		t.Errorf("unexpected state: %+v", state.Questions)
+	}
+
+	// Input ending without a newline still counts
+	answered = promptAnswers(bufio.NewReader(strings.NewReader("Soft")), def, state, []string{"US-001"})
+	if len(answered) != 1 || state.GetQuestions("US-001")[0].Answer != "Soft" {
+		t.Errorf("answered = %v, questions = %+v", answered, state.GetQuestions("US-001"))
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BudgetConfig bounds how much a `ralph run` may consume before stopping.
+// Zero values mean unlimited. Flags on `ralph run` override these per invocation.
+type BudgetConfig struct {
+	MaxIterations int     `json:"maxIterations,omitempty"` // provider sessions per run
+	MaxDuration   int     `json:"maxDuration,omitempty"`   // wall-clock seconds per run
+	MaxCost       float64 `json:"maxCost,omitempty"`       // total provider cost per feature
+	CostPattern   string  `json:"costPattern,omitempty"`   // regex whose first capture group is a cost amount
+}
+
+// validateBudget checks the budget section of the config against the provider chain.
+func validateBudget(b *BudgetConfig, providers []ProviderConfig) error {
+	if b == nil {
+		return nil
+	}
+	if b.MaxIterations < 0 || b.MaxDuration < 0 || b.MaxCost < 0 {
+		return fmt.Errorf("budget limits must not be negative")
+	}
+	if b.CostPattern != "" {
+		re, err := regexp.Compile(b.CostPattern)
+		if err != nil {
+			return fmt.Errorf("budget.costPattern is not a valid regex: %w", err)
+		}
+		if re.NumSubexp() < 1 {
+			return fmt.Errorf("budget.costPattern must have a capture group for the amount (got: %s)", b.CostPattern)
+		}
+	}
+	if b.MaxCost > 0 && b.CostPattern == "" && !providersReportCost(providers) {
+		return fmt.Errorf("budget.maxCost requires budget.costPattern to read costs from provider output (claude reports its cost without one)")
+	}
+	return nil
+}
+
+// providersReportCost reports whether every provider's adapter reads session cost from
+// the CLI's own output, so no costPattern is needed.
+func providersReportCost(providers []ProviderConfig) bool {
+	if len(providers) == 0 {
+		return false
+	}
+	for _, p := range providers {
+		if r, ok := adapterFor(p.Command).(costReporter); !ok || !r.reportsCost() {
+			return false
+		}
+	}
+	return true
+}
+
+// runBudget tracks the limits in effect for one `ralph run`.
+type runBudget struct {
+	maxIterations int
+	deadline      time.Time // zero = no deadline
+	maxCost       float64
+	costPattern   *regexp.Regexp
+
+	sessions int // provider sessions started so far, counted against maxIterations
+}
+
+// newRunBudget combines the config budget with command-line overrides. The wall-clock
+// deadline is measured from start.
+func newRunBudget(cfg *BudgetConfig, opts RunOptions, start time.Time) *runBudget {
+	b := &runBudget{}
+	if cfg != nil {
+		b.maxIterations = cfg.MaxIterations
+		if cfg.MaxDuration > 0 {
+			b.deadline = start.Add(time.Duration(cfg.MaxDuration) * time.Second)
+		}
+		b.maxCost = cfg.MaxCost
+		if cfg.CostPattern != "" {
+			b.costPattern = regexp.MustCompile(cfg.CostPattern) // validated in LoadConfig
+		}
+	}
+	if opts.MaxIterations > 0 {
+		b.maxIterations = opts.MaxIterations
+	}
+	if opts.Deadline > 0 {
+		b.deadline = start.Add(opts.Deadline)
+	}
+	if opts.MaxCost > 0 {
+		b.maxCost = opts.MaxCost
+	}
+	return b
+}
+
+// describe summarizes the active limits for the run header. Empty when unlimited.
+func (b *runBudget) describe() string {
+	var parts []string
+	if b.maxIterations > 0 {
+		parts = append(parts, fmt.Sprintf("%d iterations", b.maxIterations))
+	}
+	if !b.deadline.IsZero() {
+		parts = append(parts, "until "+b.deadline.Format("15:04:05"))
+	}
+	if b.maxCost > 0 {
+		parts = append(parts, fmt.Sprintf("$%.2f", b.maxCost))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// exhausted returns why the run must stop before starting another iteration,
+// or "" while every budget has room left.
+func (b *runBudget) exhausted(spent float64, now time.Time) string {
+	if b.maxIterations > 0 && b.sessions >= b.maxIterations {
+		return fmt.Sprintf("iteration budget exhausted (%d iterations)", b.maxIterations)
+	}
+	if !b.deadline.IsZero() && !now.Before(b.deadline) {
+		return fmt.Sprintf("deadline reached (%s)", b.deadline.Format(time.RFC3339))
+	}
+	if b.maxCost > 0 && spent >= b.maxCost {
+		return fmt.Sprintf("cost budget exhausted ($%.2f spent of $%.2f)", spent, b.maxCost)
+	}
+	return ""
+}
+
+// startSessions counts n provider sessions against maxIterations. Each story of a
+// parallel batch is its own session, so a batch can't spend n times the budget.
+func (b *runBudget) startSessions(n int) {
+	b.sessions += n
+}
+
+// sessionsLeft caps n at the number of provider sessions maxIterations still allows.
+func (b *runBudget) sessionsLeft(n int) int {
+	if b.maxIterations > 0 && b.maxIterations-b.sessions < n {
+		return max(b.maxIterations-b.sessions, 0)
+	}
+	return n
+}
+
+// attemptCost is what one provider session cost: the sum matched by costPattern when
+// configured, otherwise the cost the provider's adapter reported.
+func (b *runBudget) attemptCost(result *ProviderResult) float64 {
+	if b.costPattern == nil {
+		return result.ReportedCost
+	}
+	return b.parseCost(result.Output)
+}
+
+// parseCost sums every cost amount found in provider output. Thousands separators are
+// ignored; lines whose capture group isn't a number are skipped.
+func (b *runBudget) parseCost(output string) float64 {
+	if b.costPattern == nil {
+		return 0
+	}
+	total := 0.0
+	for _, m := range b.costPattern.FindAllStringSubmatch(output, -1) {
+		amount, err := strconv.ParseFloat(strings.ReplaceAll(m[1], ",", ""), 64)
+		if err == nil {
+			total += amount
+		}
+	}
+	return total
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateBudget(t *testing.T) {
+	tests := []struct {
+		name    string
+		budget  *BudgetConfig
+		wantErr string
+	}{
+		{"nil", nil, ""},
+		{"limits only", &BudgetConfig{MaxIterations: 20, MaxDuration: 3600}, ""},
+		{"cost with pattern", &BudgetConfig{MaxCost: 10, CostPattern: `Total cost: \$([0-9.]+)`}, ""},
+		{"cost without pattern", &BudgetConfig{MaxCost: 10}, "requires budget.costPattern"},
+		{"invalid regex", &BudgetConfig{CostPattern: `cost: ([0-9.]+`}, "not a valid regex"},
+		{"no capture group", &BudgetConfig{CostPattern: `cost: [0-9.]+`}, "capture group"},
+		{"negative", &BudgetConfig{MaxIterations: -1}, "must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateBudget(tt.budget, nil)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("expected error containing %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestNewRunBudget_FlagsOverrideConfig(t *testing.T) {
+	start := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+	cfg := &BudgetConfig{MaxIterations: 50, MaxDuration: 3600, MaxCost: 20, CostPattern: `\$([0-9.]+)`}
+
+	b := newRunBudget(cfg, RunOptions{}, start)
+	if b.maxIterations != 50 || !b.deadline.Equal(start.Add(time.Hour)) || b.maxCost != 20 {
+		t.Errorf("unexpected budget from config: %+v", b)
+	}
+
+	b = newRunBudget(cfg, RunOptions{MaxIterations: 5, Deadline: 30 * time.Minute, MaxCost: 2}, start)
+	if b.maxIterations != 5 || !b.deadline.Equal(start.Add(30*time.Minute)) || b.maxCost != 2 {
+		t.Errorf("expected flags to override config: %+v", b)
+	}
+
+	if d := newRunBudget(nil, RunOptions{}, start).describe(); d != "" {
+		t.Errorf("expected unlimited budget to describe as empty, got %q", d)
+	}
+}
+
+func TestRunBudget_Exhausted(t *testing.T) {
+	start := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+	b := newRunBudget(&BudgetConfig{MaxIterations: 3, MaxDuration: 3600, MaxCost: 5, CostPattern: `\$([0-9.]+)`}, RunOptions{}, start)
+
+	b.startSessions(2)
+	if reason := b.exhausted(4.99, start.Add(59*time.Minute)); reason != "" {
+		t.Errorf("expected budget to have room, got %q", reason)
+	}
+	if reason := b.exhausted(0, start.Add(time.Hour)); !strings.Contains(reason, "deadline") {
+		t.Errorf("expected deadline reached, got %q", reason)
+	}
+	if reason := b.exhausted(5, start); !strings.Contains(reason, "cost budget") {
+		t.Errorf("expected cost budget exhausted, got %q", reason)
+	}
+	b.startSessions(1)
+	if reason := b.exhausted(0, start); !strings.Contains(reason, "iteration budget") {
+		t.Errorf("expected iteration budget exhausted, got %q", reason)
+	}
+}
+
+func TestRunBudget_ParallelSessions(t *testing.T) {
+	b := newRunBudget(&BudgetConfig{MaxIterations: 5}, RunOptions{}, time.Now())
+
+	// A batch of four parallel stories is four provider sessions, not one iteration
+	if n := b.sessionsLeft(4); n != 4 {
+		t.Errorf("expected room for the whole batch, got %d", n)
+	}
+	b.startSessions(4)
+	if reason := b.exhausted(0, time.Now()); reason != "" {
+		t.Errorf("expected one session left, got %q", reason)
+	}
+	if n := b.sessionsLeft(4); n != 1 {
+		t.Errorf("expected the next batch capped at the one session left, got %d", n)
+	}
+	b.startSessions(1)
+	if reason := b.exhausted(0, time.Now()); !strings.Contains(reason, "iteration budget") {
+		t.Errorf("expected iteration budget exhausted after 5 sessions, got %q", reason)
+	}
+
+	if n := newRunBudget(nil, RunOptions{}, time.Now()).sessionsLeft(4); n != 4 {
+		t.Errorf("an unlimited budget should not cap batches, got %d", n)
+	}
+}
+
+func TestRunBudget_ParseCost(t *testing.T) {
+	b := newRunBudget(&BudgetConfig{CostPattern: `Cost: \$([0-9.,]+)`}, RunOptions{}, time.Now())
+	output := "working...\nCost: $0.25\nmore output\nCost: $1,000.50\nCost: $n/a\n"
+	if got := b.parseCost(output); got != 1000.75 {
+		t.Errorf("expected 1000.75, got %v", got)
+	}
+
+	if got := newRunBudget(nil, RunOptions{}, time.Now()).parseCost(output); got != 0 {
+		t.Errorf("expected 0 without a cost pattern, got %v", got)
+	}
+}
+
+func TestValidateBudget_ReportedCostNeedsNoPattern(t *testing.T) {
+	b := &BudgetConfig{MaxCost: 10}
+	if err := validateBudget(b, []ProviderConfig{{Command: "claude"}}); err != nil {
+		t.Errorf("claude reports cost, expected no error: %v", err)
+	}
+	if err := validateBudget(b, []ProviderConfig{{Command: "claude"}, {Command: "amp"}}); err == nil {
+		t.Error("expected error when an escalation provider can't report cost")
+	}
+}
+
+func TestRunBudget_AttemptCost(t *testing.T) {
+	result := &ProviderResult{Output: "Total cost: $0.50\n", ReportedCost: 1.25}
+
+	b := newRunBudget(nil, RunOptions{}, time.Now())
+	if got := b.attemptCost(result); got != 1.25 {
+		t.Errorf("without costPattern expected reported cost 1.25, got %v", got)
+	}
+
+	b = newRunBudget(&BudgetConfig{CostPattern: `Total cost: \$([0-9.]+)`}, RunOptions{}, time.Now())
+	if got := b.attemptCost(result); got != 0.50 {
+		t.Errorf("costPattern should take precedence, expected 0.50, got %v", got)
+	}
+}
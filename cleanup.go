@@ -11,17 +11,21 @@ import (
 // Resources register themselves when created, and the coordinator ensures they
 // are cleaned up properly when signals are received, even when os.Exit() is called.
 type CleanupCoordinator struct {
-	mu       sync.Mutex
-	svcMgr   *ServiceManager
-	provider *exec.Cmd
-	logger   *RunLogger
-	lock     *LockFile
-	done     bool
+	mu        sync.Mutex
+	svcMgr    *ServiceManager
+	providers map[*exec.Cmd]bool // running provider processes (several during parallel runs)
+	worktrees map[string]*GitOps // worktree path → repo that owns it
+	logger    *RunLogger
+	lock      *LockFile
+	done      bool
 }
 
 // NewCleanupCoordinator creates a new cleanup coordinator.
 func NewCleanupCoordinator() *CleanupCoordinator {
-	return &CleanupCoordinator{}
+	return &CleanupCoordinator{
+		providers: make(map[*exec.Cmd]bool),
+		worktrees: make(map[string]*GitOps),
+	}
 }
 
 // SetServiceManager registers the service manager for cleanup.
@@ -31,18 +35,34 @@ func (c *CleanupCoordinator) SetServiceManager(sm *ServiceManager) {
 	c.svcMgr = sm
 }
 
-// SetProvider registers the current provider process for cleanup.
-func (c *CleanupCoordinator) SetProvider(cmd *exec.Cmd) {
+// AddProvider registers a running provider process for cleanup.
+func (c *CleanupCoordinator) AddProvider(cmd *exec.Cmd) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.provider = cmd
+	if cmd != nil {
+		c.providers[cmd] = true
+	}
 }
 
-// ClearProvider unregisters the provider process after it completes.
-func (c *CleanupCoordinator) ClearProvider() {
+// RemoveProvider unregisters a provider process after it completes.
+func (c *CleanupCoordinator) RemoveProvider(cmd *exec.Cmd) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.provider = nil
+	delete(c.providers, cmd)
+}
+
+// AddWorktree registers a git worktree to be removed on cleanup.
+func (c *CleanupCoordinator) AddWorktree(git *GitOps, path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.worktrees[path] = git
+}
+
+// RemoveWorktree unregisters a worktree that was already removed.
+func (c *CleanupCoordinator) RemoveWorktree(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.worktrees, path)
 }
 
 // SetLogger registers the run logger for cleanup.
@@ -70,11 +90,26 @@ func (c *CleanupCoordinator) Cleanup() {
 	}
 	c.done = true
 
-	// Kill provider process group first (fast, prevents more output)
-	if c.provider != nil && c.provider.Process != nil {
-		syscall.Kill(-c.provider.Process.Pid, syscall.SIGTERM)
+	// Kill provider process groups first (fast, prevents more output)
+	var pids []int
+	for cmd := range c.providers {
+		if cmd.Process != nil {
+			pids = append(pids, cmd.Process.Pid)
+		}
+	}
+	if len(pids) > 0 {
+		for _, pid := range pids {
+			syscall.Kill(-pid, syscall.SIGTERM)
+		}
 		time.Sleep(500 * time.Millisecond)
-		syscall.Kill(-c.provider.Process.Pid, syscall.SIGKILL)
+		for _, pid := range pids {
+			syscall.Kill(-pid, syscall.SIGKILL)
+		}
+	}
+
+	// Remove worktrees created by a parallel run
+	for path, git := range c.worktrees {
+		git.RemoveWorktree(path)
 	}
 
 	// Stop services (may take up to 5 seconds due to SIGTERM+wait)
@@ -31,7 +31,7 @@ func TestCleanupCoordinatorSettersWithNil(t *testing.T) {
 
 	// Setting nil values should not panic
 	c.SetServiceManager(nil)
-	c.SetProvider(nil)
+	c.AddProvider(nil)
 	c.SetLogger(nil)
 	c.SetLock(nil)
 
@@ -42,9 +42,9 @@ func TestCleanupCoordinatorSettersWithNil(t *testing.T) {
 func TestCleanupCoordinatorClearProvider(t *testing.T) {
 	c := NewCleanupCoordinator()
 
-	// Set and clear provider should not panic
-	c.SetProvider(nil)
-	c.ClearProvider()
+	// Add and remove provider should not panic
+	c.AddProvider(nil)
+	c.RemoveProvider(nil)
 
 	// Cleanup after clearing should work
 	c.Cleanup()
@@ -14,13 +14,76 @@ import (
 )
 
 func checkProviderAvailable(cfg *ResolvedConfig) {
-	if !isCommandAvailable(cfg.Config.Provider.Command) {
-		fmt.Fprintf(os.Stderr, "Error: provider command '%s' not found in PATH\n", cfg.Config.Provider.Command)
-		fmt.Fprintln(os.Stderr, "Install it or update provider.command in ralph.config.json.")
+	for _, p := range cfg.Config.Provider.Chain() {
+		if !isCommandAvailable(p.Command) {
+			fmt.Fprintf(os.Stderr, "Error: provider command '%s' not found in PATH\n", p.Command)
+			fmt.Fprintln(os.Stderr, "Install it or update provider.command in ralph.config.json.")
+			os.Exit(1)
+		}
+	}
+}
+
+// checkPromptOverrides loads the templates in .ralph/prompts for this command, stopping on
+// an invalid one before any provider sees it and printing warnings for the rest.
+func checkPromptOverrides(cfg *ResolvedConfig) {
+	errs, warnings := loadPromptOverrides(cfg.ProjectRoot)
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+	}
+	if len(errs) > 0 {
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", e)
+		}
+		fmt.Fprintln(os.Stderr, "Fix the template or remove it to use the built-in one ('ralph prompts diff <name>').")
 		os.Exit(1)
 	}
 }
 
+// ralphGitignorePatterns keep ralph's runtime files in .ralph/ out of commits.
+var ralphGitignorePatterns = []string{"ralph.lock", "*.tmp", "*/logs/", "*/attempts/", "*/verify-cache.json", "flaky.json"}
+
+// missingGitignorePatterns returns the patterns a .ralph/.gitignore lacks, such as those
+// added after the project was initialized.
+func missingGitignorePatterns(content string) []string {
+	present := make(map[string]bool)
+	for _, line := range strings.Split(content, "\n") {
+		present[strings.TrimSpace(line)] = true
+	}
+	var missing []string
+	for _, p := range ralphGitignorePatterns {
+		if !present[p] {
+			missing = append(missing, p)
+		}
+	}
+	return missing
+}
+
+// updateRalphGitignore appends the patterns an existing .ralph/.gitignore lacks, so a
+// project initialized by an older version doesn't commit newer runtime files. A project
+// without the file has chosen its own ignores and is left alone.
+func updateRalphGitignore(projectRoot string) {
+	path := filepath.Join(projectRoot, ".ralph", ".gitignore")
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err == nil {
+		content := string(data)
+		missing := missingGitignorePatterns(content)
+		if len(missing) == 0 {
+			return
+		}
+		if content != "" && !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		if err = os.WriteFile(path, []byte(content+strings.Join(missing, "\n")+"\n"), 0644); err == nil {
+			fmt.Printf("Added to .ralph/.gitignore: %s\n", strings.Join(missing, ", "))
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Warning: failed to update .ralph/.gitignore: %v\n", err)
+}
+
 func checkGitAvailable() {
 	if !isCommandAvailable("git") {
 		fmt.Fprintln(os.Stderr, "Error: git not found in PATH")
@@ -188,11 +251,7 @@ func cmdInit(args []string) {
 
 	// Create .ralph/.gitignore
 	gitignorePath := filepath.Join(ralphDir, ".gitignore")
-	gitignoreContent := `# Ralph temporary files
-ralph.lock
-*.tmp
-*/logs/
-`
+	gitignoreContent := "# Ralph temporary files\n" + strings.Join(ralphGitignorePatterns, "\n") + "\n"
 	if err := os.WriteFile(gitignorePath, []byte(gitignoreContent), 0644); err != nil {
 		fmt.Fprintf(os.Stderr, "Warning: failed to write .gitignore: %v\n", err)
 	}
@@ -220,14 +279,53 @@ ralph.lock
 }
 
 func cmdRun(args []string) {
-	if len(args) == 0 {
-		fmt.Fprintln(os.Stderr, "Usage: ralph run <feature>")
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	parallel := fs.Int("parallel", 1, "Implement up to N independent stories at once, each in its own git worktree")
+	maxIterations := fs.Int("max-iterations", 0, "Stop after N provider sessions (overrides budget.maxIterations)")
+	deadline := fs.Duration("deadline", 0, "Stop starting new iterations after this long, e.g. 2h30m (overrides budget.maxDuration)")
+	maxCost := fs.Float64("max-cost", 0, "Stop once the feature's provider cost reaches this amount (overrides budget.maxCost)")
+	story := fs.String("story", "", "Work on only this story (ignores priority and skips), exit after one attempt")
+	dryRun := fs.Bool("dry-run", false, "Print the prompt for the next story (or --story) without running the provider")
+	output := fs.String("output", "", "With --dry-run, write the prompt to this file instead of printing it")
+
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: ralph run <feature> [options]")
 		fmt.Fprintln(os.Stderr, "")
-		fmt.Fprintln(os.Stderr, "Example: ralph run auth")
+		fmt.Fprintln(os.Stderr, "Options:")
+		fs.PrintDefaults()
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Examples:")
+		fmt.Fprintln(os.Stderr, "  ralph run auth                     # One story at a time")
+		fmt.Fprintln(os.Stderr, "  ralph run auth --parallel 3        # Up to 3 stories side by side")
+		fmt.Fprintln(os.Stderr, "  ralph run auth --deadline 8h       # Unattended overnight run")
+		fmt.Fprintln(os.Stderr, "  ralph run auth --story US-004      # Retry one story, then exit")
+		fmt.Fprintln(os.Stderr, "  ralph run auth --dry-run           # Show the next prompt without running it")
+	}
+
+	feature, flagArgs := splitFeatureArgs(args)
+	if feature == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	fs.Parse(flagArgs)
+
+	if *parallel < 1 {
+		fmt.Fprintln(os.Stderr, "Error: --parallel must be at least 1")
+		os.Exit(1)
+	}
+	if *maxIterations < 0 || *deadline < 0 || *maxCost < 0 {
+		fmt.Fprintln(os.Stderr, "Error: --max-iterations, --deadline, and --max-cost must not be negative")
+		os.Exit(1)
+	}
+	if *story != "" && *parallel > 1 {
+		fmt.Fprintln(os.Stderr, "Error: --story runs a single story and can't be combined with --parallel")
+		os.Exit(1)
+	}
+	if *output != "" && !*dryRun {
+		fmt.Fprintln(os.Stderr, "Error: --output is only used with --dry-run")
 		os.Exit(1)
 	}
 
-	feature := args[0]
 	projectRoot := GetProjectRoot()
 
 	cfg, err := LoadConfig(projectRoot)
@@ -237,6 +335,7 @@ func cmdRun(args []string) {
 	}
 
 	checkProviderAvailable(cfg)
+	checkPromptOverrides(cfg)
 	checkGitAvailable()
 
 	featureDir, err := FindFeatureDir(projectRoot, feature, false)
@@ -256,6 +355,10 @@ func cmdRun(args []string) {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
+	if *story != "" && GetStoryByID(def, *story) == nil {
+		fmt.Fprintf(os.Stderr, "Error: story %s not found in %s\n", *story, featureDir.PrdJsonPath())
+		os.Exit(1)
+	}
 
 	// Enforce codebase readiness
 	if issues := CheckReadiness(&cfg.Config, def); len(issues) > 0 {
@@ -278,7 +381,28 @@ func cmdRun(args []string) {
 		fmt.Fprintln(os.Stderr, "")
 	}
 
-	if err := runLoop(cfg, featureDir); err != nil {
+	if *maxCost > 0 && (cfg.Config.Budget == nil || cfg.Config.Budget.CostPattern == "") && !providersReportCost(cfg.Config.Provider.Chain()) {
+		fmt.Fprintln(os.Stderr, "Error: --max-cost requires budget.costPattern in ralph.config.json (or a provider that reports its cost, like claude)")
+		os.Exit(1)
+	}
+
+	opts := RunOptions{
+		Parallel:      *parallel,
+		MaxIterations: *maxIterations,
+		Deadline:      *deadline,
+		MaxCost:       *maxCost,
+		Story:         *story,
+		PromptOut:     *output,
+	}
+	if *dryRun {
+		if err := runDryRun(cfg, featureDir, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	updateRalphGitignore(projectRoot)
+	if err := runLoop(cfg, featureDir, opts); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
@@ -302,6 +426,7 @@ func cmdVerify(args []string) {
 	}
 
 	checkProviderAvailable(cfg)
+	checkPromptOverrides(cfg)
 	checkGitAvailable()
 
 	featureDir, err := FindFeatureDir(projectRoot, feature, false)
@@ -314,6 +439,7 @@ func cmdVerify(args []string) {
 		fmt.Fprintf(os.Stderr, "No prd.json found for feature '%s'\n", feature)
 		os.Exit(1)
 	}
+	updateRalphGitignore(projectRoot)
 
 	def, err := LoadPRDDefinition(featureDir.PrdJsonPath())
 	if err != nil {
@@ -392,6 +518,7 @@ func cmdPrd(args []string) {
 	}
 
 	checkProviderAvailable(cfg)
+	checkPromptOverrides(cfg)
 	checkGitAvailable()
 
 	// Warn about placeholder verify commands (soft — don't block PRD creation)
@@ -469,15 +596,25 @@ func cmdStatus(args []string) {
 					passed := CountPassed(st)
 					total := len(def.UserStories)
 					skipped := CountSkipped(st)
+					blocked := CountBlocked(st)
+					awaiting := len(GetAwaitingInput(def, st))
 					if passed == total {
 						status = "✓"
-					} else if skipped > 0 {
+					} else if awaiting > 0 {
+						status = "?"
+					} else if skipped > 0 || blocked > 0 {
 						status = "!"
 					}
 					fmt.Printf("  %s %s (%d/%d complete", status, f.Feature, passed, total)
 					if skipped > 0 {
 						fmt.Printf(", %d skipped", skipped)
 					}
+					if blocked > 0 {
+						fmt.Printf(", %d blocked", blocked)
+					}
+					if awaiting > 0 {
+						fmt.Printf(", %d awaiting input", awaiting)
+					}
 					fmt.Println(")")
 					continue
 				}
@@ -534,10 +671,18 @@ func cmdStatus(args []string) {
 
 	passed := CountPassed(state)
 	skipped := CountSkipped(state)
+	blocked := CountBlocked(state)
 	fmt.Printf("Progress: %d/%d stories complete", passed, len(def.UserStories))
 	if skipped > 0 {
 		fmt.Printf(" (%d skipped)", skipped)
 	}
+	if blocked > 0 {
+		fmt.Printf(" (%d blocked)", blocked)
+	}
+	awaiting := GetAwaitingInput(def, state)
+	if len(awaiting) > 0 {
+		fmt.Printf(" (%d awaiting input)", len(awaiting))
+	}
 	fmt.Println()
 	fmt.Println()
 
@@ -548,6 +693,10 @@ func cmdStatus(args []string) {
 			status = "✓"
 		} else if state.IsSkipped(story.ID) {
 			status = "✗"
+		} else if state.IsBlocked(story.ID) {
+			status = "⊘"
+		} else if state.IsAwaitingInput(story.ID) {
+			status = "?"
 		}
 		retries := ""
 		if r := state.GetRetries(story.ID); r > 0 {
@@ -561,9 +710,54 @@ func cmdStatus(args []string) {
 			}
 		}
 		fmt.Printf("  %s %s: %s%s%s\n", status, story.ID, story.Title, tags, retries)
-		if note := state.GetLastFailure(story.ID); note != "" {
+		if len(story.DependsOn) > 0 {
+			fmt.Printf("    └─ Depends on: %s\n", strings.Join(story.DependsOn, ", "))
+		}
+		if reason := state.GetBlockedReason(story.ID); reason != "" {
+			fmt.Printf("    └─ Blocked: %s\n", reason)
+		}
+		attempts := state.GetAttempts(story.ID)
+		if state.GetRetries(story.ID) > 0 || len(attempts) > 1 {
+			for _, a := range attempts {
+				fmt.Printf("    └─ %s\n", formatAttempt(a))
+			}
+		}
+		// A question shows below instead, with its answer once there is one
+		asked := len(attempts) > 0 && attempts[len(attempts)-1].Failure == FailureQuestion
+		if note := state.GetLastFailure(story.ID); note != "" && !asked {
 			fmt.Printf("    └─ Note: %s\n", note)
 		}
+		if n := len(attempts); n > 0 && !attempts[n-1].Passed && len(attempts[n-1].FailedTests) > 0 {
+			fmt.Println("    └─ Failing tests:")
+			for _, line := range strings.Split(strings.TrimRight(formatTestFailures(attempts[n-1].FailedTests, 10), "\n"), "\n") {
+				fmt.Printf("       %s\n", line)
+			}
+		}
+		for _, q := range state.GetQuestions(story.ID) {
+			if q.Answer == "" {
+				fmt.Printf("    └─ Awaiting input: %s\n", q.Question)
+			} else if !state.IsPassed(story.ID) {
+				fmt.Printf("    └─ Q: %s\n       A: %s\n", q.Question, q.Answer)
+			}
+		}
+	}
+
+	if len(awaiting) > 0 {
+		fmt.Println()
+		fmt.Printf("Answer with 'ralph answer %s', then 'ralph run %s' to continue.\n", feature, feature)
+	}
+
+	if state.Cost > 0 {
+		fmt.Println()
+		fmt.Printf("Provider cost: $%.2f\n", state.Cost)
+	}
+
+	if state.Baseline != nil && len(state.Baseline.Failures) > 0 {
+		fmt.Println()
+		fmt.Println("Pre-existing failures (baseline):")
+		for _, f := range state.Baseline.Failures {
+			fmt.Printf("  ! %s (%s)\n", f.Command, f.Failure)
+		}
 	}
 
 	if len(state.Learnings) > 0 {
@@ -591,6 +785,7 @@ func cmdRefine(args []string) {
 	}
 
 	checkProviderAvailable(cfg)
+	checkPromptOverrides(cfg)
 	checkGitAvailable()
 
 	// Find or create feature directory
@@ -650,7 +845,7 @@ func cmdRefine(args []string) {
 	fmt.Printf("Branch: %s\n", branchName)
 	fmt.Println()
 
-	if err := runProviderInteractive(cfg, prompt); err != nil {
+	if err := runProviderInteractive(cfg, featureDir, prompt); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
@@ -685,12 +880,14 @@ func cmdDoctor(args []string) {
 	} else {
 		fmt.Printf("✓ ralph.config.json found\n")
 
-		// Check provider command
-		if isCommandAvailable(cfg.Config.Provider.Command) {
-			fmt.Printf("✓ Provider command: %s\n", cfg.Config.Provider.Command)
-		} else {
-			fmt.Printf("✗ Provider command not found: %s\n", cfg.Config.Provider.Command)
-			issues++
+		// Check provider commands (every entry of an escalation chain)
+		for _, p := range cfg.Config.Provider.Chain() {
+			if isCommandAvailable(p.Command) {
+				fmt.Printf("✓ Provider command: %s\n", p.Command)
+			} else {
+				fmt.Printf("✗ Provider command not found: %s\n", p.Command)
+				issues++
+			}
 		}
 	}
 
@@ -698,6 +895,11 @@ func cmdDoctor(args []string) {
 	ralphDir := filepath.Join(projectRoot, ".ralph")
 	if fileExists(ralphDir) {
 		fmt.Printf("✓ .ralph directory exists\n")
+		if data, err := os.ReadFile(filepath.Join(ralphDir, ".gitignore")); err == nil {
+			if missing := missingGitignorePatterns(string(data)); len(missing) > 0 {
+				fmt.Printf("! .ralph/.gitignore is missing %s (added on the next 'ralph run' or 'ralph verify')\n", strings.Join(missing, ", "))
+			}
+		}
 	} else {
 		fmt.Printf("○ .ralph directory: not found (run 'ralph init')\n")
 	}
@@ -755,7 +957,35 @@ func cmdDoctor(args []string) {
 		} else {
 			fmt.Printf("○ verify.ui: no commands (required for UI stories)\n")
 		}
+		if len(cfg.Config.Verify.ByTag) > 0 {
+			fmt.Printf("✓ verify.byTag: %s\n", strings.Join(sortedKeys(cfg.Config.Verify.ByTag), ", "))
+		}
+		if len(cfg.Config.Verify.Affected) > 0 {
+			fmt.Printf("✓ verify.affected: %d commands narrowed per story\n", len(cfg.Config.Verify.Affected))
+		}
+		if n := cfg.Config.Verify.FlakyRetries; n > 0 {
+			known := 0
+			if log, err := LoadFlakyLog(flakyLogPath(projectRoot)); err == nil {
+				known = len(log.Entries)
+			}
+			fmt.Printf("✓ verify.flakyRetries: failed test commands re-run up to %d times (%d known flaky, see 'ralph flaky')\n", n, known)
+		}
+
+	}
 
+	// Check prompt overrides
+	if overrides, _ := promptOverrides(projectRoot); len(overrides) > 0 {
+		errs, warnings := ValidatePromptOverrides(projectRoot)
+		for _, w := range warnings {
+			fmt.Printf("! %s\n", w)
+		}
+		for _, e := range errs {
+			fmt.Printf("✗ %s\n", e)
+		}
+		issues += len(errs)
+		if len(errs) == 0 {
+			fmt.Printf("✓ prompt overrides: %s\n", strings.Join(overrides, ", "))
+		}
 	}
 
 	// List features
@@ -796,6 +1026,19 @@ func cmdDoctor(args []string) {
 	}
 }
 
+// splitFeatureArgs separates the leading feature argument from the flags that follow it.
+func splitFeatureArgs(args []string) (feature string, flagArgs []string) {
+	for i, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			return feature, args[i:]
+		}
+		if feature == "" {
+			feature = arg
+		}
+	}
+	return feature, nil
+}
+
 func cmdLogs(args []string) {
 	fs := flag.NewFlagSet("logs", flag.ExitOnError)
 	runNum := fs.Int("run", 0, "Show specific run number (default: latest)")
@@ -824,23 +1067,7 @@ func cmdLogs(args []string) {
 		fmt.Fprintln(os.Stderr, "  ralph logs auth --summary          # Quick summary of latest run")
 	}
 
-	// Find feature argument before flags
-	var feature string
-	var flagArgs []string
-	for i, arg := range args {
-		if strings.HasPrefix(arg, "-") {
-			flagArgs = args[i:]
-			break
-		}
-		if feature == "" {
-			feature = arg
-		}
-	}
-	if feature == "" && len(args) > 0 && !strings.HasPrefix(args[0], "-") {
-		feature = args[0]
-		flagArgs = args[1:]
-	}
-
+	feature, flagArgs := splitFeatureArgs(args)
 	if feature == "" {
 		fmt.Fprintln(os.Stderr, "Usage: ralph logs <feature> [options]")
 		fmt.Fprintln(os.Stderr, "")
@@ -1152,6 +1379,61 @@ func printEvent(e *Event) {
 		line, _ := e.Data["line"].(string)
 		fmt.Printf("[%s]   %s\n", timestamp, line)
 
+	case EventSessionStart:
+		id, _ := e.Data["session_id"].(string)
+		fmt.Printf("[%s]   Session %s\n", timestamp, id)
+
+	case EventToolCall:
+		tool, _ := e.Data["tool"].(string)
+		input, _ := e.Data["input"].(string)
+		fmt.Printf("[%s]   ⚙ %s %s\n", timestamp, tool, input)
+
+	case EventFileEdit:
+		path, _ := e.Data["path"].(string)
+		fmt.Printf("[%s]   ✎ %s\n", timestamp, path)
+
+	case EventTokenUsage:
+		in, _ := e.Data["input_tokens"].(float64)
+		out, _ := e.Data["output_tokens"].(float64)
+		cost := ""
+		if c, ok := e.Data["cost"].(float64); ok {
+			cost = fmt.Sprintf(" ($%.2f)", c)
+		}
+		fmt.Printf("[%s]   Tokens: %s in, %s out%s\n", timestamp, formatTokens(int(in)), formatTokens(int(out)), cost)
+
+	case EventCompletion:
+		status := "✗"
+		if e.Success != nil && *e.Success {
+			status = "✓"
+		}
+		summary, _ := e.Data["summary"].(string)
+		fmt.Printf("[%s]   %s Session finished %s\n", timestamp, status, summary)
+
+	case EventPromptBudget:
+		tokens, _ := e.Data["tokens"].(float64)
+		budget, _ := e.Data["budget"].(float64)
+		sections, _ := e.Data["sections"].(map[string]interface{})
+		var parts []string
+		for _, name := range sortedKeys(sections) {
+			sec, _ := sections[name].(map[string]interface{})
+			size, _ := sec["tokens"].(float64)
+			final, _ := sec["final"].(float64)
+			if final < size {
+				parts = append(parts, fmt.Sprintf("%s %s→%s", name, formatTokens(int(size)), formatTokens(int(final))))
+			} else if size > 0 {
+				parts = append(parts, fmt.Sprintf("%s %s", name, formatTokens(int(size))))
+			}
+		}
+		fmt.Printf("[%s]   Prompt: ~%s tokens (budget %s) %s\n", timestamp, formatTokens(int(tokens)), formatTokens(int(budget)), strings.Join(parts, ", "))
+
+	case EventBackoff:
+		failures, _ := e.Data["failures"].(float64)
+		wait := ""
+		if e.Duration != nil {
+			wait = ", waiting " + FormatDuration(time.Duration(*e.Duration))
+		}
+		fmt.Printf("[%s] ⏸ Transient provider error #%d%s: %s\n", timestamp, int(failures), wait, e.Message)
+
 	case EventLearning:
 		fmt.Printf("[%s] ~ Learning: %s\n", timestamp, e.Message)
 
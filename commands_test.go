@@ -110,14 +110,14 @@ func TestProviderChoices_Sorted(t *testing.T) {
 }
 
 func TestProviderChoices_MatchKnownProviders(t *testing.T) {
-	// Every choice should be in the knownProviders map
+	// Every choice should have an adapter
 	for _, choice := range providerChoices {
-		if _, ok := knownProviders[choice]; !ok {
-			t.Errorf("providerChoices contains %q which is not in knownProviders", choice)
+		if !isKnownProvider(choice) {
+			t.Errorf("providerChoices contains %q which has no provider adapter", choice)
 		}
 	}
-	// Every known provider should be in the choices
-	for name := range knownProviders {
+	// Every adapter should be in the choices
+	for name := range providerAdapters {
 		found := false
 		for _, choice := range providerChoices {
 			if choice == name {
@@ -126,7 +126,7 @@ func TestProviderChoices_MatchKnownProviders(t *testing.T) {
 			}
 		}
 		if !found {
-			t.Errorf("knownProviders has %q which is not in providerChoices", name)
+			t.Errorf("providerAdapters has %q which is not in providerChoices", name)
 		}
 	}
 }
@@ -226,7 +226,7 @@ func TestCmdInit_CreatesGitignore(t *testing.T) {
 
 	// Replicate the gitignore creation logic from cmdInit
 	gitignorePath := filepath.Join(ralphDir, ".gitignore")
-	gitignoreContent := "# Ralph temporary files\nralph.lock\n*.tmp\n*/logs/\n"
+	gitignoreContent := "# Ralph temporary files\nralph.lock\n*.tmp\n*/logs/\n*/attempts/\n*/verify-cache.json\nflaky.json\n"
 	if err := os.WriteFile(gitignorePath, []byte(gitignoreContent), 0644); err != nil {
 		t.Fatalf("failed to write .gitignore: %v", err)
 	}
@@ -238,10 +238,63 @@ func TestCmdInit_CreatesGitignore(t *testing.T) {
 	}
 	content := string(data)
 
-	expectedPatterns := []string{"ralph.lock", "*.tmp", "*/logs/"}
+	expectedPatterns := []string{"ralph.lock", "*.tmp", "*/logs/", "*/attempts/", "*/verify-cache.json", "flaky.json"}
 	for _, pattern := range expectedPatterns {
 		if !strings.Contains(content, pattern) {
 			t.Errorf(".gitignore should contain %q, got:\n%s", pattern, content)
 		}
 	}
 }
+
+func TestUpdateRalphGitignore_AppendsMissingPatterns(t *testing.T) {
+	dir := t.TempDir()
+	ralphDir := filepath.Join(dir, ".ralph")
+	os.MkdirAll(ralphDir, 0755)
+	gitignorePath := filepath.Join(ralphDir, ".gitignore")
+
+	// A .gitignore written by an older ralph init, with a user addition and no final newline
+	os.WriteFile(gitignorePath, []byte("# Ralph temporary files\nralph.lock\n*.tmp\n*/logs/\nscratch/"), 0644)
+	if got := missingGitignorePatterns("ralph.lock\n*.tmp\n*/logs/\n"); strings.Join(got, " ") != "*/attempts/ */verify-cache.json flaky.json" {
+		t.Errorf("missing patterns = %v", got)
+	}
+
+	updateRalphGitignore(dir)
+	data, _ := os.ReadFile(gitignorePath)
+	want := "# Ralph temporary files\nralph.lock\n*.tmp\n*/logs/\nscratch/\n*/attempts/\n*/verify-cache.json\nflaky.json\n"
+	if string(data) != want {
+		t.Errorf(".gitignore =\n%s\nwant\n%s", data, want)
+	}
+
+	updateRalphGitignore(dir)
+	if again, _ := os.ReadFile(gitignorePath); string(again) != want {
+		t.Errorf("an up-to-date .gitignore should be left alone, got:\n%s", again)
+	}
+
+	os.Remove(gitignorePath)
+	updateRalphGitignore(dir)
+	if fileExists(gitignorePath) {
+		t.Error("a project without .ralph/.gitignore should not get one")
+	}
+}
+
+func TestSplitFeatureArgs(t *testing.T) {
+	tests := []struct {
+		args      []string
+		feature   string
+		flagCount int
+	}{
+		{[]string{"auth"}, "auth", 0},
+		{[]string{"auth", "--parallel", "3"}, "auth", 2},
+		{[]string{"--parallel", "3"}, "", 2},
+		{nil, "", 0},
+	}
+	for _, tt := range tests {
+		feature, flagArgs := splitFeatureArgs(tt.args)
+		if feature != tt.feature {
+			t.Errorf("splitFeatureArgs(%v) feature = %q, want %q", tt.args, feature, tt.feature)
+		}
+		if len(flagArgs) != tt.flagCount {
+			t.Errorf("splitFeatureArgs(%v) flags = %v, want %d", tt.args, flagArgs, tt.flagCount)
+		}
+	}
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
@@ -13,10 +15,157 @@ import (
 type ProviderConfig struct {
 	Command       string   `json:"command"`
 	Args          []string `json:"args"`
-	Timeout       int      `json:"timeout"`       // seconds per iteration
-	PromptMode    string   `json:"promptMode"`    // "stdin", "arg", or "file" (auto-detected if empty)
-	PromptFlag    string   `json:"promptFlag"`    // flag before prompt in arg/file modes (e.g. "--message")
-	KnowledgeFile string   `json:"knowledgeFile"` // "AGENTS.md", "CLAUDE.md", etc. (auto-detected if empty)
+	Timeout       int      `json:"timeout"`                 // seconds per iteration
+	PromptMode    string   `json:"promptMode"`              // "stdin", "arg", or "file" (auto-detected if empty)
+	PromptFlag    string   `json:"promptFlag"`              // flag before prompt in arg/file modes (e.g. "--message")
+	KnowledgeFile string   `json:"knowledgeFile"`           // "AGENTS.md", "CLAUDE.md", etc. (auto-detected if empty)
+	IdleTimeout   int      `json:"idleTimeout,omitempty"`   // seconds without an output line before the provider is killed (0 = off)
+	FromAttempt   int      `json:"fromAttempt,omitempty"`   // escalation: first attempt (1-based) that uses this provider
+	Resume        bool     `json:"resume,omitempty"`        // retries continue the previous attempt's session (claude, codex)
+	MCP           bool     `json:"mcp,omitempty"`           // connect the session to ralph's MCP tools (claude, codex)
+	ContextBudget int      `json:"contextBudget,omitempty"` // estimated tokens a run prompt may use before sections are trimmed
+
+	// Routes adjust this provider for stories by tag or complexity, applied in order.
+	Routes []ProviderRoute `json:"routes,omitempty"`
+
+	// Escalation holds the later providers when "provider" is a list; this config is the first entry.
+	Escalation []ProviderConfig `json:"-"`
+	// Session is the provider session this attempt continues (set per attempt by WithSession).
+	Session string `json:"-"`
+	// Attempt is the story's 1-based attempt number, counting uncharged attempts too (set per
+	// attempt by WithAttempt). The provider sees it as RALPH_ATTEMPT.
+	Attempt int `json:"-"`
+}
+
+// ProviderRoute changes the provider for stories matching a tag and/or complexity.
+// When both are set, a story must match both.
+type ProviderRoute struct {
+	Tag        string   `json:"tag,omitempty"`
+	Complexity string   `json:"complexity,omitempty"`
+	Command    string   `json:"command,omitempty"`   // run this CLI instead; its defaults apply
+	Args       []string `json:"args,omitempty"`      // replace the provider's args
+	ExtraArgs  []string `json:"extraArgs,omitempty"` // append to the provider's args
+	Timeout    int      `json:"timeout,omitempty"`   // seconds per iteration
+}
+
+// matches reports whether the route applies to a story.
+func (r ProviderRoute) matches(story *StoryDefinition) bool {
+	if r.Tag != "" && !HasTag(story, r.Tag) {
+		return false
+	}
+	if r.Complexity != "" && story.Complexity != r.Complexity {
+		return false
+	}
+	return r.Tag != "" || r.Complexity != ""
+}
+
+// describe names what the route matches, e.g. "tag ui" or "complexity high".
+func (r ProviderRoute) describe() string {
+	var parts []string
+	if r.Tag != "" {
+		parts = append(parts, "tag "+r.Tag)
+	}
+	if r.Complexity != "" {
+		parts = append(parts, "complexity "+r.Complexity)
+	}
+	return strings.Join(parts, " + ")
+}
+
+// UnmarshalJSON accepts either a single provider object or an ordered escalation list.
+func (p *ProviderConfig) UnmarshalJSON(data []byte) error {
+	type plain ProviderConfig // no UnmarshalJSON method, avoids recursion
+	if trimmed := bytes.TrimSpace(data); len(trimmed) == 0 || trimmed[0] != '[' {
+		return json.Unmarshal(data, (*plain)(p))
+	}
+
+	var list []plain
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		return fmt.Errorf("provider list must have at least one entry")
+	}
+	*p = ProviderConfig(list[0])
+	for _, entry := range list[1:] {
+		p.Escalation = append(p.Escalation, ProviderConfig(entry))
+	}
+	return nil
+}
+
+// Chain returns every configured provider in escalation order, starting with this one.
+func (p ProviderConfig) Chain() []ProviderConfig {
+	first := p
+	first.Escalation = nil
+	return append([]ProviderConfig{first}, p.Escalation...)
+}
+
+// usesProvider reports whether command runs any attempt: as the provider, an escalation
+// step, or a route.
+func usesProvider(p ProviderConfig, command string) bool {
+	for _, step := range p.Chain() {
+		if step.Command == command {
+			return true
+		}
+		for _, r := range step.Routes {
+			if r.Command == command {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ForStory applies every route matching the story, in order, and returns the resulting
+// provider along with descriptions of the routes that matched.
+func (p ProviderConfig) ForStory(story *StoryDefinition) (ProviderConfig, []string) {
+	routed := p
+	var matched []string
+	for _, r := range p.Routes {
+		if !r.matches(story) {
+			continue
+		}
+		matched = append(matched, r.describe())
+		if r.Command != "" && r.Command != routed.Command {
+			// A different CLI gets its own defaults; attempt-level settings carry over
+			routed = ProviderConfig{
+				Command:       r.Command,
+				Timeout:       routed.Timeout,
+				IdleTimeout:   routed.IdleTimeout,
+				ContextBudget: routed.ContextBudget,
+				Resume:        routed.Resume && canResume(r.Command),
+				MCP:           routed.MCP && canServeMCP(r.Command),
+				Routes:        p.Routes,
+			}
+			applyProviderDefaults(&routed)
+		}
+		if r.Args != nil {
+			routed.Args = append([]string{}, r.Args...)
+		}
+		if len(r.ExtraArgs) > 0 {
+			routed.Args = append(append([]string{}, routed.Args...), r.ExtraArgs...)
+		}
+		if r.Timeout > 0 {
+			routed.Timeout = r.Timeout
+		}
+	}
+	return routed, matched
+}
+
+// ForAttempt returns the provider to use for the given 1-based attempt. An entry applies
+// from its fromAttempt (default: its position in the list) until the next entry takes over.
+func (p ProviderConfig) ForAttempt(attempt int) ProviderConfig {
+	chain := p.Chain()
+	selected := chain[0]
+	for i, entry := range chain[1:] {
+		from := entry.FromAttempt
+		if from <= 0 {
+			from = i + 2
+		}
+		if attempt >= from {
+			selected = entry
+		}
+	}
+	return selected
 }
 
 // ProviderDefaults contains default settings for known providers
@@ -27,15 +176,6 @@ type ProviderDefaults struct {
 	KnowledgeFile string
 }
 
-// knownProviders maps provider commands to their defaults
-var knownProviders = map[string]ProviderDefaults{
-	"amp":      {PromptMode: "stdin", DefaultArgs: []string{"--dangerously-allow-all"}, KnowledgeFile: "AGENTS.md"},
-	"claude":   {PromptMode: "stdin", DefaultArgs: []string{"--print", "--dangerously-skip-permissions"}, KnowledgeFile: "CLAUDE.md"},
-	"opencode": {PromptMode: "arg", DefaultArgs: []string{"run"}, KnowledgeFile: "AGENTS.md"},
-	"aider":    {PromptMode: "arg", PromptFlag: "--message", DefaultArgs: []string{"--yes-always"}, KnowledgeFile: "AGENTS.md"},
-	"codex":    {PromptMode: "arg", DefaultArgs: []string{"exec", "--full-auto"}, KnowledgeFile: "AGENTS.md"},
-}
-
 // defaultProviderDefaults is used for unknown providers
 var defaultProviderDefaults = ProviderDefaults{
 	PromptMode:    "stdin",
@@ -53,14 +193,76 @@ type ServiceConfig struct {
 
 // VerifyConfig configures verification commands
 type VerifyConfig struct {
-	Default []string `json:"default"`
-	UI      []string `json:"ui,omitempty"`
-	Timeout int      `json:"timeout,omitempty"` // seconds per command, default 300
+	Default      []string            `json:"default"`
+	UI           []string            `json:"ui,omitempty"`
+	ByTag        map[string][]string `json:"byTag,omitempty"`        // tag → extra commands for stories with that tag
+	Affected     map[string]string   `json:"affected,omitempty"`     // command → narrowed form run during story verification
+	Reports      []string            `json:"reports,omitempty"`      // globs of JUnit XML/JSON report files verify commands write
+	FlakyRetries int                 `json:"flakyRetries,omitempty"` // re-runs of a failed test command before it counts as failed
+	Timeout      int                 `json:"timeout,omitempty"`      // seconds per command, default 300
+	Concurrency  int                 `json:"concurrency,omitempty"`  // verify.default commands run at once, default 1
+	Cache        *bool               `json:"cache,omitempty"`        // reuse passes on an unchanged tree, default true
+}
+
+// CacheEnabled returns whether verify passes are cached per tree (defaults to true).
+func (v VerifyConfig) CacheEnabled() bool {
+	return v.Cache == nil || *v.Cache
+}
+
+// StoryCommands returns the commands a story runs in addition to verify.default and
+// verify.ui: verify.byTag entries for its tags, in tag order, then the story's own verify
+// list. Commands already in verify.default, or listed twice, run once.
+func (v VerifyConfig) StoryCommands(story *StoryDefinition) []string {
+	seen := make(map[string]bool, len(v.Default))
+	for _, cmd := range v.Default {
+		seen[cmd] = true
+	}
+	var cmds []string
+	add := func(list []string) {
+		for _, cmd := range list {
+			if !seen[cmd] {
+				seen[cmd] = true
+				cmds = append(cmds, cmd)
+			}
+		}
+	}
+	for _, tag := range story.Tags {
+		add(v.ByTag[tag])
+	}
+	add(story.Verify)
+	return cmds
+}
+
+// FeatureCommands returns the extra commands of every story in the PRD, in story order,
+// for verifying the feature as a whole.
+func (v VerifyConfig) FeatureCommands(def *PRDDefinition) []string {
+	seen := make(map[string]bool)
+	var cmds []string
+	for i := range def.UserStories {
+		for _, cmd := range v.StoryCommands(&def.UserStories[i]) {
+			if !seen[cmd] {
+				seen[cmd] = true
+				cmds = append(cmds, cmd)
+			}
+		}
+	}
+	return cmds
+}
+
+// MCPServerConfig is a stdio MCP server passed to provider sessions
+type MCPServerConfig struct {
+	Command string            `json:"command"`
+	Args    []string          `json:"args,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
 }
 
+// mcpServerNamePattern keeps server names valid as codex config keys and tool prefixes.
+var mcpServerNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
 // CommitsConfig configures git commit behavior
 type CommitsConfig struct {
-	PrdChanges bool `json:"prdChanges,omitempty"`
+	PrdChanges bool   `json:"prdChanges,omitempty"`
+	Rollback   string `json:"rollback,omitempty"` // "off" (default), "verify", or "always"
 }
 
 // RalphConfig is the main configuration loaded from ralph.config.json
@@ -74,6 +276,11 @@ type RalphConfig struct {
 	Commits    *CommitsConfig   `json:"commits,omitempty"`
 	Logging    *LoggingConfig   `json:"logging,omitempty"`
 	Resources  *ResourcesConfig `json:"resources,omitempty"`
+	Budget     *BudgetConfig    `json:"budget,omitempty"`
+	Transient  *TransientConfig `json:"transient,omitempty"`
+
+	// MCPServers are given to every PRD, run, verify, and refine session, keyed by name.
+	MCPServers map[string]MCPServerConfig `json:"mcpServers,omitempty"`
 }
 
 // ResolvedConfig is the fully resolved configuration
@@ -82,6 +289,43 @@ type ResolvedConfig struct {
 	Config      RalphConfig
 }
 
+// ForAttempt returns a copy of the config whose provider is the escalation entry for the
+// given 1-based attempt. Returns the config itself when no escalation is configured.
+func (rc *ResolvedConfig) ForAttempt(attempt int) *ResolvedConfig {
+	if len(rc.Config.Provider.Escalation) == 0 {
+		return rc
+	}
+	attemptCfg := *rc
+	attemptCfg.Config.Provider = rc.Config.Provider.ForAttempt(attempt)
+	return &attemptCfg
+}
+
+// ForStory returns a copy of the config with the provider routed for the story, plus the
+// routes that matched. Returns the config itself when no route matches.
+func (rc *ResolvedConfig) ForStory(story *StoryDefinition) (*ResolvedConfig, []string) {
+	routed, matched := rc.Config.Provider.ForStory(story)
+	if len(matched) == 0 {
+		return rc, nil
+	}
+	storyCfg := *rc
+	storyCfg.Config.Provider = routed
+	return &storyCfg, matched
+}
+
+// WithSession returns a copy of the config whose provider continues the given session.
+func (rc *ResolvedConfig) WithSession(sessionID string) *ResolvedConfig {
+	sessionCfg := *rc
+	sessionCfg.Config.Provider.Session = sessionID
+	return &sessionCfg
+}
+
+// WithAttempt returns a copy of the config whose provider runs the given attempt.
+func (rc *ResolvedConfig) WithAttempt(attempt int) *ResolvedConfig {
+	attemptCfg := *rc
+	attemptCfg.Config.Provider.Attempt = attempt
+	return &attemptCfg
+}
+
 // ConfigPath returns the path to ralph.config.json
 func ConfigPath(projectRoot string) string {
 	return filepath.Join(projectRoot, "ralph.config.json")
@@ -116,9 +360,21 @@ func LoadConfig(projectRoot string) (*ResolvedConfig, error) {
 	if cfg.Provider.Timeout <= 0 {
 		cfg.Provider.Timeout = 1800 // 30 minutes
 	}
+	if cfg.Provider.ContextBudget <= 0 {
+		cfg.Provider.ContextBudget = defaultContextBudget
+	}
 
 	// Auto-detect provider defaults based on command
 	applyProviderDefaults(&cfg.Provider)
+	for i := range cfg.Provider.Escalation {
+		if cfg.Provider.Escalation[i].Timeout <= 0 {
+			cfg.Provider.Escalation[i].Timeout = cfg.Provider.Timeout
+		}
+		if cfg.Provider.Escalation[i].ContextBudget <= 0 {
+			cfg.Provider.Escalation[i].ContextBudget = cfg.Provider.ContextBudget
+		}
+		applyProviderDefaults(&cfg.Provider.Escalation[i])
+	}
 	if cfg.Commits == nil {
 		cfg.Commits = &CommitsConfig{
 			PrdChanges: true,
@@ -139,6 +395,9 @@ func LoadConfig(projectRoot string) (*ResolvedConfig, error) {
 	if cfg.Verify.Timeout <= 0 {
 		cfg.Verify.Timeout = 300 // 5 minutes per command
 	}
+	if cfg.Verify.Concurrency <= 0 {
+		cfg.Verify.Concurrency = 1 // sequential
+	}
 
 	// Validate required fields
 	if err := validateConfig(&cfg); err != nil {
@@ -156,9 +415,102 @@ func validateConfig(cfg *RalphConfig) error {
 	if cfg.Provider.Command == "" {
 		return fmt.Errorf("provider.command is required")
 	}
+	for i, p := range cfg.Provider.Chain() {
+		if p.IdleTimeout < 0 {
+			return fmt.Errorf("provider[%d].idleTimeout must not be negative", i)
+		}
+		if p.Resume && p.Command != "" && !canResume(p.Command) {
+			return fmt.Errorf("provider[%d].resume is not supported for %s (supported: claude, codex)", i, p.Command)
+		}
+		if p.MCP && p.Command != "" && !canServeMCP(p.Command) {
+			return fmt.Errorf("provider[%d].mcp is not supported for %s (supported: claude, codex)", i, p.Command)
+		}
+		for j, r := range p.Routes {
+			if r.Tag == "" && r.Complexity == "" {
+				return fmt.Errorf("provider[%d].routes[%d] must set tag or complexity", i, j)
+			}
+			if r.Complexity != "" && !isStoryComplexity(r.Complexity) {
+				return fmt.Errorf("provider[%d].routes[%d].complexity must be one of %s (got: %s)", i, j, strings.Join(storyComplexities, ", "), r.Complexity)
+			}
+			if r.Command == "" && r.Args == nil && len(r.ExtraArgs) == 0 && r.Timeout == 0 {
+				return fmt.Errorf("provider[%d].routes[%d] must set command, args, extraArgs, or timeout", i, j)
+			}
+			if r.Timeout < 0 {
+				return fmt.Errorf("provider[%d].routes[%d].timeout must not be negative", i, j)
+			}
+		}
+	}
+	if cfg.Provider.FromAttempt > 1 {
+		return fmt.Errorf("provider[0].fromAttempt must be 1 (the first provider handles the first attempt)")
+	}
+	lastFrom := 1
+	for i, p := range cfg.Provider.Escalation {
+		if p.Command == "" {
+			return fmt.Errorf("provider[%d].command is required", i+1)
+		}
+		from := p.FromAttempt
+		if from <= 0 {
+			from = i + 2
+		}
+		if from <= lastFrom {
+			return fmt.Errorf("provider[%d].fromAttempt must be greater than the previous entry's (got: %d)", i+1, from)
+		}
+		lastFrom = from
+	}
 	if len(cfg.Verify.Default) == 0 {
 		return fmt.Errorf("verify.default must have at least one command")
 	}
+	for _, tag := range sortedKeys(cfg.Verify.ByTag) {
+		if tag == "" {
+			return fmt.Errorf("verify.byTag keys must be non-empty tag names")
+		}
+		if len(cfg.Verify.ByTag[tag]) == 0 {
+			return fmt.Errorf("verify.byTag.%s must have at least one command", tag)
+		}
+		for i, cmd := range cfg.Verify.ByTag[tag] {
+			if strings.TrimSpace(cmd) == "" {
+				return fmt.Errorf("verify.byTag.%s[%d] is empty", tag, i)
+			}
+		}
+	}
+	if err := validateAffected(&cfg.Verify); err != nil {
+		return err
+	}
+	for i, glob := range cfg.Verify.Reports {
+		if _, err := filepath.Match(glob, ""); err != nil || strings.TrimSpace(glob) == "" {
+			return fmt.Errorf("verify.reports[%d] is not a valid glob: %q", i, glob)
+		}
+	}
+	if cfg.Verify.FlakyRetries < 0 || cfg.Verify.FlakyRetries > 5 {
+		return fmt.Errorf("verify.flakyRetries must be between 0 and 5 (got: %d)", cfg.Verify.FlakyRetries)
+	}
+	if cfg.Commits != nil {
+		switch cfg.Commits.Rollback {
+		case "", "off", "verify", "always":
+		default:
+			return fmt.Errorf("commits.rollback must be \"off\", \"verify\", or \"always\" (got: %s)", cfg.Commits.Rollback)
+		}
+	}
+	if err := validateBudget(cfg.Budget, cfg.Provider.Chain()); err != nil {
+		return err
+	}
+	if err := validateTransient(cfg.Transient); err != nil {
+		return err
+	}
+	for _, name := range sortedKeys(cfg.MCPServers) {
+		if !mcpServerNamePattern.MatchString(name) {
+			return fmt.Errorf("mcpServers name %q must contain only letters, digits, '-' and '_'", name)
+		}
+		if name == "ralph" {
+			return fmt.Errorf("mcpServers.ralph is reserved for ralph's own tools (see provider.mcp)")
+		}
+		if cfg.MCPServers[name].Command == "" {
+			return fmt.Errorf("mcpServers.%s.command is required", name)
+		}
+		if len(cfg.MCPServers[name].Env) > 0 && usesProvider(cfg.Provider, "codex") {
+			return fmt.Errorf("mcpServers.%s.env can't be given to codex without showing it in the process list; configure the server in codex's config.toml instead", name)
+		}
+	}
 	if len(cfg.Services) == 0 {
 		return fmt.Errorf("services must have at least one entry (e.g. {\"name\": \"dev\", \"start\": \"npm run dev\", \"ready\": \"http://localhost:3000\"})")
 	}
@@ -205,11 +557,8 @@ func isCommandAvailable(cmd string) bool {
 
 // applyProviderDefaults sets PromptMode, PromptFlag, Args, and KnowledgeFile based on known providers
 func applyProviderDefaults(p *ProviderConfig) {
-	// Get defaults for this provider (or use fallback)
-	defaults, ok := knownProviders[p.Command]
-	if !ok {
-		defaults = defaultProviderDefaults
-	}
+	// Get defaults from this provider's adapter (or the plain-text fallback)
+	defaults := adapterFor(p.Command).Defaults()
 
 	// Only apply if not already set by user
 	if p.PromptMode == "" {
@@ -307,11 +656,25 @@ func CheckReadinessWarnings(cfg *RalphConfig) []string {
 	var warnings []string
 
 	// Warn about unknown providers using default fallback settings
-	if _, ok := knownProviders[cfg.Provider.Command]; !ok {
-		warnings = append(warnings, fmt.Sprintf(
-			"Provider '%s' is not a known provider. Using defaults: promptMode=stdin, knowledgeFile=AGENTS.md. Set these explicitly in ralph.config.json if needed.",
-			cfg.Provider.Command,
-		))
+	for _, p := range cfg.Provider.Chain() {
+		if !isKnownProvider(p.Command) && !isMockProvider(p) {
+			warnings = append(warnings, fmt.Sprintf(
+				"Provider '%s' is not a known provider. Using defaults: promptMode=stdin, knowledgeFile=AGENTS.md. Set these explicitly in ralph.config.json if needed.",
+				p.Command,
+			))
+		}
+	}
+
+	// mcpServers only reach CLIs ralph knows how to pass them to
+	if len(cfg.MCPServers) > 0 {
+		for _, p := range cfg.Provider.Chain() {
+			if !canServeMCP(p.Command) && !isMockProvider(p) {
+				warnings = append(warnings, fmt.Sprintf(
+					"mcpServers are not passed to '%s' (supported: claude, codex). Configure them in %s's own settings.",
+					p.Command, p.Command,
+				))
+			}
+		}
 	}
 
 	return warnings
@@ -373,6 +736,28 @@ func CheckReadiness(cfg *RalphConfig, def *PRDDefinition) []string {
 		}
 	}
 
+	// Check verify.byTag command binaries are available
+	for _, tag := range sortedKeys(cfg.Verify.ByTag) {
+		for _, cmd := range cfg.Verify.ByTag[tag] {
+			base := extractBaseCommand(cmd)
+			if base != "" && !isCommandAvailable(base) {
+				issues = append(issues, fmt.Sprintf("verify.byTag.%s: '%s' not found in PATH (from: %s)", tag, base, cmd))
+			}
+		}
+	}
+
+	// Check story-specific verify command binaries are available
+	if def != nil {
+		for _, s := range def.UserStories {
+			for _, cmd := range s.Verify {
+				base := extractBaseCommand(cmd)
+				if base != "" && !isCommandAvailable(base) {
+					issues = append(issues, fmt.Sprintf("%s verify: '%s' not found in PATH (from: %s)", s.ID, base, cmd))
+				}
+			}
+		}
+	}
+
 	// Check service start command binaries are available
 	for _, svc := range cfg.Services {
 		if svc.Start != "" {
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/user"
@@ -150,9 +151,15 @@ func TestLoadConfig_Defaults(t *testing.T) {
 	if cfg.Config.Provider.Timeout != 1800 {
 		t.Errorf("expected default timeout=1800, got %d", cfg.Config.Provider.Timeout)
 	}
+	if cfg.Config.Provider.ContextBudget != defaultContextBudget {
+		t.Errorf("expected default contextBudget=%d, got %d", defaultContextBudget, cfg.Config.Provider.ContextBudget)
+	}
 	if cfg.Config.Commits == nil || !cfg.Config.Commits.PrdChanges {
 		t.Error("expected commits.prdChanges=true by default")
 	}
+	if cfg.Config.Verify.Concurrency != 1 {
+		t.Errorf("expected default verify.concurrency=1, got %d", cfg.Config.Verify.Concurrency)
+	}
 }
 
 func TestIsCommandAvailable(t *testing.T) {
@@ -1058,3 +1065,444 @@ func TestCheckReadiness_PlaceholderServiceCommand(t *testing.T) {
 	}
 }
 
+
+func TestValidateConfig_Rollback(t *testing.T) {
+	tests := []struct {
+		rollback string
+		wantErr  bool
+	}{
+		{"", false},
+		{"off", false},
+		{"verify", false},
+		{"always", false},
+		{"sometimes", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rollback, func(t *testing.T) {
+			cfg := &RalphConfig{
+				Provider: ProviderConfig{Command: "claude"},
+				Verify:   VerifyConfig{Default: []string{"go test ./..."}},
+				Services: []ServiceConfig{{Name: "dev", Ready: "http://localhost:3000"}},
+				Commits:  &CommitsConfig{PrdChanges: true, Rollback: tt.rollback},
+			}
+			err := validateConfig(cfg)
+			if tt.wantErr && (err == nil || !strings.Contains(err.Error(), "commits.rollback")) {
+				t.Errorf("expected commits.rollback error, got: %v", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_ProviderEscalationList(t *testing.T) {
+	dir := t.TempDir()
+	configContent := `{
+		"provider": [
+			{"command": "amp"},
+			{"command": "claude", "fromAttempt": 3, "timeout": 3600}
+		],
+		"verify": {"default": ["bun run test"]},
+		"services": [{"name": "dev", "ready": "http://localhost:3000"}]
+	}`
+	os.WriteFile(filepath.Join(dir, "ralph.config.json"), []byte(configContent), 0644)
+
+	cfg, err := LoadConfig(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Config.Provider.Command != "amp" {
+		t.Errorf("expected first provider amp, got %s", cfg.Config.Provider.Command)
+	}
+	if len(cfg.Config.Provider.Escalation) != 1 {
+		t.Fatalf("expected 1 escalation entry, got %d", len(cfg.Config.Provider.Escalation))
+	}
+	esc := cfg.Config.Provider.Escalation[0]
+	if esc.KnowledgeFile != "CLAUDE.md" || esc.Timeout != 3600 {
+		t.Errorf("expected provider defaults applied to escalation entry, got %+v", esc)
+	}
+
+	for attempt, want := range map[int]string{1: "amp", 2: "amp", 3: "claude", 4: "claude"} {
+		if got := cfg.ForAttempt(attempt).Config.Provider.Command; got != want {
+			t.Errorf("attempt %d: expected %s, got %s", attempt, want, got)
+		}
+	}
+}
+
+func TestProviderConfig_ForAttempt_DefaultPositions(t *testing.T) {
+	p := ProviderConfig{Command: "a", Escalation: []ProviderConfig{{Command: "b"}, {Command: "c"}}}
+	for attempt, want := range map[int]string{1: "a", 2: "b", 3: "c", 5: "c"} {
+		if got := p.ForAttempt(attempt).Command; got != want {
+			t.Errorf("attempt %d: expected %s, got %s", attempt, want, got)
+		}
+	}
+	if p.ForAttempt(2).Escalation != nil {
+		t.Error("selected provider should not carry the escalation chain")
+	}
+
+	single := &ResolvedConfig{Config: RalphConfig{Provider: ProviderConfig{Command: "amp"}}}
+	if single.ForAttempt(3) != single {
+		t.Error("expected the same config when no escalation is configured")
+	}
+}
+
+func TestValidateConfig_ProviderEscalation(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider ProviderConfig
+		wantErr  string
+	}{
+		{"valid", ProviderConfig{Command: "amp", Escalation: []ProviderConfig{{Command: "claude", FromAttempt: 3}}}, ""},
+		{"missing command", ProviderConfig{Command: "amp", Escalation: []ProviderConfig{{FromAttempt: 2}}}, "provider[1].command is required"},
+		{"not increasing", ProviderConfig{Command: "amp", Escalation: []ProviderConfig{{Command: "b", FromAttempt: 3}, {Command: "c", FromAttempt: 2}}}, "provider[2].fromAttempt"},
+		{"first starts late", ProviderConfig{Command: "amp", FromAttempt: 2}, "provider[0].fromAttempt must be 1"},
+		{"resume supported", ProviderConfig{Command: "claude", Resume: true}, ""},
+		{"resume unsupported", ProviderConfig{Command: "amp", Escalation: []ProviderConfig{{Command: "aider", Resume: true}}}, "provider[1].resume is not supported for aider"},
+		{"mcp supported", ProviderConfig{Command: "codex", MCP: true}, ""},
+		{"mcp unsupported", ProviderConfig{Command: "opencode", MCP: true}, "provider[0].mcp is not supported for opencode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &RalphConfig{
+				Provider: tt.provider,
+				Verify:   VerifyConfig{Default: []string{"go test ./..."}},
+				Services: []ServiceConfig{{Name: "dev", Ready: "http://localhost:3000"}},
+			}
+			err := validateConfig(cfg)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("expected error containing %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestProviderConfig_UnmarshalEmptyList(t *testing.T) {
+	var cfg RalphConfig
+	err := json.Unmarshal([]byte(`{"provider": []}`), &cfg)
+	if err == nil || !strings.Contains(err.Error(), "at least one entry") {
+		t.Errorf("expected empty list error, got: %v", err)
+	}
+}
+
+func TestProviderConfig_ForStory(t *testing.T) {
+	p := ProviderConfig{
+		Command: "claude",
+		Args:    []string{"--print"},
+		Timeout: 1800,
+		Routes: []ProviderRoute{
+			{Tag: "ui", ExtraArgs: []string{"--model", "opus"}},
+			{Tag: "docs", Args: []string{"--print", "--model", "haiku"}},
+			{Complexity: "high", Timeout: 3600},
+		},
+	}
+
+	plain, matched := p.ForStory(&StoryDefinition{ID: "US-001", Tags: []string{"api"}})
+	if len(matched) != 0 || strings.Join(plain.Args, " ") != "--print" || plain.Timeout != 1800 {
+		t.Errorf("unrouted story changed: %+v (routes %v)", plain, matched)
+	}
+
+	ui, matched := p.ForStory(&StoryDefinition{ID: "US-002", Tags: []string{"ui"}, Complexity: "high"})
+	if got := strings.Join(ui.Args, " "); got != "--print --model opus" {
+		t.Errorf("ui args = %q", got)
+	}
+	if ui.Timeout != 3600 {
+		t.Errorf("high complexity timeout = %d, want 3600", ui.Timeout)
+	}
+	if strings.Join(matched, ", ") != "tag ui, complexity high" {
+		t.Errorf("matched = %v", matched)
+	}
+	if len(p.Args) != 1 {
+		t.Errorf("routing must not modify the base args, got %v", p.Args)
+	}
+
+	docs, _ := p.ForStory(&StoryDefinition{ID: "US-003", Tags: []string{"docs"}})
+	if got := strings.Join(docs.Args, " "); got != "--print --model haiku" {
+		t.Errorf("docs args = %q", got)
+	}
+}
+
+func TestProviderConfig_ForStory_CommandSwitchAppliesDefaults(t *testing.T) {
+	p := ProviderConfig{
+		Command: "claude", Args: []string{"--print"}, PromptMode: "stdin", KnowledgeFile: "CLAUDE.md", Timeout: 900, Resume: true, MCP: true,
+		Routes: []ProviderRoute{{Tag: "docs", Command: "aider"}},
+	}
+	routed, _ := p.ForStory(&StoryDefinition{Tags: []string{"docs"}})
+	if routed.Command != "aider" || routed.PromptMode != "arg" || routed.PromptFlag != "--message" || routed.KnowledgeFile != "AGENTS.md" {
+		t.Errorf("expected aider defaults, got %+v", routed)
+	}
+	if strings.Join(routed.Args, " ") != "--yes-always" {
+		t.Errorf("expected aider default args, got %v", routed.Args)
+	}
+	if routed.Timeout != 900 {
+		t.Errorf("timeout should carry over, got %d", routed.Timeout)
+	}
+	if routed.Resume || routed.MCP {
+		t.Error("resume and mcp should be dropped for a CLI that supports neither")
+	}
+}
+
+func TestProviderRoute_MatchesBoth(t *testing.T) {
+	r := ProviderRoute{Tag: "ui", Complexity: "high", Timeout: 10}
+	if r.matches(&StoryDefinition{Tags: []string{"ui"}, Complexity: "low"}) {
+		t.Error("route with tag and complexity should require both")
+	}
+	if !r.matches(&StoryDefinition{Tags: []string{"ui"}, Complexity: "high"}) {
+		t.Error("expected match")
+	}
+	if r.describe() != "tag ui + complexity high" {
+		t.Errorf("describe = %q", r.describe())
+	}
+}
+
+func TestResolvedConfig_ForStory(t *testing.T) {
+	cfg := &ResolvedConfig{Config: RalphConfig{Provider: ProviderConfig{
+		Command: "claude", Timeout: 1800,
+		Routes: []ProviderRoute{{Complexity: "high", Timeout: 3600}},
+	}}}
+	if same, matched := cfg.ForStory(&StoryDefinition{Complexity: "low"}); same != cfg || matched != nil {
+		t.Error("expected the same config when no route matches")
+	}
+	routed, _ := cfg.ForStory(&StoryDefinition{Complexity: "high"})
+	if routed == cfg || routed.Config.Provider.Timeout != 3600 || cfg.Config.Provider.Timeout != 1800 {
+		t.Errorf("expected a routed copy, got timeout %d (base %d)", routed.Config.Provider.Timeout, cfg.Config.Provider.Timeout)
+	}
+}
+
+func TestValidateConfig_ProviderRoutes(t *testing.T) {
+	tests := []struct {
+		name    string
+		routes  []ProviderRoute
+		wantErr string
+	}{
+		{"valid", []ProviderRoute{{Tag: "ui", ExtraArgs: []string{"--model", "opus"}}, {Complexity: "high", Timeout: 3600}}, ""},
+		{"no match", []ProviderRoute{{Timeout: 60}}, "routes[0] must set tag or complexity"},
+		{"bad complexity", []ProviderRoute{{Complexity: "extreme", Timeout: 60}}, "complexity must be one of low, medium, high"},
+		{"no effect", []ProviderRoute{{Tag: "ui"}}, "must set command, args, extraArgs, or timeout"},
+		{"negative timeout", []ProviderRoute{{Tag: "ui", Timeout: -1}}, "timeout must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &RalphConfig{
+				Provider: ProviderConfig{Command: "claude", Routes: tt.routes},
+				Verify:   VerifyConfig{Default: []string{"go test ./..."}},
+				Services: []ServiceConfig{{Name: "dev", Ready: "http://localhost:3000"}},
+			}
+			err := validateConfig(cfg)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("expected error containing %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateConfig_MCPServers(t *testing.T) {
+	tests := []struct {
+		name    string
+		servers map[string]MCPServerConfig
+		wantErr string
+	}{
+		{"valid", map[string]MCPServerConfig{"github": {Command: "npx", Args: []string{"-y", "server-github"}}}, ""},
+		{"missing command", map[string]MCPServerConfig{"docs": {Args: []string{"serve"}}}, "mcpServers.docs.command is required"},
+		{"bad name", map[string]MCPServerConfig{"my server": {Command: "x"}}, `mcpServers name "my server"`},
+		{"reserved", map[string]MCPServerConfig{"ralph": {Command: "x"}}, "mcpServers.ralph is reserved"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &RalphConfig{
+				Provider:   ProviderConfig{Command: "claude"},
+				Verify:     VerifyConfig{Default: []string{"go test ./..."}},
+				Services:   []ServiceConfig{{Name: "dev", Ready: "http://localhost:3000"}},
+				MCPServers: tt.servers,
+			}
+			err := validateConfig(cfg)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("expected error containing %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateConfig_MCPServerEnvWithCodex(t *testing.T) {
+	servers := map[string]MCPServerConfig{"github": {Command: "npx", Env: map[string]string{"GITHUB_TOKEN": "t0k"}}}
+	providers := map[string]ProviderConfig{
+		"provider":   {Command: "codex"},
+		"escalation": {Command: "claude", Escalation: []ProviderConfig{{Command: "codex", FromAttempt: 2}}},
+		"route":      {Command: "claude", Routes: []ProviderRoute{{Tag: "backend", Command: "codex"}}},
+	}
+	for name, p := range providers {
+		cfg := &RalphConfig{
+			Provider:   p,
+			Verify:     VerifyConfig{Default: []string{"go test ./..."}},
+			Services:   []ServiceConfig{{Name: "dev", Ready: "http://localhost:3000"}},
+			MCPServers: servers,
+		}
+		if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "mcpServers.github.env can't be given to codex") {
+			t.Errorf("%s: expected an error for env with codex, got %v", name, err)
+		}
+	}
+
+	cfg := &RalphConfig{
+		Provider:   ProviderConfig{Command: "claude"},
+		Verify:     VerifyConfig{Default: []string{"go test ./..."}},
+		Services:   []ServiceConfig{{Name: "dev", Ready: "http://localhost:3000"}},
+		MCPServers: servers,
+	}
+	if err := validateConfig(cfg); err != nil {
+		t.Errorf("claude keeps env out of argv, got %v", err)
+	}
+}
+
+func TestCheckReadinessWarnings_MCPServersUnsupported(t *testing.T) {
+	cfg := &RalphConfig{
+		Provider:   ProviderConfig{Command: "claude", Escalation: []ProviderConfig{{Command: "aider"}}},
+		MCPServers: map[string]MCPServerConfig{"github": {Command: "npx"}},
+	}
+	warnings := CheckReadinessWarnings(cfg)
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "not passed to 'aider'") {
+		t.Errorf("expected one warning for aider, got %v", warnings)
+	}
+
+	cfg.MCPServers = nil
+	if warnings := CheckReadinessWarnings(cfg); len(warnings) != 0 {
+		t.Errorf("expected no warnings without mcpServers, got %v", warnings)
+	}
+}
+
+func TestValidateConfig_Transient(t *testing.T) {
+	cfg := &RalphConfig{
+		Provider:  ProviderConfig{Command: "claude"},
+		Verify:    VerifyConfig{Default: []string{"go test ./..."}},
+		Services:  []ServiceConfig{{Name: "dev", Ready: "http://localhost:3000"}},
+		Transient: &TransientConfig{Patterns: []string{"[quota"}},
+	}
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "transient.patterns[0] is not a valid regex") {
+		t.Errorf("expected an invalid pattern error, got: %v", err)
+	}
+}
+
+func TestVerifyConfig_StoryCommands(t *testing.T) {
+	v := VerifyConfig{
+		Default: []string{"go test ./..."},
+		ByTag: map[string][]string{
+			"db":  {"make migrate-check", "go test ./..."},
+			"api": {"make contract-test", "make migrate-check"},
+		},
+	}
+
+	story := &StoryDefinition{ID: "US-001", Tags: []string{"api", "db"}, Verify: []string{"./scripts/check-users.sh", "make contract-test"}}
+	got := v.StoryCommands(story)
+	want := []string{"make contract-test", "make migrate-check", "./scripts/check-users.sh"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("StoryCommands() = %v, want %v", got, want)
+	}
+	if got := v.StoryCommands(&StoryDefinition{ID: "US-002", Tags: []string{"ui"}}); len(got) != 0 {
+		t.Errorf("expected no extra commands for tags without checks, got %v", got)
+	}
+
+	def := &PRDDefinition{UserStories: []StoryDefinition{
+		{ID: "US-001", Tags: []string{"db"}},
+		{ID: "US-002", Verify: []string{"./scripts/smoke.sh"}},
+		{ID: "US-003", Tags: []string{"api", "db"}},
+	}}
+	got = v.FeatureCommands(def)
+	want = []string{"make migrate-check", "./scripts/smoke.sh", "make contract-test"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("FeatureCommands() = %v, want %v", got, want)
+	}
+}
+
+func TestValidateConfig_VerifyByTag(t *testing.T) {
+	tests := []struct {
+		name  string
+		byTag map[string][]string
+		want  string
+	}{
+		{"valid", map[string][]string{"db": {"make migrate-check"}}, ""},
+		{"empty tag", map[string][]string{"": {"make migrate-check"}}, "verify.byTag keys must be non-empty tag names"},
+		{"no commands", map[string][]string{"api": {}}, "verify.byTag.api must have at least one command"},
+		{"blank command", map[string][]string{"db": {"make migrate-check", " "}}, "verify.byTag.db[1] is empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &RalphConfig{
+				Provider: ProviderConfig{Command: "claude"},
+				Verify:   VerifyConfig{Default: []string{"go test ./..."}, ByTag: tt.byTag},
+				Services: []ServiceConfig{{Name: "dev", Ready: "http://localhost:3000"}},
+			}
+			err := validateConfig(cfg)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("expected valid, got: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected %q, got: %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestValidateConfig_VerifyReports(t *testing.T) {
+	cfg := &RalphConfig{
+		Provider: ProviderConfig{Command: "claude"},
+		Verify:   VerifyConfig{Default: []string{"pytest"}, Reports: []string{"reports/*.xml", "coverage/junit-*.xml"}},
+		Services: []ServiceConfig{{Name: "dev", Ready: "http://localhost:3000"}},
+	}
+	if err := validateConfig(cfg); err != nil {
+		t.Errorf("expected valid, got: %v", err)
+	}
+
+	cfg.Verify.Reports = []string{"reports/*.xml", "reports/[.xml"}
+	if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "verify.reports[1] is not a valid glob") {
+		t.Errorf("expected an invalid glob error, got: %v", err)
+	}
+}
+
+func TestValidateConfig_FlakyRetries(t *testing.T) {
+	cfg := &RalphConfig{
+		Provider: ProviderConfig{Command: "claude"},
+		Verify:   VerifyConfig{Default: []string{"npx playwright test"}, FlakyRetries: 2},
+		Services: []ServiceConfig{{Name: "dev", Ready: "http://localhost:3000"}},
+	}
+	if err := validateConfig(cfg); err != nil {
+		t.Errorf("expected valid, got: %v", err)
+	}
+	for _, n := range []int{-1, 6} {
+		cfg.Verify.FlakyRetries = n
+		if err := validateConfig(cfg); err == nil || !strings.Contains(err.Error(), "verify.flakyRetries must be between 0 and 5") {
+			t.Errorf("flakyRetries %d: expected a range error, got: %v", n, err)
+		}
+	}
+}
+
+func TestCheckReadiness_ExtraVerifyCommandsNotInPATH(t *testing.T) {
+	cfg := &RalphConfig{
+		Verify: VerifyConfig{
+			Default: []string{"go version"},
+			ByTag:   map[string][]string{"db": {"nonexistent-migrate-xyz123 check"}},
+		},
+	}
+	def := &PRDDefinition{UserStories: []StoryDefinition{
+		{ID: "US-001", Verify: []string{"nonexistent-contract-xyz123 run"}},
+	}}
+
+	issues := strings.Join(CheckReadiness(cfg, def), "\n")
+	if !strings.Contains(issues, "verify.byTag.db: 'nonexistent-migrate-xyz123' not found in PATH") {
+		t.Errorf("expected a verify.byTag issue, got %v", issues)
+	}
+	if !strings.Contains(issues, "US-001 verify: 'nonexistent-contract-xyz123' not found in PATH") {
+		t.Errorf("expected a story verify issue, got %v", issues)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultContextBudget is the prompt size, in estimated tokens, used when
+// provider.contextBudget is unset. It leaves most of a 200k context window for the work.
+const defaultContextBudget = 100000
+
+// maxArgPromptTokens keeps arg-mode prompts under Linux's 128 KiB limit on a single
+// command-line argument.
+const maxArgPromptTokens = 30000
+
+// estimateTokens approximates the token count of text at four bytes per token.
+func estimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+// promptBudget returns the token budget for prompts sent to this provider (0 = unlimited).
+func (p ProviderConfig) promptBudget() int {
+	budget := p.ContextBudget
+	if p.PromptMode == "arg" && (budget <= 0 || budget > maxArgPromptTokens) {
+		budget = maxArgPromptTokens
+	}
+	return budget
+}
+
+// promptSection is a variable-size part of a prompt that can give way to the budget.
+type promptSection struct {
+	name      string              // template placeholder
+	hint      string              // where the agent finds what was cut
+	keepTail  bool                // keep the end (most recent) rather than the start
+	summarize func(string) string // optional cheaper form tried before trimming
+}
+
+// runPromptSections are the run prompt's variable-size sections, in the order they
+// give way when the prompt is over budget: context the agent can rebuild itself goes
+// first, and the failure it has to fix goes last.
+var runPromptSections = []promptSection{
+	{name: "diffSummary", hint: "run `git diff --stat` to see the branch's changes"},
+	{name: "codebaseContext", hint: "explore the repository for the rest"},
+	{name: "resourceGuidance", hint: "read the framework documentation directly"},
+	{name: "storyMap", hint: "the full story list is in prd.json", summarize: summarizeStoryMap},
+	{name: "learnings", hint: "older learnings omitted", keepTail: true},
+	{name: "retryInfo", hint: "rerun the failing command for full output"},
+}
+
+// PromptBudget is the size breakdown of a generated prompt.
+type PromptBudget struct {
+	Budget   int             // token budget (0 = unlimited)
+	Tokens   int             // estimated tokens of the final prompt
+	Sections []PromptSection // variable-size sections, in shrink order
+}
+
+// PromptSection is one section's estimated size before and after fitting the budget.
+type PromptSection struct {
+	Name   string
+	Tokens int // as built
+	Final  int // as sent
+}
+
+// Over reports whether the prompt is still larger than its budget.
+func (b *PromptBudget) Over() bool {
+	return b.Budget > 0 && b.Tokens > b.Budget
+}
+
+// Trimmed describes each section that was cut, e.g. "codebaseContext 20.1k→8.0k".
+func (b *PromptBudget) Trimmed() []string {
+	var trimmed []string
+	for _, s := range b.Sections {
+		if s.Final < s.Tokens {
+			trimmed = append(trimmed, fmt.Sprintf("%s %s→%s", s.Name, formatTokens(s.Tokens), formatTokens(s.Final)))
+		}
+	}
+	return trimmed
+}
+
+// fitPrompt renders a prompt template and, while it is over budget, shrinks its sections
+// in order: first to their summary form, then to as many lines as fit, then to a note.
+// vars is modified in place.
+func fitPrompt(name string, vars map[string]string, sections []promptSection, budget int) (string, *PromptBudget) {
+	report := &PromptBudget{Budget: budget}
+	for _, s := range sections {
+		report.Sections = append(report.Sections, PromptSection{Name: s.name, Tokens: estimateTokens(vars[s.name])})
+	}
+
+	prompt := getPrompt(name, vars)
+	total := estimateTokens(prompt)
+	for _, s := range sections {
+		if budget <= 0 || total <= budget {
+			break
+		}
+		text := vars[s.name]
+		if text == "" {
+			continue
+		}
+		if s.summarize != nil {
+			text = s.summarize(text)
+		}
+		target := estimateTokens(vars[s.name]) - (total - budget)
+		vars[s.name] = shrinkSection(text, target, s)
+		prompt = getPrompt(name, vars)
+		total = estimateTokens(prompt)
+	}
+
+	for i, s := range sections {
+		report.Sections[i].Final = estimateTokens(vars[s.name])
+	}
+	report.Tokens = total
+	return prompt, report
+}
+
+// shrinkSection cuts text to about target tokens on line boundaries, noting what was left
+// out. A code fence left open by the cut is closed.
+func shrinkSection(text string, target int, s promptSection) string {
+	if estimateTokens(text) <= target {
+		return text
+	}
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	note := func(kept int) string {
+		if kept == 0 {
+			return fmt.Sprintf("_(omitted to fit the prompt budget; %s)_", s.hint)
+		}
+		return fmt.Sprintf("_(%d of %d lines omitted to fit the prompt budget; %s)_", len(lines)-kept, len(lines), s.hint)
+	}
+	room := target - estimateTokens(note(len(lines))) - 2
+
+	var kept []string
+	used := 0
+	if s.keepTail {
+		// Keep the heading line, then the most recent lines that fit
+		heading := lines[0]
+		used = estimateTokens(heading)
+		var tail []string
+		for i := len(lines) - 1; i > 0; i-- {
+			cost := estimateTokens(lines[i]) + 1
+			if used+cost > room {
+				break
+			}
+			used += cost
+			tail = append([]string{lines[i]}, tail...)
+		}
+		if len(tail) == 0 {
+			return note(0) + "\n"
+		}
+		return heading + "\n" + note(len(tail)+1) + "\n" + strings.Join(tail, "\n") + "\n"
+	}
+
+	for _, line := range lines {
+		cost := estimateTokens(line) + 1
+		if used+cost > room {
+			break
+		}
+		used += cost
+		kept = append(kept, line)
+	}
+	if len(kept) == 0 {
+		return note(0) + "\n"
+	}
+	fences := 0
+	for _, line := range kept {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			fences++
+		}
+	}
+	if fences%2 == 1 {
+		kept = append(kept, "```")
+	}
+	return strings.Join(kept, "\n") + "\n" + note(len(kept)) + "\n"
+}
+
+// summarizeStoryMap drops passed stories from the story map, keeping the ones still in play.
+func summarizeStoryMap(storyMap string) string {
+	var kept []string
+	passed := 0
+	for _, line := range strings.Split(storyMap, "\n") {
+		if strings.HasPrefix(line, "✓ ") {
+			passed++
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if passed == 0 {
+		return storyMap
+	}
+	return strings.Join(append(kept, fmt.Sprintf("_(%d passed stories not listed)_", passed)), "\n")
+}
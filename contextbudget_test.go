@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	if got := estimateTokens(""); got != 0 {
+		t.Errorf("empty = %d", got)
+	}
+	if got := estimateTokens(strings.Repeat("a", 401)); got != 101 {
+		t.Errorf("401 bytes = %d, want 101", got)
+	}
+}
+
+func TestProviderConfig_PromptBudget(t *testing.T) {
+	tests := []struct {
+		p    ProviderConfig
+		want int
+	}{
+		{ProviderConfig{PromptMode: "stdin", ContextBudget: 80000}, 80000},
+		{ProviderConfig{PromptMode: "stdin"}, 0},
+		{ProviderConfig{PromptMode: "arg", ContextBudget: 80000}, maxArgPromptTokens},
+		{ProviderConfig{PromptMode: "arg", ContextBudget: 10000}, 10000},
+		{ProviderConfig{PromptMode: "file", ContextBudget: 80000}, 80000},
+	}
+	for _, tt := range tests {
+		if got := tt.p.promptBudget(); got != tt.want {
+			t.Errorf("%s/%d: promptBudget = %d, want %d", tt.p.PromptMode, tt.p.ContextBudget, got, tt.want)
+		}
+	}
+}
+
+// numberedLines returns n lines of roughly 10 tokens each.
+func numberedLines(prefix string, n int) string {
+	var lines []string
+	for i := 0; i < n; i++ {
+		lines = append(lines, fmt.Sprintf("%s line %03d of the section", prefix, i))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestFitPrompt_UnderBudgetUnchanged(t *testing.T) {
+	vars := map[string]string{"diffSummary": "small diff", "codebaseContext": "Go project"}
+	prompt, report := fitPrompt("run", vars, runPromptSections, 100000)
+	if !strings.Contains(prompt, "small diff") || len(report.Trimmed()) != 0 || report.Over() {
+		t.Errorf("expected nothing trimmed, got %v", report.Trimmed())
+	}
+	if report.Tokens != estimateTokens(prompt) || report.Sections[0].Tokens != estimateTokens("small diff") {
+		t.Errorf("unexpected report: %+v", report)
+	}
+}
+
+func TestFitPrompt_ShrinksInPriorityOrder(t *testing.T) {
+	vars := map[string]string{
+		"diffSummary":     "## Changes on Branch\n\n```\n" + numberedLines("diff", 200) + "\n```\n",
+		"codebaseContext": numberedLines("codebase", 200),
+		"retryInfo":       "**Previous Issue:** npm test failed",
+	}
+	base := estimateTokens(getPrompt("run", map[string]string{}))
+	budget := base + 2500 // room for the codebase and part of the diff
+
+	prompt, report := fitPrompt("run", vars, runPromptSections, budget)
+	if report.Over() || report.Tokens > budget {
+		t.Fatalf("prompt is %d tokens, budget %d", report.Tokens, budget)
+	}
+	if trimmed := report.Trimmed(); len(trimmed) != 1 || !strings.HasPrefix(trimmed[0], "diffSummary ") {
+		t.Errorf("expected only the diff summary trimmed, got %v", trimmed)
+	}
+	if !strings.Contains(prompt, "codebase line 199") || !strings.Contains(prompt, "npm test failed") {
+		t.Error("higher-priority sections should be intact")
+	}
+	if !strings.Contains(prompt, "diff line 000") || strings.Contains(prompt, "diff line 199") {
+		t.Error("expected the start of the diff summary kept and the end cut")
+	}
+	if !strings.Contains(prompt, "lines omitted to fit the prompt budget; run `git diff --stat`") {
+		t.Error("expected a note on what was omitted")
+	}
+	if strings.Count(vars["diffSummary"], "```")%2 != 0 {
+		t.Errorf("trimmed diff summary left a code fence open:\n%s", vars["diffSummary"])
+	}
+
+	// A tighter budget drops the diff entirely and moves on to the codebase context
+	vars = map[string]string{
+		"diffSummary":     numberedLines("diff", 200),
+		"codebaseContext": numberedLines("codebase", 200),
+	}
+	_, report = fitPrompt("run", vars, runPromptSections, base+1000)
+	if len(report.Trimmed()) != 2 || vars["diffSummary"] != "_(omitted to fit the prompt budget; run `git diff --stat` to see the branch's changes)_\n" {
+		t.Errorf("expected diff dropped and codebase trimmed, got %v / %q", report.Trimmed(), vars["diffSummary"])
+	}
+}
+
+func TestFitPrompt_OverBudgetReported(t *testing.T) {
+	_, report := fitPrompt("run", map[string]string{"storyDescription": strings.Repeat("x", 8000)}, runPromptSections, 100)
+	if !report.Over() {
+		t.Errorf("fixed content over the budget should be reported, got %+v", report)
+	}
+}
+
+func TestShrinkSection_KeepTail(t *testing.T) {
+	learnings := "## Learnings from Previous Work\n\n" + numberedLines("learning", 100)
+	got := shrinkSection(learnings, 200, promptSection{hint: "older learnings omitted", keepTail: true})
+	if !strings.HasPrefix(got, "## Learnings from Previous Work\n_(") {
+		t.Errorf("expected the heading kept ahead of the note, got %q", got[:80])
+	}
+	if !strings.Contains(got, "learning line 099") || strings.Contains(got, "learning line 000") {
+		t.Error("expected the most recent learnings kept")
+	}
+	if estimateTokens(got) > 200 {
+		t.Errorf("shrunk to %d tokens, target 200", estimateTokens(got))
+	}
+}
+
+func TestSummarizeStoryMap(t *testing.T) {
+	storyMap := "✓ US-001: Setup\n✓ US-002: Schema\n→ US-003: Login [CURRENT]\n○ US-004: Logout [depends on US-003]"
+	got := summarizeStoryMap(storyMap)
+	want := "→ US-003: Login [CURRENT]\n○ US-004: Logout [depends on US-003]\n_(2 passed stories not listed)_"
+	if got != want {
+		t.Errorf("summarizeStoryMap = %q, want %q", got, want)
+	}
+	if summarizeStoryMap("→ US-001: A [CURRENT]") != "→ US-001: A [CURRENT]" {
+		t.Error("a map with nothing passed should be unchanged")
+	}
+}
+
+func TestGenerateRunPrompt_FitsContextBudget(t *testing.T) {
+	cfg := &ResolvedConfig{Config: RalphConfig{
+		MaxRetries: 3,
+		Provider:   ProviderConfig{Command: "claude", PromptMode: "stdin", Timeout: 1800, ContextBudget: 3000},
+		Verify:     VerifyConfig{Default: []string{"go test ./..."}},
+	}}
+	story := &StoryDefinition{ID: "US-001", Title: "Login", AcceptanceCriteria: []string{"works"}}
+	def := &PRDDefinition{UserStories: []StoryDefinition{*story}}
+
+	prompt, report := generateRunPrompt(cfg, &FeatureDir{}, def, NewRunState(), story, numberedLines("codebase", 500), "", "")
+	if report.Budget != 3000 || report.Over() {
+		t.Errorf("expected the prompt fitted to 3000 tokens, got %d", report.Tokens)
+	}
+	if len(report.Trimmed()) != 1 || !strings.Contains(prompt, "explore the repository for the rest") {
+		t.Errorf("expected the codebase context trimmed, got %v", report.Trimmed())
+	}
+	if !strings.Contains(prompt, "**ID:** US-001") {
+		t.Error("story details are never trimmed")
+	}
+}
+
+func TestGenerateRunPrompt_RetryInfoKeepsFailure(t *testing.T) {
+	cfg := &ResolvedConfig{Config: RalphConfig{
+		MaxRetries: 5,
+		Provider:   ProviderConfig{Command: "claude", PromptMode: "stdin", Timeout: 1800},
+		Verify:     VerifyConfig{Default: []string{"go test ./..."}},
+	}}
+	story := &StoryDefinition{ID: "US-001", Title: "Login", AcceptanceCriteria: []string{"works"}}
+	def := &PRDDefinition{UserStories: []StoryDefinition{*story}}
+
+	// A long history of uncharged attempts ahead of the failure to fix
+	state := NewRunState()
+	for i := 0; i < 60; i++ {
+		state.RecordAttempt("US-001", AttemptRecord{Failure: FailureTransient, Reason: "Transient provider error: API Error: 529 overloaded", Uncharged: true}, cfg.Config.MaxRetries)
+	}
+	state.RecordAttempt("US-001", AttemptRecord{
+		Failure:       FailureTest,
+		FailedCommand: "go test ./...",
+		Reason:        "go test ./... failed:\n--- FAIL: TestLogin_RejectsExpiredToken",
+		FailedTests:   []TestFailure{{Name: "TestLogin_RejectsExpiredToken", File: "auth_test.go", Line: 42, Message: "token accepted"}},
+	}, cfg.Config.MaxRetries)
+
+	full, report := generateRunPrompt(cfg, &FeatureDir{}, def, state, story, "", "", "")
+	if !strings.Contains(full, "Attempt 1: transient") {
+		t.Fatal("expected the full prompt to list the attempt history")
+	}
+
+	cfg.Config.Provider.ContextBudget = report.Tokens - 300
+	prompt, report := generateRunPrompt(cfg, &FeatureDir{}, def, state, story, "", "", "")
+	if report.Over() || len(report.Trimmed()) != 1 || !strings.HasPrefix(report.Trimmed()[0], "retryInfo ") {
+		t.Fatalf("expected only retryInfo trimmed, got %v (%d tokens)", report.Trimmed(), report.Tokens)
+	}
+	for _, want := range []string{"TestLogin_RejectsExpiredToken (auth_test.go:42): token accepted", "**Previous Issue:** go test ./... failed"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("expected the failure to survive trimming: %q", want)
+		}
+	}
+	if strings.Contains(prompt, "Attempt 61:") {
+		t.Error("expected the end of the attempt history to give way first")
+	}
+}
@@ -1244,8 +1244,8 @@ func phase8PostRunAnalysis(t *testing.T, env *testEnv) {
 
 	// Check if run made any progress
 	runStarted := len(state.Passed) > 0 || len(state.Skipped) > 0 || len(state.Learnings) > 0
-	for _, r := range state.Retries {
-		if r > 0 {
+	for _, attempts := range state.Attempts {
+		if len(attempts) > 0 {
 			runStarted = true
 			break
 		}
@@ -1378,8 +1378,8 @@ func phase9PrdRefine(t *testing.T, env *testEnv) {
 				}
 			}
 			state.Skipped = newSkipped
-			delete(state.Retries, s.ID)
-			delete(state.LastFailure, s.ID)
+			delete(state.Attempts, s.ID)
+			delete(state.SkipReasons, s.ID)
 			modified = true
 			t.Logf("  Reset skipped story: %s", s.ID)
 		}
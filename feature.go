@@ -204,6 +204,16 @@ func (fd *FeatureDir) RunStatePath() string {
 	return filepath.Join(fd.Path, "run-state.json")
 }
 
+// VerifyCachePath returns the path to verify-cache.json
+func (fd *FeatureDir) VerifyCachePath() string {
+	return filepath.Join(fd.Path, "verify-cache.json")
+}
+
+// AttemptPatchPath returns the path where a rolled-back attempt's commits are saved
+func (fd *FeatureDir) AttemptPatchPath(storyID string, attempt int) string {
+	return filepath.Join(fd.Path, "attempts", fmt.Sprintf("%s-%d.patch", storyID, attempt))
+}
+
 // EnsureExists creates the feature directory if it doesn't exist
 func (fd *FeatureDir) EnsureExists() error {
 	return os.MkdirAll(fd.Path, 0755)
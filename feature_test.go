@@ -159,6 +159,9 @@ func TestFeatureDir_Paths(t *testing.T) {
 	if fd.PrdJsonPath() != "/project/.ralph/2024-01-15-auth/prd.json" {
 		t.Errorf("unexpected PrdJsonPath: %s", fd.PrdJsonPath())
 	}
+	if fd.AttemptPatchPath("US-002", 3) != "/project/.ralph/2024-01-15-auth/attempts/US-002-3.patch" {
+		t.Errorf("unexpected AttemptPatchPath: %s", fd.AttemptPatchPath("US-002", 3))
+	}
 }
 
 func TestFindFeatureDir_CaseInsensitive(t *testing.T) {
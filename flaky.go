@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FlakyEntry is a verify command, or one test it ran, that failed and then passed when
+// re-run with the code unchanged.
+type FlakyEntry struct {
+	Command   string    `json:"command"`
+	Test      string    `json:"test,omitempty"`     // failing test, when the output could be parsed
+	Location  string    `json:"location,omitempty"` // file:line of the test
+	Count     int       `json:"count"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// FlakyLog is .ralph/flaky.json, shared by every feature in the project.
+type FlakyLog struct {
+	Entries []FlakyEntry `json:"entries"`
+}
+
+// flakyMu serializes updates to flaky.json from verify commands running concurrently.
+var flakyMu sync.Mutex
+
+// flakyLogPath returns the path of the project's flaky log.
+func flakyLogPath(projectRoot string) string {
+	return filepath.Join(projectRoot, ".ralph", "flaky.json")
+}
+
+// LoadFlakyLog reads the flaky log; a missing file is an empty log.
+func LoadFlakyLog(path string) (*FlakyLog, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &FlakyLog{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var log FlakyLog
+	if err := json.Unmarshal(data, &log); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &log, nil
+}
+
+// SaveFlakyLog writes the flaky log atomically.
+func SaveFlakyLog(path string, log *FlakyLog) error {
+	data, err := json.MarshalIndent(log, "", "  ")
+	if err != nil {
+		return err
+	}
+	return AtomicWriteFile(path, append(data, '\n'))
+}
+
+// Record counts one flaky failure of cmd: an entry per failing test when they're known,
+// otherwise one for the command.
+func (l *FlakyLog) Record(cmd string, tests []TestFailure, at time.Time) {
+	if len(tests) == 0 {
+		tests = []TestFailure{{}}
+	}
+	for _, t := range tests {
+		e := l.find(cmd, t.Name)
+		if e == nil {
+			l.Entries = append(l.Entries, FlakyEntry{Command: cmd, Test: t.Name, FirstSeen: at})
+			e = &l.Entries[len(l.Entries)-1]
+		}
+		e.Count++
+		e.LastSeen = at
+		if loc := t.Location(); loc != "" {
+			e.Location = loc
+		}
+	}
+}
+
+func (l *FlakyLog) find(cmd, test string) *FlakyEntry {
+	for i := range l.Entries {
+		if l.Entries[i].Command == cmd && l.Entries[i].Test == test {
+			return &l.Entries[i]
+		}
+	}
+	return nil
+}
+
+// Clear removes entries whose command or test is match, or every entry when match is
+// empty. Returns how many were removed.
+func (l *FlakyLog) Clear(match string) int {
+	kept := l.Entries[:0]
+	for _, e := range l.Entries {
+		if match == "" || e.Command == match || e.Test == match {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	removed := len(l.Entries) - len(kept)
+	l.Entries = kept
+	return removed
+}
+
+// ForCommands returns the entries for the given commands, most frequent first.
+func (l *FlakyLog) ForCommands(cmds []string) []FlakyEntry {
+	want := make(map[string]bool, len(cmds))
+	for _, c := range cmds {
+		want[c] = true
+	}
+	var entries []FlakyEntry
+	for _, e := range l.Entries {
+		if want[e.Command] {
+			entries = append(entries, e)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Count > entries[j].Count })
+	return entries
+}
+
+// String describes an entry on one line.
+func (e FlakyEntry) String() string {
+	s := e.Command
+	if e.Test != "" {
+		s += ": " + e.Test
+		if e.Location != "" {
+			s += " (" + e.Location + ")"
+		}
+	}
+	return s
+}
+
+// recordFlaky adds a flaky failure to the project's flaky log.
+func recordFlaky(projectRoot, cmd string, tests []TestFailure) error {
+	flakyMu.Lock()
+	defer flakyMu.Unlock()
+	path := flakyLogPath(projectRoot)
+	log, err := LoadFlakyLog(path)
+	if err != nil {
+		return err
+	}
+	log.Record(cmd, tests, time.Now())
+	return SaveFlakyLog(path, log)
+}
+
+// rerunsFlaky reports whether a failure is worth re-running under verify.flakyRetries.
+// Build and lint failures don't change between runs of the same code.
+func rerunsFlaky(failure FailureClass) bool {
+	return failure == FailureTest || failure == FailureVerify || failure == FailureTimeout
+}
+
+// buildKnownFlakes notes the known flaky checks among cmds for a prompt ("" if none).
+func buildKnownFlakes(projectRoot string, cmds []string) string {
+	log, err := LoadFlakyLog(flakyLogPath(projectRoot))
+	if err != nil {
+		return ""
+	}
+	entries := log.ForCommands(cmds)
+	if len(entries) == 0 {
+		return ""
+	}
+	lines := []string{"", "", "Known flaky (failed, then passed when re-run on the same code). If one of these fails, re-run it before assuming your change broke it:"}
+	for i, e := range entries {
+		if i == maxTestsListed {
+			lines = append(lines, fmt.Sprintf("- ... and %d more", len(entries)-i))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("- %s — flaky %d time(s)", e, e.Count))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// cmdFlaky lists and clears the checks verification found to be flaky.
+func cmdFlaky(args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "Usage: ralph flaky [list | clear [command-or-test]]")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Checks that failed and then passed when re-run (verify.flakyRetries)")
+		fmt.Fprintln(os.Stderr, "are recorded in .ralph/flaky.json.")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Examples:")
+		fmt.Fprintln(os.Stderr, "  ralph flaky                                  # List known flaky checks")
+		fmt.Fprintln(os.Stderr, "  ralph flaky clear \"checkout > pays by card\"  # Forget one test (or every test of a command)")
+		fmt.Fprintln(os.Stderr, "  ralph flaky clear                            # Forget them all")
+	}
+
+	sub := "list"
+	if len(args) > 0 {
+		sub, args = args[0], args[1:]
+	}
+	path := flakyLogPath(GetProjectRoot())
+	log, err := LoadFlakyLog(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch sub {
+	case "list":
+		if len(log.Entries) == 0 {
+			fmt.Println("No flaky checks recorded.")
+			return
+		}
+		entries := append([]FlakyEntry{}, log.Entries...)
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].LastSeen.After(entries[j].LastSeen) })
+		fmt.Println("Flaky checks (failed, then passed when re-run):")
+		for _, e := range entries {
+			fmt.Printf("  %3d×  %s\n", e.Count, e)
+			fmt.Printf("        first %s, last %s\n", e.FirstSeen.Format("Jan 2 15:04"), e.LastSeen.Format("Jan 2 15:04"))
+		}
+		fmt.Println()
+		fmt.Println("Run 'ralph flaky clear' once they're fixed.")
+
+	case "clear":
+		match := strings.Join(args, " ")
+		removed := log.Clear(match)
+		if removed == 0 {
+			if match != "" {
+				fmt.Printf("No flaky entries match %q.\n", match)
+			} else {
+				fmt.Println("No flaky checks recorded.")
+			}
+			return
+		}
+		if err := SaveFlakyLog(path, log); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if removed == 1 {
+			fmt.Println("✓ Cleared 1 flaky entry")
+		} else {
+			fmt.Printf("✓ Cleared %d flaky entries\n", removed)
+		}
+
+	default:
+		usage()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFlakyLog_RecordAndClear(t *testing.T) {
+	log := &FlakyLog{}
+	first := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+	log.Record("npx playwright test", []TestFailure{{Name: "checkout > pays by card", File: "e2e/checkout.spec.ts", Line: 31}}, first)
+	log.Record("npx playwright test", []TestFailure{{Name: "checkout > pays by card"}, {Name: "login > remembers me"}}, first.Add(time.Hour))
+	log.Record("go test ./...", nil, first)
+
+	if len(log.Entries) != 3 {
+		t.Fatalf("expected an entry per test plus one for the command, got %+v", log.Entries)
+	}
+	e := log.Entries[0]
+	if e.Count != 2 || !e.FirstSeen.Equal(first) || !e.LastSeen.Equal(first.Add(time.Hour)) || e.Location != "e2e/checkout.spec.ts:31" {
+		t.Errorf("expected the repeat to be counted and keep its location, got %+v", e)
+	}
+	if got := e.String(); got != "npx playwright test: checkout > pays by card (e2e/checkout.spec.ts:31)" {
+		t.Errorf("String() = %q", got)
+	}
+	if got := log.Entries[2].String(); got != "go test ./..." {
+		t.Errorf("String() = %q", got)
+	}
+
+	entries := log.ForCommands([]string{"npx playwright test"})
+	if len(entries) != 2 || entries[0].Test != "checkout > pays by card" {
+		t.Errorf("expected the command's entries, most frequent first, got %+v", entries)
+	}
+
+	if n := log.Clear("login > remembers me"); n != 1 || len(log.Entries) != 2 {
+		t.Errorf("clearing a test should remove its entry, removed %d, left %+v", n, log.Entries)
+	}
+	if n := log.Clear("npx playwright test"); n != 1 {
+		t.Errorf("clearing a command should remove every entry for it, removed %d", n)
+	}
+	if n := log.Clear(""); n != 1 || len(log.Entries) != 0 {
+		t.Errorf("clearing with no match should remove everything, removed %d", n)
+	}
+}
+
+func TestRecordFlaky(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, ".ralph"), 0755)
+	if err := recordFlaky(root, "npm test", []TestFailure{{Name: "adds items"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordFlaky(root, "npm test", []TestFailure{{Name: "adds items"}}); err != nil {
+		t.Fatal(err)
+	}
+	log, err := LoadFlakyLog(flakyLogPath(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(log.Entries) != 1 || log.Entries[0].Count != 2 {
+		t.Errorf("expected one entry seen twice, got %+v", log.Entries)
+	}
+
+	if empty, err := LoadFlakyLog(filepath.Join(root, "missing.json")); err != nil || len(empty.Entries) != 0 {
+		t.Errorf("a missing log should be empty, got %+v %v", empty, err)
+	}
+}
+
+func TestRunStoryVerification_FlakyRetries(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, ".ralph"), 0755)
+	logger, err := NewRunLogger(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	// Fails the first time it runs, then passes
+	marker := filepath.Join(t.TempDir(), "ran")
+	flaky := `if [ -f ` + marker + ` ]; then exit 0; fi; touch ` + marker + `; echo 'not ok 1 - pays by card'; exit 1`
+	cfg := &ResolvedConfig{ProjectRoot: root, Config: RalphConfig{Verify: VerifyConfig{
+		Default:      []string{flaky, "echo 'undefined: x'; exit 2", "exit 1"},
+		FlakyRetries: 2,
+		Timeout:      30,
+	}}}
+	baseline := &VerifyBaseline{Failures: []BaselineFailure{{Command: "exit 1", Failure: FailureVerify}}}
+
+	result, err := runStoryVerification(cfg, nil, &StoryDefinition{ID: "US-001"}, nil, baseline, "", logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := result.commands[0]; !c.passed || !c.flaky {
+		t.Errorf("a command that passes on re-run should count as passed, got %+v", c)
+	}
+	if c := result.commands[1]; c.passed || c.failure != FailureCompile {
+		t.Errorf("compile failures should not be re-run, got %+v", c)
+	}
+	if c := result.commands[2]; !c.preExisting || c.flaky {
+		t.Errorf("pre-existing failures should not be re-run, got %+v", c)
+	}
+
+	log, _ := LoadFlakyLog(flakyLogPath(root))
+	if len(log.Entries) != 1 || log.Entries[0].Command != flaky || log.Entries[0].Test != "pays by card" {
+		t.Errorf("expected the flaky test to be recorded, got %+v", log.Entries)
+	}
+
+	// With re-runs off, the flake fails the story
+	os.Remove(marker)
+	cfg.Config.Verify.FlakyRetries = 0
+	cfg.Config.Verify.Default = []string{flaky}
+	result, _ = runStoryVerification(cfg, nil, &StoryDefinition{ID: "US-001"}, nil, nil, "", logger)
+	if result.passed {
+		t.Error("without verify.flakyRetries the first failure should count")
+	}
+}
+
+func TestRunStoryVerification_FlakyNarrowedCommand(t *testing.T) {
+	dir, git := initTestRepo(t)
+	commitFiles(t, dir, git, map[string]string{"src/app.ts": ""})
+	base := git.GetLastCommit()
+	commitFiles(t, dir, git, map[string]string{"src/app.ts": "export {}\n"})
+
+	logger, err := NewRunLogger(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	marker := filepath.Join(t.TempDir(), "ran")
+	cfg := &ResolvedConfig{ProjectRoot: dir, Config: RalphConfig{Verify: VerifyConfig{
+		Default:      []string{"npx jest"},
+		Affected:     map[string]string{"npx jest": `test -f ` + marker + ` || { touch ` + marker + `; exit 1; } # {{changedFiles}}`},
+		FlakyRetries: 1,
+		Timeout:      30,
+	}}}
+
+	result, err := runStoryVerification(cfg, nil, &StoryDefinition{ID: "US-001"}, nil, nil, base, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.passed {
+		t.Fatalf("expected the re-run to pass: %s", result.reason)
+	}
+	log, _ := LoadFlakyLog(flakyLogPath(dir))
+	if len(log.Entries) != 1 || log.Entries[0].Command != "npx jest" {
+		t.Errorf("a narrowed command's flake should be recorded under the configured command, got %+v", log.Entries)
+	}
+}
+
+func TestBuildKnownFlakes(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, ".ralph"), 0755)
+	if got := buildKnownFlakes(root, []string{"npm test"}); got != "" {
+		t.Errorf("expected nothing without a flaky log, got %q", got)
+	}
+
+	recordFlaky(root, "npx playwright test", []TestFailure{{Name: "checkout > pays by card", File: "e2e/checkout.spec.ts", Line: 31}})
+	recordFlaky(root, "npm run other", nil)
+
+	got := buildKnownFlakes(root, []string{"npm test", "npx playwright test"})
+	if !strings.Contains(got, "- npx playwright test: checkout > pays by card (e2e/checkout.spec.ts:31) — flaky 1 time(s)") {
+		t.Errorf("expected the flaky test, got %q", got)
+	}
+	if strings.Contains(got, "npm run other") {
+		t.Errorf("flakes of commands that don't run should be left out, got %q", got)
+	}
+
+	cfg := &ResolvedConfig{ProjectRoot: root, Config: RalphConfig{Verify: VerifyConfig{
+		Default: []string{"npm test"},
+		UI:      []string{"npx playwright test"},
+	}}}
+	state := NewRunState()
+	if list := buildVerifyList(cfg, state, &StoryDefinition{ID: "US-001"}); strings.Contains(list, "Known flaky") {
+		t.Errorf("UI flakes should only be listed for UI stories, got %q", list)
+	}
+	if list := buildVerifyList(cfg, state, &StoryDefinition{ID: "US-001", Tags: []string{"ui"}}); !strings.Contains(list, "Known flaky") {
+		t.Errorf("expected the UI story's verify list to note the flake, got %q", list)
+	}
+}
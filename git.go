@@ -222,20 +222,55 @@ func (g *GitOps) GetChangedFiles() []string {
 }
 
 // HasTestFileChanges returns true if any changed files look like test files.
-// Covers Go (_test.go), JS/TS (.test./.spec.), and Jest (__tests__/).
 func (g *GitOps) HasTestFileChanges() bool {
 	for _, f := range g.GetChangedFiles() {
-		lower := strings.ToLower(f)
-		if strings.Contains(lower, "_test.") ||
-			strings.Contains(lower, ".test.") ||
-			strings.Contains(lower, ".spec.") ||
-			strings.Contains(lower, "__tests__/") {
+		if isTestFile(f) {
 			return true
 		}
 	}
 	return false
 }
 
+// AddWorktree creates a detached worktree at path, checked out at commit.
+func (g *GitOps) AddWorktree(path, commit string) error {
+	_, err := g.run("worktree", "add", "--detach", path, commit)
+	return err
+}
+
+// RemoveWorktree removes a worktree, discarding any uncommitted changes in it.
+func (g *GitOps) RemoveWorktree(path string) error {
+	_, err := g.run("worktree", "remove", "--force", path)
+	return err
+}
+
+// PruneWorktrees removes administrative data for worktrees whose directories are gone
+// (e.g., left behind by an interrupted parallel run).
+func (g *GitOps) PruneWorktrees() error {
+	_, err := g.run("worktree", "prune")
+	return err
+}
+
+// CherryPickRange applies the commits in (from, to] onto HEAD.
+// On conflict the cherry-pick is aborted, leaving HEAD and the working tree unchanged.
+func (g *GitOps) CherryPickRange(from, to string) error {
+	if _, err := g.run("cherry-pick", from+".."+to); err != nil {
+		g.run("cherry-pick", "--abort")
+		return err
+	}
+	return nil
+}
+
+// FormatPatch returns the commits in (from, to] as a mailbox-format patch series.
+func (g *GitOps) FormatPatch(from, to string) (string, error) {
+	return g.run("format-patch", "--stdout", from+".."+to)
+}
+
+// ResetHard moves HEAD to commit and discards working tree changes.
+func (g *GitOps) ResetHard(commit string) error {
+	_, err := g.run("reset", "--hard", commit)
+	return err
+}
+
 // run executes a git command and returns the output
 func (g *GitOps) run(args ...string) (string, error) {
 	cmd := exec.Command("git", args...)
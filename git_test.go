@@ -938,3 +938,109 @@ func TestGitOps_EnsureBranch_ExistingBranchIgnoresStartPoint(t *testing.T) {
 		t.Errorf("expected branch 'ralph/feature', got '%s'", current)
 	}
 }
+
+// commitTestFile writes a file in dir and commits it.
+func commitTestFile(t *testing.T, dir, name, content, message string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{{"add", name}, {"commit", "-m", message}} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %s\n%s", args, err, out)
+		}
+	}
+}
+
+func TestGitOps_WorktreeCherryPick(t *testing.T) {
+	dir, git := initTestRepo(t)
+	base := git.GetLastCommit()
+
+	wtDir := filepath.Join(t.TempDir(), "US-001")
+	if err := git.AddWorktree(wtDir, base); err != nil {
+		t.Fatalf("AddWorktree failed: %v", err)
+	}
+	wtGit := NewGitOps(wtDir)
+	commitTestFile(t, wtDir, "a.txt", "a", "feat: a")
+	commitTestFile(t, wtDir, "b.txt", "b", "feat: b")
+
+	// Main tree is untouched by worktree commits
+	if git.GetLastCommit() != base {
+		t.Fatal("expected main HEAD to be unchanged by worktree commits")
+	}
+
+	if err := git.CherryPickRange(base, wtGit.GetLastCommit()); err != nil {
+		t.Fatalf("CherryPickRange failed: %v", err)
+	}
+	for _, f := range []string{"a.txt", "b.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, f)); err != nil {
+			t.Errorf("expected %s on main after cherry-pick", f)
+		}
+	}
+
+	if err := git.RemoveWorktree(wtDir); err != nil {
+		t.Fatalf("RemoveWorktree failed: %v", err)
+	}
+	if _, err := os.Stat(wtDir); !os.IsNotExist(err) {
+		t.Error("expected worktree directory to be removed")
+	}
+}
+
+func TestGitOps_CherryPickRange_ConflictAborts(t *testing.T) {
+	dir, git := initTestRepo(t)
+	base := git.GetLastCommit()
+
+	wtDir := filepath.Join(t.TempDir(), "US-002")
+	if err := git.AddWorktree(wtDir, base); err != nil {
+		t.Fatalf("AddWorktree failed: %v", err)
+	}
+	defer git.RemoveWorktree(wtDir)
+	commitTestFile(t, wtDir, "README.md", "from worktree", "worktree edit")
+	commitTestFile(t, dir, "README.md", "from main", "main edit")
+	mainHead := git.GetLastCommit()
+
+	if err := git.CherryPickRange(base, NewGitOps(wtDir).GetLastCommit()); err == nil {
+		t.Fatal("expected conflict error")
+	}
+	if git.GetLastCommit() != mainHead {
+		t.Error("expected HEAD unchanged after aborted cherry-pick")
+	}
+	if !git.IsWorkingTreeClean() {
+		t.Error("expected clean working tree after aborted cherry-pick")
+	}
+}
+
+func TestGitOps_ResetHard(t *testing.T) {
+	dir, git := initTestRepo(t)
+	base := git.GetLastCommit()
+	commitTestFile(t, dir, "x.txt", "x", "add x")
+
+	if err := git.ResetHard(base); err != nil {
+		t.Fatalf("ResetHard failed: %v", err)
+	}
+	if git.GetLastCommit() != base {
+		t.Error("expected HEAD back at base")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "x.txt")); !os.IsNotExist(err) {
+		t.Error("expected x.txt removed by reset")
+	}
+}
+
+func TestGitOps_FormatPatch(t *testing.T) {
+	dir, git := initTestRepo(t)
+	base := git.GetLastCommit()
+	commitTestFile(t, dir, "a.txt", "hello\n", "add a")
+	commitTestFile(t, dir, "b.txt", "world\n", "add b")
+
+	patch, err := git.FormatPatch(base, git.GetLastCommit())
+	if err != nil {
+		t.Fatalf("FormatPatch failed: %v", err)
+	}
+	for _, want := range []string{"Subject: [PATCH 1/2] add a", "Subject: [PATCH 2/2] add b", "+hello", "+world"} {
+		if !strings.Contains(patch, want) {
+			t.Errorf("patch should contain %q, got:\n%s", want, patch)
+		}
+	}
+}
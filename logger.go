@@ -37,6 +37,13 @@ const (
 	EventStateChange    EventType = "state_change"
 	EventLearning       EventType = "learning"
 	EventProviderLine   EventType = "provider_line"
+	EventSessionStart   EventType = "session_start"
+	EventToolCall       EventType = "tool_call"
+	EventFileEdit       EventType = "file_edit"
+	EventTokenUsage     EventType = "token_usage"
+	EventCompletion     EventType = "completion"
+	EventPromptBudget   EventType = "prompt_budget"
+	EventBackoff        EventType = "provider_backoff"
 	EventWarning        EventType = "warning"
 	EventError          EventType = "error"
 )
@@ -57,7 +64,7 @@ type Event struct {
 type RunLogger struct {
 	file         *os.File
 	encoder      *json.Encoder
-	mu           sync.Mutex
+	mu           *sync.Mutex // shared with story-scoped loggers from ForStory
 	runNumber    int
 	iteration    int
 	currentStory string
@@ -65,6 +72,7 @@ type RunLogger struct {
 	featureDir   string
 	enabled      bool
 	config       *LoggingConfig
+	prefix       string // console prefix for story-scoped loggers (parallel runs)
 
 	// Duration tracking
 	iterationStart time.Time
@@ -97,6 +105,7 @@ func NewRunLogger(featureDir string, config *LoggingConfig) (*RunLogger, error)
 	}
 
 	logger := &RunLogger{
+		mu:         &sync.Mutex{},
 		featureDir: featureDir,
 		startTime:  time.Now(),
 		enabled:    config.Enabled,
@@ -170,6 +179,27 @@ func (l *RunLogger) SetCurrentStory(id string) {
 	l.currentStory = id
 }
 
+// ForStory returns a logger bound to a single story that writes to the same log file.
+// Used by parallel runs so concurrent providers each get their own story ID, duration
+// tracking, and console prefix without interleaving partial events.
+func (l *RunLogger) ForStory(storyID string) *RunLogger {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return &RunLogger{
+		file:         l.file,
+		encoder:      l.encoder,
+		mu:           l.mu,
+		runNumber:    l.runNumber,
+		iteration:    l.iteration,
+		currentStory: storyID,
+		startTime:    l.startTime,
+		featureDir:   l.featureDir,
+		enabled:      l.enabled,
+		config:       l.config,
+		prefix:       "[" + storyID + "] ",
+	}
+}
+
 // logEvent is an internal helper that writes an event with all fields
 func (l *RunLogger) logEvent(event Event) {
 	if !l.enabled || l.file == nil {
@@ -287,6 +317,67 @@ func (l *RunLogger) ProviderLine(stream, line string) {
 	})
 }
 
+// ProviderActivity logs a structured event parsed from the provider's native output
+func (l *RunLogger) ProviderActivity(ev ProviderEvent) {
+	data := map[string]interface{}{}
+	var success *bool
+	switch ev.Type {
+	case EventSessionStart:
+		data["session_id"] = ev.SessionID
+	case EventToolCall:
+		data["tool"] = ev.Tool
+		if ev.Input != "" {
+			data["input"] = ev.Input
+		}
+	case EventFileEdit:
+		data["tool"] = ev.Tool
+		data["path"] = ev.Path
+	case EventTokenUsage:
+		data["input_tokens"] = ev.InputTokens
+		data["output_tokens"] = ev.OutputTokens
+		if ev.Cost > 0 {
+			data["cost"] = ev.Cost
+		}
+	case EventCompletion:
+		success = &ev.Success
+		if ev.Summary != "" {
+			data["summary"] = ev.Summary
+		}
+	}
+	l.logEvent(Event{
+		Type:    ev.Type,
+		Success: success,
+		Data:    data,
+	})
+}
+
+// PromptBudget logs the estimated size of a run prompt, per section, against its budget.
+func (l *RunLogger) PromptBudget(b *PromptBudget) {
+	sections := map[string]interface{}{}
+	for _, sec := range b.Sections {
+		sections[sec.Name] = map[string]int{"tokens": sec.Tokens, "final": sec.Final}
+	}
+	l.logEvent(Event{
+		Type: EventPromptBudget,
+		Data: map[string]interface{}{
+			"tokens":   b.Tokens,
+			"budget":   b.Budget,
+			"sections": sections,
+		},
+	})
+}
+
+// ProviderBackoff logs a wait after a transient provider error.
+func (l *RunLogger) ProviderBackoff(reason string, failures int, delay time.Duration) {
+	d := delay.Nanoseconds()
+	l.logEvent(Event{
+		Type:     EventBackoff,
+		Message:  reason,
+		Duration: &d,
+		Data:     map[string]interface{}{"failures": failures},
+	})
+}
+
 // MarkerDetected logs a detected marker
 func (l *RunLogger) MarkerDetected(marker, value string) {
 	l.logEvent(Event{
@@ -443,10 +534,10 @@ func (l *RunLogger) LogPrint(format string, args ...interface{}) {
 		}
 		if len(msg) > 0 {
 			timestamp := time.Now().Format("15:04:05")
-			fmt.Printf("[%s] %s", timestamp, msg)
+			fmt.Printf("[%s] %s%s", timestamp, l.prefix, msg)
 		}
 	} else {
-		fmt.Print(msg)
+		fmt.Print(l.prefix + msg)
 	}
 }
 
@@ -460,9 +551,9 @@ func (l *RunLogger) LogPrintln(args ...interface{}) {
 			msg = msg[1:]
 		}
 		timestamp := time.Now().Format("15:04:05")
-		fmt.Printf("[%s] %s\n", timestamp, msg)
+		fmt.Printf("[%s] %s%s\n", timestamp, l.prefix, msg)
 	} else {
-		fmt.Println(msg)
+		fmt.Println(l.prefix + msg)
 	}
 }
 
@@ -397,7 +397,91 @@ func TestRunLogger_ProviderLine(t *testing.T) {
 	}
 }
 
+func TestRunLogger_ForStory(t *testing.T) {
+	dir := t.TempDir()
+
+	logger, err := NewRunLogger(dir, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logger.SetIteration(3)
+
+	a := logger.ForStory("US-001")
+	b := logger.ForStory("US-002")
+	a.ProviderLine("stdout", "from a")
+	b.ProviderLine("stdout", "from b")
+	logger.Warning("shared")
+	logger.Close()
+
+	events, err := ReadEvents(logger.LogPath(), nil)
+	if err != nil {
+		t.Fatalf("failed to read events: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events in shared log, got %d", len(events))
+	}
+	if events[0].StoryID != "US-001" || events[1].StoryID != "US-002" {
+		t.Errorf("expected story IDs US-001/US-002, got %q/%q", events[0].StoryID, events[1].StoryID)
+	}
+	if events[2].StoryID != "" {
+		t.Errorf("expected parent logger to keep no story, got %q", events[2].StoryID)
+	}
+	if events[0].Iteration != 3 {
+		t.Errorf("expected iteration inherited from parent, got %d", events[0].Iteration)
+	}
+}
+
 // Helper to create bool pointer
 func ptrBool(b bool) *bool {
 	return &b
 }
+
+func TestRunLogger_ProviderActivity(t *testing.T) {
+	dir := t.TempDir()
+
+	logger, err := NewRunLogger(dir, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	logger.ProviderActivity(ProviderEvent{Type: EventFileEdit, Tool: "Edit", Path: "main.go"})
+	logger.ProviderActivity(ProviderEvent{Type: EventTokenUsage, InputTokens: 1200, OutputTokens: 80, Cost: 0.5})
+	logger.ProviderActivity(ProviderEvent{Type: EventCompletion, Success: true})
+	logger.Close()
+
+	events, err := ReadEvents(logger.LogPath(), nil)
+	if err != nil {
+		t.Fatalf("failed to read events: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+	if events[0].Type != EventFileEdit || events[0].Data["path"] != "main.go" {
+		t.Errorf("unexpected file_edit event: %+v", events[0])
+	}
+	if events[1].Type != EventTokenUsage || events[1].Data["input_tokens"] != float64(1200) || events[1].Data["cost"] != 0.5 {
+		t.Errorf("unexpected token_usage event: %+v", events[1])
+	}
+	if events[2].Type != EventCompletion || events[2].Success == nil || !*events[2].Success {
+		t.Errorf("unexpected completion event: %+v", events[2])
+	}
+}
+
+func TestRunLogger_PromptBudget(t *testing.T) {
+	logger, err := NewRunLogger(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logger.PromptBudget(&PromptBudget{Budget: 1000, Tokens: 990, Sections: []PromptSection{{Name: "codebaseContext", Tokens: 800, Final: 300}}})
+	logger.Close()
+
+	events, err := ReadEvents(logger.LogPath(), nil)
+	if err != nil || len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d (%v)", len(events), err)
+	}
+	sections, _ := events[0].Data["sections"].(map[string]interface{})
+	codebase, _ := sections["codebaseContext"].(map[string]interface{})
+	if events[0].Type != EventPromptBudget || events[0].Data["tokens"] != float64(990) || codebase["final"] != float64(300) {
+		t.Errorf("unexpected prompt_budget event: %+v", events[0])
+	}
+}
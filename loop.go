@@ -9,9 +9,11 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -29,6 +31,7 @@ var (
 	VerifyFailPattern    = regexp.MustCompile(`^<ralph>VERIFY_FAIL:(.+?)</ralph>$`)
 	SummaryStartPattern  = regexp.MustCompile(`^<ralph>SUMMARY_START</ralph>$`)
 	SummaryEndPattern    = regexp.MustCompile(`^<ralph>SUMMARY_END</ralph>$`)
+	QuestionPattern      = regexp.MustCompile(`^<ralph>QUESTION:(.+?)</ralph>$`)
 )
 
 // ProviderResult contains the result of a provider iteration
@@ -37,13 +40,35 @@ type ProviderResult struct {
 	Done      bool
 	Stuck     bool
 	StuckNote string
+	Questions []string // questions for a human; the story waits for answers
 	Learnings []string
 	ExitCode  int
 	TimedOut  bool
+	Stalled   bool     // killed after provider.idleTimeout without output
+	Errors    []string // last lines of stderr and reported errors, searched for transient errors
+
+	// Structured activity, when the provider's adapter parses its native output
+	ToolCalls     int
+	FilesEdited   []string
+	InputTokens   int
+	OutputTokens  int
+	ReportedCost  float64 // cost the CLI reported itself, in USD
+	SessionID     string  // id for resuming the session (provider.resume)
+	ErrorReported bool    // the CLI reported that the session failed
+}
+
+// RunOptions holds command-line options for a single `ralph run` invocation.
+type RunOptions struct {
+	Parallel      int           // max stories implemented concurrently in worktrees (<= 1 = serial)
+	MaxIterations int           // overrides budget.maxIterations when > 0
+	Deadline      time.Duration // overrides budget.maxDuration when > 0
+	MaxCost       float64       // overrides budget.maxCost when > 0
+	Story         string        // work on only this story for one attempt, ignoring priority and skips
+	PromptOut     string        // dry run: write the prompt here instead of printing it
 }
 
 // runLoop runs the main implementation loop for a feature
-func runLoop(cfg *ResolvedConfig, featureDir *FeatureDir) error {
+func runLoop(cfg *ResolvedConfig, featureDir *FeatureDir, opts RunOptions) error {
 	prdPath := featureDir.PrdJsonPath()
 	statePath := featureDir.RunStatePath()
 	git := NewGitOps(cfg.ProjectRoot)
@@ -58,6 +83,9 @@ func runLoop(cfg *ResolvedConfig, featureDir *FeatureDir) error {
 		return err
 	}
 
+	budget := newRunBudget(cfg.Config.Budget, opts, time.Now())
+	backoff := newTransientBackoff(cfg.Config.Transient)
+
 	// Create cleanup coordinator early for signal handling
 	cleanup := NewCleanupCoordinator()
 
@@ -105,6 +133,15 @@ func runLoop(cfg *ResolvedConfig, featureDir *FeatureDir) error {
 	fmt.Printf(" Branch: %s\n", def.BranchName)
 	fmt.Printf(" PRD: %s\n", prdPath)
 	fmt.Printf(" Project root: %s\n", cfg.ProjectRoot)
+	if opts.Parallel > 1 {
+		fmt.Printf(" Parallel: up to %d stories\n", opts.Parallel)
+	}
+	if limits := budget.describe(); limits != "" {
+		fmt.Printf(" Budget: %s\n", limits)
+	}
+	if opts.Story != "" {
+		fmt.Printf(" Story: %s only\n", opts.Story)
+	}
 	if logger.LogPath() != "" {
 		fmt.Printf(" Run: #%d (logs: %s)\n", logger.RunNumber(), logger.LogPath())
 	}
@@ -139,6 +176,39 @@ func runLoop(cfg *ResolvedConfig, featureDir *FeatureDir) error {
 	// Sync source code resources for detected frameworks
 	rm := ensureResourceSync(cfg, codebaseCtx)
 
+	// Record which verify commands already fail before any story touches the branch.
+	// Only taken on a fresh run; once stories have run, the branch is no longer a baseline.
+	if state.Baseline == nil && len(state.Attempted) == 0 && len(state.Passed) == 0 {
+		state.Baseline = runBaselineVerification(cfg, git, logger)
+		if err := SaveRunState(statePath, state); err != nil {
+			return fmt.Errorf("failed to save state: %w", err)
+		}
+		if cfg.Config.Commits.PrdChanges {
+			if commitErr := commitPrdOnly(cfg.ProjectRoot, statePath, "ralph: record verify baseline"); commitErr != nil {
+				logger.Warning("failed to commit state: " + commitErr.Error())
+			}
+		}
+	}
+
+	var parallel *parallelRun
+	if opts.Parallel > 1 {
+		parallel = &parallelRun{
+			cfg:         cfg,
+			featureDir:  featureDir,
+			def:         def,
+			statePath:   statePath,
+			git:         git,
+			logger:      logger,
+			cleanup:     cleanup,
+			svcMgr:      svcMgr,
+			rm:          rm,
+			codebaseCtx: codebaseCtx,
+			codebaseStr: codebaseStr,
+			budget:      budget,
+			backoff:     backoff,
+		}
+	}
+
 	iteration := 0
 	for {
 		iteration++
@@ -152,8 +222,35 @@ func runLoop(cfg *ResolvedConfig, featureDir *FeatureDir) error {
 			return err
 		}
 
+		// Block stories downstream of skipped dependencies instead of attempting them
+		if newlyBlocked := RefreshBlocked(def, state); len(newlyBlocked) > 0 {
+			for _, id := range newlyBlocked {
+				reason := state.GetBlockedReason(id)
+				logger.LogPrint("⊘ %s blocked: %s\n", id, reason)
+				logger.StateChange(id, "pending", "blocked", map[string]interface{}{"reason": reason})
+			}
+			if err := SaveRunState(statePath, state); err != nil {
+				return fmt.Errorf("failed to save state: %w", err)
+			}
+			if cfg.Config.Commits.PrdChanges {
+				if commitErr := commitPrdOnly(cfg.ProjectRoot, statePath, fmt.Sprintf("ralph: block %s", strings.Join(newlyBlocked, ", "))); commitErr != nil {
+					logger.Warning("failed to commit state: " + commitErr.Error())
+				}
+			}
+		}
+
+		// Single-story mode: exactly one pass at the named story, then report and exit
+		if opts.Story != "" && (iteration > 1 || state.IsPassed(opts.Story)) {
+			return finishSingleStory(opts.Story, state, logger)
+		}
+
 		// Check if all stories complete
-		if AllComplete(def, state) {
+		if opts.Story == "" && AllComplete(def, state) {
+			if err := runFullAffectedVerification(cfg, featureDir, def, state.Baseline, logger); err != nil {
+				logger.RunEnd(false, "full verification failed")
+				return err
+			}
+
 			logger.LogPrintln()
 			fmt.Println(strings.Repeat("=", 60))
 			logger.LogPrintln(" All stories complete!")
@@ -168,6 +265,17 @@ func runLoop(cfg *ResolvedConfig, featureDir *FeatureDir) error {
 				}
 			}
 
+			// List stories that were never attempted because a dependency was skipped
+			if CountBlocked(state) > 0 {
+				logger.LogPrintln()
+				logger.LogPrint("Blocked stories (%d):\n", CountBlocked(state))
+				for _, s := range def.UserStories {
+					if reason := state.GetBlockedReason(s.ID); reason != "" {
+						logger.LogPrint("  - %s: %s (%s)\n", s.ID, s.Title, reason)
+					}
+				}
+			}
+
 			// Check if knowledge file was updated
 			knowledgeFile := cfg.Config.Provider.KnowledgeFile
 			if knowledgeFile != "" && !git.HasFileChanged(knowledgeFile) {
@@ -181,8 +289,27 @@ func runLoop(cfg *ResolvedConfig, featureDir *FeatureDir) error {
 			return nil
 		}
 
+		// Nothing is ready while stories wait on answers: stop and say what to answer
+		story := storyForRun(def, state, opts.Story)
+		if awaiting := GetAwaitingInput(def, state); story == nil && len(awaiting) > 0 {
+			logger.LogPrintln()
+			fmt.Println(strings.Repeat("=", 60))
+			logger.LogPrintln(" ? Waiting for answers")
+			fmt.Println(strings.Repeat("=", 60))
+			logger.LogPrintln()
+			for _, id := range awaiting {
+				logger.LogPrint("  - %s: %s\n", id, GetStoryByID(def, id).Title)
+				for _, q := range state.PendingQuestions(id) {
+					logger.LogPrint("    └─ %s\n", q.Question)
+				}
+			}
+			logger.LogPrintln()
+			logger.LogPrintln("Answer with 'ralph answer " + featureDir.Feature + "', then run 'ralph run " + featureDir.Feature + "' to continue.")
+			logger.RunEnd(false, "awaiting input")
+			return fmt.Errorf("stories are awaiting input")
+		}
+
 		// Check if all remaining stories are skipped (no next story)
-		story := GetNextStory(def, state)
 		if story == nil {
 			logger.LogPrintln()
 			fmt.Println(strings.Repeat("=", 60))
@@ -199,18 +326,48 @@ func runLoop(cfg *ResolvedConfig, featureDir *FeatureDir) error {
 					}
 				}
 			}
+			for _, s := range def.UserStories {
+				if reason := state.GetBlockedReason(s.ID); reason != "" {
+					logger.LogPrint("  - %s: %s (blocked: %s)\n", s.ID, s.Title, reason)
+				}
+			}
 			logger.LogPrintln()
 			logger.LogPrintln("Manual intervention required.")
 			logger.RunEnd(false, "all remaining stories skipped")
 			return fmt.Errorf("all remaining stories skipped")
 		}
 
+		// Stop at an iteration boundary once a budget is used up. State is saved after
+		// every iteration, so a later `ralph run` resumes from here.
+		if reason := budget.exhausted(state.Cost, time.Now()); reason != "" {
+			logger.LogPrintln()
+			fmt.Println(strings.Repeat("=", 60))
+			logger.LogPrint(" ! Stopping: %s\n", reason)
+			fmt.Println(strings.Repeat("=", 60))
+			logger.LogPrintln()
+			logger.LogPrintln("Progress is saved. Run 'ralph run " + featureDir.Feature + "' to resume (raise the budget if it is a config limit).")
+			logger.RunEnd(false, "budget exhausted: "+reason)
+			return fmt.Errorf("stopped early: %s", reason)
+		}
+
+		// Parallel mode: run independent stories side by side in worktrees when more than
+		// one is ready; otherwise fall through to the regular single-story iteration.
+		if parallel != nil {
+			if batch := selectParallelBatch(def, state, budget.sessionsLeft(opts.Parallel)); len(batch) > 1 {
+				if err := parallel.runBatch(state, batch, iteration); err != nil {
+					logger.RunEnd(false, err.Error())
+					return err
+				}
+				continue
+			}
+		}
+
 		// Verify-at-top: if this story already passes, mark it and skip implementation.
 		// Only runs for stories that were previously attempted (given to a provider).
 		// This prevents false positives where a story's generic tests pass vacuously
 		// before any story-specific implementation exists on the branch.
 		if !state.IsPassed(story.ID) && state.IsAttempted(story.ID) {
-			verifyResult, verifyErr := runStoryVerification(cfg, featureDir, story, svcMgr, logger)
+			verifyResult, verifyErr := runStoryVerification(cfg, featureDir, story, svcMgr, state.Baseline, state.StoryBase(story.ID, ""), logger)
 			if verifyErr == nil && verifyResult.passed {
 				logger.LogPrint("\n✓ %s already passes verification, marking complete\n", story.ID)
 				state.MarkPassed(story.ID)
@@ -247,51 +404,48 @@ func runLoop(cfg *ResolvedConfig, featureDir *FeatureDir) error {
 
 		// Capture commit hash AFTER state commit, BEFORE provider runs
 		preRunCommit := git.GetLastCommit()
+		attemptCfg := attemptConfig(cfg, state, story, logger)
+		attempt := AttemptRecord{PreCommit: preRunCommit, Provider: attemptCfg.Config.Provider.Command}
+
+		// Generate and send prompt: a short follow-up when continuing the last session
+		var prompt string
+		if session := resumeSession(attemptCfg, state, story.ID); session != "" {
+			logger.LogPrint("Resuming provider session %s\n", session)
+			attemptCfg = attemptCfg.WithSession(session)
+			attempt.Resumed = true
+			prompt = generateResumePrompt(attemptCfg, state, story)
+		} else {
+			// Compute diff summary per-iteration (changes as provider commits)
+			diffSummary := ""
+			if diffStat := git.GetDiffSummary(); diffStat != "" {
+				diffSummary = "## Changes on Branch\n\n```\n" + truncateOutput(diffStat, 60) + "\n```\n"
+			}
 
-		// Compute diff summary per-iteration (changes as provider commits)
-		diffSummary := ""
-		if diffStat := git.GetDiffSummary(); diffStat != "" {
-			diffSummary = "## Changes on Branch\n\n```\n" + truncateOutput(diffStat, 60) + "\n```\n"
-		}
+			// Resource consultation (before spawning main agent)
+			resourceGuidance := buildStoryGuidance(cfg, featureDir, story, rm, codebaseCtx, logger)
 
-		// Resource consultation (before spawning main agent)
-		var resourceGuidance string
-		if rm != nil && rm.HasDetectedResources() {
-			consultResult := ConsultResources(context.Background(), cfg, story, rm, codebaseCtx, featureDir.Path)
-			resourceGuidance = FormatGuidance(consultResult)
-			if len(consultResult.Consultations) > 0 {
-				logger.LogPrint("  Consulted %d framework(s) for %s\n", len(consultResult.Consultations), story.ID)
-			}
-		} else {
-			resourceGuidance = buildResourceFallbackInstructions()
+			var size *PromptBudget
+			prompt, size = generateRunPrompt(attemptCfg, featureDir, def, state, story, codebaseStr, diffSummary, resourceGuidance)
+			logPromptBudget(logger, size)
 		}
-
-		// Generate and send prompt
-		prompt := generateRunPrompt(cfg, featureDir, def, state, story, codebaseStr, diffSummary, resourceGuidance)
 		logger.LogPrintln("Provider running...")
 		logger.ProviderStart()
-		providerStartTime := time.Now()
-		result, err := runProvider(cfg, prompt, logger, cleanup)
+		attempt.StartedAt = time.Now()
+		budget.startSessions(1)
+		result, err := runProvider(attemptCfg, prompt, &mcpContext{story: story, services: svcMgr, baseline: state.Baseline, base: state.StoryBase(story.ID, preRunCommit)}, logger, cleanup)
 
-		// Collect markers for logging
-		var detectedMarkers []string
+		logProviderEnd(logger, result)
+		logger.LogPrint("Provider done (%s)\n", FormatDuration(time.Since(attempt.StartedAt)))
 		if result != nil {
-			if result.Done {
-				detectedMarkers = append(detectedMarkers, "DONE")
-			}
-			if result.Stuck {
-				detectedMarkers = append(detectedMarkers, "STUCK")
-			}
-			if len(result.Learnings) > 0 {
-				detectedMarkers = append(detectedMarkers, "LEARNING")
+			attempt.ExitCode = result.ExitCode
+			attempt.Markers = providerMarkers(result)
+			attempt.Session = result.SessionID
+			attempt.Cost = budget.attemptCost(result)
+			if attempt.Cost > 0 {
+				state.Cost += attempt.Cost
+				logger.LogPrint("Cost: $%.2f (feature total: $%.2f)\n", attempt.Cost, state.Cost)
 			}
 		}
-		if result != nil {
-			logger.ProviderEnd(result.ExitCode, result.TimedOut, detectedMarkers)
-		} else {
-			logger.ProviderEnd(0, false, nil)
-		}
-		logger.LogPrint("Provider done (%s)\n", FormatDuration(time.Since(providerStartTime)))
 
 		// Process learnings even on error
 		if result != nil {
@@ -301,8 +455,45 @@ func runLoop(cfg *ResolvedConfig, featureDir *FeatureDir) error {
 			}
 		}
 
+		// Rate limits and other transient errors: back off and try the story again
+		// without charging a retry. A burst of them shouldn't end an unattended run.
+		if reason := backoff.classify(result, err); reason != "" {
+			logger.LogPrint("\n! Transient provider error on %s: %s\n", story.ID, reason)
+			logger.Warning("transient provider error: " + reason)
+			attempt.Failure = FailureTransient
+			attempt.Reason = "Transient provider error: " + reason
+			attempt.Uncharged = true
+			finishAttempt(&attempt, git)
+			state.RecordAttempt(story.ID, attempt, cfg.Config.MaxRetries)
+			if err := SaveRunState(statePath, state); err != nil {
+				logger.IterationEnd(false)
+				return fmt.Errorf("failed to save state: %w", err)
+			}
+			if cfg.Config.Commits.PrdChanges {
+				if commitErr := commitPrdOnly(cfg.ProjectRoot, statePath, fmt.Sprintf("ralph: %s transient provider error", story.ID)); commitErr != nil {
+					logger.Warning("failed to commit state: " + commitErr.Error())
+				}
+			}
+			logger.IterationEnd(false)
+			if err := waitTransient(backoff, budget, reason, logger); err != nil {
+				logger.RunEnd(false, "provider error")
+				return err
+			}
+			continue
+		}
+		backoff.reset()
+
 		if err != nil {
 			logger.Error("provider error", err)
+			// Record the attempt for history, but a provider failure is not the story's fault
+			attempt.Failure = FailureProvider
+			if result != nil && result.TimedOut {
+				attempt.Failure = FailureTimeout
+			}
+			attempt.Reason = err.Error()
+			attempt.Uncharged = true
+			finishAttempt(&attempt, git)
+			state.RecordAttempt(story.ID, attempt, cfg.Config.MaxRetries)
 			if saveErr := SaveRunState(statePath, state); saveErr != nil {
 				fmt.Fprintf(os.Stderr, "Warning: failed to save state: %v\n", saveErr)
 			}
@@ -316,6 +507,59 @@ func runLoop(cfg *ResolvedConfig, featureDir *FeatureDir) error {
 			return fmt.Errorf("provider error: %w", err)
 		}
 
+		// A question for a human parks the story until it's answered; other stories carry
+		// on. Asking isn't a failure, so no retry is charged and the work stays on the branch.
+		if len(result.Questions) > 0 && !result.Done {
+			question := strings.Join(result.Questions, "\n")
+			logger.LogPrint("\n? %s is awaiting input: %s\n", story.ID, question)
+			logger.LogPrint("  Answer with: ralph answer %s %s \"...\"\n", featureDir.Feature, story.ID)
+			logger.StateChange(story.ID, "pending", "awaiting_input", map[string]interface{}{"question": question})
+			for _, q := range result.Questions {
+				state.AskQuestion(story.ID, q)
+			}
+			attempt.Failure = FailureQuestion
+			attempt.Reason = "Question: " + question
+			attempt.Uncharged = true
+			finishAttempt(&attempt, git)
+			state.RecordAttempt(story.ID, attempt, cfg.Config.MaxRetries)
+			if err := SaveRunState(statePath, state); err != nil {
+				logger.IterationEnd(false)
+				return fmt.Errorf("failed to save state: %w", err)
+			}
+			if cfg.Config.Commits.PrdChanges {
+				if commitErr := commitPrdOnly(cfg.ProjectRoot, statePath, fmt.Sprintf("ralph: %s awaiting input", story.ID)); commitErr != nil {
+					logger.Warning("failed to commit state: " + commitErr.Error())
+				}
+			}
+			logger.IterationEnd(false)
+			continue
+		}
+
+		// Check for a stalled provider (killed by the idle watchdog)
+		if result.Stalled {
+			reason := fmt.Sprintf("Provider stalled: no output for %ds, process killed. It may have been waiting on an interactive prompt or permission request.", attemptCfg.Config.Provider.IdleTimeout)
+			logger.LogPrint("\n! Provider stalled on %s (no output for %ds)\n", story.ID, attemptCfg.Config.Provider.IdleTimeout)
+			logger.StateChange(story.ID, "pending", "failed", map[string]interface{}{"reason": reason})
+			attempt.Failure = FailureStalled
+			attempt.Reason = reason
+			finishAttempt(&attempt, git)
+			if rollbackEnabled(cfg, false) {
+				rollbackAttempt(git, featureDir, state, story.ID, &attempt, logger)
+			}
+			state.RecordAttempt(story.ID, attempt, cfg.Config.MaxRetries)
+			if err := SaveRunState(statePath, state); err != nil {
+				logger.IterationEnd(false)
+				return fmt.Errorf("failed to save state: %w", err)
+			}
+			if cfg.Config.Commits.PrdChanges {
+				if commitErr := commitPrdOnly(cfg.ProjectRoot, statePath, fmt.Sprintf("ralph: %s stalled", story.ID)); commitErr != nil {
+					logger.Warning("failed to commit state: " + commitErr.Error())
+				}
+			}
+			logger.IterationEnd(false)
+			continue
+		}
+
 		// Check for STUCK marker
 		if result.Stuck {
 			reason := result.StuckNote
@@ -324,7 +568,13 @@ func runLoop(cfg *ResolvedConfig, featureDir *FeatureDir) error {
 			}
 			logger.LogPrint("\n! Provider stuck on %s: %s\n", story.ID, reason)
 			logger.StateChange(story.ID, "pending", "failed", map[string]interface{}{"reason": reason})
-			state.MarkFailed(story.ID, reason, cfg.Config.MaxRetries)
+			attempt.Failure = FailureStuck
+			attempt.Reason = reason
+			finishAttempt(&attempt, git)
+			if rollbackEnabled(cfg, false) {
+				rollbackAttempt(git, featureDir, state, story.ID, &attempt, logger)
+			}
+			state.RecordAttempt(story.ID, attempt, cfg.Config.MaxRetries)
 			if err := SaveRunState(statePath, state); err != nil {
 				logger.IterationEnd(false)
 				return fmt.Errorf("failed to save state: %w", err)
@@ -342,7 +592,13 @@ func runLoop(cfg *ResolvedConfig, featureDir *FeatureDir) error {
 		if !result.Done {
 			logger.LogPrintln("\nProvider did not signal completion. Retrying...")
 			logger.Warning("provider did not signal completion")
-			state.MarkFailed(story.ID, "Provider did not signal completion", cfg.Config.MaxRetries)
+			attempt.Failure = FailureNoSignal
+			attempt.Reason = "Provider did not signal completion"
+			finishAttempt(&attempt, git)
+			if rollbackEnabled(cfg, false) {
+				rollbackAttempt(git, featureDir, state, story.ID, &attempt, logger)
+			}
+			state.RecordAttempt(story.ID, attempt, cfg.Config.MaxRetries)
 			if err := SaveRunState(statePath, state); err != nil {
 				logger.IterationEnd(false)
 				return fmt.Errorf("failed to save state: %w", err)
@@ -360,7 +616,10 @@ func runLoop(cfg *ResolvedConfig, featureDir *FeatureDir) error {
 		if !git.HasNewCommitSince(preRunCommit) {
 			logger.LogPrintln("\n! Provider signaled DONE but made no new commit.")
 			logger.Warning("provider signaled DONE but made no new commit")
-			state.MarkFailed(story.ID, "No commit made — provider signaled DONE without committing code", cfg.Config.MaxRetries)
+			attempt.Failure = FailureNoCommit
+			attempt.Reason = "No commit made — provider signaled DONE without committing code"
+			finishAttempt(&attempt, git)
+			state.RecordAttempt(story.ID, attempt, cfg.Config.MaxRetries)
 			if err := SaveRunState(statePath, state); err != nil {
 				logger.IterationEnd(false)
 				return fmt.Errorf("failed to save state: %w", err)
@@ -383,7 +642,7 @@ func runLoop(cfg *ResolvedConfig, featureDir *FeatureDir) error {
 		// Run verification
 		logger.LogPrintln("\nRunning verification...")
 		logger.VerifyStart()
-		verifyResult, err := runStoryVerification(cfg, featureDir, story, svcMgr, logger)
+		verifyResult, err := runStoryVerification(cfg, featureDir, story, svcMgr, state.Baseline, state.StoryBase(story.ID, attempt.PreCommit), logger)
 		if err != nil {
 			logger.Error("verification error", err)
 			logger.VerifyEnd(false)
@@ -397,7 +656,15 @@ func runLoop(cfg *ResolvedConfig, featureDir *FeatureDir) error {
 			logger.LogPrint("\nVerification failed: %s\n", verifyResult.reason)
 			logger.VerifyEnd(false)
 			logger.StateChange(story.ID, "pending", "failed", map[string]interface{}{"reason": verifyResult.reason})
-			state.MarkFailed(story.ID, verifyResult.reason, cfg.Config.MaxRetries)
+			attempt.Failure = verifyResult.failure
+			attempt.FailedCommand = verifyResult.failedCmd
+			attempt.FailedTests = verifyResult.failedTests
+			attempt.Reason = verifyResult.reason
+			finishAttempt(&attempt, git)
+			if rollbackEnabled(cfg, true) {
+				rollbackAttempt(git, featureDir, state, story.ID, &attempt, logger)
+			}
+			state.RecordAttempt(story.ID, attempt, cfg.Config.MaxRetries)
 			if err := SaveRunState(statePath, state); err != nil {
 				logger.IterationEnd(false)
 				return fmt.Errorf("failed to save state: %w", err)
@@ -413,7 +680,9 @@ func runLoop(cfg *ResolvedConfig, featureDir *FeatureDir) error {
 		logger.VerifyEnd(true)
 
 		// Story passed!
-		state.MarkPassed(story.ID)
+		attempt.Passed = true
+		finishAttempt(&attempt, git)
+		state.RecordAttempt(story.ID, attempt, cfg.Config.MaxRetries)
 		logger.StateChange(story.ID, "pending", "passed", nil)
 
 		if err := SaveRunState(statePath, state); err != nil {
@@ -432,6 +701,162 @@ func runLoop(cfg *ResolvedConfig, featureDir *FeatureDir) error {
 	}
 }
 
+// storyForRun returns the story to work on next: the one named by --story, regardless
+// of priority, skips, or dependencies, or else the next ready story.
+func storyForRun(def *PRDDefinition, state *RunState, storyID string) *StoryDefinition {
+	if storyID != "" {
+		return GetStoryByID(def, storyID)
+	}
+	return GetNextStory(def, state)
+}
+
+// finishSingleStory reports the outcome of a --story run. Returns an error unless the
+// story passed, so scripts can tell the two apart.
+func finishSingleStory(storyID string, state *RunState, logger *RunLogger) error {
+	logger.LogPrintln()
+	if state.IsPassed(storyID) {
+		logger.LogPrint("✓ %s passed\n", storyID)
+		logger.RunEnd(true, storyID+" passed")
+		return nil
+	}
+	reason := state.GetLastFailure(storyID)
+	if i := strings.Index(reason, "\n"); i >= 0 {
+		reason = reason[:i]
+	}
+	logger.LogPrint("✗ %s did not pass: %s\n", storyID, reason)
+	logger.RunEnd(false, storyID+" did not pass")
+	return fmt.Errorf("%s did not pass", storyID)
+}
+
+// runDryRun renders the prompt the next iteration would send, consultation guidance
+// included, and prints it (or writes it to opts.PromptOut). Nothing else happens: no
+// provider, no branch switch, no services, no state changes.
+func runDryRun(cfg *ResolvedConfig, featureDir *FeatureDir, opts RunOptions) error {
+	def, err := LoadPRDDefinition(featureDir.PrdJsonPath())
+	if err != nil {
+		return err
+	}
+	state, err := LoadRunState(featureDir.RunStatePath())
+	if err != nil {
+		return err
+	}
+	RefreshBlocked(def, state)
+
+	story := storyForRun(def, state, opts.Story)
+	if story == nil {
+		return fmt.Errorf("no story is ready to run (all passed, skipped, or blocked)")
+	}
+
+	git := NewGitOps(cfg.ProjectRoot)
+	if branch, err := git.CurrentBranch(); err == nil && branch != def.BranchName {
+		fmt.Fprintf(os.Stderr, "Note: on branch %s, not %s; the diff summary reflects the current checkout.\n", branch, def.BranchName)
+	}
+
+	// Console-only logger: a dry run leaves no run log behind
+	logger, err := NewRunLogger(featureDir.Path, &LoggingConfig{Enabled: false})
+	if err != nil {
+		return err
+	}
+
+	codebaseCtx := DiscoverCodebase(cfg.ProjectRoot, &cfg.Config)
+	codebaseStr := FormatCodebaseContext(codebaseCtx)
+	rm := ensureResourceSync(cfg, codebaseCtx)
+
+	diffSummary := ""
+	if diffStat := git.GetDiffSummary(); diffStat != "" {
+		diffSummary = "## Changes on Branch\n\n```\n" + truncateOutput(diffStat, 60) + "\n```\n"
+	}
+
+	attemptCfg := attemptConfig(cfg, state, story, logger)
+	var prompt string
+	if session := resumeSession(attemptCfg, state, story.ID); session != "" {
+		fmt.Printf("Dry run: the provider would resume session %s\n", session)
+		prompt = generateResumePrompt(attemptCfg, state, story)
+	} else {
+		resourceGuidance := buildStoryGuidance(cfg, featureDir, story, rm, codebaseCtx, logger)
+		var size *PromptBudget
+		prompt, size = generateRunPrompt(attemptCfg, featureDir, def, state, story, codebaseStr, diffSummary, resourceGuidance)
+		logPromptBudget(logger, size)
+	}
+
+	if opts.PromptOut != "" {
+		if err := os.WriteFile(opts.PromptOut, []byte(prompt), 0644); err != nil {
+			return fmt.Errorf("failed to write prompt: %w", err)
+		}
+		fmt.Printf("Dry run: prompt for %s (%s) written to %s (%d bytes)\n", story.ID, attemptCfg.Config.Provider.Command, opts.PromptOut, len(prompt))
+		return nil
+	}
+
+	fmt.Printf("Dry run: prompt for %s - %s (provider: %s)\n", story.ID, story.Title, attemptCfg.Config.Provider.Command)
+	fmt.Println(strings.Repeat("=", 60))
+	fmt.Print(prompt)
+	if !strings.HasSuffix(prompt, "\n") {
+		fmt.Println()
+	}
+	return nil
+}
+
+// buildStoryGuidance runs resource consultation for a story, falling back to generic
+// instructions when no framework resources were detected.
+func buildStoryGuidance(cfg *ResolvedConfig, featureDir *FeatureDir, story *StoryDefinition, rm *ResourceManager, codebaseCtx *CodebaseContext, logger *RunLogger) string {
+	if rm == nil || !rm.HasDetectedResources() {
+		return buildResourceFallbackInstructions()
+	}
+	consultResult := ConsultResources(context.Background(), cfg, story, rm, codebaseCtx, featureDir.Path)
+	if len(consultResult.Consultations) > 0 {
+		logger.LogPrint("  Consulted %d framework(s) for %s\n", len(consultResult.Consultations), story.ID)
+	}
+	return FormatGuidance(consultResult)
+}
+
+// providerMarkers lists the marker types detected in a provider result.
+func providerMarkers(result *ProviderResult) []string {
+	var markers []string
+	if result.Done {
+		markers = append(markers, "DONE")
+	}
+	if result.Stuck {
+		markers = append(markers, "STUCK")
+	}
+	if len(result.Questions) > 0 {
+		markers = append(markers, "QUESTION")
+	}
+	if len(result.Learnings) > 0 {
+		markers = append(markers, "LEARNING")
+	}
+	return markers
+}
+
+// logProviderEnd logs the provider_end event with the markers detected in result.
+func logProviderEnd(logger *RunLogger, result *ProviderResult) {
+	if result == nil {
+		logger.ProviderEnd(0, false, nil)
+		return
+	}
+	logger.ProviderEnd(result.ExitCode, result.TimedOut, providerMarkers(result))
+	if summary := result.activitySummary(); summary != "" {
+		logger.LogPrint("Activity: %s\n", summary)
+	}
+}
+
+// logPromptBudget records a run prompt's size breakdown and reports on the console when
+// sections were trimmed to fit the provider's context budget, or still don't fit.
+func logPromptBudget(logger *RunLogger, b *PromptBudget) {
+	logger.PromptBudget(b)
+	if trimmed := b.Trimmed(); len(trimmed) > 0 {
+		logger.LogPrint("Prompt trimmed to ~%s tokens (budget %s): %s\n", formatTokens(b.Tokens), formatTokens(b.Budget), strings.Join(trimmed, ", "))
+	}
+	if b.Over() {
+		logger.Warning(fmt.Sprintf("prompt is ~%s tokens, over the provider's %s token context budget even after trimming", formatTokens(b.Tokens), formatTokens(b.Budget)))
+	}
+}
+
+// finishAttempt stamps the end time and resulting HEAD on an attempt record.
+func finishAttempt(a *AttemptRecord, git *GitOps) {
+	a.EndedAt = time.Now()
+	a.PostCommit = git.GetLastCommit()
+}
+
 // buildProviderArgs builds the final argument list for a provider subprocess.
 func buildProviderArgs(baseArgs []string, promptMode, promptFlag, prompt string) (args []string, promptFile string, err error) {
 	args = append([]string{}, baseArgs...)
@@ -464,14 +889,46 @@ func buildProviderArgs(baseArgs []string, promptMode, promptFlag, prompt string)
 	return args, promptFile, nil
 }
 
-// runProvider runs the provider with the given prompt
-func runProvider(cfg *ResolvedConfig, prompt string, logger *RunLogger, cleanup *CleanupCoordinator) (*ProviderResult, error) {
+// runProvider runs the provider with the given prompt. With provider.mcp set, tools gives
+// the session's ralph MCP tools their story; nil runs without them.
+func runProvider(cfg *ResolvedConfig, prompt string, tools *mcpContext, logger *RunLogger, cleanup *CleanupCoordinator) (*ProviderResult, error) {
 	timeout := time.Duration(cfg.Config.Provider.Timeout) * time.Second
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
+	// Markers in the output and MCP tool calls both land in result, under mu
+	var mu sync.Mutex
+	result := &ProviderResult{}
+
 	p := cfg.Config.Provider
-	args, promptFile, err := buildProviderArgs(p.Args, p.PromptMode, p.PromptFlag, prompt)
+	adapter := adapterFor(p.Command)
+	if r, ok := adapter.(sessionResumer); ok && p.Session != "" {
+		p.Args = r.resumeArgs(p.Args, p.Session)
+	}
+	var ralphServer map[string]MCPServerConfig
+	if p.MCP && tools != nil && canServeMCP(p.Command) {
+		session := &mcpSession{cfg: cfg, ctx: tools, result: result, mu: &mu, logger: logger}
+		bridge, err := startMCPBridge(session.handle)
+		if err != nil {
+			return nil, err
+		}
+		defer bridge.Close()
+		ralphServer = map[string]MCPServerConfig{"ralph": bridge.server()}
+	}
+	// MCP config files go with the run's logs, which are gitignored
+	mcpDir := ""
+	if logger != nil && logger.featureDir != "" {
+		mcpDir = LogsDir(logger.featureDir)
+	}
+	mcpArgs, mcpConfig, err := withMCPServers(p, cfg.Config.MCPServers, ralphServer, mcpDir)
+	if err != nil {
+		return nil, err
+	}
+	if mcpConfig != "" {
+		defer os.Remove(mcpConfig)
+	}
+	p.Args = mcpArgs
+	args, promptFile, err := adapter.BuildArgs(p, prompt)
 	if err != nil {
 		return nil, err
 	}
@@ -479,6 +936,9 @@ func runProvider(cfg *ResolvedConfig, prompt string, logger *RunLogger, cleanup
 	cmd := exec.CommandContext(ctx, cfg.Config.Provider.Command, args...)
 	cmd.Dir = cfg.ProjectRoot
 	cmd.Env = os.Environ()
+	if p.Attempt > 0 {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("RALPH_ATTEMPT=%d", p.Attempt))
+	}
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	cmd.Cancel = func() error {
 		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
@@ -523,8 +983,8 @@ func runProvider(cfg *ResolvedConfig, prompt string, logger *RunLogger, cleanup
 
 	// Register provider with cleanup coordinator for signal handling
 	if cleanup != nil {
-		cleanup.SetProvider(cmd)
-		defer cleanup.ClearProvider()
+		cleanup.AddProvider(cmd)
+		defer cleanup.RemoveProvider(cmd)
 	}
 
 	// Cleanup prompt file when done (for file mode)
@@ -541,9 +1001,20 @@ func runProvider(cfg *ResolvedConfig, prompt string, logger *RunLogger, cleanup
 	}
 
 	// Collect output with marker detection
-	var mu sync.Mutex
 	var stdoutBuilder, stderrBuilder, outputBuilder strings.Builder
-	result := &ProviderResult{}
+
+	// Idle watchdog: kill the process group when no output line arrives within
+	// provider.idleTimeout (e.g. a provider blocked on an interactive prompt).
+	var lastOutput atomic.Int64
+	var stalled atomic.Bool
+	lastOutput.Store(time.Now().UnixNano())
+	idleTimeout := time.Duration(cfg.Config.Provider.IdleTimeout) * time.Second
+	if idleTimeout > 0 {
+		go watchIdle(ctx, idleTimeout, &lastOutput, func() {
+			stalled.Store(true)
+			cancel()
+		})
+	}
 
 	// Use WaitGroup for stderr goroutine
 	var wg sync.WaitGroup
@@ -556,13 +1027,15 @@ func runProvider(cfg *ResolvedConfig, prompt string, logger *RunLogger, cleanup
 		s.Buffer(make([]byte, 64*1024), 1024*1024)
 		for s.Scan() {
 			line := s.Text()
+			lastOutput.Store(time.Now().UnixNano())
 			if logger != nil {
 				logger.ProviderLine("stderr", line)
 			}
 			mu.Lock()
 			stderrBuilder.WriteString(line + "\n")
 			outputBuilder.WriteString(line + "\n")
-			processLine(line, result, logger)
+			result.addError(line)
+			processOutputLine(adapter, line, result, logger)
 			mu.Unlock()
 		}
 		stderrScanErr = s.Err()
@@ -573,13 +1046,14 @@ func runProvider(cfg *ResolvedConfig, prompt string, logger *RunLogger, cleanup
 	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
 	for scanner.Scan() {
 		line := scanner.Text()
+		lastOutput.Store(time.Now().UnixNano())
 		if logger != nil {
 			logger.ProviderLine("stdout", line)
 		}
 		mu.Lock()
 		stdoutBuilder.WriteString(line + "\n")
 		outputBuilder.WriteString(line + "\n")
-		processLine(line, result, logger)
+		processOutputLine(adapter, line, result, logger)
 		mu.Unlock()
 	}
 
@@ -610,6 +1084,15 @@ func runProvider(cfg *ResolvedConfig, prompt string, logger *RunLogger, cleanup
 		return result, fmt.Errorf("provider timed out after %v", timeout)
 	}
 
+	// A stall is the story attempt's failure, not a provider error: the loop moves on
+	if stalled.Load() {
+		result.Stalled = true
+		if logger != nil {
+			logger.Warning(fmt.Sprintf("provider stalled: no output for %v, killed", idleTimeout))
+		}
+		return result, nil
+	}
+
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			result.ExitCode = exitErr.ExitCode()
@@ -621,107 +1104,559 @@ func runProvider(cfg *ResolvedConfig, prompt string, logger *RunLogger, cleanup
 	return result, nil
 }
 
+// watchIdle calls onIdle once if lastOutput (unix nanos) falls more than idle behind the
+// clock. Returns when ctx is done.
+func watchIdle(ctx context.Context, idle time.Duration, lastOutput *atomic.Int64, onIdle func()) {
+	interval := idle / 10
+	if interval > time.Second {
+		interval = time.Second
+	}
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if now.Sub(time.Unix(0, lastOutput.Load())) >= idle {
+				onIdle()
+				return
+			}
+		}
+	}
+}
+
+// processOutputLine passes one line of provider output through its adapter: text is
+// scanned for markers and structured events are tallied and logged.
+func processOutputLine(adapter ProviderAdapter, line string, result *ProviderResult, logger *RunLogger) {
+	parsed := adapter.ParseLine(line)
+	for _, text := range parsed.Text {
+		processLine(text, result, logger)
+	}
+	for _, ev := range parsed.Events {
+		result.addEvent(ev)
+		if ev.Type == EventCompletion && !ev.Success {
+			result.ErrorReported = true
+			if ev.Summary != "" {
+				result.addError(ev.Summary)
+			}
+		}
+		if logger != nil {
+			logger.ProviderActivity(ev)
+		}
+	}
+}
+
+// addEvent folds a structured provider event into the result's totals.
+func (r *ProviderResult) addEvent(ev ProviderEvent) {
+	switch ev.Type {
+	case EventSessionStart:
+		r.SessionID = ev.SessionID
+	case EventToolCall:
+		r.ToolCalls++
+	case EventFileEdit:
+		for _, f := range r.FilesEdited {
+			if f == ev.Path {
+				return
+			}
+		}
+		r.FilesEdited = append(r.FilesEdited, ev.Path)
+	case EventTokenUsage:
+		r.InputTokens += ev.InputTokens
+		r.OutputTokens += ev.OutputTokens
+		r.ReportedCost += ev.Cost
+	}
+}
+
+// activitySummary describes the structured activity of a provider session for the
+// console. Empty when the adapter reported none.
+func (r *ProviderResult) activitySummary() string {
+	var parts []string
+	if r.ToolCalls > 0 {
+		parts = append(parts, fmt.Sprintf("%d tool calls", r.ToolCalls))
+	}
+	if len(r.FilesEdited) > 0 {
+		parts = append(parts, fmt.Sprintf("%d files edited", len(r.FilesEdited)))
+	}
+	if r.InputTokens > 0 || r.OutputTokens > 0 {
+		parts = append(parts, fmt.Sprintf("%s tokens in, %s out", formatTokens(r.InputTokens), formatTokens(r.OutputTokens)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatTokens abbreviates a token count (e.g. 12.3k).
+func formatTokens(n int) string {
+	switch {
+	case n >= 1000000:
+		return fmt.Sprintf("%.1fM", float64(n)/1000000)
+	case n >= 1000:
+		return fmt.Sprintf("%.1fk", float64(n)/1000)
+	}
+	return fmt.Sprintf("%d", n)
+}
+
 // processLine processes a line of output for markers.
 // Uses whole-line matching (after trimming whitespace) to prevent marker spoofing.
 func processLine(line string, result *ProviderResult, logger *RunLogger) {
 	trimmed := strings.TrimSpace(line)
 
 	if trimmed == DoneMarker {
-		result.Done = true
-		if logger != nil {
-			logger.MarkerDetected("DONE", "")
-			logger.LogPrintln("  ◆ DONE")
-		}
+		result.markDone(logger)
 	}
 	if trimmed == StuckMarker {
-		result.Stuck = true
-		if logger != nil {
-			logger.MarkerDetected("STUCK", "")
-			logger.LogPrintln("  ◆ STUCK")
-		}
+		result.markStuck("", logger)
 	}
 
 	// STUCK with reason: <ralph>STUCK:reason text</ralph>
 	if matches := StuckNotePattern.FindStringSubmatch(trimmed); len(matches) > 1 {
-		result.Stuck = true
-		result.StuckNote = strings.TrimSpace(matches[1])
-		if logger != nil {
-			logger.MarkerDetected("STUCK", result.StuckNote)
-			logger.LogPrint("  ◆ STUCK: %s\n", result.StuckNote)
-		}
+		result.markStuck(strings.TrimSpace(matches[1]), logger)
+	}
+
+	// Questions for a human: <ralph>QUESTION:text</ralph>
+	if matches := QuestionPattern.FindStringSubmatch(trimmed); len(matches) > 1 {
+		result.addQuestion(strings.TrimSpace(matches[1]), logger)
 	}
 
 	// Extract learnings
 	if matches := LearningPattern.FindStringSubmatch(trimmed); len(matches) > 1 {
-		value := strings.TrimSpace(matches[1])
-		result.Learnings = append(result.Learnings, value)
+		result.addLearning(strings.TrimSpace(matches[1]), logger)
+	}
+}
+
+// markDone records a DONE signal, from a marker or the signal_done tool.
+func (r *ProviderResult) markDone(logger *RunLogger) {
+	r.Done = true
+	if logger != nil {
+		logger.MarkerDetected("DONE", "")
+		logger.LogPrintln("  ◆ DONE")
+	}
+}
+
+// markStuck records a STUCK signal with an optional reason.
+func (r *ProviderResult) markStuck(note string, logger *RunLogger) {
+	r.Stuck = true
+	if note == "" {
 		if logger != nil {
-			logger.MarkerDetected("LEARNING", value)
-			logger.LogPrint("  ~ LEARNING: %s\n", value)
+			logger.MarkerDetected("STUCK", "")
+			logger.LogPrintln("  ◆ STUCK")
 		}
+		return
+	}
+	r.StuckNote = note
+	if logger != nil {
+		logger.MarkerDetected("STUCK", note)
+		logger.LogPrint("  ◆ STUCK: %s\n", note)
+	}
+}
+
+// addQuestion records a question the story can't go on without a human answering.
+func (r *ProviderResult) addQuestion(question string, logger *RunLogger) {
+	r.Questions = append(r.Questions, question)
+	if logger != nil {
+		logger.MarkerDetected("QUESTION", question)
+		logger.LogPrint("  ◆ QUESTION: %s\n", question)
 	}
 }
 
+// addLearning records a learning for future iterations.
+func (r *ProviderResult) addLearning(value string, logger *RunLogger) {
+	r.Learnings = append(r.Learnings, value)
+	if logger != nil {
+		logger.MarkerDetected("LEARNING", value)
+		logger.LogPrint("  ~ LEARNING: %s\n", value)
+	}
+}
+
+// attemptConfig picks the provider for a story's next attempt: the escalation entry for
+// the attempt number, then that entry's routes for the story's tags and complexity. Only
+// charged failures count, so provider crashes and timeouts retry with the same provider.
+func attemptConfig(cfg *ResolvedConfig, state *RunState, story *StoryDefinition, logger *RunLogger) *ResolvedConfig {
+	attempt := state.GetRetries(story.ID) + 1
+	attemptCfg, routes := cfg.ForAttempt(attempt).ForStory(story)
+	switch {
+	case len(routes) > 0:
+		logger.LogPrint("Provider for attempt %d: %s (routed by %s)\n", attempt, attemptCfg.Config.Provider.Command, strings.Join(routes, ", "))
+	case len(cfg.Config.Provider.Escalation) > 0:
+		logger.LogPrint("Provider for attempt %d: %s\n", attempt, attemptCfg.Config.Provider.Command)
+	}
+	return attemptCfg.WithAttempt(len(state.GetAttempts(story.ID)) + 1)
+}
+
+// resumeSession returns the provider session a story's next attempt should continue, or
+// "" for a fresh session. Resuming needs provider.resume, a recorded session from the same
+// provider on the last attempt, and that attempt's commits still on the branch.
+func resumeSession(cfg *ResolvedConfig, state *RunState, storyID string) string {
+	p := cfg.Config.Provider
+	if !p.Resume || !canResume(p.Command) {
+		return ""
+	}
+	attempts := state.GetAttempts(storyID)
+	if len(attempts) == 0 {
+		return ""
+	}
+	last := attempts[len(attempts)-1]
+	if last.Session == "" || last.Provider != p.Command || last.Patch != "" {
+		return ""
+	}
+	return last.Session
+}
+
+// rollbackEnabled reports whether commits.rollback resets the branch after a failed
+// attempt: "verify" only after failed verification, "always" after any failure.
+func rollbackEnabled(cfg *ResolvedConfig, verifyFailed bool) bool {
+	if cfg.Config.Commits == nil {
+		return false
+	}
+	switch cfg.Config.Commits.Rollback {
+	case "always":
+		return true
+	case "verify":
+		return verifyFailed
+	}
+	return false
+}
+
+// rollbackAttempt saves the commits a failed attempt made to attempts/<story>-<n>.patch
+// and resets the branch to where the attempt started, so the next attempt (and other
+// stories) begin from a clean tree. The branch is left alone if the patch can't be saved.
+func rollbackAttempt(git *GitOps, featureDir *FeatureDir, state *RunState, storyID string, attempt *AttemptRecord, logger *RunLogger) {
+	if attempt.PreCommit == "" || attempt.PostCommit == "" || attempt.PreCommit == attempt.PostCommit {
+		return
+	}
+	number := len(state.GetAttempts(storyID)) + 1
+	patch, err := saveAttemptPatch(git, featureDir, storyID, number, attempt.PreCommit, attempt.PostCommit)
+	if err != nil {
+		logger.Warning("rollback skipped, failed to save attempt patch: " + err.Error())
+		return
+	}
+	if err := git.ResetHard(attempt.PreCommit); err != nil {
+		logger.Warning("rollback failed: " + err.Error())
+		return
+	}
+	attempt.Patch = patch
+	logger.LogPrint("  ↺ Rolled back %s to %s (saved %s)\n", storyID, shortHash(attempt.PreCommit), patch)
+}
+
+// saveAttemptPatch writes the commits in (from, to] to the feature's attempts/ directory.
+// Returns the patch path relative to the feature dir.
+func saveAttemptPatch(git *GitOps, featureDir *FeatureDir, storyID string, number int, from, to string) (string, error) {
+	patch, err := git.FormatPatch(from, to)
+	if err != nil {
+		return "", err
+	}
+	path := featureDir.AttemptPatchPath(storyID, number)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(patch), 0644); err != nil {
+		return "", err
+	}
+	return filepath.Rel(featureDir.Path, path)
+}
+
+// shortHash abbreviates a commit hash for display.
+func shortHash(hash string) string {
+	if len(hash) > 7 {
+		return hash[:7]
+	}
+	return hash
+}
+
 // StoryVerifyResult contains the result of story verification
 type StoryVerifyResult struct {
-	passed bool
-	reason string
+	passed      bool
+	reason      string                // combined failure context for every failing check
+	failedCmd   string                // first verify command that failed ("" for service failures)
+	failure     FailureClass          // classification of the first failure
+	failedTests []TestFailure         // failing tests parsed from every failing command
+	commands    []VerifyCommandResult // one entry per verify command, in config order
 }
 
-// runStoryVerification runs verification for a single story
-func runStoryVerification(cfg *ResolvedConfig, featureDir *FeatureDir, story *StoryDefinition, svcMgr *ServiceManager, logger *RunLogger) (*StoryVerifyResult, error) {
-	result := &StoryVerifyResult{passed: true}
+// VerifyCommandResult is the outcome of a single verify command during story verification.
+type VerifyCommandResult struct {
+	cmd         string
+	ui          bool
+	passed      bool
+	preExisting bool // failed the same way in the baseline; doesn't fail the story
+	skipped     bool // not run (UI commands after a failed service restart, or nothing affected)
+	cached      bool // not run: it passed on this same tree before
+	flaky       bool // failed, then passed when re-run (verify.flakyRetries)
+	output      string
+	err         error
+	failure     FailureClass
+	duration    time.Duration
+	baseline    *BaselineFailure
+	tests       []TestFailure // failing tests parsed from structured output or report files
+}
 
-	// Run default verification commands
+// failureReason formats a failed command for LastFailure and the retry prompt.
+// Parsed failing tests aren't included; they're kept in AttemptRecord.FailedTests.
+func (r *VerifyCommandResult) failureReason() string {
+	if r.baseline != nil {
+		return fmt.Sprintf("%s failed: %v\nThis command already failed before the run with a %s failure; it now fails with a %s failure, so this is a new breakage.\n\n--- Output (last 50 lines) ---\n%s", r.cmd, r.err, r.baseline.Failure, r.failure, r.output)
+	}
+	return fmt.Sprintf("%s failed: %v\n\n--- Output (last 50 lines) ---\n%s", r.cmd, r.err, r.output)
+}
+
+// runBaselineVerification runs every verify.default command on the untouched branch and
+// records the ones that fail, so later failures can be compared against what predates the run.
+func runBaselineVerification(cfg *ResolvedConfig, git *GitOps, logger *RunLogger) *VerifyBaseline {
+	baseline := &VerifyBaseline{Commit: git.GetLastCommit(), CheckedAt: time.Now()}
+	logger.LogPrintln("\nRecording verification baseline...")
 	for _, cmd := range cfg.Config.Verify.Default {
 		logger.LogPrint("  → %s\n", cmd)
 		logger.VerifyCmdStart(cmd)
 		startTime := time.Now()
 		output, err := runCommand(cfg.ProjectRoot, cmd, cfg.Config.Verify.Timeout)
 		duration := time.Since(startTime)
+		logger.VerifyCmdEnd(cmd, err == nil, output, duration.Nanoseconds())
 		if err != nil {
-			logger.VerifyCmdEnd(cmd, false, output, duration.Nanoseconds())
-			result.passed = false
-			result.reason = fmt.Sprintf("%s failed: %v\n\n--- Output (last 50 lines) ---\n%s", cmd, err, output)
-			return result, nil
+			failure := classifyVerifyFailure(cmd, output, err)
+			logger.LogPrint("    ✗ already failing (%s)\n", failure)
+			logger.Warning(fmt.Sprintf("baseline: %s already fails (%s)", cmd, failure))
+			baseline.Failures = append(baseline.Failures, BaselineFailure{
+				Command: cmd,
+				Failure: failure,
+				Output:  truncateOutput(output, 30),
+			})
+			continue
 		}
-		logger.VerifyCmdEnd(cmd, true, output, duration.Nanoseconds())
 		if logger.config != nil && logger.config.ConsoleDurations {
 			logger.LogPrint("    ✓ (%s)\n", FormatDuration(duration))
 		}
 	}
+	return baseline
+}
+
+// runVerifyCommands runs a set of verify commands, up to concurrency at a time, and returns
+// their results in input order. Failures are classified and compared against the baseline.
+// A command the cache saw pass on this tree isn't run again; new passes are added to it.
+// Failing tests are parsed from the full output of a failed command, or from the
+// verify.reports files it wrote. A failed test command is re-run under verify.flakyRetries;
+// names maps narrowed commands to the configured ones flakes are recorded under.
+func runVerifyCommands(cfg *ResolvedConfig, cmds []string, ui bool, concurrency int, baseline *VerifyBaseline, cache *verifyCache, names map[string]string, logger *RunLogger) []VerifyCommandResult {
+	results := make([]VerifyCommandResult, len(cmds))
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	concurrent := concurrency > 1 && len(cmds) > 1
+
+	// Console lines name the command when several run at once, since they finish out of order
+	report := func(r *VerifyCommandResult) {
+		label := ""
+		if concurrent {
+			label = r.cmd + " "
+		}
+		switch {
+		case r.passed:
+			if logger.config != nil && logger.config.ConsoleDurations {
+				logger.LogPrint("    ✓ %s(%s)\n", label, FormatDuration(r.duration))
+			} else if concurrent {
+				logger.LogPrint("    ✓ %s\n", r.cmd)
+			}
+		case r.preExisting:
+			logger.LogPrint("    ⚠ %sfailing as before the run (pre-existing %s failure)\n", label, r.failure)
+			logger.Warning(fmt.Sprintf("%s: pre-existing %s failure, not counted against the story", r.cmd, r.failure))
+		default:
+			logger.LogPrint("    ✗ %s(%s)\n", label, r.failure)
+		}
+	}
+
+	run := func(i int) {
+		cmd := cmds[i]
+		if entry, ok := cache.passed(cmd); ok {
+			logger.LogPrint("  ✓ %s (cached: passed on this tree %s)\n", cmd, entry.PassedAt.Format("Jan 2 15:04"))
+			results[i] = VerifyCommandResult{cmd: cmd, ui: ui, passed: true, cached: true}
+			return
+		}
+		logger.LogPrint("  → %s\n", cmd)
+		logger.VerifyCmdStart(cmd)
+		startTime := time.Now()
+		full, err := runCommandFull(cfg.ProjectRoot, cmd, cfg.Config.Verify.Timeout)
+		output := truncateOutput(full, 50)
+		r := VerifyCommandResult{cmd: cmd, ui: ui, passed: err == nil, output: output, err: err, duration: time.Since(startTime)}
+		logger.VerifyCmdEnd(cmd, r.passed, output, r.duration.Nanoseconds())
+		if err == nil {
+			cache.recordPass(cmd, r.duration)
+		} else {
+			r.failure = classifyVerifyFailure(cmd, output, err)
+			r.tests = parseTestFailures(cfg.ProjectRoot, cfg.Config.Verify.Reports, startTime, full)
+			if !ui {
+				r.baseline = baseline.FailureFor(cmd)
+				r.preExisting = r.baseline != nil && r.baseline.Failure == r.failure
+			}
+			if !r.preExisting {
+				name := cmd
+				if full, ok := names[cmd]; ok {
+					name = full
+				}
+				rerunFlaky(cfg, &r, name, logger)
+			}
+		}
+		report(&r)
+		results[i] = r
+	}
+
+	if !concurrent {
+		for i := range cmds {
+			run(i)
+		}
+		return results
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for i := range cmds {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			run(i)
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+// rerunFlaky re-runs a failed command up to verify.flakyRetries times. If a re-run passes,
+// the command counts as passed and the flake is recorded in .ralph/flaky.json under name,
+// the command as configured. The result keeps the first failure's output either way.
+func rerunFlaky(cfg *ResolvedConfig, r *VerifyCommandResult, name string, logger *RunLogger) {
+	retries := cfg.Config.Verify.FlakyRetries
+	if retries <= 0 || !rerunsFlaky(r.failure) {
+		return
+	}
+	for i := 1; i <= retries; i++ {
+		logger.LogPrint("    ↻ %s failed (%s), re-running (%d/%d)\n", r.cmd, r.failure, i, retries)
+		logger.VerifyCmdStart(r.cmd)
+		startTime := time.Now()
+		output, err := runCommand(cfg.ProjectRoot, r.cmd, cfg.Config.Verify.Timeout)
+		duration := time.Since(startTime)
+		logger.VerifyCmdEnd(r.cmd, err == nil, output, duration.Nanoseconds())
+		r.duration += duration
+		if err != nil {
+			continue
+		}
+		r.passed, r.flaky = true, true
+		logger.LogPrint("    ✓ %s passed on re-run %d; recorded as flaky\n", r.cmd, i)
+		logger.Warning(fmt.Sprintf("%s is flaky: failed (%s), then passed on re-run %d", r.cmd, r.failure, i))
+		if err := recordFlaky(cfg.ProjectRoot, name, r.tests); err != nil {
+			logger.Warning("failed to record flaky check: " + err.Error())
+		}
+		return
+	}
+}
+
+// runAffectedCommands runs story verify commands through runVerifyCommands, narrowed by
+// verify.affected to what changed since base. A command with nothing affected is skipped.
+// Results are in input order and name the command that actually ran.
+func runAffectedCommands(cfg *ResolvedConfig, cmds []string, base string, baseline *VerifyBaseline, cache *verifyCache, logger *RunLogger) []VerifyCommandResult {
+	narrowed, warnings := narrowCommands(cfg, cmds, base)
+	for _, w := range warnings {
+		logger.LogPrint("  ! %s\n", w)
+		logger.Warning(w)
+	}
+
+	var run []string
+	full := make(map[string]string)
+	for i, cmd := range narrowed {
+		switch {
+		case cmd == "":
+			logger.LogPrint("  ○ %s (skipped: nothing it checks changed)\n", cmds[i])
+		case cmd != cmds[i]:
+			full[cmd] = cmds[i]
+			run = append(run, cmd)
+		default:
+			run = append(run, cmd)
+		}
+	}
+	ran := runVerifyCommands(cfg, run, false, cfg.Config.Verify.Concurrency, narrowedBaseline(baseline, full), cache, full, logger)
+
+	results := make([]VerifyCommandResult, 0, len(cmds))
+	for i, cmd := range narrowed {
+		if cmd == "" {
+			results = append(results, VerifyCommandResult{cmd: cmds[i], skipped: true})
+			continue
+		}
+		results = append(results, ran[0])
+		ran = ran[1:]
+	}
+	return results
+}
+
+// runFullAffectedVerification runs the commands that have a verify.affected form in full,
+// once every story is done, since stories only checked what they changed.
+func runFullAffectedVerification(cfg *ResolvedConfig, featureDir *FeatureDir, def *PRDDefinition, baseline *VerifyBaseline, logger *RunLogger) error {
+	var cmds []string
+	for _, cmd := range append(append([]string{}, cfg.Config.Verify.Default...), cfg.Config.Verify.FeatureCommands(def)...) {
+		if _, ok := cfg.Config.Verify.Affected[cmd]; ok {
+			cmds = append(cmds, cmd)
+		}
+	}
+	if len(cmds) == 0 {
+		return nil
+	}
+
+	logger.LogPrintln("\nRunning full verification (stories ran verify.affected commands narrowed to their changes)...")
+	cache := openVerifyCache(cfg, featureDir)
+	var failed, reasons []string
+	for _, r := range runVerifyCommands(cfg, cmds, false, cfg.Config.Verify.Concurrency, baseline, cache, nil, logger) {
+		if !r.passed && !r.preExisting {
+			failed = append(failed, r.cmd)
+			reasons = append(reasons, r.failureReason()+testFailureSection(r.tests))
+		}
+	}
+	saveVerifyCache(cache, logger)
+	if len(failed) == 0 {
+		return nil
+	}
+	logger.LogPrint("\n%s\n", strings.Join(reasons, "\n\n"))
+	logger.LogPrintln("Stories only checked what they changed, so this went unnoticed until now. Fix it on the branch, or run 'ralph verify' for a fix session.")
+	return fmt.Errorf("full verification failed: %s", strings.Join(failed, ", "))
+}
+
+// runStoryVerification runs verification for a single story: verify.default, the story's
+// verify.byTag and own verify commands, then verify.ui for UI stories. Every command
+// runs even after a failure, so the retry prompt covers all breakage at once.
+// A verify.default command that fails the same way it did in the baseline is reported
+// but doesn't fail the story; one that fails differently is treated as a regression.
+// Commands with a verify.affected form only check what changed since base.
+func runStoryVerification(cfg *ResolvedConfig, featureDir *FeatureDir, story *StoryDefinition, svcMgr *ServiceManager, baseline *VerifyBaseline, base string, logger *RunLogger) (*StoryVerifyResult, error) {
+	result := &StoryVerifyResult{passed: true}
+	var problems []string
+
+	// Run default verification commands, plus the ones this story's tags and definition add
+	cmds := append(append([]string{}, cfg.Config.Verify.Default...), cfg.Config.Verify.StoryCommands(story)...)
+	cache := openVerifyCache(cfg, featureDir)
+	result.commands = runAffectedCommands(cfg, cmds, base, baseline, cache, logger)
+	saveVerifyCache(cache, logger)
 
 	// Run UI verification if story has UI tag
 	if IsUIStory(story) {
+		restartFailed := false
 		// Restart services before UI verification (fresh state)
 		if svcMgr != nil && svcMgr.HasUIServices() {
 			logger.LogPrintln("  → Restarting services for UI verification...")
 			if err := svcMgr.RestartForVerify(); err != nil {
 				logger.ServiceRestart("all", false)
-				result.passed = false
-				result.reason = fmt.Sprintf("service restart failed: %v", err)
-				return result, nil
-			}
-			logger.ServiceRestart("all", true)
-		}
-
-		// Run UI verification commands
-		for _, cmd := range cfg.Config.Verify.UI {
-			logger.LogPrint("  → %s\n", cmd)
-			logger.VerifyCmdStart(cmd)
-			startTime := time.Now()
-			output, err := runCommand(cfg.ProjectRoot, cmd, cfg.Config.Verify.Timeout)
-			duration := time.Since(startTime)
-			if err != nil {
-				logger.VerifyCmdEnd(cmd, false, output, duration.Nanoseconds())
-				result.passed = false
-				result.reason = fmt.Sprintf("%s failed: %v\n\n--- Output (last 50 lines) ---\n%s", cmd, err, output)
-				return result, nil
+				problems = append(problems, fmt.Sprintf("service restart failed: %v", err))
+				restartFailed = true
+			} else {
+				logger.ServiceRestart("all", true)
 			}
-			logger.VerifyCmdEnd(cmd, true, output, duration.Nanoseconds())
-			if logger.config != nil && logger.config.ConsoleDurations {
-				logger.LogPrint("    ✓ (%s)\n", FormatDuration(duration))
+		}
+
+		// Run UI verification commands sequentially (they share the running services)
+		if restartFailed {
+			for _, cmd := range cfg.Config.Verify.UI {
+				result.commands = append(result.commands, VerifyCommandResult{cmd: cmd, ui: true, skipped: true})
 			}
+		} else {
+			result.commands = append(result.commands, runVerifyCommands(cfg, cfg.Config.Verify.UI, true, 1, baseline, nil, nil, logger)...)
 		}
 	}
 
@@ -738,18 +1673,89 @@ func runStoryVerification(cfg *ResolvedConfig, featureDir *FeatureDir, story *St
 					reason += fmt.Sprintf("\n\n--- %s output (last 30 lines) ---\n%s", svc.Name, svcOutput)
 				}
 			}
-			result.passed = false
-			result.reason = reason
-			return result, nil
+			problems = append(problems, reason)
+		} else {
+			for _, svc := range cfg.Config.Services {
+				logger.ServiceHealth(svc.Name, true, "")
+			}
 		}
-		for _, svc := range cfg.Config.Services {
-			logger.ServiceHealth(svc.Name, true, "")
+	}
+
+	// Combine every failing command and service problem into one failure
+	var failed []string
+	var reasons []string
+	for i := range result.commands {
+		r := &result.commands[i]
+		if r.passed || r.preExisting || r.skipped {
+			continue
+		}
+		if result.failedCmd == "" {
+			result.failedCmd = r.cmd
+			result.failure = r.failure
 		}
+		failed = append(failed, r.cmd)
+		reasons = append(reasons, r.failureReason())
+		result.failedTests = append(result.failedTests, r.tests...)
+	}
+	if len(problems) > 0 && result.failure == "" {
+		result.failure = FailureService
+	}
+	reasons = append(reasons, problems...)
+	if len(reasons) == 0 {
+		return result, nil
 	}
 
+	result.passed = false
+	result.reason = strings.Join(reasons, "\n\n")
+	if len(failed) > 1 {
+		result.reason = fmt.Sprintf("%d verify commands failed: %s\n\n%s", len(failed), strings.Join(failed, ", "), result.reason)
+	}
 	return result, nil
 }
 
+// compileErrorMarkers are output fragments that mean code failed to build, even when
+// the failing command was a test runner or linter.
+var compileErrorMarkers = []string{
+	"[build failed]",
+	"undefined: ",
+	"syntax error",
+	"error TS",
+	"SyntaxError:",
+	"cannot find package",
+	"Module not found",
+}
+
+// classifyVerifyFailure guesses what kind of check a failed verify command was from
+// its error, output, and command text. Falls back to FailureVerify.
+func classifyVerifyFailure(cmd, output string, err error) FailureClass {
+	if err != nil && strings.HasPrefix(err.Error(), "timed out") {
+		return FailureTimeout
+	}
+	for _, marker := range compileErrorMarkers {
+		if strings.Contains(output, marker) {
+			return FailureCompile
+		}
+	}
+	lower := strings.ToLower(cmd)
+	hasAny := func(words ...string) bool {
+		for _, w := range words {
+			if strings.Contains(lower, w) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case hasAny("tsc", "typecheck", "type-check", "build", "compile", "mypy", "pyright"):
+		return FailureCompile
+	case hasAny("lint", "vet", "fmt", "format", "prettier", "ruff", "clippy", "staticcheck"):
+		return FailureLint
+	case hasAny("test", "jest", "vitest", "pytest", "mocha", "playwright", "cypress", "e2e"):
+		return FailureTest
+	}
+	return FailureVerify
+}
+
 // --- VerifyReport for ralph verify ---
 
 // VerifyItem represents a single verification check result
@@ -856,21 +1862,37 @@ func (r *VerifyReport) FormatForPrompt() string {
 func runVerifyChecks(cfg *ResolvedConfig, featureDir *FeatureDir, def *PRDDefinition, state *RunState, svcMgr *ServiceManager, logger *RunLogger, resourceGuidance string) (*VerifyReport, error) {
 	report := &VerifyReport{}
 
-	// 1. Run verify.default commands
-	for _, cmd := range cfg.Config.Verify.Default {
+	// 1. Run verify.default commands, plus every story's verify.byTag and own commands.
+	// Passes cached on this tree (say, by the run that just finished) are reused.
+	cache := openVerifyCache(cfg, featureDir)
+	for _, cmd := range append(append([]string{}, cfg.Config.Verify.Default...), cfg.Config.Verify.FeatureCommands(def)...) {
+		if entry, ok := cache.passed(cmd); ok {
+			logger.LogPrint("  ✓ %s (cached: passed on this tree %s)\n", cmd, entry.PassedAt.Format("Jan 2 15:04"))
+			report.AddPass(fmt.Sprintf("%s (cached)", cmd))
+			continue
+		}
 		logger.LogPrint("  → %s\n", cmd)
 		logger.VerifyCmdStart(cmd)
 		startTime := time.Now()
-		output, err := runCommand(cfg.ProjectRoot, cmd, cfg.Config.Verify.Timeout)
+		full, err := runCommandFull(cfg.ProjectRoot, cmd, cfg.Config.Verify.Timeout)
+		output := truncateOutput(full, 50)
 		duration := time.Since(startTime)
 		if err != nil {
 			logger.VerifyCmdEnd(cmd, false, output, duration.Nanoseconds())
-			report.AddFail(fmt.Sprintf("%s (%s)", cmd, FormatDuration(duration)), output)
+			r := VerifyCommandResult{cmd: cmd, failure: classifyVerifyFailure(cmd, output, err), duration: duration}
+			r.tests = parseTestFailures(cfg.ProjectRoot, cfg.Config.Verify.Reports, startTime, full)
+			if rerunFlaky(cfg, &r, cmd, logger); r.passed {
+				report.AddPass(fmt.Sprintf("%s (%s, flaky: passed on re-run)", cmd, FormatDuration(r.duration)))
+				continue
+			}
+			report.AddFail(fmt.Sprintf("%s (%s)", cmd, FormatDuration(duration)), output+testFailureSection(r.tests))
 		} else {
 			logger.VerifyCmdEnd(cmd, true, output, duration.Nanoseconds())
 			report.AddPass(fmt.Sprintf("%s (%s)", cmd, FormatDuration(duration)))
+			cache.recordPass(cmd, duration)
 		}
 	}
+	saveVerifyCache(cache, logger)
 
 	// 2. Run verify.ui commands
 	for _, cmd := range cfg.Config.Verify.UI {
@@ -881,6 +1903,11 @@ func runVerifyChecks(cfg *ResolvedConfig, featureDir *FeatureDir, def *PRDDefini
 		duration := time.Since(startTime)
 		if err != nil {
 			logger.VerifyCmdEnd(cmd, false, output, duration.Nanoseconds())
+			r := VerifyCommandResult{cmd: cmd, ui: true, failure: classifyVerifyFailure(cmd, output, err), duration: duration}
+			if rerunFlaky(cfg, &r, cmd, logger); r.passed {
+				report.AddPass(fmt.Sprintf("%s (%s, flaky: passed on re-run)", cmd, FormatDuration(r.duration)))
+				continue
+			}
 			report.AddFail(fmt.Sprintf("%s (%s)", cmd, FormatDuration(duration)), output)
 		} else {
 			logger.VerifyCmdEnd(cmd, true, output, duration.Nanoseconds())
@@ -924,7 +1951,7 @@ func runVerifyChecks(cfg *ResolvedConfig, featureDir *FeatureDir, def *PRDDefini
 	// 6. AI deep verification (always runs during ralph verify)
 	logger.LogPrintln("  → AI verification analysis...")
 	analyzePrompt := generateVerifyAnalyzePrompt(cfg, featureDir, def, state, report, resourceGuidance)
-	aiResult, aiErr := runVerifySubagent(cfg, analyzePrompt)
+	aiResult, aiErr := runVerifySubagent(cfg, featureDir, analyzePrompt)
 	if aiErr != nil {
 		report.AddFail("AI analysis", aiErr.Error())
 	} else if !aiResult.Passed {
@@ -1042,7 +2069,7 @@ func runVerify(cfg *ResolvedConfig, featureDir *FeatureDir, resourceGuidance str
 
 	if promptYesNo("Open interactive AI session to investigate and fix?") {
 		prompt := generateVerifyFixPrompt(cfg, featureDir, def, state, report, resourceGuidance)
-		if err := runProviderInteractive(cfg, prompt); err != nil {
+		if err := runProviderInteractive(cfg, featureDir, prompt); err != nil {
 			logger.RunEnd(false, "interactive session error")
 			return err
 		}
@@ -1060,6 +2087,12 @@ func runVerify(cfg *ResolvedConfig, featureDir *FeatureDir, resourceGuidance str
 
 // runCommand runs a shell command with a per-command timeout.
 func runCommand(dir, cmdStr string, timeoutSec int) (string, error) {
+	output, err := runCommandFull(dir, cmdStr, timeoutSec)
+	return truncateOutput(output, 50), err
+}
+
+// runCommandFull is runCommand without truncation, for parsing structured test output.
+func runCommandFull(dir, cmdStr string, timeoutSec int) (string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeoutSec)*time.Second)
 	defer cancel()
 	cmd := exec.CommandContext(ctx, "sh", "-c", cmdStr)
@@ -1074,9 +2107,9 @@ func runCommand(dir, cmdStr string, timeoutSec int) (string, error) {
 	cmd.Stderr = &buf
 	err := cmd.Run()
 	if ctx.Err() == context.DeadlineExceeded {
-		return truncateOutput(buf.String(), 50), fmt.Errorf("timed out after %ds", timeoutSec)
+		return buf.String(), fmt.Errorf("timed out after %ds", timeoutSec)
 	}
-	return truncateOutput(buf.String(), 50), err
+	return buf.String(), err
 }
 
 // truncateOutput keeps the last N lines of output for diagnostic context.
@@ -1097,13 +2130,20 @@ type VerifyAnalysisResult struct {
 
 // runVerifySubagent spawns a non-interactive provider to perform AI deep verification.
 // Scans output for VERIFY_PASS/VERIFY_FAIL markers.
-func runVerifySubagent(cfg *ResolvedConfig, prompt string) (*VerifyAnalysisResult, error) {
+func runVerifySubagent(cfg *ResolvedConfig, featureDir *FeatureDir, prompt string) (*VerifyAnalysisResult, error) {
 	timeout := time.Duration(cfg.Config.Provider.Timeout) * time.Second
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
 	p := cfg.Config.Provider
-	args, promptFile, err := buildProviderArgs(p.Args, p.PromptMode, p.PromptFlag, prompt)
+	mcpArgs, mcpConfig, err := withMCPServers(p, cfg.Config.MCPServers, nil, LogsDir(featureDir.Path))
+	if err != nil {
+		return nil, err
+	}
+	if mcpConfig != "" {
+		defer os.Remove(mcpConfig)
+	}
+	args, promptFile, err := buildProviderArgs(mcpArgs, p.PromptMode, p.PromptFlag, prompt)
 	if err != nil {
 		return nil, err
 	}
@@ -1393,6 +2433,8 @@ func archiveFeature(cfg *ResolvedConfig, featureDir *FeatureDir, def *PRDDefinit
 	for _, f := range filesToDelete {
 		os.Remove(f) // ignore errors — files may not exist
 	}
+	// The verify cache is gitignored, so it's removed but not committed
+	os.Remove(featureDir.VerifyCachePath())
 
 	// Commit all changes
 	git := NewGitOps(cfg.ProjectRoot)
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -40,6 +42,19 @@ func TestProcessLine_MultipleLearnings(t *testing.T) {
 	}
 }
 
+func TestProcessLine_QuestionMarker(t *testing.T) {
+	result := &ProviderResult{}
+	processLine("  <ralph>QUESTION: Soft delete or hard delete? </ralph>", result, nil)
+	processLine("Should I ask <ralph>QUESTION:inline</ralph>", result, nil)
+
+	if len(result.Questions) != 1 || result.Questions[0] != "Soft delete or hard delete?" {
+		t.Fatalf("expected one trimmed question, got %v", result.Questions)
+	}
+	if got := providerMarkers(result); len(got) != 1 || got[0] != "QUESTION" {
+		t.Errorf("markers = %v", got)
+	}
+}
+
 func TestProcessLine_NoMarkers(t *testing.T) {
 	result := &ProviderResult{}
 	processLine("Regular output without any markers", result, nil)
@@ -701,3 +716,491 @@ func TestVerifyReport_AddWarn(t *testing.T) {
 		t.Errorf("expected output 'was not modified', got %q", item.Output)
 	}
 }
+
+func TestClassifyVerifyFailure(t *testing.T) {
+	tests := []struct {
+		cmd    string
+		output string
+		err    error
+		want   FailureClass
+	}{
+		{"npm test", "", fmt.Errorf("timed out after 300s"), FailureTimeout},
+		{"go test ./...", "# pkg\n./a.go:3:2: undefined: foo\nFAIL pkg [build failed]", fmt.Errorf("exit status 1"), FailureCompile},
+		{"go test ./...", "--- FAIL: TestX", fmt.Errorf("exit status 1"), FailureTest},
+		{"bun run typecheck", "", fmt.Errorf("exit status 2"), FailureCompile},
+		{"npx tsc --noEmit", "", fmt.Errorf("exit status 2"), FailureCompile},
+		{"npm run lint", "", fmt.Errorf("exit status 1"), FailureLint},
+		{"go vet ./...", "", fmt.Errorf("exit status 1"), FailureLint},
+		{"npx playwright test", "", fmt.Errorf("exit status 1"), FailureTest},
+		{"./scripts/check.sh", "", fmt.Errorf("exit status 1"), FailureVerify},
+	}
+	for _, tt := range tests {
+		if got := classifyVerifyFailure(tt.cmd, tt.output, tt.err); got != tt.want {
+			t.Errorf("classifyVerifyFailure(%q) = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestResumeSession(t *testing.T) {
+	claude := &ResolvedConfig{Config: RalphConfig{Provider: ProviderConfig{Command: "claude", Resume: true}}}
+
+	state := NewRunState()
+	if got := resumeSession(claude, state, "US-001"); got != "" {
+		t.Errorf("first attempt should start fresh, got %q", got)
+	}
+
+	state.RecordAttempt("US-001", AttemptRecord{Provider: "claude", Session: "sess-1", Failure: FailureTest}, 3)
+	if got := resumeSession(claude, state, "US-001"); got != "sess-1" {
+		t.Errorf("expected to resume sess-1, got %q", got)
+	}
+
+	optOut := &ResolvedConfig{Config: RalphConfig{Provider: ProviderConfig{Command: "claude"}}}
+	if got := resumeSession(optOut, state, "US-001"); got != "" {
+		t.Errorf("resume is opt-in, got %q", got)
+	}
+
+	escalated := &ResolvedConfig{Config: RalphConfig{Provider: ProviderConfig{Command: "codex", Resume: true}}}
+	if got := resumeSession(escalated, state, "US-001"); got != "" {
+		t.Errorf("a different provider must not resume another CLI's session, got %q", got)
+	}
+
+	state.RecordAttempt("US-001", AttemptRecord{Provider: "claude", Session: "sess-2", Failure: FailureVerify, Patch: "attempts/US-001-2.patch"}, 3)
+	if got := resumeSession(claude, state, "US-001"); got != "" {
+		t.Errorf("rolled-back attempt's session should not be resumed, got %q", got)
+	}
+
+	state.RecordAttempt("US-001", AttemptRecord{Provider: "claude", Failure: FailureProvider}, 3)
+	if got := resumeSession(claude, state, "US-001"); got != "" {
+		t.Errorf("attempt without a session should start fresh, got %q", got)
+	}
+}
+
+func TestRollbackEnabled(t *testing.T) {
+	tests := []struct {
+		policy       string
+		verifyFailed bool
+		want         bool
+	}{
+		{"", true, false},
+		{"off", true, false},
+		{"verify", true, true},
+		{"verify", false, false},
+		{"always", false, true},
+		{"always", true, true},
+	}
+	for _, tt := range tests {
+		cfg := &ResolvedConfig{Config: RalphConfig{Commits: &CommitsConfig{Rollback: tt.policy}}}
+		if got := rollbackEnabled(cfg, tt.verifyFailed); got != tt.want {
+			t.Errorf("rollbackEnabled(%q, %v) = %v, want %v", tt.policy, tt.verifyFailed, got, tt.want)
+		}
+	}
+}
+
+func TestRollbackAttempt(t *testing.T) {
+	dir, git := initTestRepo(t)
+	featureDir := &FeatureDir{Feature: "auth", Path: filepath.Join(dir, ".ralph", "2024-01-15-auth")}
+	logger, err := NewRunLogger(featureDir.Path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	state := NewRunState()
+	state.RecordAttempt("US-001", AttemptRecord{Failure: FailureTest, Reason: "first try"}, 3)
+
+	pre := git.GetLastCommit()
+	commitTestFile(t, dir, "login.go", "package login\n", "feat: US-001 login")
+	attempt := AttemptRecord{PreCommit: pre, PostCommit: git.GetLastCommit(), Failure: FailureTest}
+
+	rollbackAttempt(git, featureDir, state, "US-001", &attempt, logger)
+
+	if git.GetLastCommit() != pre {
+		t.Error("expected branch reset to pre-attempt commit")
+	}
+	if attempt.Patch != filepath.Join("attempts", "US-001-2.patch") {
+		t.Errorf("unexpected patch path: %q", attempt.Patch)
+	}
+	data, err := os.ReadFile(filepath.Join(featureDir.Path, attempt.Patch))
+	if err != nil {
+		t.Fatalf("patch not written: %v", err)
+	}
+	if !strings.Contains(string(data), "feat: US-001 login") {
+		t.Errorf("patch should contain the discarded commit, got:\n%s", data)
+	}
+}
+
+func TestRollbackAttempt_NoCommits(t *testing.T) {
+	dir, git := initTestRepo(t)
+	featureDir := &FeatureDir{Feature: "auth", Path: filepath.Join(dir, ".ralph", "2024-01-15-auth")}
+	logger, err := NewRunLogger(featureDir.Path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	head := git.GetLastCommit()
+	attempt := AttemptRecord{PreCommit: head, PostCommit: head}
+	rollbackAttempt(git, featureDir, NewRunState(), "US-001", &attempt, logger)
+
+	if attempt.Patch != "" {
+		t.Errorf("expected no patch when the attempt made no commits, got %q", attempt.Patch)
+	}
+	if _, err := os.Stat(filepath.Join(featureDir.Path, "attempts")); !os.IsNotExist(err) {
+		t.Error("attempts/ should not be created")
+	}
+}
+
+func TestRunBaselineVerification(t *testing.T) {
+	dir, git := initTestRepo(t)
+	logger, err := NewRunLogger(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	cfg := &ResolvedConfig{
+		ProjectRoot: dir,
+		Config:      RalphConfig{Verify: VerifyConfig{Default: []string{"true", "echo broken && exit 1"}, Timeout: 30}},
+	}
+	baseline := runBaselineVerification(cfg, git, logger)
+
+	if baseline.Commit != git.GetLastCommit() {
+		t.Errorf("expected baseline commit %s, got %s", git.GetLastCommit(), baseline.Commit)
+	}
+	if len(baseline.Failures) != 1 {
+		t.Fatalf("expected 1 baseline failure, got %d", len(baseline.Failures))
+	}
+	f := baseline.Failures[0]
+	if f.Command != "echo broken && exit 1" || f.Failure != FailureVerify || !strings.Contains(f.Output, "broken") {
+		t.Errorf("unexpected baseline failure: %+v", f)
+	}
+}
+
+func TestRunStoryVerification_Baseline(t *testing.T) {
+	dir := t.TempDir()
+	logger, err := NewRunLogger(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	cfg := &ResolvedConfig{
+		ProjectRoot: dir,
+		Config:      RalphConfig{Verify: VerifyConfig{Default: []string{"exit 1", "true"}, Timeout: 30}},
+	}
+	story := &StoryDefinition{ID: "US-001"}
+
+	tests := []struct {
+		name       string
+		baseline   *VerifyBaseline
+		wantPassed bool
+		wantReason string
+	}{
+		{"no baseline", nil, false, "exit 1 failed"},
+		{"same failure as baseline", &VerifyBaseline{Failures: []BaselineFailure{{Command: "exit 1", Failure: FailureVerify}}}, true, ""},
+		{"failure class changed", &VerifyBaseline{Failures: []BaselineFailure{{Command: "exit 1", Failure: FailureTest}}}, false, "new breakage"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := runStoryVerification(cfg, nil, story, nil, tt.baseline, "", logger)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.passed != tt.wantPassed {
+				t.Errorf("passed = %v, want %v (reason: %s)", result.passed, tt.wantPassed, result.reason)
+			}
+			if tt.wantReason != "" && !strings.Contains(result.reason, tt.wantReason) {
+				t.Errorf("reason should contain %q, got: %s", tt.wantReason, result.reason)
+			}
+		})
+	}
+}
+
+func TestRunStoryVerification_ReportsEveryFailure(t *testing.T) {
+	logger, err := NewRunLogger(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	cfg := &ResolvedConfig{
+		ProjectRoot: t.TempDir(),
+		Config: RalphConfig{Verify: VerifyConfig{
+			Default: []string{"echo type error; exit 1", "true", "echo lint error; exit 2"},
+			UI:      []string{"echo e2e error; exit 1"},
+			Timeout: 30,
+		}},
+	}
+	story := &StoryDefinition{ID: "US-001", Tags: []string{"ui"}}
+
+	result, err := runStoryVerification(cfg, nil, story, nil, nil, "", logger)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.passed {
+		t.Fatal("expected verification to fail")
+	}
+	if len(result.commands) != 4 {
+		t.Fatalf("expected a result for all 4 commands, got %d", len(result.commands))
+	}
+	if !result.commands[1].passed || result.commands[0].passed || result.commands[2].passed || result.commands[3].passed {
+		t.Errorf("unexpected per-command results: %+v", result.commands)
+	}
+	if !result.commands[3].ui {
+		t.Error("expected the last result to be the UI command")
+	}
+	if result.failedCmd != "echo type error; exit 1" {
+		t.Errorf("failedCmd should be the first failure, got %q", result.failedCmd)
+	}
+	for _, want := range []string{"3 verify commands failed", "type error", "lint error", "e2e error"} {
+		if !strings.Contains(result.reason, want) {
+			t.Errorf("reason should contain %q, got:\n%s", want, result.reason)
+		}
+	}
+}
+
+func TestRunVerifyCommands_Concurrent(t *testing.T) {
+	logger, err := NewRunLogger(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	cfg := &ResolvedConfig{
+		ProjectRoot: t.TempDir(),
+		Config:      RalphConfig{Verify: VerifyConfig{Timeout: 30}},
+	}
+	cmds := []string{"sleep 0.4; echo one", "sleep 0.4; exit 1", "sleep 0.4; echo three"}
+
+	start := time.Now()
+	results := runVerifyCommands(cfg, cmds, false, 3, nil, nil, nil, logger)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected commands to run concurrently, took %s", elapsed)
+	}
+	for i, r := range results {
+		if r.cmd != cmds[i] {
+			t.Errorf("results[%d] = %q, want input order", i, r.cmd)
+		}
+	}
+	if !results[0].passed || results[1].passed || !results[2].passed {
+		t.Errorf("unexpected results: %+v", results)
+	}
+}
+
+func TestRunProvider_PassesAttemptCountingUncharged(t *testing.T) {
+	state := NewRunState()
+	state.RecordAttempt("US-001", AttemptRecord{Number: 1}, 3)
+	state.RecordAttempt("US-001", AttemptRecord{Number: 2, Uncharged: true}, 3)
+	story := &StoryDefinition{ID: "US-001"}
+	cfg := &ResolvedConfig{
+		ProjectRoot: t.TempDir(),
+		Config: RalphConfig{Provider: ProviderConfig{
+			Command:    "sh",
+			Args:       []string{"-c", `cat >/dev/null; echo "$RALPH_ATTEMPT" > attempt.txt`},
+			PromptMode: "stdin",
+			Timeout:    30,
+		}},
+	}
+
+	attemptCfg := attemptConfig(cfg, state, story, nil)
+	if attemptCfg.Config.Provider.Attempt != 3 {
+		t.Fatalf("expected attempt 3 after one charged and one uncharged attempt, got %d", attemptCfg.Config.Provider.Attempt)
+	}
+	if cfg.Config.Provider.Attempt != 0 {
+		t.Error("attemptConfig must not modify the run's config")
+	}
+	if _, err := runProvider(attemptCfg, "prompt", nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(filepath.Join(cfg.ProjectRoot, "attempt.txt"))
+	if strings.TrimSpace(string(data)) != "3" {
+		t.Errorf("expected RALPH_ATTEMPT=3 in the provider's environment, got %q", data)
+	}
+}
+
+func TestRunProvider_IdleTimeoutStalls(t *testing.T) {
+	cfg := &ResolvedConfig{
+		ProjectRoot: t.TempDir(),
+		Config: RalphConfig{Provider: ProviderConfig{
+			Command:     "sh",
+			Args:        []string{"-c", "echo starting; sleep 30"},
+			PromptMode:  "stdin",
+			Timeout:     60,
+			IdleTimeout: 1,
+		}},
+	}
+
+	start := time.Now()
+	result, err := runProvider(cfg, "prompt", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("a stall should not be a provider error, got: %v", err)
+	}
+	if !result.Stalled {
+		t.Error("expected result.Stalled")
+	}
+	if result.TimedOut {
+		t.Error("a stall is not a total timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected the stalled provider to be killed quickly, took %s", elapsed)
+	}
+	if !strings.Contains(result.Output, "starting") {
+		t.Errorf("expected output before the stall to be kept, got %q", result.Output)
+	}
+}
+
+func TestRunProvider_IdleTimeoutResetByOutput(t *testing.T) {
+	cfg := &ResolvedConfig{
+		ProjectRoot: t.TempDir(),
+		Config: RalphConfig{Provider: ProviderConfig{
+			Command:     "sh",
+			Args:        []string{"-c", "for i in 1 2 3 4; do echo tick; sleep 0.5; done; echo '<ralph>DONE</ralph>'"},
+			PromptMode:  "stdin",
+			Timeout:     60,
+			IdleTimeout: 1,
+		}},
+	}
+
+	result, err := runProvider(cfg, "prompt", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Stalled {
+		t.Error("steady output should keep the idle watchdog from firing")
+	}
+	if !result.Done {
+		t.Error("expected DONE marker")
+	}
+}
+
+func TestWatchIdle(t *testing.T) {
+	var lastOutput atomic.Int64
+	lastOutput.Store(time.Now().Add(-time.Second).UnixNano())
+
+	fired := make(chan struct{})
+	go watchIdle(context.Background(), 100*time.Millisecond, &lastOutput, func() { close(fired) })
+
+	select {
+	case <-fired:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected onIdle to fire for stale output")
+	}
+}
+
+func TestStoryForRun(t *testing.T) {
+	def := &PRDDefinition{UserStories: []StoryDefinition{
+		{ID: "US-001", Priority: 1},
+		{ID: "US-002", Priority: 2},
+		{ID: "US-003", Priority: 3},
+	}}
+	state := NewRunState()
+	state.MarkSkipped("US-003", "too hard")
+
+	if s := storyForRun(def, state, ""); s == nil || s.ID != "US-001" {
+		t.Errorf("expected next story by priority, got %v", s)
+	}
+	if s := storyForRun(def, state, "US-003"); s == nil || s.ID != "US-003" {
+		t.Errorf("expected --story to pick a skipped story, got %v", s)
+	}
+	if s := storyForRun(def, state, "US-999"); s != nil {
+		t.Errorf("expected nil for unknown story, got %v", s)
+	}
+}
+
+func TestFinishSingleStory(t *testing.T) {
+	logger, err := NewRunLogger(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	state := NewRunState()
+	state.MarkFailed("US-001", "npm test failed: 2 tests\nmore output", 3)
+	err = finishSingleStory("US-001", state, logger)
+	if err == nil || !strings.Contains(err.Error(), "US-001 did not pass") {
+		t.Errorf("expected failure error, got: %v", err)
+	}
+
+	state.RecordAttempt("US-001", AttemptRecord{Passed: true}, 3)
+	if err := finishSingleStory("US-001", state, logger); err != nil {
+		t.Errorf("expected nil for a passed story, got: %v", err)
+	}
+}
+
+func TestArchiveFeature_IgnoredVerifyCache(t *testing.T) {
+	dir, git := initTestRepo(t)
+	ralphDir := filepath.Join(dir, ".ralph")
+	featureDir := newFeatureDir(ralphDir, "auth")
+	commitFiles(t, dir, git, map[string]string{
+		".ralph/.gitignore":                             "ralph.lock\n*.tmp\n*/logs/\n*/attempts/\n*/verify-cache.json\nflaky.json\n",
+		".ralph/" + featureDir.Name + "/prd.md":         "# Auth\n",
+		".ralph/" + featureDir.Name + "/prd.json":       "{}\n",
+		".ralph/" + featureDir.Name + "/run-state.json": "{}\n",
+	})
+	os.WriteFile(featureDir.VerifyCachePath(), []byte("{}\n"), 0644)
+
+	cfg := &ResolvedConfig{
+		ProjectRoot: dir,
+		Config: RalphConfig{Provider: ProviderConfig{
+			Command:    "sh",
+			Args:       []string{"-c", "cat >/dev/null; echo '<ralph>SUMMARY_START</ralph>'; echo Added auth.; echo '<ralph>SUMMARY_END</ralph>'"},
+			PromptMode: "stdin",
+			Timeout:    30,
+		}},
+	}
+	def := &PRDDefinition{Project: "Auth"}
+	if err := archiveFeature(cfg, featureDir, def, NewRunState()); err != nil {
+		t.Fatalf("archiveFeature failed: %v", err)
+	}
+
+	for _, f := range []string{featureDir.PrdMdPath(), featureDir.PrdJsonPath(), featureDir.RunStatePath(), featureDir.VerifyCachePath()} {
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be deleted", filepath.Base(f))
+		}
+	}
+	if status, _ := git.run("status", "--porcelain"); strings.TrimSpace(status) != "" {
+		t.Errorf("expected the archive to be committed, got status:\n%s", status)
+	}
+	if msg, _ := git.run("log", "-1", "--format=%s"); strings.TrimSpace(msg) != "ralph: archive feature auth" {
+		t.Errorf("unexpected last commit: %q", msg)
+	}
+}
+
+func TestRunProvider_MCPConfigFile(t *testing.T) {
+	// A stand-in claude that prints the MCP config it was given
+	bin := t.TempDir()
+	script := "#!/bin/sh\nfor a in \"$@\"; do case \"$a\" in --mcp-config=*) echo \"${a#--mcp-config=}\"; cat \"${a#--mcp-config=}\"; echo;; esac; done\n"
+	if err := os.WriteFile(filepath.Join(bin, "claude"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	featureDir := t.TempDir()
+	logger, err := NewRunLogger(featureDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+	cfg := &ResolvedConfig{
+		ProjectRoot: t.TempDir(),
+		Config: RalphConfig{
+			Provider:   ProviderConfig{Command: "claude", PromptMode: "stdin", Timeout: 30},
+			MCPServers: map[string]MCPServerConfig{"github": {Command: "npx", Env: map[string]string{"GITHUB_TOKEN": "t0k"}}},
+		},
+	}
+
+	result, err := runProvider(cfg, "prompt", nil, logger, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitN(result.Output, "\n", 2)
+	if filepath.Dir(lines[0]) != LogsDir(featureDir) {
+		t.Errorf("expected the config under the feature's logs, got %q", lines[0])
+	}
+	if !strings.Contains(result.Output, `"GITHUB_TOKEN":"t0k"`) {
+		t.Errorf("expected the provider to read the servers from the file, got %q", result.Output)
+	}
+	if _, err := os.Stat(lines[0]); !os.IsNotExist(err) {
+		t.Error("expected the config file to be removed after the run")
+	}
+}
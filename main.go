@@ -16,7 +16,7 @@ func main() {
 	cmd := os.Args[1]
 	args := os.Args[2:]
 
-	if cmd != "upgrade" {
+	if cmd != "upgrade" && cmd != "mock-provider" && cmd != "mcp-serve" {
 		startUpdateCheck()
 		defer printUpdateNotice()
 	}
@@ -36,14 +36,24 @@ func main() {
 		cmdPrd(args)
 	case "status":
 		cmdStatus(args)
+	case "answer":
+		cmdAnswer(args)
 	case "refine":
 		cmdRefine(args)
 	case "doctor":
 		cmdDoctor(args)
 	case "logs":
 		cmdLogs(args)
+	case "flaky":
+		cmdFlaky(args)
+	case "prompts":
+		cmdPrompts(args)
 	case "upgrade":
 		cmdUpgrade(args)
+	case "mock-provider":
+		cmdMockProvider(args)
+	case "mcp-serve":
+		cmdMCPServe(args)
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", cmd)
 		fmt.Fprintln(os.Stderr, "Run 'ralph --help' for usage.")
@@ -59,13 +69,18 @@ Usage: ralph <command> [feature] [options]
 Commands:
   init [--force]       Initialize Ralph (creates ralph.config.json + .ralph/)
   prd <feature>        Create, refine, or manage a PRD for a feature
-  run <feature>        Run the agent loop for a feature
+  run <feature>        Run the agent loop for a feature (--parallel, --deadline, etc.)
   verify <feature>     Run verification checks (interactive fix on failure)
   refine <feature>     Interactive AI session for post-verification refinement
   status [feature]     Show story status (all features or specific)
+  answer <feature>     Answer questions providers asked about stories
   logs <feature>       View run logs (--list, --summary, --follow, etc.)
+  flaky [clear]        List or clear checks that passed only when re-run
   doctor               Check Ralph environment
+  prompts              List, show, diff, or validate prompt templates
   upgrade              Upgrade Ralph to the latest version
+  mock-provider        Scripted stand-in for a provider CLI (testing configs offline)
+  mcp-serve            MCP server for provider sessions (started via provider.mcp)
 
 Options:
   -h, --help           Show this help message
@@ -75,8 +90,12 @@ Examples:
   ralph init                    # Initialize Ralph in current project
   ralph prd auth                # Create, refine, or manage PRD for 'auth' feature
   ralph run auth                # Run the loop for 'auth' feature
+  ralph run auth --parallel 3   # Implement up to 3 independent stories at once
+  ralph run auth --deadline 8h  # Stop starting new stories after 8 hours
+  ralph run auth --dry-run      # Print the next story's prompt without running it
   ralph verify auth             # Run all verification checks for 'auth' feature
   ralph status                  # Show status of all features
   ralph status auth             # Show status of 'auth' feature
+  ralph answer auth             # Answer the questions 'auth' stories are waiting on
 `, version)
 }
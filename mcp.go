@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// `ralph mcp-serve` is a stdio MCP server the provider CLI launches for the length of a
+// story session (provider.mcp). It is a thin bridge: each tool call is forwarded over a
+// unix socket to the `ralph run` process that started the provider, which answers from
+// its own state and feeds signals into the same ProviderResult as <ralph> markers.
+
+// mcpProtocolVersion is offered when the client doesn't name a version.
+const mcpProtocolVersion = "2025-06-18"
+
+// mcpTool describes one tool for tools/list.
+type mcpTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"inputSchema"`
+}
+
+// mcpTools are the tools ralph offers a provider session.
+var mcpTools = []mcpTool{
+	{
+		Name:        "get_story",
+		Description: "Get the story you are implementing: id, title, description, tags, and acceptance criteria.",
+		InputSchema: mcpSchema(nil),
+	},
+	{
+		Name:        "get_acceptance_criteria",
+		Description: "Get the story's acceptance criteria as a numbered list.",
+		InputSchema: mcpSchema(nil),
+	},
+	{
+		Name:        "report_learning",
+		Description: "Save a non-obvious pattern, convention, or gotcha for future iterations. Same as a LEARNING marker.",
+		InputSchema: mcpSchema(map[string]string{"text": "The learning, specific and actionable"}, "text"),
+	},
+	{
+		Name:        "signal_done",
+		Description: "Signal that the story is implemented, tested, and committed. The CLI runs verification after you exit. Same as the DONE marker.",
+		InputSchema: mcpSchema(nil),
+	},
+	{
+		Name:        "signal_stuck",
+		Description: "Signal that you cannot proceed, with the reason. Same as a STUCK marker.",
+		InputSchema: mcpSchema(map[string]string{"reason": "What is blocking you"}, "reason"),
+	},
+	{
+		Name:        "ask_question",
+		Description: "Ask a human to decide something the story and codebase leave open. The story waits for the answer, which you'll get in your next attempt; no retry is charged. Same as a QUESTION marker.",
+		InputSchema: mcpSchema(map[string]string{"question": "The question, with the options you see"}, "question"),
+	},
+	{
+		Name:        "run_verification",
+		Description: "Run the story's verification commands now and report which pass and which fail, with output.",
+		InputSchema: mcpSchema(nil),
+	},
+	{
+		Name:        "get_service_logs",
+		Description: "Get recent output from the dev services the CLI manages.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"service": map[string]string{"type": "string", "description": "Service name (default: all services)"},
+				"lines":   map[string]string{"type": "integer", "description": "Lines per service (default: 50)"},
+			},
+		},
+	},
+}
+
+// mcpSchema builds an object schema of string properties.
+func mcpSchema(props map[string]string, required ...string) map[string]interface{} {
+	properties := map[string]interface{}{}
+	for name, desc := range props {
+		properties[name] = map[string]string{"type": "string", "description": desc}
+	}
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// mcpToolResult is a tool's answer: text for the agent, flagged when the call failed.
+type mcpToolResult struct {
+	Text    string `json:"text"`
+	IsError bool   `json:"isError,omitempty"`
+}
+
+func mcpError(format string, args ...interface{}) mcpToolResult {
+	return mcpToolResult{Text: fmt.Sprintf(format, args...), IsError: true}
+}
+
+type jsonrpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type jsonrpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+}
+
+type jsonrpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// serveMCP speaks newline-delimited JSON-RPC on in/out until in closes, passing tool
+// calls to call.
+func serveMCP(in io.Reader, out io.Writer, call func(tool string, args json.RawMessage) mcpToolResult) error {
+	enc := json.NewEncoder(out)
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var req jsonrpcRequest
+		if err := json.Unmarshal([]byte(line), &req); err != nil {
+			enc.Encode(jsonrpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &jsonrpcError{Code: -32700, Message: "parse error"}})
+			continue
+		}
+		if len(req.ID) == 0 {
+			continue // notifications need no answer
+		}
+		resp := jsonrpcResponse{JSONRPC: "2.0", ID: req.ID}
+		switch req.Method {
+		case "initialize":
+			var params struct {
+				ProtocolVersion string `json:"protocolVersion"`
+			}
+			json.Unmarshal(req.Params, &params)
+			if params.ProtocolVersion == "" {
+				params.ProtocolVersion = mcpProtocolVersion
+			}
+			resp.Result = map[string]interface{}{
+				"protocolVersion": params.ProtocolVersion,
+				"capabilities":    map[string]interface{}{"tools": map[string]interface{}{}},
+				"serverInfo":      map[string]string{"name": "ralph", "version": version},
+			}
+		case "ping":
+			resp.Result = map[string]interface{}{}
+		case "tools/list":
+			resp.Result = map[string]interface{}{"tools": mcpTools}
+		case "tools/call":
+			var params struct {
+				Name      string          `json:"name"`
+				Arguments json.RawMessage `json:"arguments"`
+			}
+			if err := json.Unmarshal(req.Params, &params); err != nil || params.Name == "" {
+				resp.Error = &jsonrpcError{Code: -32602, Message: "invalid params: tool name required"}
+				break
+			}
+			r := call(params.Name, params.Arguments)
+			resp.Result = map[string]interface{}{
+				"content": []map[string]string{{"type": "text", "text": r.Text}},
+				"isError": r.IsError,
+			}
+		default:
+			resp.Error = &jsonrpcError{Code: -32601, Message: "method not found: " + req.Method}
+		}
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// mcpBridgeRequest is one tool call forwarded from `ralph mcp-serve` to `ralph run`.
+type mcpBridgeRequest struct {
+	Tool string          `json:"tool"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+// callMCPBridge forwards a tool call to the ralph run process listening on socket.
+func callMCPBridge(socket, tool string, args json.RawMessage) mcpToolResult {
+	if socket == "" {
+		return mcpError("ralph mcp-serve was not started by ralph run; no story session to report to")
+	}
+	conn, err := net.DialTimeout("unix", socket, 5*time.Second)
+	if err != nil {
+		return mcpError("cannot reach ralph run: %v", err)
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(mcpBridgeRequest{Tool: tool, Args: args}); err != nil {
+		return mcpError("cannot reach ralph run: %v", err)
+	}
+	var result mcpToolResult
+	if err := json.NewDecoder(conn).Decode(&result); err != nil {
+		return mcpError("no answer from ralph run: %v", err)
+	}
+	return result
+}
+
+// mcpBridge is the ralph run side of the bridge: a unix socket that answers tool calls
+// for one provider session.
+type mcpBridge struct {
+	listener net.Listener
+	dir      string
+	exe      string
+	wg       sync.WaitGroup
+}
+
+// startMCPBridge listens on a fresh socket and answers each call with handle.
+func startMCPBridge(handle func(tool string, args json.RawMessage) mcpToolResult) (*mcpBridge, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate ralph for mcp-serve: %w", err)
+	}
+	dir, err := os.MkdirTemp("", "ralph-mcp-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create MCP socket dir: %w", err)
+	}
+	listener, err := net.Listen("unix", filepath.Join(dir, "ralph.sock"))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to listen for MCP tool calls: %w", err)
+	}
+	b := &mcpBridge{listener: listener, dir: dir, exe: exe}
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return // closed
+			}
+			b.wg.Add(1)
+			go func() {
+				defer b.wg.Done()
+				defer conn.Close()
+				var req mcpBridgeRequest
+				if err := json.NewDecoder(conn).Decode(&req); err != nil {
+					return
+				}
+				json.NewEncoder(conn).Encode(handle(req.Tool, req.Args))
+			}()
+		}
+	}()
+	return b, nil
+}
+
+// server is the MCP server entry the provider launches to reach this bridge.
+func (b *mcpBridge) server() MCPServerConfig {
+	return MCPServerConfig{Command: b.exe, Args: []string{"mcp-serve", "--socket", b.listener.Addr().String()}}
+}
+
+// Close stops accepting calls, waits for calls in flight, and removes the socket.
+func (b *mcpBridge) Close() {
+	b.listener.Close()
+	b.wg.Wait()
+	os.RemoveAll(b.dir)
+}
+
+// mcpContext is what ralph's MCP tools can see during one story session.
+type mcpContext struct {
+	story    *StoryDefinition
+	services *ServiceManager
+	baseline *VerifyBaseline
+	base     string // commit the story's changes are measured from, for verify.affected
+}
+
+// mcpSession answers tool calls for one provider run. Signals are written to result
+// under mu, the lock that guards marker scanning of the provider's output.
+type mcpSession struct {
+	cfg    *ResolvedConfig
+	ctx    *mcpContext
+	result *ProviderResult
+	mu     *sync.Mutex
+	logger *RunLogger
+}
+
+func (s *mcpSession) handle(tool string, args json.RawMessage) mcpToolResult {
+	var in struct {
+		Text     string `json:"text"`
+		Reason   string `json:"reason"`
+		Question string `json:"question"`
+		Service  string `json:"service"`
+		Lines    int    `json:"lines"`
+	}
+	if len(args) > 0 {
+		if err := json.Unmarshal(args, &in); err != nil {
+			return mcpError("invalid arguments for %s: %v", tool, err)
+		}
+	}
+
+	switch tool {
+	case "get_story":
+		return mcpToolResult{Text: formatStoryForMCP(s.ctx.story)}
+	case "get_acceptance_criteria":
+		var lines []string
+		for i, c := range s.ctx.story.AcceptanceCriteria {
+			lines = append(lines, fmt.Sprintf("%d. %s", i+1, c))
+		}
+		return mcpToolResult{Text: strings.Join(lines, "\n")}
+	case "report_learning":
+		text := strings.TrimSpace(in.Text)
+		if text == "" {
+			return mcpError("text is required")
+		}
+		s.mu.Lock()
+		s.result.addLearning(text, s.logger)
+		s.mu.Unlock()
+		return mcpToolResult{Text: "Learning saved."}
+	case "signal_done":
+		s.mu.Lock()
+		s.result.markDone(s.logger)
+		s.mu.Unlock()
+		return mcpToolResult{Text: "DONE recorded. End your session; the CLI runs verification next."}
+	case "signal_stuck":
+		reason := strings.TrimSpace(in.Reason)
+		if reason == "" {
+			return mcpError("reason is required")
+		}
+		s.mu.Lock()
+		s.result.markStuck(reason, s.logger)
+		s.mu.Unlock()
+		return mcpToolResult{Text: "STUCK recorded. End your session."}
+	case "ask_question":
+		question := strings.TrimSpace(in.Question)
+		if question == "" {
+			return mcpError("question is required")
+		}
+		s.mu.Lock()
+		s.result.addQuestion(question, s.logger)
+		s.mu.Unlock()
+		return mcpToolResult{Text: "Question recorded. Commit any work worth keeping, then end your session; the answer comes with your next attempt."}
+	case "run_verification":
+		return s.runVerification()
+	case "get_service_logs":
+		return s.serviceLogs(in.Service, in.Lines)
+	}
+	return mcpError("unknown tool: %s", tool)
+}
+
+// formatStoryForMCP renders the story definition for get_story.
+func formatStoryForMCP(story *StoryDefinition) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "**ID:** %s\n**Title:** %s\n**Description:** %s\n", story.ID, story.Title, story.Description)
+	if len(story.Tags) > 0 {
+		fmt.Fprintf(&b, "**Tags:** %s\n", strings.Join(story.Tags, ", "))
+	}
+	if len(story.DependsOn) > 0 {
+		fmt.Fprintf(&b, "**Depends On:** %s\n", strings.Join(story.DependsOn, ", "))
+	}
+	b.WriteString("\n**Acceptance Criteria:**\n")
+	for _, c := range story.AcceptanceCriteria {
+		b.WriteString("- " + c + "\n")
+	}
+	fmt.Fprintf(&b, "\nCommit message: `feat: %s - %s`\n", story.ID, story.Title)
+	return b.String()
+}
+
+// runVerification runs the commands the CLI verifies the story with after DONE. Services
+// are not restarted, and failures that predate the run are marked as such.
+func (s *mcpSession) runVerification() mcpToolResult {
+	cmds := append([]string{}, s.cfg.Config.Verify.Default...)
+	cmds = append(cmds, s.cfg.Config.Verify.StoryCommands(s.ctx.story)...)
+	narrowed, warnings := narrowCommands(s.cfg, cmds, s.ctx.base)
+	if IsUIStory(s.ctx.story) {
+		cmds = append(cmds, s.cfg.Config.Verify.UI...)
+		narrowed = append(narrowed, s.cfg.Config.Verify.UI...)
+	}
+	if len(cmds) == 0 {
+		return mcpToolResult{Text: "No verification commands are configured."}
+	}
+
+	var b strings.Builder
+	for _, w := range warnings {
+		fmt.Fprintf(&b, "! %s\n", w)
+	}
+	failed := 0
+	for i, cmd := range narrowed {
+		if cmd == "" {
+			fmt.Fprintf(&b, "○ %s (skipped: nothing it checks changed)\n", cmds[i])
+			continue
+		}
+		startTime := time.Now()
+		full, err := runCommandFull(s.cfg.ProjectRoot, cmd, s.cfg.Config.Verify.Timeout)
+		output := truncateOutput(full, 50)
+		if err == nil {
+			fmt.Fprintf(&b, "✓ %s\n", cmd)
+			continue
+		}
+		if known := s.ctx.baseline.FailureFor(cmds[i]); known != nil && known.Failure == classifyVerifyFailure(cmd, output, err) {
+			fmt.Fprintf(&b, "⚠ %s (failed the same way before the run; not counted against the story)\n", cmd)
+			continue
+		}
+		failed++
+		tests := parseTestFailures(s.cfg.ProjectRoot, s.cfg.Config.Verify.Reports, startTime, full)
+		fmt.Fprintf(&b, "✗ %s: %v\n--- Output (last 50 lines) ---\n%s%s\n", cmd, err, output, testFailureSection(tests))
+	}
+	if failed > 0 {
+		return mcpToolResult{Text: fmt.Sprintf("%d of %d verification commands failed.\n\n%s", failed, len(cmds), b.String()), IsError: true}
+	}
+	return mcpToolResult{Text: "All verification commands pass.\n\n" + b.String()}
+}
+
+// serviceLogs returns recent output of one service, or of every configured service.
+func (s *mcpSession) serviceLogs(name string, lines int) mcpToolResult {
+	if s.ctx.services == nil || !s.ctx.services.HasServices() {
+		return mcpToolResult{Text: "No services are configured."}
+	}
+	if lines <= 0 {
+		lines = 50
+	}
+	var b strings.Builder
+	found := false
+	for _, svc := range s.cfg.Config.Services {
+		if name != "" && svc.Name != name {
+			continue
+		}
+		found = true
+		output := s.ctx.services.GetRecentOutput(svc.Name, lines)
+		if output == "" {
+			output = "(no output captured)"
+		}
+		fmt.Fprintf(&b, "--- %s (last %d lines) ---\n%s\n", svc.Name, lines, output)
+	}
+	if !found {
+		return mcpError("unknown service %q", name)
+	}
+	return mcpToolResult{Text: b.String()}
+}
+
+func cmdMCPServe(args []string) {
+	fs := flag.NewFlagSet("mcp-serve", flag.ExitOnError)
+	socket := fs.String("socket", os.Getenv("RALPH_MCP_SOCKET"), "Socket of the ralph run session to report to")
+
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: ralph mcp-serve --socket <path>")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Serves ralph's story tools over MCP (stdio). ralph run starts it through the")
+		fmt.Fprintln(os.Stderr, "provider when provider.mcp is set; it is not meant to be run by hand.")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Options:")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	err := serveMCP(os.Stdin, os.Stdout, func(tool string, toolArgs json.RawMessage) mcpToolResult {
+		return callMCPBridge(*socket, tool, toolArgs)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
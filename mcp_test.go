@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// mcpExchange feeds newline-delimited requests to serveMCP and returns the decoded responses.
+func mcpExchange(t *testing.T, call func(string, json.RawMessage) mcpToolResult, requests ...string) []map[string]interface{} {
+	t.Helper()
+	var out bytes.Buffer
+	if err := serveMCP(strings.NewReader(strings.Join(requests, "\n")+"\n"), &out, call); err != nil {
+		t.Fatal(err)
+	}
+	var responses []map[string]interface{}
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var r map[string]interface{}
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		responses = append(responses, r)
+	}
+	return responses
+}
+
+func TestServeMCP_InitializeAndListTools(t *testing.T) {
+	responses := mcpExchange(t, nil,
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{}}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`,
+	)
+	if len(responses) != 2 {
+		t.Fatalf("expected 2 responses (notifications get none), got %d: %v", len(responses), responses)
+	}
+
+	init := responses[0]["result"].(map[string]interface{})
+	if init["protocolVersion"] != "2025-03-26" {
+		t.Errorf("expected the client's protocol version echoed, got %v", init["protocolVersion"])
+	}
+	if init["serverInfo"].(map[string]interface{})["name"] != "ralph" {
+		t.Errorf("unexpected serverInfo: %v", init["serverInfo"])
+	}
+
+	var names []string
+	for _, tool := range responses[1]["result"].(map[string]interface{})["tools"].([]interface{}) {
+		names = append(names, tool.(map[string]interface{})["name"].(string))
+	}
+	want := "get_story get_acceptance_criteria report_learning signal_done signal_stuck ask_question run_verification get_service_logs"
+	if got := strings.Join(names, " "); got != want {
+		t.Errorf("tools = %q, want %q", got, want)
+	}
+}
+
+func TestServeMCP_ToolCall(t *testing.T) {
+	var gotTool, gotArgs string
+	call := func(tool string, args json.RawMessage) mcpToolResult {
+		gotTool, gotArgs = tool, string(args)
+		return mcpToolResult{Text: "stuck recorded", IsError: false}
+	}
+	responses := mcpExchange(t, call,
+		`{"jsonrpc":"2.0","id":"a","method":"tools/call","params":{"name":"signal_stuck","arguments":{"reason":"no db"}}}`,
+		`{"jsonrpc":"2.0","id":"b","method":"resources/list"}`,
+		`not json`,
+	)
+	if gotTool != "signal_stuck" || !strings.Contains(gotArgs, "no db") {
+		t.Errorf("call got tool=%q args=%q", gotTool, gotArgs)
+	}
+	if len(responses) != 3 {
+		t.Fatalf("expected 3 responses, got %v", responses)
+	}
+
+	content := responses[0]["result"].(map[string]interface{})["content"].([]interface{})
+	if content[0].(map[string]interface{})["text"] != "stuck recorded" || responses[0]["id"] != "a" {
+		t.Errorf("unexpected tool response: %v", responses[0])
+	}
+	if code := responses[1]["error"].(map[string]interface{})["code"]; code != float64(-32601) {
+		t.Errorf("expected method not found, got %v", responses[1])
+	}
+	if code := responses[2]["error"].(map[string]interface{})["code"]; code != float64(-32700) {
+		t.Errorf("expected parse error, got %v", responses[2])
+	}
+}
+
+func TestMCPBridge_RoundTrip(t *testing.T) {
+	bridge, err := startMCPBridge(func(tool string, args json.RawMessage) mcpToolResult {
+		return mcpToolResult{Text: tool + " " + string(args)}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := bridge.server()
+	if len(server.Args) != 3 || server.Args[0] != "mcp-serve" || server.Args[1] != "--socket" {
+		t.Errorf("unexpected server spec: %+v", server)
+	}
+
+	got := callMCPBridge(server.Args[2], "report_learning", json.RawMessage(`{"text":"x"}`))
+	if got.IsError || got.Text != `report_learning {"text":"x"}` {
+		t.Errorf("unexpected bridge result: %+v", got)
+	}
+
+	bridge.Close()
+	if got := callMCPBridge(server.Args[2], "signal_done", nil); !got.IsError {
+		t.Errorf("expected an error after the bridge closed, got %+v", got)
+	}
+	if got := callMCPBridge("", "signal_done", nil); !got.IsError || !strings.Contains(got.Text, "not started by ralph run") {
+		t.Errorf("expected a standalone error, got %+v", got)
+	}
+}
+
+func TestMCPSession_SignalsFeedResult(t *testing.T) {
+	result := &ProviderResult{}
+	s := &mcpSession{
+		cfg:    &ResolvedConfig{},
+		ctx:    &mcpContext{story: &StoryDefinition{ID: "US-001", Title: "Login", AcceptanceCriteria: []string{"Form renders", "Bad password shows error"}}},
+		result: result,
+		mu:     &sync.Mutex{},
+	}
+
+	s.handle("report_learning", json.RawMessage(`{"text":"auth lives in lib/auth.ts"}`))
+	s.handle("signal_stuck", json.RawMessage(`{"reason":"no database"}`))
+	s.handle("signal_done", nil)
+
+	if !result.Done || !result.Stuck || result.StuckNote != "no database" {
+		t.Errorf("expected DONE and STUCK recorded, got %+v", result)
+	}
+	if len(result.Learnings) != 1 || result.Learnings[0] != "auth lives in lib/auth.ts" {
+		t.Errorf("learnings = %v", result.Learnings)
+	}
+	if got := providerMarkers(result); len(got) == 0 {
+		t.Error("tool signals should show up as markers on the attempt")
+	}
+
+	if r := s.handle("signal_stuck", json.RawMessage(`{}`)); !r.IsError {
+		t.Error("signal_stuck without a reason should fail")
+	}
+	s.handle("ask_question", json.RawMessage(`{"question":"Soft or hard delete?"}`))
+	if len(result.Questions) != 1 || result.Questions[0] != "Soft or hard delete?" {
+		t.Errorf("questions = %v", result.Questions)
+	}
+	if r := s.handle("ask_question", json.RawMessage(`{"question":" "}`)); !r.IsError {
+		t.Error("ask_question without a question should fail")
+	}
+	if r := s.handle("get_acceptance_criteria", nil); r.Text != "1. Form renders\n2. Bad password shows error" {
+		t.Errorf("criteria = %q", r.Text)
+	}
+	if r := s.handle("get_story", nil); !strings.Contains(r.Text, "**ID:** US-001") || !strings.Contains(r.Text, "`feat: US-001 - Login`") {
+		t.Errorf("story = %q", r.Text)
+	}
+	if r := s.handle("delete_everything", nil); !r.IsError {
+		t.Error("unknown tools should fail")
+	}
+}
+
+func TestMCPSession_RunVerification(t *testing.T) {
+	cfg := &ResolvedConfig{
+		ProjectRoot: t.TempDir(),
+		Config: RalphConfig{Verify: VerifyConfig{
+			Default: []string{"true", "echo lint broke; exit 1", "echo old failure; exit 1"},
+			UI:      []string{"echo e2e"},
+			Timeout: 10,
+		}},
+	}
+	baseline := &VerifyBaseline{Failures: []BaselineFailure{{Command: "echo old failure; exit 1", Failure: FailureVerify}}}
+	s := &mcpSession{cfg: cfg, ctx: &mcpContext{story: &StoryDefinition{ID: "US-001"}, baseline: baseline}, result: &ProviderResult{}, mu: &sync.Mutex{}}
+
+	r := s.handle("run_verification", nil)
+	if !r.IsError || !strings.HasPrefix(r.Text, "1 of 3 verification commands failed") {
+		t.Errorf("expected one new failure, got %q", r.Text)
+	}
+	if !strings.Contains(r.Text, "lint broke") || !strings.Contains(r.Text, "⚠ echo old failure") {
+		t.Errorf("expected failure output and the pre-existing note, got %q", r.Text)
+	}
+	if strings.Contains(r.Text, "echo e2e") {
+		t.Error("UI commands should only run for UI stories")
+	}
+
+	cfg.Config.Verify.Default = []string{"true"}
+	s.ctx.story.Tags = []string{"ui"}
+	if r := s.handle("run_verification", nil); r.IsError || !strings.Contains(r.Text, "✓ echo e2e") {
+		t.Errorf("expected UI commands to pass, got %q", r.Text)
+	}
+
+	cfg.Config.Verify.ByTag = map[string][]string{"db": {"echo migrations"}}
+	s.ctx.story.Tags = []string{"db"}
+	s.ctx.story.Verify = []string{"echo contract"}
+	r = s.handle("run_verification", nil)
+	if r.IsError || !strings.Contains(r.Text, "✓ echo migrations") || !strings.Contains(r.Text, "✓ echo contract") {
+		t.Errorf("expected tag and story commands to run, got %q", r.Text)
+	}
+}
+
+func TestMCPSession_RunVerificationAffected(t *testing.T) {
+	dir, git := initTestRepo(t)
+	base := git.GetLastCommit()
+	commitFiles(t, dir, git, map[string]string{"src/app.ts": "export {}\n"})
+
+	cfg := &ResolvedConfig{ProjectRoot: dir, Config: RalphConfig{Verify: VerifyConfig{
+		Default:  []string{"echo lint", "echo related", "echo tests"},
+		Affected: map[string]string{"echo related": "echo related {{changedFiles}}", "echo tests": "echo tests {{changedTestFiles}}"},
+		Timeout:  10,
+	}}}
+	s := &mcpSession{cfg: cfg, ctx: &mcpContext{story: &StoryDefinition{ID: "US-001"}, base: base}, result: &ProviderResult{}, mu: &sync.Mutex{}}
+
+	r := s.handle("run_verification", nil)
+	for _, want := range []string{"✓ echo lint", "✓ echo related src/app.ts", "○ echo tests (skipped: nothing it checks changed)"} {
+		if !strings.Contains(r.Text, want) {
+			t.Errorf("expected %q, got %q", want, r.Text)
+		}
+	}
+}
+
+func TestMCPSession_ServiceLogs(t *testing.T) {
+	s := &mcpSession{cfg: &ResolvedConfig{}, ctx: &mcpContext{story: &StoryDefinition{}}, result: &ProviderResult{}, mu: &sync.Mutex{}}
+	if r := s.handle("get_service_logs", nil); r.Text != "No services are configured." {
+		t.Errorf("got %q", r.Text)
+	}
+
+	services := []ServiceConfig{{Name: "dev", Ready: "http://localhost:3000"}}
+	s.cfg.Config.Services = services
+	s.ctx.services = NewServiceManager(t.TempDir(), services)
+	if r := s.handle("get_service_logs", json.RawMessage(`{"service":"api"}`)); !r.IsError {
+		t.Errorf("expected unknown service error, got %q", r.Text)
+	}
+	if r := s.handle("get_service_logs", json.RawMessage(`{"service":"dev","lines":10}`)); !strings.Contains(r.Text, "--- dev (last 10 lines) ---") {
+		t.Errorf("got %q", r.Text)
+	}
+}
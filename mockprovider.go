@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MockScript is the script `ralph mock-provider` follows in place of an AI. Steps run
+// for every story unless the story has its own entry in Stories.
+type MockScript struct {
+	Steps   []MockStep            `json:"steps"`
+	Stories map[string][]MockStep `json:"stories,omitempty"`
+}
+
+// MockStep is one scripted action. Exactly one action field is set; Attempt optionally
+// limits the step to one attempt of a story.
+type MockStep struct {
+	Attempt int `json:"attempt,omitempty"` // run only on this 1-based attempt (0 = every attempt)
+
+	Write    string  `json:"write,omitempty"`    // write Content to this path
+	Content  string  `json:"content,omitempty"`  // file content for write
+	Append   bool    `json:"append,omitempty"`   // append to the write path instead of replacing it
+	Run      string  `json:"run,omitempty"`      // shell command; a failure is printed, not fatal
+	Commit   string  `json:"commit,omitempty"`   // stage everything and commit with this message
+	Print    string  `json:"print,omitempty"`    // print text to stdout
+	Expect   string  `json:"expect,omitempty"`   // exit 1 unless the prompt contains this text
+	Learning string  `json:"learning,omitempty"` // emit a LEARNING marker
+	Stuck    string  `json:"stuck,omitempty"`    // emit a STUCK marker with this reason
+	Question string  `json:"question,omitempty"` // emit a QUESTION marker and stop, unless the prompt already has its answer
+	Done     bool    `json:"done,omitempty"`     // emit the DONE marker
+	Sleep    float64 `json:"sleep,omitempty"`    // pause for this many seconds
+	Hang     bool    `json:"hang,omitempty"`     // block silently until killed
+	Exit     *int    `json:"exit,omitempty"`     // stop the script with this exit code
+}
+
+// action returns the name of the step's action, or "" when it has none or several.
+func (s MockStep) action() string {
+	var set []string
+	if s.Write != "" {
+		set = append(set, "write")
+	}
+	if s.Run != "" {
+		set = append(set, "run")
+	}
+	if s.Commit != "" {
+		set = append(set, "commit")
+	}
+	if s.Print != "" {
+		set = append(set, "print")
+	}
+	if s.Expect != "" {
+		set = append(set, "expect")
+	}
+	if s.Learning != "" {
+		set = append(set, "learning")
+	}
+	if s.Stuck != "" {
+		set = append(set, "stuck")
+	}
+	if s.Question != "" {
+		set = append(set, "question")
+	}
+	if s.Done {
+		set = append(set, "done")
+	}
+	if s.Sleep > 0 {
+		set = append(set, "sleep")
+	}
+	if s.Hang {
+		set = append(set, "hang")
+	}
+	if s.Exit != nil {
+		set = append(set, "exit")
+	}
+	if len(set) != 1 {
+		return ""
+	}
+	return set[0]
+}
+
+// isMockProvider reports whether a provider entry runs `ralph mock-provider`.
+func isMockProvider(p ProviderConfig) bool {
+	return len(p.Args) > 0 && p.Args[0] == "mock-provider"
+}
+
+// LoadMockScript reads and validates a mock provider script.
+func LoadMockScript(path string) (*MockScript, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mock script: %w", err)
+	}
+	var script MockScript
+	if err := json.Unmarshal(data, &script); err != nil {
+		return nil, fmt.Errorf("invalid mock script %s: %w", path, err)
+	}
+	check := func(where string, steps []MockStep) error {
+		for i, s := range steps {
+			if s.action() == "" {
+				return fmt.Errorf("%s step %d must set exactly one action (write, run, commit, print, expect, learning, stuck, question, done, sleep, hang, exit)", where, i+1)
+			}
+			if s.Attempt < 0 {
+				return fmt.Errorf("%s step %d: attempt must not be negative", where, i+1)
+			}
+		}
+		return nil
+	}
+	if err := check("steps", script.Steps); err != nil {
+		return nil, err
+	}
+	for id, steps := range script.Stories {
+		if err := check("stories."+id, steps); err != nil {
+			return nil, err
+		}
+	}
+	return &script, nil
+}
+
+var (
+	mockStoryIDPattern    = regexp.MustCompile(`(?m)^\*\*ID:\*\* (\S+)`)
+	mockStoryTitlePattern = regexp.MustCompile(`(?m)^\*\*Title:\*\* (.+)$`)
+)
+
+// mockContext is what the mock provider reads from the run prompt.
+type mockContext struct {
+	prompt     string
+	storyID    string
+	storyTitle string
+	attempt    int
+}
+
+// newMockContext reads the story from the prompt. attempt is RALPH_ATTEMPT, which counts
+// every attempt of the story; the prompt's retry count leaves out uncharged ones.
+func newMockContext(prompt string, attempt int) mockContext {
+	ctx := mockContext{prompt: prompt, attempt: max(attempt, 1)}
+	if m := mockStoryIDPattern.FindStringSubmatch(prompt); m != nil {
+		ctx.storyID = m[1]
+	}
+	if m := mockStoryTitlePattern.FindStringSubmatch(prompt); m != nil {
+		ctx.storyTitle = strings.TrimSpace(m[1])
+	}
+	return ctx
+}
+
+// expand replaces {{storyId}}, {{storyTitle}} and {{attempt}} in a step value.
+func (c mockContext) expand(s string) string {
+	return strings.NewReplacer(
+		"{{storyId}}", c.storyID,
+		"{{storyTitle}}", c.storyTitle,
+		"{{attempt}}", strconv.Itoa(c.attempt),
+	).Replace(s)
+}
+
+// runMockScript performs the script's steps for the story in the prompt, in dir.
+// Returns the exit code for the mock provider process.
+func runMockScript(script *MockScript, prompt string, attempt int, dir string, stdout, stderr io.Writer) int {
+	ctx := newMockContext(prompt, attempt)
+	steps := script.Steps
+	if storySteps, ok := script.Stories[ctx.storyID]; ok {
+		steps = storySteps
+	}
+
+	for _, s := range steps {
+		if s.Attempt > 0 && s.Attempt != ctx.attempt {
+			continue
+		}
+		switch s.action() {
+		case "write":
+			path := ctx.expand(s.Write)
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				fmt.Fprintf(stderr, "mock-provider: %v\n", err)
+				return 1
+			}
+			flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+			if s.Append {
+				flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+			}
+			f, err := os.OpenFile(path, flags, 0644)
+			if err == nil {
+				_, err = f.WriteString(ctx.expand(s.Content))
+				f.Close()
+			}
+			if err != nil {
+				fmt.Fprintf(stderr, "mock-provider: failed to write %s: %v\n", s.Write, err)
+				return 1
+			}
+			fmt.Fprintf(stdout, "mock-provider: wrote %s\n", s.Write)
+		case "run":
+			cmd := exec.Command("sh", "-c", ctx.expand(s.Run))
+			cmd.Dir = dir
+			cmd.Stdout = stdout
+			cmd.Stderr = stderr
+			if err := cmd.Run(); err != nil {
+				fmt.Fprintf(stdout, "mock-provider: %q failed: %v\n", s.Run, err)
+			}
+		case "commit":
+			for _, args := range [][]string{{"add", "-A"}, {"commit", "-m", ctx.expand(s.Commit)}} {
+				cmd := exec.Command("git", args...)
+				cmd.Dir = dir
+				if out, err := cmd.CombinedOutput(); err != nil {
+					fmt.Fprintf(stderr, "mock-provider: git %s failed: %v\n%s", args[0], err, out)
+					return 1
+				}
+			}
+			fmt.Fprintf(stdout, "mock-provider: committed %q\n", ctx.expand(s.Commit))
+		case "print":
+			fmt.Fprintln(stdout, ctx.expand(s.Print))
+		case "expect":
+			if !strings.Contains(ctx.prompt, ctx.expand(s.Expect)) {
+				fmt.Fprintf(stderr, "mock-provider: prompt does not contain %q\n", s.Expect)
+				return 1
+			}
+		case "learning":
+			fmt.Fprintf(stdout, "<ralph>LEARNING:%s</ralph>\n", ctx.expand(s.Learning))
+		case "stuck":
+			fmt.Fprintf(stdout, "<ralph>STUCK:%s</ralph>\n", ctx.expand(s.Stuck))
+		case "question":
+			question := ctx.expand(s.Question)
+			if !strings.Contains(ctx.prompt, "**Q:** "+question) {
+				fmt.Fprintf(stdout, "<ralph>QUESTION:%s</ralph>\n", question)
+				return 0
+			}
+		case "done":
+			fmt.Fprintln(stdout, DoneMarker)
+		case "sleep":
+			time.Sleep(time.Duration(s.Sleep * float64(time.Second)))
+		case "hang":
+			for {
+				time.Sleep(time.Hour) // a bare select{} would trip Go's deadlock detector
+			}
+		case "exit":
+			return *s.Exit
+		}
+	}
+	return 0
+}
+
+// readMockPrompt returns the prompt from the last argument (prompt text, or a prompt
+// file in file mode) or, with no argument, from stdin.
+func readMockPrompt(args []string, stdin io.Reader) (string, error) {
+	if len(args) == 0 {
+		data, err := io.ReadAll(stdin)
+		return string(data), err
+	}
+	last := args[len(args)-1]
+	if !strings.Contains(last, "\n") && fileExists(last) {
+		data, err := os.ReadFile(last)
+		return string(data), err
+	}
+	return last, nil
+}
+
+func cmdMockProvider(args []string) {
+	fs := flag.NewFlagSet("mock-provider", flag.ExitOnError)
+	scriptPath := fs.String("script", os.Getenv("RALPH_MOCK_SCRIPT"), "Script of actions to perform (default: $RALPH_MOCK_SCRIPT)")
+
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: ralph mock-provider --script <file> [prompt | prompt-file]")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Acts as a provider CLI without calling an AI. Reads the prompt from stdin")
+		fmt.Fprintln(os.Stderr, "(or the last argument) and performs the scripted steps.")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Options:")
+		fs.PrintDefaults()
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Config:")
+		fmt.Fprintln(os.Stderr, `  "provider": {"command": "ralph", "args": ["mock-provider", "--script", "mock.json"]}`)
+	}
+	fs.Parse(args)
+
+	if *scriptPath == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	script, err := LoadMockScript(*scriptPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	prompt, err := readMockPrompt(fs.Args(), os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to read prompt: %v\n", err)
+		os.Exit(1)
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	attempt, _ := strconv.Atoi(os.Getenv("RALPH_ATTEMPT"))
+	os.Exit(runMockScript(script, prompt, attempt, dir, os.Stdout, os.Stderr))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const mockTestPrompt = "# Story Implementation\n\n**ID:** US-002\n**Title:** Add login form\n**Description:** ...\n"
+
+func writeMockScript(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "mock.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadMockScript_Valid(t *testing.T) {
+	path := writeMockScript(t, `{
+		"steps": [{"print": "hi"}, {"done": true}],
+		"stories": {"US-002": [{"stuck": "no db"}, {"exit": 0}]}
+	}`)
+	script, err := LoadMockScript(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(script.Steps) != 2 || len(script.Stories["US-002"]) != 2 {
+		t.Errorf("unexpected script: %+v", script)
+	}
+}
+
+func TestLoadMockScript_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"no action", `{"steps": [{"attempt": 2}]}`, "exactly one action"},
+		{"two actions", `{"steps": [{"print": "x", "done": true}]}`, "exactly one action"},
+		{"story step", `{"steps": [], "stories": {"US-001": [{}]}}`, "stories.US-001 step 1"},
+		{"bad json", `{"steps": [`, "invalid mock script"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadMockScript(writeMockScript(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestNewMockContext(t *testing.T) {
+	ctx := newMockContext(mockTestPrompt, 0)
+	if ctx.storyID != "US-002" || ctx.storyTitle != "Add login form" || ctx.attempt != 1 {
+		t.Errorf("unexpected context: %+v", ctx)
+	}
+
+	// The prompt's retry count leaves out uncharged attempts, so only RALPH_ATTEMPT counts
+	retry := newMockContext(mockTestPrompt+"\n**Previous Attempts:** 1 of 3 (2 remaining before skipped)\n", 3)
+	if retry.attempt != 3 {
+		t.Errorf("expected attempt 3, got %d", retry.attempt)
+	}
+
+	if got := ctx.expand("feat: {{storyId}} - {{storyTitle}} ({{attempt}})"); got != "feat: US-002 - Add login form (1)" {
+		t.Errorf("expand = %q", got)
+	}
+}
+
+func TestRunMockScript_WritesCommitsAndSignals(t *testing.T) {
+	dir, git := initTestRepo(t)
+	before := git.GetLastCommit()
+
+	script := &MockScript{Steps: []MockStep{
+		{Expect: "**ID:** US-002"},
+		{Write: "src/{{storyId}}.txt", Content: "hello\n"},
+		{Write: "src/{{storyId}}.txt", Content: "again\n", Append: true},
+		{Run: "ls src"},
+		{Commit: "feat: {{storyId}} - {{storyTitle}}"},
+		{Learning: "the store lives in src/"},
+		{Done: true},
+	}}
+	var stdout, stderr bytes.Buffer
+	if code := runMockScript(script, mockTestPrompt, 1, dir, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit code %d, stderr: %s", code, stderr.String())
+	}
+
+	data, _ := os.ReadFile(filepath.Join(dir, "src", "US-002.txt"))
+	if string(data) != "hello\nagain\n" {
+		t.Errorf("file content = %q", data)
+	}
+	if git.GetLastCommit() == before {
+		t.Error("expected a new commit")
+	}
+	if !git.IsWorkingTreeClean() {
+		t.Error("expected commit to include all changes")
+	}
+
+	result := &ProviderResult{}
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		processLine(line, result, nil)
+	}
+	if !result.Done || len(result.Learnings) != 1 {
+		t.Errorf("expected DONE and one LEARNING in output, got %+v\n%s", result, stdout.String())
+	}
+	if !strings.Contains(stdout.String(), "US-002.txt") {
+		t.Errorf("expected run output in stdout, got %s", stdout.String())
+	}
+}
+
+func TestRunMockScript_StoryAndAttemptSelection(t *testing.T) {
+	exit3 := 3
+	script := &MockScript{
+		Steps: []MockStep{{Print: "default"}},
+		Stories: map[string][]MockStep{
+			"US-002": {
+				{Stuck: "first try fails", Attempt: 1},
+				{Exit: &exit3, Attempt: 1},
+				{Done: true},
+			},
+		},
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := runMockScript(script, mockTestPrompt, 1, t.TempDir(), &stdout, &stderr); code != 3 {
+		t.Errorf("attempt 1: exit code = %d, want 3", code)
+	}
+	if !strings.Contains(stdout.String(), "<ralph>STUCK:first try fails</ralph>") || strings.Contains(stdout.String(), DoneMarker) {
+		t.Errorf("attempt 1 output = %q", stdout.String())
+	}
+
+	stdout.Reset()
+	if code := runMockScript(script, mockTestPrompt, 2, t.TempDir(), &stdout, &stderr); code != 0 {
+		t.Errorf("attempt 2: exit code = %d, want 0", code)
+	}
+	if strings.TrimSpace(stdout.String()) != DoneMarker {
+		t.Errorf("attempt 2 output = %q", stdout.String())
+	}
+
+	stdout.Reset()
+	runMockScript(script, "**ID:** US-009\n", 1, t.TempDir(), &stdout, &stderr)
+	if strings.TrimSpace(stdout.String()) != "default" {
+		t.Errorf("other stories should use default steps, got %q", stdout.String())
+	}
+}
+
+func TestRunMockScript_QuestionUntilAnswered(t *testing.T) {
+	script := &MockScript{Steps: []MockStep{{Question: "Soft or hard delete for {{storyId}}?"}, {Done: true}}}
+
+	var stdout, stderr bytes.Buffer
+	if code := runMockScript(script, mockTestPrompt, 1, t.TempDir(), &stdout, &stderr); code != 0 {
+		t.Errorf("exit code = %d, want 0", code)
+	}
+	if strings.TrimSpace(stdout.String()) != "<ralph>QUESTION:Soft or hard delete for US-002?</ralph>" {
+		t.Errorf("expected the question and nothing after it, got %q", stdout.String())
+	}
+
+	stdout.Reset()
+	answered := mockTestPrompt + "**Q:** Soft or hard delete for US-002?\n**A:** Soft\n"
+	runMockScript(script, answered, 1, t.TempDir(), &stdout, &stderr)
+	if strings.TrimSpace(stdout.String()) != DoneMarker {
+		t.Errorf("an answered question should not be asked again, got %q", stdout.String())
+	}
+}
+
+func TestRunMockScript_ExpectFails(t *testing.T) {
+	script := &MockScript{Steps: []MockStep{{Expect: "## Custom Section"}, {Done: true}}}
+	var stdout, stderr bytes.Buffer
+	if code := runMockScript(script, mockTestPrompt, 1, t.TempDir(), &stdout, &stderr); code != 1 {
+		t.Errorf("exit code = %d, want 1", code)
+	}
+	if !strings.Contains(stderr.String(), "## Custom Section") || stdout.Len() != 0 {
+		t.Errorf("expected failure before DONE, stdout=%q stderr=%q", stdout.String(), stderr.String())
+	}
+}
+
+func TestReadMockPrompt(t *testing.T) {
+	got, _ := readMockPrompt(nil, strings.NewReader("from stdin"))
+	if got != "from stdin" {
+		t.Errorf("stdin prompt = %q", got)
+	}
+
+	got, _ = readMockPrompt([]string{"line one\nline two"}, strings.NewReader(""))
+	if got != "line one\nline two" {
+		t.Errorf("arg prompt = %q", got)
+	}
+
+	file := filepath.Join(t.TempDir(), "prompt.md")
+	os.WriteFile(file, []byte("from file"), 0644)
+	got, _ = readMockPrompt([]string{file}, strings.NewReader(""))
+	if got != "from file" {
+		t.Errorf("file prompt = %q", got)
+	}
+}
+
+func TestCheckReadinessWarnings_MockProviderIsKnown(t *testing.T) {
+	cfg := &RalphConfig{Provider: ProviderConfig{Command: "ralph", Args: []string{"mock-provider", "--script", "mock.json"}}}
+	if warnings := CheckReadinessWarnings(cfg); len(warnings) != 0 {
+		t.Errorf("expected no unknown-provider warning for mock-provider, got %v", warnings)
+	}
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// parallelRun holds the run-wide context needed to execute batches of stories
+// concurrently. Providers work in detached git worktrees; this coordinator is the only
+// writer of run state and the only one touching the main working tree.
+type parallelRun struct {
+	cfg         *ResolvedConfig
+	featureDir  *FeatureDir
+	def         *PRDDefinition
+	statePath   string
+	git         *GitOps
+	logger      *RunLogger
+	cleanup     *CleanupCoordinator
+	svcMgr      *ServiceManager
+	rm          *ResourceManager
+	codebaseCtx *CodebaseContext
+	codebaseStr string
+	budget      *runBudget
+	backoff     *transientBackoff
+}
+
+// parallelWorker tracks one story's provider run inside its worktree.
+type parallelWorker struct {
+	story   *StoryDefinition
+	logger  *RunLogger
+	cfg     *ResolvedConfig // config with this attempt's escalation provider
+	dir     string
+	prompt  string
+	result  *ProviderResult
+	err     error
+	attempt AttemptRecord
+	head    string // worktree HEAD after the provider finished
+	dirty   bool
+
+	transient string // why the provider failed transiently, if it did
+}
+
+// selectParallelBatch picks up to n ready stories to implement concurrently.
+// UI stories are never batched: services run from the main checkout, so their e2e
+// verification can't see work in a worktree. A UI story at the top of the queue
+// returns nil so the regular serial iteration handles it.
+func selectParallelBatch(def *PRDDefinition, state *RunState, n int) []*StoryDefinition {
+	ready := GetReadyStories(def, state)
+	if len(ready) == 0 || IsUIStory(ready[0]) {
+		return nil
+	}
+	var batch []*StoryDefinition
+	for _, s := range ready {
+		if len(batch) >= n {
+			break
+		}
+		if !IsUIStory(s) {
+			batch = append(batch, s)
+		}
+	}
+	return batch
+}
+
+// runBatch implements a batch of independent stories at the same time, then merges
+// each story's commits onto the feature branch in priority order, re-verifying after
+// every merge. A story whose commits conflict or fail verification is reset off the
+// branch and charged a retry, so later merges are verified against a clean base.
+func (p *parallelRun) runBatch(state *RunState, batch []*StoryDefinition, iteration int) error {
+	cfg := p.cfg
+	logger := p.logger
+
+	// Verify-at-top for previously attempted stories (in the main tree)
+	var preverified []string
+	for _, story := range batch {
+		if !state.IsAttempted(story.ID) {
+			continue
+		}
+		verifyResult, verifyErr := runStoryVerification(cfg, p.featureDir, story, p.svcMgr, state.Baseline, state.StoryBase(story.ID, ""), logger)
+		if verifyErr == nil && verifyResult.passed {
+			logger.LogPrint("\n✓ %s already passes verification, marking complete\n", story.ID)
+			state.MarkPassed(story.ID)
+			preverified = append(preverified, story.ID)
+		}
+	}
+	if len(preverified) > 0 {
+		return p.saveState(state, fmt.Sprintf("ralph: %s pre-verified", strings.Join(preverified, ", ")))
+	}
+
+	ids := make([]string, len(batch))
+	for i, s := range batch {
+		ids[i] = s.ID
+	}
+
+	logger.LogPrintln()
+	fmt.Println(strings.Repeat("=", 60))
+	logger.LogPrint(" Iteration %d: %d stories in parallel (%s)\n", iteration, len(batch), strings.Join(ids, ", "))
+	fmt.Println(strings.Repeat("=", 60))
+
+	// Mark all stories attempted before provider spawn (enables verify-at-top on restart)
+	for _, story := range batch {
+		state.MarkAttempted(story.ID)
+	}
+	if cfg.Config.Commits.PrdChanges {
+		if err := commitPrdOnly(cfg.ProjectRoot, p.statePath, fmt.Sprintf("ralph: start %s", strings.Join(ids, ", "))); err != nil {
+			fmt.Printf("Warning: failed to commit state: %v\n", err)
+		}
+	}
+
+	// Every worktree starts from the same commit: after the state commit, before any provider runs
+	baseCommit := p.git.GetLastCommit()
+
+	diffSummary := ""
+	if diffStat := p.git.GetDiffSummary(); diffStat != "" {
+		diffSummary = "## Changes on Branch\n\n```\n" + truncateOutput(diffStat, 60) + "\n```\n"
+	}
+
+	// Drop bookkeeping for worktrees left behind by an interrupted run
+	p.git.PruneWorktrees()
+	wtRoot, err := os.MkdirTemp("", "ralph-worktrees-")
+	if err != nil {
+		return fmt.Errorf("failed to create worktree directory: %w", err)
+	}
+	defer os.RemoveAll(wtRoot)
+
+	// Prepare prompts and worktrees serially (consultation may spawn its own subagents)
+	workers := make([]*parallelWorker, 0, len(batch))
+	defer func() {
+		for _, w := range workers {
+			if err := p.git.RemoveWorktree(w.dir); err != nil {
+				logger.Warning(fmt.Sprintf("failed to remove worktree %s: %v", w.dir, err))
+			}
+			p.cleanup.RemoveWorktree(w.dir)
+		}
+	}()
+	for _, story := range batch {
+		w := &parallelWorker{
+			story:  story,
+			logger: logger.ForStory(story.ID),
+			dir:    filepath.Join(wtRoot, story.ID),
+		}
+		if err := p.git.AddWorktree(w.dir, baseCommit); err != nil {
+			return fmt.Errorf("failed to create worktree for %s: %w", story.ID, err)
+		}
+		p.cleanup.AddWorktree(p.git, w.dir)
+		workers = append(workers, w)
+
+		resourceGuidance := buildStoryGuidance(cfg, p.featureDir, story, p.rm, p.codebaseCtx, logger)
+		w.cfg = attemptConfig(cfg, state, story, w.logger)
+		var size *PromptBudget
+		w.prompt, size = generateRunPrompt(w.cfg, p.featureDir, p.def, state, story, p.codebaseStr, diffSummary, resourceGuidance)
+		logPromptBudget(w.logger, size)
+	}
+
+	// Run all providers concurrently, each rooted in its own worktree
+	p.budget.startSessions(len(workers))
+	var wg sync.WaitGroup
+	for _, w := range workers {
+		wg.Add(1)
+		go func(w *parallelWorker) {
+			defer wg.Done()
+			w.logger.IterationStart(w.story.ID, w.story.Title, state.GetRetries(w.story.ID))
+			w.logger.LogPrintln("Provider running...")
+			w.logger.ProviderStart()
+			w.attempt = AttemptRecord{StartedAt: time.Now(), PreCommit: baseCommit, Provider: w.cfg.Config.Provider.Command}
+
+			wtCfg := *w.cfg
+			wtCfg.ProjectRoot = w.dir
+			tools := &mcpContext{story: w.story, services: p.svcMgr, baseline: state.Baseline, base: state.StoryBase(w.story.ID, baseCommit)}
+			w.result, w.err = runProvider(&wtCfg, w.prompt, tools, w.logger, p.cleanup)
+
+			logProviderEnd(w.logger, w.result)
+			w.logger.LogPrint("Provider done (%s)\n", FormatDuration(time.Since(w.attempt.StartedAt)))
+			if w.result != nil {
+				w.attempt.ExitCode = w.result.ExitCode
+				w.attempt.Markers = providerMarkers(w.result)
+				w.attempt.Session = w.result.SessionID
+				w.attempt.Cost = p.budget.attemptCost(w.result)
+			}
+
+			wtGit := NewGitOps(w.dir)
+			w.head = wtGit.GetLastCommit()
+			w.dirty = !wtGit.IsWorkingTreeClean()
+		}(w)
+	}
+	wg.Wait()
+	for _, w := range workers {
+		state.Cost += w.attempt.Cost
+	}
+
+	// Merge results back one story at a time, in priority order. Errors stop the run,
+	// but only after every story in the batch has been recorded.
+	var firstErr error
+	transient := ""
+	for _, w := range workers {
+		if err := p.mergeWorker(state, w, baseCommit); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if w.transient != "" && transient == "" {
+			transient = w.transient
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+
+	// One backoff for the batch: its providers share the same API limits
+	if transient == "" {
+		p.backoff.reset()
+		return nil
+	}
+	return waitTransient(p.backoff, p.budget, transient, logger)
+}
+
+// mergeWorker applies one story's outcome to run state. Successful providers get their
+// commits cherry-picked onto the feature branch and verified in the main tree.
+// Returns an error for provider errors and state save failures.
+func (p *parallelRun) mergeWorker(state *RunState, w *parallelWorker, baseCommit string) error {
+	cfg := p.cfg
+	story := w.story
+	logger := w.logger
+	attempt := w.attempt
+	attempt.PostCommit = w.head
+
+	// Process learnings even on error
+	if w.result != nil {
+		for _, learning := range w.result.Learnings {
+			state.AddLearning(learning)
+			logger.Learning(learning)
+		}
+	}
+
+	if reason := p.backoff.classify(w.result, w.err); reason != "" {
+		logger.LogPrint("\n! Transient provider error on %s: %s\n", story.ID, reason)
+		logger.Warning("transient provider error: " + reason)
+		w.transient = reason
+		attempt.Failure = FailureTransient
+		attempt.Reason = "Transient provider error: " + reason
+		attempt.Uncharged = true
+		attempt.EndedAt = time.Now()
+		state.RecordAttempt(story.ID, attempt, cfg.Config.MaxRetries)
+		logger.IterationEnd(false)
+		return p.saveState(state, fmt.Sprintf("ralph: %s transient provider error", story.ID))
+	}
+
+	if w.err != nil {
+		logger.Error("provider error", w.err)
+		attempt.Failure = FailureProvider
+		if w.result != nil && w.result.TimedOut {
+			attempt.Failure = FailureTimeout
+		}
+		attempt.Reason = w.err.Error()
+		attempt.Uncharged = true
+		attempt.EndedAt = time.Now()
+		state.RecordAttempt(story.ID, attempt, cfg.Config.MaxRetries)
+		logger.IterationEnd(false)
+		if err := p.saveState(state, fmt.Sprintf("ralph: %s provider error", story.ID)); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+		return fmt.Errorf("provider error (%s): %w", story.ID, w.err)
+	}
+
+	if len(w.result.Questions) > 0 && !w.result.Done {
+		question := strings.Join(w.result.Questions, "\n")
+		logger.LogPrint("\n? %s is awaiting input: %s\n", story.ID, question)
+		logger.LogPrint("  Answer with: ralph answer %s %s \"...\"\n", p.featureDir.Feature, story.ID)
+		logger.StateChange(story.ID, "pending", "awaiting_input", map[string]interface{}{"question": question})
+		for _, q := range w.result.Questions {
+			state.AskQuestion(story.ID, q)
+		}
+		attempt.Failure = FailureQuestion
+		attempt.Reason = "Question: " + question
+		attempt.Uncharged = true
+		attempt.EndedAt = time.Now()
+		// Worktree commits aren't merged until the story is done; keep them for reference
+		if w.head != "" && w.head != baseCommit {
+			p.savePatch(state, w, &attempt, baseCommit)
+		}
+		state.RecordAttempt(story.ID, attempt, cfg.Config.MaxRetries)
+		logger.IterationEnd(false)
+		return p.saveState(state, fmt.Sprintf("ralph: %s awaiting input", story.ID))
+	}
+
+	if w.result.Stalled {
+		idle := w.cfg.Config.Provider.IdleTimeout
+		logger.LogPrint("\n! Provider stalled on %s (no output for %ds)\n", story.ID, idle)
+		attempt.Failure = FailureStalled
+		attempt.Reason = fmt.Sprintf("Provider stalled: no output for %ds, process killed. It may have been waiting on an interactive prompt or permission request.", idle)
+		return p.failStory(state, w, attempt, baseCommit, "stalled")
+	}
+
+	if w.result.Stuck {
+		reason := w.result.StuckNote
+		if reason == "" {
+			reason = "Provider signaled STUCK"
+		}
+		logger.LogPrint("\n! Provider stuck on %s: %s\n", story.ID, reason)
+		attempt.Failure = FailureStuck
+		attempt.Reason = reason
+		return p.failStory(state, w, attempt, baseCommit, "stuck")
+	}
+
+	if !w.result.Done {
+		logger.LogPrintln("\nProvider did not signal completion. Retrying...")
+		logger.Warning("provider did not signal completion")
+		attempt.Failure = FailureNoSignal
+		attempt.Reason = "Provider did not signal completion"
+		return p.failStory(state, w, attempt, baseCommit, "no completion signal")
+	}
+
+	if w.head == "" || w.head == baseCommit {
+		logger.LogPrintln("\n! Provider signaled DONE but made no new commit.")
+		logger.Warning("provider signaled DONE but made no new commit")
+		attempt.Failure = FailureNoCommit
+		attempt.Reason = "No commit made — provider signaled DONE without committing code"
+		return p.failStory(state, w, attempt, baseCommit, "no commit")
+	}
+
+	if w.dirty {
+		logger.LogPrintln("\n! Worktree has uncommitted changes after provider finished (not merged).")
+		logger.Warning("worktree has uncommitted changes after provider finished")
+	}
+
+	// Bring the story's commits onto the feature branch
+	preMerge := p.git.GetLastCommit()
+	logger.LogPrintln("\nMerging commits onto " + p.def.BranchName + "...")
+	if err := p.git.CherryPickRange(baseCommit, w.head); err != nil {
+		logger.LogPrint("\n! Merge conflict for %s\n", story.ID)
+		attempt.Failure = FailureConflict
+		attempt.Reason = fmt.Sprintf("Merge conflict: commits from the parallel worktree did not apply cleanly on top of other stories merged in the same batch.\n%v", err)
+		return p.failStory(state, w, attempt, baseCommit, "merge conflict")
+	}
+
+	// Re-verify on the merged branch
+	logger.LogPrintln("\nRunning verification...")
+	logger.VerifyStart()
+	verifyResult, err := runStoryVerification(cfg, p.featureDir, story, p.svcMgr, state.Baseline, state.StoryBase(story.ID, preMerge), logger)
+	if err != nil || !verifyResult.passed {
+		if err != nil {
+			attempt.Failure = FailureVerify
+			attempt.Reason = fmt.Sprintf("verification error: %v", err)
+		} else {
+			attempt.Failure = verifyResult.failure
+			attempt.FailedCommand = verifyResult.failedCmd
+			attempt.FailedTests = verifyResult.failedTests
+			attempt.Reason = verifyResult.reason
+		}
+		logger.LogPrint("\nVerification failed: %s\n", attempt.Reason)
+		logger.VerifyEnd(false)
+		// Take the story back off the branch so later merges verify against known-good code
+		if resetErr := p.git.ResetHard(preMerge); resetErr != nil {
+			logger.Warning("failed to reset merged commits: " + resetErr.Error())
+		}
+		return p.failStory(state, w, attempt, baseCommit, "failed verification")
+	}
+	logger.VerifyEnd(true)
+
+	attempt.Passed = true
+	attempt.PostCommit = p.git.GetLastCommit()
+	attempt.EndedAt = time.Now()
+	state.RecordAttempt(story.ID, attempt, cfg.Config.MaxRetries)
+	logger.StateChange(story.ID, "pending", "passed", nil)
+	if err := p.saveState(state, fmt.Sprintf("ralph: %s complete", story.ID)); err != nil {
+		logger.IterationEnd(false)
+		return err
+	}
+	logger.LogPrint("\n✓ %s complete\n", story.ID)
+	logger.IterationEnd(true)
+	return nil
+}
+
+// savePatch preserves a dropped story's worktree commits as attempts/<story>-<n>.patch.
+func (p *parallelRun) savePatch(state *RunState, w *parallelWorker, attempt *AttemptRecord, baseCommit string) {
+	number := len(state.GetAttempts(w.story.ID)) + 1
+	patch, err := saveAttemptPatch(p.git, p.featureDir, w.story.ID, number, baseCommit, w.head)
+	if err != nil {
+		w.logger.Warning("failed to save attempt patch: " + err.Error())
+		return
+	}
+	attempt.Patch = patch
+}
+
+// failStory records a failed attempt for a worker's story and commits the state. Any
+// commits the provider made in its worktree are saved as a patch, since they never reach
+// the feature branch.
+func (p *parallelRun) failStory(state *RunState, w *parallelWorker, attempt AttemptRecord, baseCommit, commitSuffix string) error {
+	if w.head != "" && w.head != baseCommit {
+		p.savePatch(state, w, &attempt, baseCommit)
+	}
+	w.logger.StateChange(w.story.ID, "pending", "failed", map[string]interface{}{"reason": attempt.Reason})
+	attempt.EndedAt = time.Now()
+	state.RecordAttempt(w.story.ID, attempt, p.cfg.Config.MaxRetries)
+	err := p.saveState(state, fmt.Sprintf("ralph: %s %s", w.story.ID, commitSuffix))
+	w.logger.IterationEnd(false)
+	return err
+}
+
+// saveState persists run state and commits it when prdChanges commits are enabled.
+func (p *parallelRun) saveState(state *RunState, message string) error {
+	if err := SaveRunState(p.statePath, state); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	if p.cfg.Config.Commits.PrdChanges {
+		if commitErr := commitPrdOnly(p.cfg.ProjectRoot, p.statePath, message); commitErr != nil {
+			p.logger.Warning("failed to commit state: " + commitErr.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func batchIDs(batch []*StoryDefinition) string {
+	var ids []string
+	for _, s := range batch {
+		ids = append(ids, s.ID)
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestSelectParallelBatch(t *testing.T) {
+	def := &PRDDefinition{
+		UserStories: []StoryDefinition{
+			{ID: "US-001", Priority: 1},
+			{ID: "US-002", Priority: 2, Tags: []string{"ui"}},
+			{ID: "US-003", Priority: 3},
+			{ID: "US-004", Priority: 4, DependsOn: []string{"US-001"}},
+			{ID: "US-005", Priority: 5},
+		},
+	}
+	state := NewRunState()
+
+	// UI stories and stories with unmet dependencies are left out; capped at n
+	if got := batchIDs(selectParallelBatch(def, state, 2)); got != "US-001,US-003" {
+		t.Errorf("expected US-001,US-003, got %s", got)
+	}
+	if got := batchIDs(selectParallelBatch(def, state, 10)); got != "US-001,US-003,US-005" {
+		t.Errorf("expected US-001,US-003,US-005, got %s", got)
+	}
+
+	// UI story at the top of the queue runs serially
+	state.MarkPassed("US-001")
+	if batch := selectParallelBatch(def, state, 3); batch != nil {
+		t.Errorf("expected nil batch when a UI story is next, got %s", batchIDs(batch))
+	}
+}
+
+func TestSelectParallelBatch_NothingReady(t *testing.T) {
+	def := &PRDDefinition{UserStories: []StoryDefinition{{ID: "US-001", Priority: 1}}}
+	state := NewRunState()
+	state.MarkPassed("US-001")
+
+	if batch := selectParallelBatch(def, state, 3); batch != nil {
+		t.Errorf("expected nil batch, got %s", batchIDs(batch))
+	}
+}
+
+func TestMergeWorker_SavesUnmergedCommits(t *testing.T) {
+	dir, git := initTestRepo(t)
+	base := git.GetLastCommit()
+	commitFiles(t, dir, git, map[string]string{"src/app.go": "package app\n"})
+	head := git.GetLastCommit()
+	// The commit only exists in the story's worktree
+	git.run("reset", "--hard", base)
+
+	featureDir := newFeatureDir(filepath.Join(dir, ".ralph"), "auth")
+	os.MkdirAll(featureDir.Path, 0755)
+	logger, err := NewRunLogger(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	cfg := &ResolvedConfig{ProjectRoot: dir, Config: RalphConfig{MaxRetries: 5, Commits: &CommitsConfig{}}}
+	p := &parallelRun{
+		cfg:        cfg,
+		featureDir: featureDir,
+		statePath:  featureDir.RunStatePath(),
+		git:        git,
+		logger:     logger,
+		backoff:    newTransientBackoff(nil),
+	}
+	state := NewRunState()
+	story := &StoryDefinition{ID: "US-001"}
+
+	for _, result := range []*ProviderResult{{Stalled: true}, {Stuck: true}, {ExitCode: 0}} {
+		w := &parallelWorker{story: story, logger: logger, cfg: cfg, result: result, head: head}
+		if err := p.mergeWorker(state, w, base); err != nil {
+			t.Fatal(err)
+		}
+		attempts := state.GetAttempts(story.ID)
+		last := attempts[len(attempts)-1]
+		if last.Patch == "" {
+			t.Errorf("%s: expected the worktree commits to be saved as a patch", last.Failure)
+			continue
+		}
+		if data, err := os.ReadFile(filepath.Join(featureDir.Path, last.Patch)); err != nil || !strings.Contains(string(data), "src/app.go") {
+			t.Errorf("%s: expected the patch to hold the commit, got %v", last.Failure, err)
+		}
+	}
+
+	// A provider that made no commits has nothing to save
+	w := &parallelWorker{story: story, logger: logger, cfg: cfg, result: &ProviderResult{Stuck: true}, head: base}
+	if err := p.mergeWorker(state, w, base); err != nil {
+		t.Fatal(err)
+	}
+	attempts := state.GetAttempts(story.ID)
+	if last := attempts[len(attempts)-1]; last.Patch != "" {
+		t.Errorf("expected no patch without commits, got %q", last.Patch)
+	}
+}
+
+func TestMergeWorker_NoSignalMentioningRateLimits(t *testing.T) {
+	dir, git := initTestRepo(t)
+	base := git.GetLastCommit()
+	featureDir := newFeatureDir(filepath.Join(dir, ".ralph"), "auth")
+	os.MkdirAll(featureDir.Path, 0755)
+	logger, err := NewRunLogger(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	cfg := &ResolvedConfig{ProjectRoot: dir, Config: RalphConfig{MaxRetries: 5, Commits: &CommitsConfig{}}}
+	p := &parallelRun{
+		cfg:        cfg,
+		featureDir: featureDir,
+		statePath:  featureDir.RunStatePath(),
+		git:        git,
+		logger:     logger,
+		backoff:    newTransientBackoff(nil),
+	}
+	state := NewRunState()
+	story := &StoryDefinition{ID: "US-001"}
+
+	for _, script := range []string{
+		"echo 'Added the limiter: requests over the rate limit get HTTP 429'",
+		"echo 'Still wiring up the 429 status for rate limiting'; exit 1",
+	} {
+		providerCfg := &ResolvedConfig{ProjectRoot: dir, Config: RalphConfig{Provider: ProviderConfig{
+			Command: "sh", Args: []string{"-c", script}, PromptMode: "stdin", Timeout: 30,
+		}}}
+		result, err := runProvider(providerCfg, "prompt", nil, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := &parallelWorker{story: story, logger: logger, cfg: cfg, result: result, head: base}
+		if err := p.mergeWorker(state, w, base); err != nil {
+			t.Fatal(err)
+		}
+		attempts := state.GetAttempts(story.ID)
+		if last := attempts[len(attempts)-1]; last.Failure != FailureNoSignal || last.Uncharged {
+			t.Errorf("%q: expected a charged no-signal failure, got %s (uncharged %v)", script, last.Failure, last.Uncharged)
+		}
+	}
+}
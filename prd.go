@@ -38,7 +38,7 @@ func prdStateNew(cfg *ResolvedConfig, featureDir *FeatureDir, resourceGuidance s
 
 	// Generate and run brainstorming prompt
 	prompt := generatePrdCreatePrompt(cfg, featureDir, codebaseCtx, resourceGuidance)
-	if err := runProviderInteractive(cfg, prompt); err != nil {
+	if err := runProviderInteractive(cfg, featureDir, prompt); err != nil {
 		return err
 	}
 
@@ -75,7 +75,7 @@ func prdRefineAndFinalize(cfg *ResolvedConfig, featureDir *FeatureDir, resourceG
 
 	// Generate and run refine prompt
 	prompt := generatePrdRefinePrompt(cfg, featureDir, codebaseCtx, resourceGuidance)
-	if err := runProviderInteractive(cfg, prompt); err != nil {
+	if err := runProviderInteractive(cfg, featureDir, prompt); err != nil {
 		return err
 	}
 
@@ -97,7 +97,7 @@ func prdFinalize(cfg *ResolvedConfig, featureDir *FeatureDir, resourceGuidance s
 	}
 
 	prompt := generatePrdFinalizePrompt(cfg, featureDir, string(content), resourceGuidance)
-	if err := runProviderInteractive(cfg, prompt); err != nil {
+	if err := runProviderInteractive(cfg, featureDir, prompt); err != nil {
 		return err
 	}
 
@@ -131,7 +131,9 @@ func prdFinalize(cfg *ResolvedConfig, featureDir *FeatureDir, resourceGuidance s
 // need it (e.g., to avoid shell argument length limits).
 // Non-interactive flags like --print are stripped so the provider runs
 // as a full interactive CLI session (user answers questions, then exits).
-func runProviderInteractive(cfg *ResolvedConfig, prompt string) error {
+// The configured mcpServers are passed along, so these sessions get the
+// same tools as ralph run.
+func runProviderInteractive(cfg *ResolvedConfig, featureDir *FeatureDir, prompt string) error {
 	promptMode := cfg.Config.Provider.PromptMode
 	if promptMode == "stdin" || promptMode == "" {
 		promptMode = "arg"
@@ -139,7 +141,14 @@ func runProviderInteractive(cfg *ResolvedConfig, prompt string) error {
 
 	// Strip non-interactive flags so the provider runs interactively.
 	// e.g., claude's --print suppresses streaming and prevents conversation.
-	interactiveArgs := stripNonInteractiveArgs(cfg.Config.Provider.Args)
+	mcpArgs, mcpConfig, err := withMCPServers(cfg.Config.Provider, cfg.Config.MCPServers, nil, LogsDir(featureDir.Path))
+	if err != nil {
+		return err
+	}
+	if mcpConfig != "" {
+		defer os.Remove(mcpConfig)
+	}
+	interactiveArgs := stripNonInteractiveArgs(mcpArgs)
 
 	args, promptFile, err := buildProviderArgs(
 		interactiveArgs,
@@ -4,23 +4,21 @@ import (
 	"embed"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
 //go:embed prompts/*
 var promptsFS embed.FS
 
+// getPrompt renders the named template, preferring the project's override loaded from
+// .ralph/prompts at startup over the embedded one.
 func getPrompt(name string, vars map[string]string) string {
-	data, err := promptsFS.ReadFile("prompts/" + name + ".md")
-	if err != nil {
+	content, ok := activePrompt(name)
+	if !ok {
 		panic("prompt not found: " + name)
 	}
-
-	content := string(data)
-	for key, value := range vars {
-		content = strings.ReplaceAll(content, "{{"+key+"}}", value)
-	}
-	return content
+	return renderPrompt(content, vars)
 }
 
 const maxLearningsInPrompt = 50
@@ -48,10 +46,15 @@ func buildProgress(def *PRDDefinition, state *RunState) string {
 	passed := CountPassed(state)
 	skipped := CountSkipped(state)
 
+	blocked := CountBlocked(state)
+
 	s := fmt.Sprintf("%d/%d stories complete", passed, total)
 	if skipped > 0 {
 		s += fmt.Sprintf(" (%d skipped)", skipped)
 	}
+	if blocked > 0 {
+		s += fmt.Sprintf(" (%d blocked)", blocked)
+	}
 	return s
 }
 
@@ -73,36 +76,137 @@ func buildStoryMap(def *PRDDefinition, state *RunState, current *StoryDefinition
 			} else {
 				line += " (skipped)"
 			}
+		case state.IsBlocked(s.ID):
+			line = fmt.Sprintf("⊘ %s: %s (blocked: %s)", s.ID, s.Title, state.GetBlockedReason(s.ID))
+		case state.IsAwaitingInput(s.ID):
+			line = fmt.Sprintf("? %s: %s (awaiting input)", s.ID, s.Title)
 		default:
 			line = fmt.Sprintf("○ %s: %s", s.ID, s.Title)
 		}
+		if len(s.DependsOn) > 0 {
+			line += " [depends on " + strings.Join(s.DependsOn, ", ") + "]"
+		}
 		lines = append(lines, line)
 	}
 	return strings.Join(lines, "\n")
 }
 
-// generateRunPrompt generates the prompt for story implementation.
-// codebaseStr and diffSummary are pre-computed in runLoop to avoid redundant per-iteration I/O.
-// resourceGuidance is the pre-computed consultation guidance (or fallback instructions).
-func generateRunPrompt(cfg *ResolvedConfig, featureDir *FeatureDir, def *PRDDefinition, state *RunState, story *StoryDefinition, codebaseStr, diffSummary, resourceGuidance string) string {
-	// Build acceptance criteria list
-	var criteria []string
-	for _, c := range story.AcceptanceCriteria {
-		criteria = append(criteria, "- "+c)
+// buildAnswers lists the questions a human has answered for a story. Empty when there
+// are none.
+func buildAnswers(state *RunState, storyID string) string {
+	var b strings.Builder
+	for _, q := range state.GetQuestions(storyID) {
+		if q.Answer == "" {
+			continue
+		}
+		if b.Len() == 0 {
+			b.WriteString("## Answers to Your Questions\n\nA human answered questions asked in earlier attempts. These answers are decisions: follow them over your own assumptions.\n")
+		}
+		fmt.Fprintf(&b, "\n**Q:** %s\n**A:** %s\n", q.Question, q.Answer)
 	}
-	criteriaStr := strings.Join(criteria, "\n")
+	return b.String()
+}
 
-	// Build verify commands list
+// formatAttempt renders one attempt record as a single line, e.g.
+// "Attempt 2: test — npm test (3m12s)".
+func formatAttempt(a AttemptRecord) string {
+	outcome := "passed"
+	if !a.Passed {
+		outcome = "failed"
+		if a.Failure != "" {
+			outcome = string(a.Failure)
+		}
+	}
+	line := fmt.Sprintf("Attempt %d: %s", a.Number, outcome)
+
+	detail := a.FailedCommand
+	if detail == "" && !a.Passed && a.Reason != "" {
+		detail = strings.SplitN(a.Reason, "\n", 2)[0]
+		if len(detail) > 120 {
+			detail = detail[:117] + "..."
+		}
+	}
+	if detail != "" {
+		line += " — " + detail
+	}
+
+	var notes []string
+	if d := a.Duration(); d > 0 {
+		notes = append(notes, FormatDuration(d))
+	}
+	if a.Uncharged {
+		notes = append(notes, "no retry charged")
+	}
+	if a.Resumed {
+		notes = append(notes, "resumed session")
+	}
+	if a.Patch != "" {
+		notes = append(notes, "rolled back to "+a.Patch)
+	}
+	if len(notes) > 0 {
+		line += " (" + strings.Join(notes, ", ") + ")"
+	}
+	return line
+}
+
+// buildVerifyList lists the verify commands that will run for a story, noting commands
+// that were already failing at the baseline and commands narrowed by verify.affected,
+// followed by the known flaky ones.
+func buildVerifyList(cfg *ResolvedConfig, state *RunState, story *StoryDefinition) string {
 	var verifyLines []string
-	for _, cmd := range cfg.Config.Verify.Default {
-		verifyLines = append(verifyLines, "- "+cmd)
+	cmds := append(append([]string{}, cfg.Config.Verify.Default...), cfg.Config.Verify.StoryCommands(story)...)
+	for _, cmd := range cmds {
+		line := "- " + cmd
+		if _, ok := cfg.Config.Verify.Affected[cmd]; ok {
+			line += " (narrowed to the files and packages your changes affect)"
+		}
+		if known := state.Baseline.FailureFor(cmd); known != nil {
+			line += fmt.Sprintf(" (already failing before this run with a %s failure — doesn't block this story unless it starts failing differently)", known.Failure)
+		}
+		verifyLines = append(verifyLines, line)
 	}
 	if IsUIStory(story) {
 		for _, cmd := range cfg.Config.Verify.UI {
 			verifyLines = append(verifyLines, "- "+cmd+" (UI)")
 		}
+		cmds = append(cmds, cfg.Config.Verify.UI...)
 	}
-	verifyStr := strings.Join(verifyLines, "\n")
+	return strings.Join(verifyLines, "\n") + buildKnownFlakes(cfg.ProjectRoot, cmds)
+}
+
+// buildSignalTools describes ralph's MCP tools when the provider session is connected
+// to them (provider.mcp). Empty otherwise; markers always work.
+func buildSignalTools(cfg *ResolvedConfig) string {
+	p := cfg.Config.Provider
+	if !p.MCP || !canServeMCP(p.Command) {
+		return ""
+	}
+	return strings.Join([]string{
+		"",
+		"The `ralph` MCP server is connected to this session. Prefer its tools to the markers below: a tool call can't be misread.",
+		"- `signal_done`, `signal_stuck`, `ask_question`, `report_learning` — the same as the DONE, STUCK, QUESTION, and LEARNING markers",
+		"- `run_verification` — run the verification commands now and see what fails, before you signal DONE",
+		"- `get_story`, `get_acceptance_criteria` — re-read the story",
+		"- `get_service_logs` — recent output from the dev services",
+		"",
+		"If a tool call fails, fall back to the markers.",
+		"",
+	}, "\n")
+}
+
+// generateRunPrompt generates the prompt for story implementation, fitted to the
+// provider's context budget, along with its size breakdown.
+// codebaseStr and diffSummary are pre-computed in runLoop to avoid redundant per-iteration I/O.
+// resourceGuidance is the pre-computed consultation guidance (or fallback instructions).
+func generateRunPrompt(cfg *ResolvedConfig, featureDir *FeatureDir, def *PRDDefinition, state *RunState, story *StoryDefinition, codebaseStr, diffSummary, resourceGuidance string) (string, *PromptBudget) {
+	// Build acceptance criteria list
+	var criteria []string
+	for _, c := range story.AcceptanceCriteria {
+		criteria = append(criteria, "- "+c)
+	}
+	criteriaStr := strings.Join(criteria, "\n")
+
+	verifyStr := buildVerifyList(cfg, state, story)
 
 	// Build learnings (capped at maxLearningsInPrompt most recent)
 	learningsStr := buildLearnings(state.Learnings, "## Learnings from Previous Work")
@@ -113,15 +217,38 @@ func generateRunPrompt(cfg *ResolvedConfig, featureDir *FeatureDir, def *PRDDefi
 		tagsStr = fmt.Sprintf("**Tags:** %s\n", strings.Join(story.Tags, ", "))
 	}
 
-	// Build retry info with remaining retries context
+	// Build retry info with remaining retries context and the full attempt history. The
+	// failure to fix comes first: retryInfo keeps its start when cut to the prompt budget,
+	// so the history gives way before the failing tests and the error do.
 	retryStr := ""
 	retries := state.GetRetries(story.ID)
 	if retries > 0 {
 		remaining := cfg.Config.MaxRetries - retries
 		retryStr = fmt.Sprintf("\n**Previous Attempts:** %d of %d (%d remaining before skipped)\n", retries, cfg.Config.MaxRetries, remaining)
+		attempts := state.GetAttempts(story.ID)
+		var last AttemptRecord
+		if len(attempts) > 0 {
+			last = attempts[len(attempts)-1]
+		}
+		if len(last.FailedTests) > 0 {
+			retryStr += "**Failing Tests:** Make these pass without weakening them:\n" + formatTestFailures(last.FailedTests, maxTestsListed)
+		}
+		if last.Patch != "" {
+			patchPath := filepath.Join(featureDir.Path, last.Patch)
+			retryStr += fmt.Sprintf("**Rolled-Back Code:** The previous attempt's commits were removed from the branch. They are saved at `%s` — read it and reuse what worked.\n", patchPath)
+		}
 		if lastFailure := state.GetLastFailure(story.ID); lastFailure != "" {
 			retryStr += fmt.Sprintf("**Previous Issue:** %s\n", lastFailure)
 		}
+		if known := state.Baseline.FailureFor(last.FailedCommand); len(attempts) > 0 && known != nil && !last.Passed && known.Output != "" {
+			retryStr += fmt.Sprintf("**Baseline Output:** `%s` already failed before this run. Compare against its output from then to find what your change broke:\n```\n%s\n```\n", known.Command, known.Output)
+		}
+		if len(attempts) > 1 {
+			retryStr += "**Attempt History:**\n"
+			for _, a := range attempts {
+				retryStr += "- " + formatAttempt(a) + "\n"
+			}
+		}
 	}
 
 	// Build service URLs
@@ -133,13 +260,14 @@ func generateRunPrompt(cfg *ResolvedConfig, featureDir *FeatureDir, def *PRDDefi
 		}
 	}
 
-	return getPrompt("run", map[string]string{
+	vars := map[string]string{
 		"storyId":            story.ID,
 		"storyTitle":         story.Title,
 		"storyDescription":   story.Description,
 		"acceptanceCriteria": criteriaStr,
 		"tags":               tagsStr,
 		"retryInfo":          retryStr,
+		"answers":            buildAnswers(state, story.ID),
 		"verifyCommands":     verifyStr,
 		"learnings":          learningsStr,
 		"knowledgeFile":      cfg.Config.Provider.KnowledgeFile,
@@ -153,6 +281,34 @@ func generateRunPrompt(cfg *ResolvedConfig, featureDir *FeatureDir, def *PRDDefi
 		"codebaseContext":   codebaseStr,
 		"diffSummary":       diffSummary,
 		"resourceGuidance":  resourceGuidance,
+		"signalTools":       buildSignalTools(cfg),
+	}
+	return fitPrompt("run", vars, runPromptSections, cfg.Config.Provider.promptBudget())
+}
+
+// generateResumePrompt generates the short follow-up sent when a retry continues the
+// previous attempt's provider session, which already holds the story and codebase context.
+func generateResumePrompt(cfg *ResolvedConfig, state *RunState, story *StoryDefinition) string {
+	lastAttempt := ""
+	if attempts := state.GetAttempts(story.ID); len(attempts) > 0 {
+		last := attempts[len(attempts)-1]
+		lastAttempt = formatAttempt(last)
+		if last.Reason != "" {
+			lastAttempt += "\n\n```\n" + last.Reason + "\n```"
+		}
+	}
+	retries := state.GetRetries(story.ID)
+
+	return getPrompt("resume", map[string]string{
+		"storyId":        story.ID,
+		"storyTitle":     story.Title,
+		"lastAttempt":    lastAttempt,
+		"answers":        buildAnswers(state, story.ID),
+		"retryInfo":      fmt.Sprintf("**Previous Attempts:** %d of %d (%d remaining before skipped)", retries, cfg.Config.MaxRetries, cfg.Config.MaxRetries-retries),
+		"verifyCommands": buildVerifyList(cfg, state, story),
+		"knowledgeFile":  cfg.Config.Provider.KnowledgeFile,
+		"timeout":        fmt.Sprintf("%d minutes", cfg.Config.Provider.Timeout/60),
+		"signalTools":    buildSignalTools(cfg),
 	})
 }
 
@@ -160,13 +316,17 @@ func generateRunPrompt(cfg *ResolvedConfig, featureDir *FeatureDir, def *PRDDefi
 func generateVerifyFixPrompt(cfg *ResolvedConfig, featureDir *FeatureDir, def *PRDDefinition, state *RunState, report *VerifyReport, resourceGuidance string) string {
 	// Build verify commands list
 	var verifyLines []string
+	cmds := append(append(append([]string{}, cfg.Config.Verify.Default...), cfg.Config.Verify.FeatureCommands(def)...), cfg.Config.Verify.UI...)
 	for _, cmd := range cfg.Config.Verify.Default {
 		verifyLines = append(verifyLines, "- "+cmd)
 	}
+	for _, cmd := range cfg.Config.Verify.FeatureCommands(def) {
+		verifyLines = append(verifyLines, "- "+cmd)
+	}
 	for _, cmd := range cfg.Config.Verify.UI {
 		verifyLines = append(verifyLines, "- "+cmd+" (UI)")
 	}
-	verifyStr := strings.Join(verifyLines, "\n")
+	verifyStr := strings.Join(verifyLines, "\n") + buildKnownFlakes(cfg.ProjectRoot, cmds)
 
 	// Build learnings
 	learningsStr := buildLearnings(state.Learnings, "## Learnings from Previous Runs")
@@ -363,6 +523,8 @@ func buildRefinementStoryDetails(def *PRDDefinition, state *RunState) string {
 			status = "PASSED"
 		} else if state.IsSkipped(s.ID) {
 			status = "SKIPPED"
+		} else if state.IsBlocked(s.ID) {
+			status = "BLOCKED"
 		}
 
 		line := fmt.Sprintf("- **%s: %s** — %s", s.ID, s.Title, status)
@@ -375,6 +537,9 @@ func buildRefinementStoryDetails(def *PRDDefinition, state *RunState) string {
 		if lastFailure := state.GetLastFailure(s.ID); lastFailure != "" {
 			lines = append(lines, fmt.Sprintf("  Notes: %s", lastFailure))
 		}
+		if reason := state.GetBlockedReason(s.ID); reason != "" {
+			lines = append(lines, fmt.Sprintf("  Blocked: %s", reason))
+		}
 	}
 
 	lines = append(lines, "")
@@ -389,9 +554,24 @@ func generatePrdFinalizePrompt(cfg *ResolvedConfig, featureDir *FeatureDir, cont
 		"prdContent":       content,
 		"outputPath":       featureDir.PrdJsonPath(),
 		"resourceGuidance": resourceGuidance,
+		"verifyTags":       buildVerifyTags(cfg),
 	})
 }
 
+// buildVerifyTags describes the verify.byTag checks for the PRD finalizer, so it can tag
+// stories that need them. Empty when none are configured.
+func buildVerifyTags(cfg *ResolvedConfig) string {
+	byTag := cfg.Config.Verify.ByTag
+	if len(byTag) == 0 {
+		return ""
+	}
+	lines := []string{"**Tag checks configured for this project** — a story with the tag also runs these commands:"}
+	for _, tag := range sortedKeys(byTag) {
+		lines = append(lines, fmt.Sprintf("- `%s`: %s", tag, "`"+strings.Join(byTag[tag], "`, `")+"`"))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
 // generateVerifyAnalyzePrompt generates the prompt for AI deep verification.
 func generateVerifyAnalyzePrompt(cfg *ResolvedConfig, featureDir *FeatureDir, def *PRDDefinition, state *RunState, report *VerifyReport, resourceGuidance string) string {
 	// Build git diff summary
@@ -422,6 +602,8 @@ func buildCriteriaChecklist(def *PRDDefinition, state *RunState) string {
 			status = "PASSED"
 		} else if state.IsSkipped(s.ID) {
 			status = "SKIPPED"
+		} else if state.IsBlocked(s.ID) {
+			status = "BLOCKED"
 		}
 
 		lines = append(lines, fmt.Sprintf("### %s: %s (%s)", s.ID, s.Title, status))
@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestGetPrompt_Run(t *testing.T) {
@@ -108,6 +109,28 @@ func TestGetPrompt_PrdFinalize(t *testing.T) {
 	}
 }
 
+func TestGeneratePrdFinalizePrompt_VerifyTags(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &ResolvedConfig{
+		ProjectRoot: dir,
+		Config: RalphConfig{Verify: VerifyConfig{
+			Default: []string{"go test ./..."},
+			ByTag:   map[string][]string{"db": {"make migrate-check"}, "api": {"make contract-test", "make lint-openapi"}},
+		}},
+	}
+	featureDir := &FeatureDir{Feature: "auth", Path: filepath.Join(dir, ".ralph", "2024-01-15-auth")}
+
+	prompt := generatePrdFinalizePrompt(cfg, featureDir, "# Auth", "")
+	if !strings.Contains(prompt, "- `api`: `make contract-test`, `make lint-openapi`\n- `db`: `make migrate-check`") {
+		t.Errorf("prompt should list the tag checks, got:\n%s", prompt)
+	}
+
+	cfg.Config.Verify.ByTag = nil
+	if prompt := generatePrdFinalizePrompt(cfg, featureDir, "# Auth", ""); strings.Contains(prompt, "Tag checks configured") {
+		t.Error("prompt should not mention tag checks when none are configured")
+	}
+}
+
 func TestGetPrompt_RefineSession(t *testing.T) {
 	prompt := getPrompt("refine-session", map[string]string{
 		"feature":          "auth",
@@ -166,7 +189,7 @@ func TestGetPrompt_VerifyFix(t *testing.T) {
 
 func TestGetPrompt_ProviderAgnostic(t *testing.T) {
 	// Verify prompts don't contain provider-specific references
-	prompts := []string{"run", "prd-create", "prd-finalize", "prd-refine", "refine-session", "verify-fix"}
+	prompts := []string{"run", "prd-create", "prd-finalize", "prd-refine", "refine-session", "verify-fix", "resume"}
 	forbiddenTerms := []string{
 		"$AMP_CURRENT_THREAD_ID",
 		"read_thread",
@@ -260,13 +283,12 @@ func TestGenerateRunPrompt(t *testing.T) {
 	}
 
 	state := NewRunState()
-	state.Retries = map[string]int{"US-001": 1}
-	state.LastFailure = map[string]string{"US-001": "Previous attempt failed"}
+	state.MarkFailed("US-001", "Previous attempt failed", 3)
 	state.Learnings = []string{"Use bcrypt for passwords"}
 
 	story := &def.UserStories[0]
 
-	prompt := generateRunPrompt(cfg, featureDir, def, state, story, "", "", "")
+	prompt, _ := generateRunPrompt(cfg, featureDir, def, state, story, "", "", "")
 
 	if !strings.Contains(prompt, "US-001") {
 		t.Error("prompt should contain story ID")
@@ -297,6 +319,267 @@ func TestGenerateRunPrompt(t *testing.T) {
 	}
 }
 
+func TestGenerateRunPrompt_StoryVerifyCommands(t *testing.T) {
+	cfg := &ResolvedConfig{
+		ProjectRoot: t.TempDir(),
+		Config: RalphConfig{
+			MaxRetries: 3,
+			Provider:   ProviderConfig{Command: "claude", KnowledgeFile: "CLAUDE.md"},
+			Verify: VerifyConfig{
+				Default: []string{"npm test"},
+				ByTag:   map[string][]string{"db": {"npm run migrate:check"}, "api": {"npm run contract"}},
+			},
+		},
+	}
+	def := &PRDDefinition{UserStories: []StoryDefinition{
+		{ID: "US-001", Title: "Users table", Tags: []string{"db"}, Verify: []string{"./scripts/seed-check.sh"}},
+	}}
+	prompt, _ := generateRunPrompt(cfg, &FeatureDir{Feature: "auth"}, def, NewRunState(), &def.UserStories[0], "", "", "")
+
+	if !strings.Contains(prompt, "- npm test\n- npm run migrate:check\n- ./scripts/seed-check.sh") {
+		t.Errorf("verify list should add tag and story commands after the defaults, got:\n%s", prompt)
+	}
+	if strings.Contains(prompt, "npm run contract") {
+		t.Error("checks for tags the story doesn't have should not be listed")
+	}
+}
+
+func TestGenerateRunPrompt_AttemptHistory(t *testing.T) {
+	cfg := &ResolvedConfig{
+		Config: RalphConfig{
+			MaxRetries: 3,
+			Provider:   ProviderConfig{Command: "claude", Timeout: 1800, KnowledgeFile: "AGENTS.md"},
+			Verify:     VerifyConfig{Default: []string{"npm test"}},
+		},
+	}
+	featureDir := &FeatureDir{Feature: "auth", Path: t.TempDir()}
+	def := &PRDDefinition{UserStories: []StoryDefinition{{ID: "US-001", Title: "Login"}}}
+
+	state := NewRunState()
+	state.RecordAttempt("US-001", AttemptRecord{Failure: FailureCompile, FailedCommand: "npx tsc --noEmit", Reason: "tsc failed"}, 3)
+	state.RecordAttempt("US-001", AttemptRecord{Failure: FailureTest, FailedCommand: "npm test", Reason: "npm test failed: 2 tests"}, 3)
+
+	prompt, _ := generateRunPrompt(cfg, featureDir, def, state, &def.UserStories[0], "", "", "")
+
+	for _, want := range []string{
+		"**Previous Attempts:** 2 of 3",
+		"Attempt 1: compile — npx tsc --noEmit",
+		"Attempt 2: test — npm test",
+		"**Previous Issue:** npm test failed: 2 tests",
+	} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt should contain %q", want)
+		}
+	}
+}
+
+func TestGenerateRunPrompt_Answers(t *testing.T) {
+	cfg := &ResolvedConfig{
+		Config: RalphConfig{
+			MaxRetries: 3,
+			Provider:   ProviderConfig{Command: "claude", Timeout: 1800, KnowledgeFile: "AGENTS.md"},
+			Verify:     VerifyConfig{Default: []string{"npm test"}},
+		},
+	}
+	featureDir := &FeatureDir{Feature: "auth", Path: t.TempDir()}
+	def := &PRDDefinition{UserStories: []StoryDefinition{{ID: "US-001", Title: "Delete account"}}}
+	story := &def.UserStories[0]
+
+	state := NewRunState()
+	state.AskQuestion("US-001", "Soft or hard delete?")
+	state.RecordAttempt("US-001", AttemptRecord{Failure: FailureQuestion, Reason: "Question: Soft or hard delete?", Uncharged: true}, 3)
+
+	prompt, _ := generateRunPrompt(cfg, featureDir, def, state, story, "", "", "")
+	if strings.Contains(prompt, "## Answers to Your Questions") {
+		t.Error("unanswered questions should not be in the prompt")
+	}
+
+	state.AnswerQuestion("US-001", "Soft delete, keep rows 30 days")
+	want := "**Q:** Soft or hard delete?\n**A:** Soft delete, keep rows 30 days"
+	prompt, _ = generateRunPrompt(cfg, featureDir, def, state, story, "", "", "")
+	if !strings.Contains(prompt, "## Answers to Your Questions") || !strings.Contains(prompt, want) {
+		t.Errorf("run prompt should carry the answer")
+	}
+	// Asking doesn't count against the story
+	if strings.Contains(prompt, "**Previous Attempts:**") {
+		t.Error("an uncharged question should not show as a retry")
+	}
+	if resume := generateResumePrompt(cfg, state, story); !strings.Contains(resume, want) {
+		t.Error("resume prompt should carry the answer")
+	}
+}
+
+func TestGenerateRunPrompt_RolledBackPatch(t *testing.T) {
+	cfg := &ResolvedConfig{
+		Config: RalphConfig{
+			MaxRetries: 3,
+			Provider:   ProviderConfig{Command: "claude", Timeout: 1800, KnowledgeFile: "AGENTS.md"},
+			Verify:     VerifyConfig{Default: []string{"npm test"}},
+		},
+	}
+	featureDir := &FeatureDir{Feature: "auth", Path: "/project/.ralph/2024-01-15-auth"}
+	def := &PRDDefinition{UserStories: []StoryDefinition{{ID: "US-001", Title: "Login"}}}
+
+	state := NewRunState()
+	state.RecordAttempt("US-001", AttemptRecord{Failure: FailureTest, Reason: "npm test failed", Patch: "attempts/US-001-1.patch"}, 3)
+
+	prompt, _ := generateRunPrompt(cfg, featureDir, def, state, &def.UserStories[0], "", "", "")
+	if !strings.Contains(prompt, "/project/.ralph/2024-01-15-auth/attempts/US-001-1.patch") {
+		t.Error("prompt should reference the rolled-back patch")
+	}
+
+	// A later attempt without rollback supersedes the patch reference
+	state.RecordAttempt("US-001", AttemptRecord{Failure: FailureTest, Reason: "still failing"}, 3)
+	prompt, _ = generateRunPrompt(cfg, featureDir, def, state, &def.UserStories[0], "", "", "")
+	if strings.Contains(prompt, "Rolled-Back Code") {
+		t.Error("prompt should only reference a patch from the latest attempt")
+	}
+}
+
+func TestGenerateRunPrompt_FailingTests(t *testing.T) {
+	cfg := &ResolvedConfig{
+		Config: RalphConfig{
+			MaxRetries: 3,
+			Provider:   ProviderConfig{Command: "claude", Timeout: 1800, KnowledgeFile: "AGENTS.md"},
+			Verify:     VerifyConfig{Default: []string{"go test ./..."}},
+		},
+	}
+	featureDir := &FeatureDir{Feature: "auth", Path: t.TempDir()}
+	def := &PRDDefinition{UserStories: []StoryDefinition{{ID: "US-001", Title: "Login"}}}
+
+	state := NewRunState()
+	state.RecordAttempt("US-001", AttemptRecord{Failure: FailureTest, FailedCommand: "go test ./...", Reason: "go test ./... failed", FailedTests: []TestFailure{
+		{Name: "TestLogin/bad_password", File: "auth/login_test.go", Line: 31, Message: "got 200, want 401"},
+	}}, 3)
+
+	prompt, _ := generateRunPrompt(cfg, featureDir, def, state, &def.UserStories[0], "", "", "")
+	if !strings.Contains(prompt, "**Failing Tests:**") || !strings.Contains(prompt, "- TestLogin/bad_password (auth/login_test.go:31): got 200, want 401") {
+		t.Error("retry prompt should list the failing tests of the last attempt")
+	}
+
+	state.RecordAttempt("US-001", AttemptRecord{Failure: FailureCompile, Reason: "go vet failed"}, 3)
+	prompt, _ = generateRunPrompt(cfg, featureDir, def, state, &def.UserStories[0], "", "", "")
+	if strings.Contains(prompt, "**Failing Tests:**") {
+		t.Error("failing tests from an earlier attempt should not be listed")
+	}
+}
+
+func TestGenerateRunPrompt_Baseline(t *testing.T) {
+	cfg := &ResolvedConfig{
+		Config: RalphConfig{
+			MaxRetries: 3,
+			Provider:   ProviderConfig{Command: "claude", Timeout: 1800, KnowledgeFile: "AGENTS.md"},
+			Verify:     VerifyConfig{Default: []string{"npm run lint", "npm test"}},
+		},
+	}
+	featureDir := &FeatureDir{Feature: "auth", Path: t.TempDir()}
+	def := &PRDDefinition{UserStories: []StoryDefinition{{ID: "US-001", Title: "Login"}}}
+
+	state := NewRunState()
+	state.Baseline = &VerifyBaseline{Failures: []BaselineFailure{
+		{Command: "npm test", Failure: FailureTest, Output: "FAIL legacy.test.js"},
+	}}
+
+	prompt, _ := generateRunPrompt(cfg, featureDir, def, state, &def.UserStories[0], "", "", "")
+	if !strings.Contains(prompt, "- npm test (already failing before this run with a test failure") {
+		t.Error("verify list should flag the pre-existing failure")
+	}
+	if strings.Contains(prompt, "- npm run lint (") {
+		t.Error("passing baseline command should not be flagged")
+	}
+	if strings.Contains(prompt, "**Baseline Output:**") {
+		t.Error("baseline output should only appear on retries")
+	}
+
+	state.RecordAttempt("US-001", AttemptRecord{Failure: FailureCompile, FailedCommand: "npm test", Reason: "npm test failed"}, 3)
+	prompt, _ = generateRunPrompt(cfg, featureDir, def, state, &def.UserStories[0], "", "", "")
+	if !strings.Contains(prompt, "**Baseline Output:** `npm test` already failed before this run") || !strings.Contains(prompt, "FAIL legacy.test.js") {
+		t.Error("retry prompt should include the baseline output of the failed command")
+	}
+}
+
+func TestGenerateResumePrompt(t *testing.T) {
+	cfg := &ResolvedConfig{
+		Config: RalphConfig{
+			MaxRetries: 3,
+			Provider:   ProviderConfig{Command: "claude", Timeout: 1800, KnowledgeFile: "CLAUDE.md"},
+			Verify:     VerifyConfig{Default: []string{"go test ./..."}},
+		},
+	}
+	story := &StoryDefinition{ID: "US-001", Title: "Login", Description: "Full story description", AcceptanceCriteria: []string{"User can log in"}}
+
+	state := NewRunState()
+	state.RecordAttempt("US-001", AttemptRecord{Failure: FailureTest, FailedCommand: "go test ./...", Reason: "go test ./... failed:\n--- FAIL: TestLogin"}, 3)
+
+	prompt := generateResumePrompt(cfg, state, story)
+	for _, want := range []string{
+		"# Continue: US-001 - Login",
+		"Attempt 1: test — go test ./...",
+		"--- FAIL: TestLogin",
+		"**Previous Attempts:** 1 of 3 (2 remaining before skipped)",
+		"- go test ./...",
+		"`feat: US-001 - Login`",
+		"`CLAUDE.md`",
+		"30 minutes",
+	} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("resume prompt should contain %q", want)
+		}
+	}
+	// The session already has the full story; the follow-up stays short
+	if strings.Contains(prompt, "Full story description") || strings.Contains(prompt, "User can log in") {
+		t.Error("resume prompt should not repeat the story details")
+	}
+	if strings.Contains(prompt, "{{") {
+		t.Error("resume prompt has unreplaced placeholders")
+	}
+}
+
+func TestBuildSignalTools(t *testing.T) {
+	cfg := &ResolvedConfig{Config: RalphConfig{Provider: ProviderConfig{Command: "claude", Timeout: 1800}}}
+	if got := buildSignalTools(cfg); got != "" {
+		t.Errorf("expected no tool guidance without provider.mcp, got %q", got)
+	}
+
+	cfg.Config.Provider.MCP = true
+	state := NewRunState()
+	story := &StoryDefinition{ID: "US-001", Title: "Login"}
+	run, _ := generateRunPrompt(cfg, &FeatureDir{}, &PRDDefinition{}, state, story, "", "", "")
+	for _, want := range []string{"`signal_done`", "`run_verification`", "<ralph>DONE</ralph>"} {
+		if !strings.Contains(run, want) {
+			t.Errorf("run prompt with provider.mcp should contain %q", want)
+		}
+	}
+	state.RecordAttempt("US-001", AttemptRecord{Failure: FailureTest}, 3)
+	if resume := generateResumePrompt(cfg, state, story); !strings.Contains(resume, "`signal_done`") {
+		t.Error("resume prompt with provider.mcp should mention the MCP tools")
+	}
+}
+
+func TestFormatAttempt(t *testing.T) {
+	start := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		a    AttemptRecord
+		want string
+	}{
+		{"passed", AttemptRecord{Number: 1, Passed: true, StartedAt: start, EndedAt: start.Add(90 * time.Second)}, "Attempt 1: passed (1m30s)"},
+		{"verify command", AttemptRecord{Number: 2, Failure: FailureLint, FailedCommand: "npm run lint", Reason: "npm run lint failed"}, "Attempt 2: lint — npm run lint"},
+		{"reason first line", AttemptRecord{Number: 3, Failure: FailureStuck, Reason: "Missing API key\nmore"}, "Attempt 3: stuck — Missing API key"},
+		{"uncharged", AttemptRecord{Number: 4, Failure: FailureTimeout, Reason: "provider timed out", Uncharged: true}, "Attempt 4: timeout — provider timed out (no retry charged)"},
+		{"unclassified", AttemptRecord{Number: 5}, "Attempt 5: failed"},
+		{"rolled back", AttemptRecord{Number: 6, Failure: FailureVerify, FailedCommand: "make check", Patch: "attempts/US-001-6.patch"}, "Attempt 6: verify — make check (rolled back to attempts/US-001-6.patch)"},
+		{"resumed", AttemptRecord{Number: 7, Failure: FailureTest, FailedCommand: "go test ./...", Resumed: true}, "Attempt 7: test — go test ./... (resumed session)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatAttempt(tt.a); got != tt.want {
+				t.Errorf("formatAttempt() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestGenerateRunPrompt_StoryMap(t *testing.T) {
 	cfg := &ResolvedConfig{
 		Config: RalphConfig{
@@ -325,7 +608,7 @@ func TestGenerateRunPrompt_StoryMap(t *testing.T) {
 
 	story := &def.UserStories[1] // US-002 is current
 
-	prompt := generateRunPrompt(cfg, featureDir, def, state, story, "", "", "")
+	prompt, _ := generateRunPrompt(cfg, featureDir, def, state, story, "", "", "")
 
 	// Completed story
 	if !strings.Contains(prompt, "✓ US-001: Database setup") {
@@ -392,6 +675,19 @@ func TestBuildProgress(t *testing.T) {
 			}(),
 			"1/3 stories complete (1 skipped)",
 		},
+		{
+			"with blocked",
+			&PRDDefinition{UserStories: []StoryDefinition{
+				{ID: "US-001"}, {ID: "US-002", DependsOn: []string{"US-001"}}, {ID: "US-003"},
+			}},
+			func() *RunState {
+				s := NewRunState()
+				s.MarkSkipped("US-001", "too hard")
+				s.Blocked = map[string]string{"US-002": "dependency US-001 was skipped"}
+				return s
+			}(),
+			"0/3 stories complete (1 skipped) (1 blocked)",
+		},
 	}
 
 	for _, tt := range tests {
@@ -435,6 +731,41 @@ func TestBuildStoryMap(t *testing.T) {
 	}
 }
 
+func TestBuildStoryMap_BlockedAndDependencies(t *testing.T) {
+	def := &PRDDefinition{
+		UserStories: []StoryDefinition{
+			{ID: "US-001", Title: "Schema"},
+			{ID: "US-002", Title: "API", DependsOn: []string{"US-001"}},
+			{ID: "US-003", Title: "Current"},
+		},
+	}
+	state := NewRunState()
+	state.MarkSkipped("US-001", "migration fails")
+	RefreshBlocked(def, state)
+
+	result := buildStoryMap(def, state, &StoryDefinition{ID: "US-003"})
+
+	if !strings.Contains(result, "⊘ US-002: API (blocked: dependency US-001 was skipped) [depends on US-001]") {
+		t.Errorf("should show blocked story with reason and dependencies, got:\n%s", result)
+	}
+}
+
+func TestBuildStoryMap_AwaitingInput(t *testing.T) {
+	def := &PRDDefinition{
+		UserStories: []StoryDefinition{
+			{ID: "US-001", Title: "Schema"},
+			{ID: "US-002", Title: "Current"},
+		},
+	}
+	state := NewRunState()
+	state.AskQuestion("US-001", "Which database?")
+
+	result := buildStoryMap(def, state, &StoryDefinition{ID: "US-002"})
+	if !strings.Contains(result, "? US-001: Schema (awaiting input)") {
+		t.Errorf("should show the story awaiting input, got:\n%s", result)
+	}
+}
+
 func TestBuildLearnings_Empty(t *testing.T) {
 	result := buildLearnings(nil, "## Learnings")
 	if result != "" {
@@ -596,7 +927,9 @@ func TestBuildRefinementStoryDetails(t *testing.T) {
 	state := NewRunState()
 	state.MarkPassed("US-001")
 	state.MarkSkipped("US-002", "Cannot implement")
-	state.Retries = map[string]int{"US-002": 3}
+	for i := 0; i < 3; i++ {
+		state.MarkFailed("US-002", "verify failed", 3)
+	}
 
 	result := buildRefinementStoryDetails(def, state)
 
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// promptVarPattern matches a template placeholder. Anything between the braces is
+// captured so that near misses like {{ storyId }} are reported rather than left in the prompt.
+var promptVarPattern = regexp.MustCompile(`\{\{([^{}\n]+)\}\}`)
+
+// requiredPromptVars are the variables each template can't do without. An override
+// that drops one still renders, but the agent loses what it needs for the task.
+var requiredPromptVars = map[string][]string{
+	"consult":          {"storyTitle", "acceptanceCriteria", "frameworkPath"},
+	"consult-feature":  {"feature", "frameworkPath"},
+	"prd-create":       {"feature", "outputPath"},
+	"prd-finalize":     {"prdContent", "outputPath"},
+	"prd-refine":       {"prdMdContent", "outputPath"},
+	"refine-session":   {"feature", "summary"},
+	"refine-summarize": {"gitLog", "diffStat"},
+	"resume":           {"storyId", "lastAttempt"},
+	"run":              {"storyId", "storyTitle", "acceptanceCriteria", "verifyCommands"},
+	"summary":          {"storyDetails"},
+	"verify-analyze":   {"criteriaChecklist", "verifyResults"},
+	"verify-fix":       {"verifyResults"},
+}
+
+// loadedOverrides are the validated templates from .ralph/prompts that getPrompt renders,
+// read once at startup so a file edited during a run never reaches a provider unchecked.
+var (
+	loadedOverridesMu sync.RWMutex
+	loadedOverrides   map[string]string
+)
+
+// loadPromptOverrides reads and validates every template in .ralph/prompts and, when none
+// has errors, makes them the templates getPrompt uses for the rest of the process.
+func loadPromptOverrides(projectRoot string) (errs, warnings []string) {
+	overrides, errs, warnings := readPromptOverrides(projectRoot)
+	if len(errs) > 0 {
+		return errs, warnings
+	}
+	loadedOverridesMu.Lock()
+	loadedOverrides = overrides
+	loadedOverridesMu.Unlock()
+	return nil, warnings
+}
+
+// activePrompt returns the template getPrompt renders for name: the loaded override, or
+// the embedded template.
+func activePrompt(name string) (string, bool) {
+	loadedOverridesMu.RLock()
+	content, ok := loadedOverrides[name]
+	loadedOverridesMu.RUnlock()
+	if ok {
+		return content, true
+	}
+	return builtinPrompt(name)
+}
+
+// promptOverrideDir is where a project keeps replacement prompt templates.
+func promptOverrideDir(projectRoot string) string {
+	return filepath.Join(projectRoot, ".ralph", "prompts")
+}
+
+// promptNames returns the names of the built-in templates, sorted.
+func promptNames() []string {
+	entries, _ := fs.ReadDir(promptsFS, "prompts")
+	var names []string
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".md") {
+			names = append(names, strings.TrimSuffix(e.Name(), ".md"))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// builtinPrompt returns the embedded template for name.
+func builtinPrompt(name string) (string, bool) {
+	data, err := promptsFS.ReadFile("prompts/" + name + ".md")
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+// loadPrompt returns the effective template for name: the project's override in
+// .ralph/prompts when there is one, the embedded template otherwise.
+func loadPrompt(projectRoot, name string) (content string, overridden bool, err error) {
+	builtin, ok := builtinPrompt(name)
+	if !ok {
+		return "", false, fmt.Errorf("prompt not found: %s", name)
+	}
+	data, err := os.ReadFile(filepath.Join(promptOverrideDir(projectRoot), name+".md"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return builtin, false, nil
+		}
+		return "", false, fmt.Errorf("failed to read prompt override: %w", err)
+	}
+	return string(data), true, nil
+}
+
+// renderPrompt replaces each {{key}} in a template with its value.
+func renderPrompt(content string, vars map[string]string) string {
+	for key, value := range vars {
+		content = strings.ReplaceAll(content, "{{"+key+"}}", value)
+	}
+	return content
+}
+
+// promptVars returns the distinct variables a template uses, in order of first use.
+func promptVars(content string) []string {
+	seen := make(map[string]bool)
+	var vars []string
+	for _, m := range promptVarPattern.FindAllStringSubmatch(content, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			vars = append(vars, m[1])
+		}
+	}
+	return vars
+}
+
+// validatePrompt checks a replacement template against the built-in one. Variables
+// ralph doesn't supply for this template are errors (they would reach the agent as
+// literal text); required variables the template never uses are warnings.
+func validatePrompt(name, content string) (errs, warnings []string) {
+	builtin, ok := builtinPrompt(name)
+	if !ok {
+		return nil, []string{fmt.Sprintf("no built-in template named %q, so this file is never used (templates: %s)", name, strings.Join(promptNames(), ", "))}
+	}
+	known := make(map[string]bool)
+	available := promptVars(builtin)
+	for _, v := range available {
+		known[v] = true
+	}
+	sort.Strings(available)
+
+	used := make(map[string]bool)
+	for _, v := range promptVars(content) {
+		used[v] = true
+		if !known[v] {
+			errs = append(errs, fmt.Sprintf("unknown variable {{%s}} (line %d; available: %s)", v, promptVarLine(content, v), strings.Join(available, ", ")))
+		}
+	}
+	for _, v := range requiredPromptVars[name] {
+		if !used[v] {
+			warnings = append(warnings, fmt.Sprintf("required variable {{%s}} is not used", v))
+		}
+	}
+	return errs, warnings
+}
+
+// promptVarLine returns the 1-based line of the first use of a variable.
+func promptVarLine(content, v string) int {
+	i := strings.Index(content, "{{"+v+"}}")
+	if i < 0 {
+		return 0
+	}
+	return strings.Count(content[:i], "\n") + 1
+}
+
+// promptOverrides returns the names of the templates in .ralph/prompts, sorted.
+func promptOverrides(projectRoot string) ([]string, error) {
+	entries, err := os.ReadDir(promptOverrideDir(projectRoot))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".md") {
+			names = append(names, strings.TrimSuffix(e.Name(), ".md"))
+		}
+	}
+	return names, nil
+}
+
+// ValidatePromptOverrides validates every template in .ralph/prompts. Messages are
+// prefixed with the file they concern.
+func ValidatePromptOverrides(projectRoot string) (errs, warnings []string) {
+	_, errs, warnings = readPromptOverrides(projectRoot)
+	return errs, warnings
+}
+
+// readPromptOverrides reads and validates every template in .ralph/prompts, returning the
+// contents of those that replace a built-in.
+func readPromptOverrides(projectRoot string) (overrides map[string]string, errs, warnings []string) {
+	names, err := promptOverrides(projectRoot)
+	if err != nil {
+		return nil, []string{fmt.Sprintf("failed to read %s: %v", promptOverrideDir(projectRoot), err)}, nil
+	}
+	overrides = make(map[string]string)
+	for _, name := range names {
+		content, e, w := readPromptFile(projectRoot, name)
+		errs = append(errs, e...)
+		warnings = append(warnings, w...)
+		if _, ok := builtinPrompt(name); ok {
+			overrides[name] = content
+		}
+	}
+	return overrides, errs, warnings
+}
+
+// validatePromptFile validates one override file, prefixing messages with its path.
+func validatePromptFile(projectRoot, name string) (errs, warnings []string) {
+	_, errs, warnings = readPromptFile(projectRoot, name)
+	return errs, warnings
+}
+
+// readPromptFile reads and validates one override file, prefixing messages with its path.
+func readPromptFile(projectRoot, name string) (content string, errs, warnings []string) {
+	rel := filepath.Join(".ralph", "prompts", name+".md")
+	data, err := os.ReadFile(filepath.Join(projectRoot, rel))
+	if err != nil {
+		return "", []string{fmt.Sprintf("%s: %v", rel, err)}, nil
+	}
+	e, w := validatePrompt(name, string(data))
+	for _, msg := range e {
+		errs = append(errs, rel+": "+msg)
+	}
+	for _, msg := range w {
+		warnings = append(warnings, rel+": "+msg)
+	}
+	return string(data), errs, warnings
+}
+
+// diffLines returns a line diff of a against b with a few lines of context around each
+// change, in the style of diff -u without file headers. Empty when they are equal.
+func diffLines(a, b string) string {
+	x := strings.Split(strings.TrimSuffix(a, "\n"), "\n")
+	y := strings.Split(strings.TrimSuffix(b, "\n"), "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	type op struct {
+		kind byte // ' ', '-', or '+'
+		line string
+		a, b int // 1-based line numbers in x and y
+	}
+	var ops []op
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			ops = append(ops, op{' ', x[i], i + 1, j + 1})
+			i++
+			j++
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, op{'-', x[i], i + 1, j + 1})
+			i++
+		default:
+			ops = append(ops, op{'+', y[j], i + 1, j + 1})
+			j++
+		}
+	}
+
+	const context = 3
+	var sb strings.Builder
+	for start := 0; start < len(ops); {
+		if ops[start].kind == ' ' {
+			start++
+			continue
+		}
+		// Extend the hunk while changes are within 2*context lines of each other
+		from := max(start-context, 0)
+		end := start
+		for k := start; k < len(ops); k++ {
+			if ops[k].kind != ' ' {
+				end = k
+			} else if k-end > 2*context {
+				break
+			}
+		}
+		to := min(end+context+1, len(ops))
+		fmt.Fprintf(&sb, "@@ -%d +%d @@\n", ops[from].a, ops[from].b)
+		for _, o := range ops[from:to] {
+			sb.WriteByte(o.kind)
+			sb.WriteString(o.line)
+			sb.WriteByte('\n')
+		}
+		start = to
+	}
+	return sb.String()
+}
+
+func cmdPrompts(args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "Usage: ralph prompts [list | show <name> | diff <name> | validate [name]]")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Templates in .ralph/prompts/<name>.md replace the built-in ones.")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Examples:")
+		fmt.Fprintln(os.Stderr, "  ralph prompts                                        # List templates and overrides")
+		fmt.Fprintln(os.Stderr, "  ralph prompts show run                               # Print the template ralph uses")
+		fmt.Fprintln(os.Stderr, "  ralph prompts show run --builtin > .ralph/prompts/run.md  # Start an override")
+		fmt.Fprintln(os.Stderr, "  ralph prompts diff run                               # Compare an override to the built-in")
+		fmt.Fprintln(os.Stderr, "  ralph prompts validate                               # Check every override")
+	}
+
+	sub := "list"
+	if len(args) > 0 {
+		sub, args = args[0], args[1:]
+	}
+	projectRoot := GetProjectRoot()
+
+	switch sub {
+	case "list":
+		overrides, err := promptOverrides(projectRoot)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		overridden := make(map[string]bool)
+		for _, name := range overrides {
+			overridden[name] = true
+		}
+		fmt.Println("Prompt templates (overrides go in .ralph/prompts/<name>.md):")
+		for _, name := range promptNames() {
+			source := "built-in"
+			if overridden[name] {
+				source = "override"
+			}
+			fmt.Printf("  %-18s %s\n", name, source)
+			delete(overridden, name)
+		}
+		for _, name := range overrides {
+			if overridden[name] {
+				fmt.Printf("  %-18s unused (no built-in template by this name)\n", name)
+			}
+		}
+
+	case "show":
+		showFlags := flag.NewFlagSet("prompts show", flag.ExitOnError)
+		builtinOnly := showFlags.Bool("builtin", false, "Show the built-in template even if overridden")
+		name, flagArgs := splitFeatureArgs(args)
+		showFlags.Parse(flagArgs)
+		if name == "" {
+			usage()
+			os.Exit(1)
+		}
+		var content string
+		if *builtinOnly {
+			var ok bool
+			if content, ok = builtinPrompt(name); !ok {
+				fmt.Fprintf(os.Stderr, "Error: prompt not found: %s\n", name)
+				os.Exit(1)
+			}
+		} else {
+			var err error
+			if content, _, err = loadPrompt(projectRoot, name); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		fmt.Print(content)
+
+	case "diff":
+		if len(args) != 1 {
+			usage()
+			os.Exit(1)
+		}
+		name := args[0]
+		content, overridden, err := loadPrompt(projectRoot, name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if !overridden {
+			fmt.Printf("%s: no override (using the built-in template)\n", name)
+			return
+		}
+		builtin, _ := builtinPrompt(name)
+		diff := diffLines(builtin, content)
+		if diff == "" {
+			fmt.Printf("%s: override is identical to the built-in template\n", name)
+			return
+		}
+		fmt.Printf("--- built-in %s\n+++ .ralph/prompts/%s.md\n%s", name, name, diff)
+
+	case "validate":
+		var errs, warnings []string
+		if len(args) > 0 {
+			for _, name := range args {
+				if !fileExists(filepath.Join(promptOverrideDir(projectRoot), name+".md")) {
+					errs = append(errs, fmt.Sprintf("%s: no override at .ralph/prompts/%s.md", name, name))
+					continue
+				}
+				e, w := validatePromptFile(projectRoot, name)
+				errs = append(errs, e...)
+				warnings = append(warnings, w...)
+			}
+		} else {
+			overrides, _ := promptOverrides(projectRoot)
+			if len(overrides) == 0 {
+				fmt.Println("No prompt overrides in .ralph/prompts.")
+				return
+			}
+			errs, warnings = ValidatePromptOverrides(projectRoot)
+		}
+		for _, w := range warnings {
+			fmt.Printf("! %s\n", w)
+		}
+		for _, e := range errs {
+			fmt.Printf("✗ %s\n", e)
+		}
+		if len(errs) > 0 {
+			fmt.Printf("\n%d error(s) found.\n", len(errs))
+			os.Exit(1)
+		}
+		fmt.Println("✓ Prompt overrides are valid.")
+
+	case "-h", "--help", "help":
+		usage()
+
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown prompts command: %s\n\n", sub)
+		usage()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writePromptOverride writes .ralph/prompts/<name>.md under dir.
+func writePromptOverride(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.MkdirAll(promptOverrideDir(dir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(promptOverrideDir(dir), name+".md"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRequiredPromptVars_MatchBuiltins(t *testing.T) {
+	names := promptNames()
+	if len(names) != len(requiredPromptVars) {
+		t.Errorf("templates %v and requiredPromptVars disagree", names)
+	}
+	for _, name := range names {
+		required, ok := requiredPromptVars[name]
+		if !ok {
+			t.Errorf("%s has no entry in requiredPromptVars", name)
+			continue
+		}
+		builtin, _ := builtinPrompt(name)
+		errs, warnings := validatePrompt(name, builtin)
+		if len(errs) > 0 || len(warnings) > 0 {
+			t.Errorf("built-in %s should validate cleanly, got %v %v", name, errs, warnings)
+		}
+		if len(required) == 0 {
+			t.Errorf("%s should require at least one variable", name)
+		}
+	}
+}
+
+func TestLoadPrompt_PrefersOverride(t *testing.T) {
+	dir := t.TempDir()
+	builtin, _ := builtinPrompt("run")
+
+	content, overridden, err := loadPrompt(dir, "run")
+	if err != nil || overridden || content != builtin {
+		t.Fatalf("expected the built-in without an override, got overridden=%v err=%v", overridden, err)
+	}
+
+	writePromptOverride(t, dir, "run", "Implement {{storyId}}.\n")
+	content, overridden, err = loadPrompt(dir, "run")
+	if err != nil || !overridden || content != "Implement {{storyId}}.\n" {
+		t.Errorf("expected the override, got %q overridden=%v err=%v", content, overridden, err)
+	}
+
+	if _, _, err := loadPrompt(dir, "nope"); err == nil || err.Error() != "prompt not found: nope" {
+		t.Errorf("expected prompt not found, got %v", err)
+	}
+}
+
+func TestGetPrompt_UsesProjectOverride(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, ".git"), 0755)
+	writePromptOverride(t, dir, "resume", "Keep going on {{storyId}}: {{lastAttempt}}\n")
+	loadTestPromptOverrides(t, dir)
+
+	got := getPrompt("resume", map[string]string{"storyId": "US-001", "lastAttempt": "Attempt 1: failed"})
+	if got != "Keep going on US-001: Attempt 1: failed\n" {
+		t.Errorf("got %q", got)
+	}
+	if got := getPrompt("verify-fix", nil); !strings.Contains(got, "{{verifyResults}}") {
+		t.Error("templates without an override should come from the built-ins")
+	}
+}
+
+func TestGetPrompt_IgnoresOverrideEditedMidRun(t *testing.T) {
+	dir := t.TempDir()
+	writePromptOverride(t, dir, "resume", "Keep going on {{storyId}}\n")
+	loadTestPromptOverrides(t, dir)
+
+	// Neither an invalid edit nor an unreadable file after startup reaches getPrompt.
+	writePromptOverride(t, dir, "resume", "Keep going on {{storyID}}\n")
+	if got := getPrompt("resume", map[string]string{"storyId": "US-001"}); got != "Keep going on US-001\n" {
+		t.Errorf("got %q", got)
+	}
+	path := filepath.Join(dir, ".ralph", "prompts", "resume.md")
+	os.Remove(path)
+	os.Mkdir(path, 0755)
+	if got := getPrompt("resume", map[string]string{"storyId": "US-001"}); got != "Keep going on US-001\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestLoadPromptOverrides_RejectsInvalid(t *testing.T) {
+	dir := t.TempDir()
+	writePromptOverride(t, dir, "resume", "Keep going on {{storyID}}\n")
+	t.Cleanup(func() { loadedOverrides = nil })
+
+	errs, _ := loadPromptOverrides(dir)
+	if len(errs) == 0 {
+		t.Fatal("expected an error for the unknown variable")
+	}
+	if got := getPrompt("resume", map[string]string{"storyId": "US-001"}); strings.Contains(got, "Keep going") {
+		t.Error("an invalid override should not be loaded")
+	}
+}
+
+func loadTestPromptOverrides(t *testing.T, dir string) {
+	t.Helper()
+	if errs, _ := loadPromptOverrides(dir); len(errs) > 0 {
+		t.Fatalf("loadPromptOverrides: %v", errs)
+	}
+	t.Cleanup(func() { loadedOverrides = nil })
+}
+
+func TestValidatePrompt(t *testing.T) {
+	errs, warnings := validatePrompt("run", "# {{storyId}}: {{storyTitle}}\n\n{{acceptanceCriteria}}\n{{ticket}}\n{{ verifyCommands }}\n")
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", errs)
+	}
+	if !strings.HasPrefix(errs[0], "unknown variable {{ticket}} (line 4; available: acceptanceCriteria, answers, branchName,") {
+		t.Errorf("unexpected error: %s", errs[0])
+	}
+	if !strings.HasPrefix(errs[1], "unknown variable {{ verifyCommands }}") {
+		t.Errorf("near misses should be reported, got %s", errs[1])
+	}
+	if len(warnings) != 1 || warnings[0] != "required variable {{verifyCommands}} is not used" {
+		t.Errorf("unexpected warnings: %v", warnings)
+	}
+
+	// Optional variables can be dropped freely
+	if errs, warnings := validatePrompt("verify-fix", "Fix these:\n{{verifyResults}}\n"); len(errs) > 0 || len(warnings) > 0 {
+		t.Errorf("expected a clean result, got %v %v", errs, warnings)
+	}
+
+	if errs, warnings := validatePrompt("planning", "{{anything}}"); len(errs) > 0 || len(warnings) != 1 || !strings.Contains(warnings[0], "never used") {
+		t.Errorf("an unknown template name should only warn, got %v %v", errs, warnings)
+	}
+}
+
+func TestValidatePromptOverrides(t *testing.T) {
+	dir := t.TempDir()
+	if errs, warnings := ValidatePromptOverrides(dir); len(errs) > 0 || len(warnings) > 0 {
+		t.Fatalf("no overrides should be valid, got %v %v", errs, warnings)
+	}
+
+	writePromptOverride(t, dir, "summary", "{{storyDetails}}\n{{changedFiles}}\n")
+	writePromptOverride(t, dir, "verify-analyze", "{{verifyResults}}\n{{storyId}}\n")
+	os.WriteFile(filepath.Join(promptOverrideDir(dir), "notes.txt"), []byte("{{ignored}}"), 0644)
+
+	errs, warnings := ValidatePromptOverrides(dir)
+	if len(errs) != 1 || !strings.HasPrefix(errs[0], filepath.Join(".ralph", "prompts", "verify-analyze.md")+": unknown variable {{storyId}}") {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	if len(warnings) != 1 || !strings.HasSuffix(warnings[0], "verify-analyze.md: required variable {{criteriaChecklist}} is not used") {
+		t.Errorf("unexpected warnings: %v", warnings)
+	}
+
+	if names, _ := promptOverrides(dir); strings.Join(names, ",") != "summary,verify-analyze" {
+		t.Errorf("overrides = %v", names)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	if got := diffLines("a\nb\n", "a\nb\n"); got != "" {
+		t.Errorf("expected no diff, got %q", got)
+	}
+
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n"
+	b := "1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n16\n"
+	want := "@@ -1 +1 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n" +
+		"@@ -13 +13 @@\n 13\n 14\n 15\n+16\n"
+	if got := diffLines(a, b); got != want {
+		t.Errorf("diff =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ProviderAdapter drives one AI CLI: the defaults it needs, the arguments for a story
+// session, and how to read the CLI's native output. Unknown commands get a plain-text
+// adapter that only scans output for <ralph> markers.
+type ProviderAdapter interface {
+	// Defaults returns the settings applied when ralph.config.json leaves them unset.
+	Defaults() ProviderDefaults
+	// BuildArgs returns the arguments for a story session. In file mode it also returns
+	// the temp prompt file, which the caller removes when the provider exits.
+	BuildArgs(p ProviderConfig, prompt string) (args []string, promptFile string, err error)
+	// ParseLine reads one line of output into text to scan for markers and structured events.
+	ParseLine(line string) ParsedLine
+}
+
+// ParsedLine is what an adapter extracted from one line of provider output.
+type ParsedLine struct {
+	Text   []string        // human-readable lines, scanned for <ralph> markers
+	Events []ProviderEvent // structured activity reported by the CLI
+}
+
+// ProviderEvent is one piece of structured activity from a provider session.
+// Type is one of EventSessionStart, EventToolCall, EventFileEdit, EventTokenUsage or
+// EventCompletion.
+type ProviderEvent struct {
+	Type         EventType
+	SessionID    string  // session start: the CLI's id for resuming the conversation
+	Tool         string  // tool call / file edit: tool name
+	Input        string  // tool call: short summary of the input
+	Path         string  // file edit: edited file
+	InputTokens  int     // token usage
+	OutputTokens int     // token usage
+	Cost         float64 // token usage: cost in USD as reported by the CLI
+	Success      bool    // completion
+	Summary      string  // completion: final message or error
+}
+
+// providerAdapters maps provider commands to their adapters
+var providerAdapters = map[string]ProviderAdapter{
+	"amp":      textAdapter{ProviderDefaults{PromptMode: "stdin", DefaultArgs: []string{"--dangerously-allow-all"}, KnowledgeFile: "AGENTS.md"}},
+	"claude":   claudeAdapter{},
+	"opencode": textAdapter{ProviderDefaults{PromptMode: "arg", DefaultArgs: []string{"run"}, KnowledgeFile: "AGENTS.md"}},
+	"aider":    textAdapter{ProviderDefaults{PromptMode: "arg", PromptFlag: "--message", DefaultArgs: []string{"--yes-always"}, KnowledgeFile: "AGENTS.md"}},
+	"codex":    codexAdapter{},
+}
+
+// adapterFor returns the adapter for a provider command, falling back to plain-text
+// marker scanning for unknown CLIs.
+func adapterFor(command string) ProviderAdapter {
+	if a, ok := providerAdapters[command]; ok {
+		return a
+	}
+	return textAdapter{defaultProviderDefaults}
+}
+
+// isKnownProvider reports whether a provider command has a dedicated adapter.
+func isKnownProvider(command string) bool {
+	_, ok := providerAdapters[command]
+	return ok
+}
+
+// costReporter is implemented by adapters whose CLI reports the session's cost itself.
+type costReporter interface {
+	reportsCost() bool
+}
+
+// sessionResumer is implemented by adapters whose CLI can continue an earlier session.
+type sessionResumer interface {
+	resumeArgs(args []string, sessionID string) []string
+}
+
+// canResume reports whether a provider command supports provider.resume.
+func canResume(command string) bool {
+	_, ok := adapterFor(command).(sessionResumer)
+	return ok
+}
+
+// mcpConfigurer is implemented by adapters whose CLI can be given extra MCP servers
+// on the command line. A CLI that reads them from a file gets a config written to dir,
+// which the caller removes when the provider exits.
+type mcpConfigurer interface {
+	mcpArgs(args []string, servers map[string]MCPServerConfig, dir string) (newArgs []string, configFile string, err error)
+}
+
+// canServeMCP reports whether a provider command supports provider.mcp.
+func canServeMCP(command string) bool {
+	_, ok := adapterFor(command).(mcpConfigurer)
+	return ok
+}
+
+// withMCPServers returns the provider's args with the configured mcpServers and any extra
+// servers (such as ralph's own) added in the CLI's format. Args are unchanged for CLIs
+// that can't be given MCP servers. configFile is set when the servers were written to a
+// file under dir; the caller removes it when the provider exits.
+func withMCPServers(p ProviderConfig, configured, extra map[string]MCPServerConfig, dir string) (args []string, configFile string, err error) {
+	m, ok := adapterFor(p.Command).(mcpConfigurer)
+	if !ok || len(configured)+len(extra) == 0 {
+		return p.Args, "", nil
+	}
+	servers := make(map[string]MCPServerConfig, len(configured)+len(extra))
+	for name, s := range configured {
+		servers[name] = s
+	}
+	for name, s := range extra {
+		servers[name] = s
+	}
+	return m.mcpArgs(p.Args, servers, dir)
+}
+
+// textAdapter is for CLIs whose output is plain text: every line is scanned for markers.
+type textAdapter struct {
+	defaults ProviderDefaults
+}
+
+func (a textAdapter) Defaults() ProviderDefaults { return a.defaults }
+
+func (a textAdapter) BuildArgs(p ProviderConfig, prompt string) ([]string, string, error) {
+	return buildProviderArgs(p.Args, p.PromptMode, p.PromptFlag, prompt)
+}
+
+func (a textAdapter) ParseLine(line string) ParsedLine {
+	return ParsedLine{Text: []string{line}}
+}
+
+// withFlags appends flags to args unless the first of them is already present,
+// so a user who sets e.g. --output-format themselves keeps their choice.
+func withFlags(args []string, flags ...string) []string {
+	for _, a := range args {
+		if a == flags[0] || strings.HasPrefix(a, flags[0]+"=") {
+			return args
+		}
+	}
+	return append(append([]string{}, args...), flags...)
+}
+
+// hasArg reports whether args contains any of the given flags.
+func hasArg(args []string, flags ...string) bool {
+	for _, a := range args {
+		for _, f := range flags {
+			if a == f {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// splitText turns a message into lines for marker scanning.
+func splitText(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// summarizeInput renders a tool input as a single short line for the log.
+func summarizeInput(v interface{}) string {
+	var s string
+	switch in := v.(type) {
+	case string:
+		s = in
+	case nil:
+		return ""
+	default:
+		b, err := json.Marshal(in)
+		if err != nil {
+			return ""
+		}
+		s = string(b)
+	}
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) > 200 {
+		s = s[:200] + "..."
+	}
+	return s
+}
+
+// claudeAdapter reads Claude Code's stream-json output. Story sessions run with
+// --output-format stream-json unless the configured args choose a format.
+type claudeAdapter struct{}
+
+func (claudeAdapter) Defaults() ProviderDefaults {
+	return ProviderDefaults{PromptMode: "stdin", DefaultArgs: []string{"--print", "--dangerously-skip-permissions"}, KnowledgeFile: "CLAUDE.md"}
+}
+
+func (claudeAdapter) BuildArgs(p ProviderConfig, prompt string) ([]string, string, error) {
+	args := p.Args
+	if hasArg(args, "--print", "-p") {
+		// stream-json requires --verbose in print mode
+		args = withFlags(args, "--output-format", "stream-json")
+		if hasArg(args, "stream-json") {
+			args = withFlags(args, "--verbose")
+		}
+	}
+	return buildProviderArgs(args, p.PromptMode, p.PromptFlag, prompt)
+}
+
+// reportsCost is true: the stream-json result event carries total_cost_usd.
+func (claudeAdapter) reportsCost() bool { return true }
+
+// resumeArgs continues a session with `claude --resume <id>`.
+func (claudeAdapter) resumeArgs(args []string, sessionID string) []string {
+	return append(append([]string{}, args...), "--resume", sessionID)
+}
+
+// mcpArgs writes the servers to a private config file and passes its path with
+// --mcp-config, so env values such as tokens stay out of the process list. The flag takes
+// several values, so the = form keeps it from swallowing a prompt that follows it in arg mode.
+func (claudeAdapter) mcpArgs(args []string, servers map[string]MCPServerConfig, dir string) ([]string, string, error) {
+	config, err := json.Marshal(map[string]interface{}{"mcpServers": servers})
+	if err != nil {
+		return nil, "", err
+	}
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, "", fmt.Errorf("failed to create MCP config directory: %w", err)
+		}
+	}
+	// CreateTemp makes the file readable by its owner only
+	f, err := os.CreateTemp(dir, "mcp-config-*.json")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create MCP config file: %w", err)
+	}
+	_, err = f.Write(config)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, "", fmt.Errorf("failed to write MCP config file: %w", err)
+	}
+	return append([]string{"--mcp-config=" + f.Name()}, args...), f.Name(), nil
+}
+
+// claudeFileTools are the Claude Code tools that write files, keyed to their path input.
+var claudeFileTools = map[string]string{
+	"Edit":         "file_path",
+	"MultiEdit":    "file_path",
+	"Write":        "file_path",
+	"NotebookEdit": "notebook_path",
+}
+
+type claudeStreamEvent struct {
+	Type      string `json:"type"`
+	Subtype   string `json:"subtype"`
+	SessionID string `json:"session_id"`
+	Message   *struct {
+		Content []struct {
+			Type  string                 `json:"type"`
+			Text  string                 `json:"text"`
+			Name  string                 `json:"name"`
+			Input map[string]interface{} `json:"input"`
+		} `json:"content"`
+	} `json:"message"`
+	Result       string  `json:"result"`
+	IsError      bool    `json:"is_error"`
+	TotalCostUSD float64 `json:"total_cost_usd"`
+	Usage        *struct {
+		InputTokens              int `json:"input_tokens"`
+		OutputTokens             int `json:"output_tokens"`
+		CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+		CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+	} `json:"usage"`
+}
+
+func (claudeAdapter) ParseLine(line string) ParsedLine {
+	var ev claudeStreamEvent
+	if !strings.HasPrefix(strings.TrimSpace(line), "{") || json.Unmarshal([]byte(line), &ev) != nil || ev.Type == "" {
+		return ParsedLine{Text: []string{line}} // plain text (e.g. --output-format text)
+	}
+
+	var parsed ParsedLine
+	switch ev.Type {
+	case "system":
+		if ev.Subtype == "init" && ev.SessionID != "" {
+			parsed.Events = append(parsed.Events, ProviderEvent{Type: EventSessionStart, SessionID: ev.SessionID})
+		}
+	case "assistant":
+		if ev.Message == nil {
+			break
+		}
+		for _, block := range ev.Message.Content {
+			switch block.Type {
+			case "text":
+				parsed.Text = append(parsed.Text, splitText(block.Text)...)
+			case "tool_use":
+				parsed.Events = append(parsed.Events, ProviderEvent{
+					Type:  EventToolCall,
+					Tool:  block.Name,
+					Input: summarizeInput(block.Input),
+				})
+				if key, ok := claudeFileTools[block.Name]; ok {
+					if path, _ := block.Input[key].(string); path != "" {
+						parsed.Events = append(parsed.Events, ProviderEvent{Type: EventFileEdit, Tool: block.Name, Path: path})
+					}
+				}
+			}
+		}
+	case "result":
+		// The result text repeats the last assistant message, which was already scanned
+		usage := ProviderEvent{Type: EventTokenUsage, Cost: ev.TotalCostUSD}
+		if ev.Usage != nil {
+			usage.InputTokens = ev.Usage.InputTokens + ev.Usage.CacheCreationInputTokens + ev.Usage.CacheReadInputTokens
+			usage.OutputTokens = ev.Usage.OutputTokens
+		}
+		parsed.Events = append(parsed.Events, usage, ProviderEvent{
+			Type:    EventCompletion,
+			Success: ev.Subtype == "success" && !ev.IsError,
+			Summary: summarizeInput(ev.Result),
+		})
+	}
+	return parsed
+}
+
+// codexAdapter reads the JSONL event stream of `codex exec --json`.
+type codexAdapter struct{}
+
+func (codexAdapter) Defaults() ProviderDefaults {
+	return ProviderDefaults{PromptMode: "arg", DefaultArgs: []string{"exec", "--full-auto"}, KnowledgeFile: "AGENTS.md"}
+}
+
+func (codexAdapter) BuildArgs(p ProviderConfig, prompt string) ([]string, string, error) {
+	args := p.Args
+	if len(args) > 0 && args[0] == "exec" {
+		args = withFlags(args, "--json")
+	}
+	return buildProviderArgs(args, p.PromptMode, p.PromptFlag, prompt)
+}
+
+// resumeArgs continues a session with `codex exec ... resume <id>`. The prompt, appended
+// after these args in arg mode, becomes the follow-up message.
+func (codexAdapter) resumeArgs(args []string, sessionID string) []string {
+	return append(append([]string{}, args...), "resume", sessionID)
+}
+
+// mcpArgs adds the servers as -c mcp_servers.<name>.* overrides after the exec
+// subcommand. JSON strings and string arrays are valid TOML values. Anything on the
+// command line shows in the process list, so servers with env are refused.
+func (codexAdapter) mcpArgs(args []string, servers map[string]MCPServerConfig, dir string) ([]string, string, error) {
+	var flags []string
+	for _, name := range sortedKeys(servers) {
+		s := servers[name]
+		if len(s.Env) > 0 {
+			return nil, "", fmt.Errorf("mcpServers.%s.env can't be given to codex without showing it in the process list; configure the server in codex's config.toml instead", name)
+		}
+		command, _ := json.Marshal(s.Command)
+		flags = append(flags, "-c", fmt.Sprintf("mcp_servers.%s.command=%s", name, command))
+		if len(s.Args) > 0 {
+			serverArgs, _ := json.Marshal(s.Args)
+			flags = append(flags, "-c", fmt.Sprintf("mcp_servers.%s.args=%s", name, serverArgs))
+		}
+	}
+	if len(args) > 0 && args[0] == "exec" {
+		return append(append([]string{"exec"}, flags...), args[1:]...), "", nil
+	}
+	return append(flags, args...), "", nil
+}
+
+// sortedKeys returns a map's keys in order, for stable command lines.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type codexStreamEvent struct {
+	Type     string `json:"type"`
+	ThreadID string `json:"thread_id"`
+	Item     *struct {
+		Type    string `json:"type"`
+		Text    string `json:"text"`
+		Command string `json:"command"`
+		Server  string `json:"server"`
+		Tool    string `json:"tool"`
+		Query   string `json:"query"`
+		Changes []struct {
+			Path string `json:"path"`
+			Kind string `json:"kind"`
+		} `json:"changes"`
+	} `json:"item"`
+	Usage *struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+	Message string `json:"message"`
+}
+
+func (codexAdapter) ParseLine(line string) ParsedLine {
+	var ev codexStreamEvent
+	if !strings.HasPrefix(strings.TrimSpace(line), "{") || json.Unmarshal([]byte(line), &ev) != nil || ev.Type == "" {
+		return ParsedLine{Text: []string{line}}
+	}
+
+	var parsed ParsedLine
+	switch ev.Type {
+	case "thread.started":
+		if ev.ThreadID != "" {
+			parsed.Events = append(parsed.Events, ProviderEvent{Type: EventSessionStart, SessionID: ev.ThreadID})
+		}
+	case "item.completed":
+		if ev.Item == nil {
+			break
+		}
+		switch ev.Item.Type {
+		case "agent_message":
+			parsed.Text = splitText(ev.Item.Text)
+		case "command_execution":
+			parsed.Events = append(parsed.Events, ProviderEvent{Type: EventToolCall, Tool: "shell", Input: summarizeInput(ev.Item.Command)})
+		case "mcp_tool_call":
+			parsed.Events = append(parsed.Events, ProviderEvent{Type: EventToolCall, Tool: ev.Item.Server + "." + ev.Item.Tool})
+		case "web_search":
+			parsed.Events = append(parsed.Events, ProviderEvent{Type: EventToolCall, Tool: "web_search", Input: summarizeInput(ev.Item.Query)})
+		case "file_change":
+			for _, c := range ev.Item.Changes {
+				parsed.Events = append(parsed.Events, ProviderEvent{Type: EventFileEdit, Tool: c.Kind, Path: c.Path})
+			}
+		}
+	case "turn.completed":
+		usage := ProviderEvent{Type: EventTokenUsage}
+		if ev.Usage != nil {
+			usage.InputTokens = ev.Usage.InputTokens
+			usage.OutputTokens = ev.Usage.OutputTokens
+		}
+		parsed.Events = append(parsed.Events, usage, ProviderEvent{Type: EventCompletion, Success: true})
+	case "turn.failed", "error":
+		msg := ev.Message
+		if ev.Error != nil {
+			msg = ev.Error.Message
+		}
+		parsed.Events = append(parsed.Events, ProviderEvent{Type: EventCompletion, Summary: summarizeInput(msg)})
+	}
+	return parsed
+}
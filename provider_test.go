@@ -0,0 +1,318 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAdapterFor_UnknownFallsBackToText(t *testing.T) {
+	a := adapterFor("my-custom-ai")
+	if _, ok := a.(textAdapter); !ok {
+		t.Fatalf("expected textAdapter for unknown command, got %T", a)
+	}
+	if a.Defaults().PromptMode != "stdin" || a.Defaults().KnowledgeFile != "AGENTS.md" {
+		t.Errorf("unexpected fallback defaults: %+v", a.Defaults())
+	}
+	parsed := a.ParseLine(`{"type":"assistant"}`)
+	if len(parsed.Text) != 1 || len(parsed.Events) != 0 {
+		t.Errorf("text adapter should pass lines through unparsed, got %+v", parsed)
+	}
+}
+
+func TestIsKnownProvider(t *testing.T) {
+	if !isKnownProvider("claude") || !isKnownProvider("aider") {
+		t.Error("expected claude and aider to be known providers")
+	}
+	if isKnownProvider("my-custom-ai") {
+		t.Error("expected my-custom-ai to be unknown")
+	}
+}
+
+func TestClaudeAdapter_BuildArgsAddsStreamJSON(t *testing.T) {
+	p := ProviderConfig{Command: "claude", PromptMode: "stdin", Args: []string{"--print", "--dangerously-skip-permissions"}}
+	args, _, err := claudeAdapter{}.BuildArgs(p, "prompt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(args, " ")
+	want := "--print --dangerously-skip-permissions --output-format stream-json --verbose"
+	if got != want {
+		t.Errorf("args = %q, want %q", got, want)
+	}
+	if len(p.Args) != 2 {
+		t.Errorf("BuildArgs must not modify the config args, got %v", p.Args)
+	}
+}
+
+func TestClaudeAdapter_BuildArgsKeepsUserFormat(t *testing.T) {
+	p := ProviderConfig{Command: "claude", PromptMode: "stdin", Args: []string{"--print", "--output-format", "text"}}
+	args, _, _ := claudeAdapter{}.BuildArgs(p, "prompt")
+	if got := strings.Join(args, " "); got != "--print --output-format text" {
+		t.Errorf("args = %q, expected user's output format untouched", got)
+	}
+
+	// Without --print (interactive) nothing is added
+	p = ProviderConfig{Command: "claude", PromptMode: "arg", Args: []string{}}
+	args, _, _ = claudeAdapter{}.BuildArgs(p, "prompt")
+	if got := strings.Join(args, " "); got != "prompt" {
+		t.Errorf("args = %q, want just the prompt", got)
+	}
+}
+
+func TestClaudeAdapter_ParseAssistantMessage(t *testing.T) {
+	line := `{"type":"assistant","message":{"content":[` +
+		`{"type":"text","text":"Implemented it.\n<ralph>LEARNING:use the store</ralph>\n<ralph>DONE</ralph>"},` +
+		`{"type":"tool_use","name":"Bash","input":{"command":"go test ./..."}},` +
+		`{"type":"tool_use","name":"Edit","input":{"file_path":"main.go","old_string":"a","new_string":"b"}}]}}`
+	parsed := claudeAdapter{}.ParseLine(line)
+
+	if len(parsed.Text) != 3 || parsed.Text[2] != "<ralph>DONE</ralph>" {
+		t.Errorf("expected text split into lines, got %q", parsed.Text)
+	}
+	if len(parsed.Events) != 3 {
+		t.Fatalf("expected 2 tool calls and 1 file edit, got %+v", parsed.Events)
+	}
+	if parsed.Events[0].Type != EventToolCall || parsed.Events[0].Tool != "Bash" || !strings.Contains(parsed.Events[0].Input, "go test") {
+		t.Errorf("unexpected tool call: %+v", parsed.Events[0])
+	}
+	if parsed.Events[2].Type != EventFileEdit || parsed.Events[2].Path != "main.go" {
+		t.Errorf("expected file edit of main.go, got %+v", parsed.Events[2])
+	}
+}
+
+func TestClaudeAdapter_ParseResult(t *testing.T) {
+	line := `{"type":"result","subtype":"success","is_error":false,"result":"<ralph>DONE</ralph>",` +
+		`"total_cost_usd":0.42,"usage":{"input_tokens":100,"cache_read_input_tokens":900,"output_tokens":50}}`
+	parsed := claudeAdapter{}.ParseLine(line)
+
+	if len(parsed.Text) != 0 {
+		t.Errorf("result text repeats the last message and must not be rescanned, got %q", parsed.Text)
+	}
+	if len(parsed.Events) != 2 {
+		t.Fatalf("expected usage and completion events, got %+v", parsed.Events)
+	}
+	usage := parsed.Events[0]
+	if usage.Type != EventTokenUsage || usage.InputTokens != 1000 || usage.OutputTokens != 50 || usage.Cost != 0.42 {
+		t.Errorf("unexpected usage: %+v", usage)
+	}
+	if done := parsed.Events[1]; done.Type != EventCompletion || !done.Success {
+		t.Errorf("expected successful completion, got %+v", done)
+	}
+}
+
+func TestClaudeAdapter_PlainTextFallback(t *testing.T) {
+	for _, line := range []string{"<ralph>DONE</ralph>", "{not json", `{"foo":1}`} {
+		parsed := claudeAdapter{}.ParseLine(line)
+		if len(parsed.Text) != 1 || parsed.Text[0] != line {
+			t.Errorf("ParseLine(%q) = %+v, expected the line as text", line, parsed)
+		}
+	}
+	// Known JSON events without text (tool results) produce nothing
+	parsed := claudeAdapter{}.ParseLine(`{"type":"user","message":{"content":[{"type":"tool_result","content":"ok"}]}}`)
+	if len(parsed.Text) != 0 || len(parsed.Events) != 0 {
+		t.Errorf("expected nothing from tool result, got %+v", parsed)
+	}
+}
+
+func TestCodexAdapter_BuildArgsAddsJSON(t *testing.T) {
+	p := ProviderConfig{Command: "codex", PromptMode: "arg", Args: []string{"exec", "--full-auto"}}
+	args, _, _ := codexAdapter{}.BuildArgs(p, "do it")
+	if got := strings.Join(args, " "); got != "exec --full-auto --json do it" {
+		t.Errorf("args = %q", got)
+	}
+}
+
+func TestCodexAdapter_ParseEvents(t *testing.T) {
+	a := codexAdapter{}
+
+	msg := a.ParseLine(`{"type":"item.completed","item":{"id":"item_3","type":"agent_message","text":"done\n<ralph>DONE</ralph>"}}`)
+	if len(msg.Text) != 2 || msg.Text[1] != "<ralph>DONE</ralph>" {
+		t.Errorf("expected agent message text, got %q", msg.Text)
+	}
+
+	cmd := a.ParseLine(`{"type":"item.completed","item":{"type":"command_execution","command":"bash -lc 'go test ./...'","exit_code":0}}`)
+	if len(cmd.Events) != 1 || cmd.Events[0].Type != EventToolCall || cmd.Events[0].Tool != "shell" {
+		t.Errorf("expected shell tool call, got %+v", cmd.Events)
+	}
+
+	edit := a.ParseLine(`{"type":"item.completed","item":{"type":"file_change","changes":[{"path":"a.go","kind":"update"},{"path":"b.go","kind":"add"}]}}`)
+	if len(edit.Events) != 2 || edit.Events[1].Type != EventFileEdit || edit.Events[1].Path != "b.go" {
+		t.Errorf("expected two file edits, got %+v", edit.Events)
+	}
+
+	turn := a.ParseLine(`{"type":"turn.completed","usage":{"input_tokens":2000,"cached_input_tokens":1500,"output_tokens":300}}`)
+	if len(turn.Events) != 2 || turn.Events[0].InputTokens != 2000 || turn.Events[0].OutputTokens != 300 || !turn.Events[1].Success {
+		t.Errorf("unexpected turn events: %+v", turn.Events)
+	}
+
+	failed := a.ParseLine(`{"type":"turn.failed","error":{"message":"rate limited"}}`)
+	if len(failed.Events) != 1 || failed.Events[0].Success || failed.Events[0].Summary != "rate limited" {
+		t.Errorf("expected failed completion, got %+v", failed.Events)
+	}
+}
+
+func TestProcessOutputLine_TalliesEventsAndMarkers(t *testing.T) {
+	result := &ProviderResult{}
+	lines := []string{
+		`{"type":"assistant","message":{"content":[{"type":"tool_use","name":"Write","input":{"file_path":"x.go"}}]}}`,
+		`{"type":"assistant","message":{"content":[{"type":"tool_use","name":"Edit","input":{"file_path":"x.go"}}]}}`,
+		`{"type":"assistant","message":{"content":[{"type":"text","text":"<ralph>DONE</ralph>"}]}}`,
+		`{"type":"result","subtype":"success","total_cost_usd":1.5,"usage":{"input_tokens":1200,"output_tokens":80}}`,
+	}
+	for _, line := range lines {
+		processOutputLine(claudeAdapter{}, line, result, nil)
+	}
+
+	if !result.Done {
+		t.Error("expected DONE marker from assistant text")
+	}
+	if result.ToolCalls != 2 {
+		t.Errorf("ToolCalls = %d, want 2", result.ToolCalls)
+	}
+	if len(result.FilesEdited) != 1 || result.FilesEdited[0] != "x.go" {
+		t.Errorf("FilesEdited = %v, want [x.go] (deduplicated)", result.FilesEdited)
+	}
+	if result.ReportedCost != 1.5 || result.InputTokens != 1200 {
+		t.Errorf("unexpected usage totals: cost=%v in=%d", result.ReportedCost, result.InputTokens)
+	}
+	if got := result.activitySummary(); got != "2 tool calls, 1 files edited, 1.2k tokens in, 80 out" {
+		t.Errorf("activitySummary = %q", got)
+	}
+}
+
+func TestProcessOutputLine_TextAdapterUsesMarkers(t *testing.T) {
+	result := &ProviderResult{}
+	processOutputLine(adapterFor("amp"), "<ralph>STUCK:no database</ralph>", result, nil)
+	if !result.Stuck || result.StuckNote != "no database" {
+		t.Errorf("expected STUCK marker, got %+v", result)
+	}
+	if result.activitySummary() != "" {
+		t.Errorf("text adapters report no activity, got %q", result.activitySummary())
+	}
+}
+
+func TestAdapters_SessionStart(t *testing.T) {
+	parsed := claudeAdapter{}.ParseLine(`{"type":"system","subtype":"init","session_id":"abc-123","tools":[]}`)
+	if len(parsed.Events) != 1 || parsed.Events[0].Type != EventSessionStart || parsed.Events[0].SessionID != "abc-123" {
+		t.Errorf("expected claude session start, got %+v", parsed.Events)
+	}
+
+	parsed = codexAdapter{}.ParseLine(`{"type":"thread.started","thread_id":"0199-thread"}`)
+	if len(parsed.Events) != 1 || parsed.Events[0].Type != EventSessionStart || parsed.Events[0].SessionID != "0199-thread" {
+		t.Errorf("expected codex session start, got %+v", parsed.Events)
+	}
+
+	result := &ProviderResult{}
+	processOutputLine(claudeAdapter{}, `{"type":"system","subtype":"init","session_id":"abc-123"}`, result, nil)
+	if result.SessionID != "abc-123" {
+		t.Errorf("SessionID = %q", result.SessionID)
+	}
+}
+
+func TestAdapters_ResumeArgs(t *testing.T) {
+	if !canResume("claude") || !canResume("codex") || canResume("amp") || canResume("my-custom-ai") {
+		t.Error("only claude and codex should support resume")
+	}
+
+	p := ProviderConfig{Command: "claude", PromptMode: "stdin", Args: []string{"--print"}}
+	p.Args = claudeAdapter{}.resumeArgs(p.Args, "abc-123")
+	args, _, _ := claudeAdapter{}.BuildArgs(p, "follow-up")
+	if got := strings.Join(args, " "); got != "--print --resume abc-123 --output-format stream-json --verbose" {
+		t.Errorf("claude resume args = %q", got)
+	}
+
+	p = ProviderConfig{Command: "codex", PromptMode: "arg", Args: []string{"exec", "--full-auto"}}
+	p.Args = codexAdapter{}.resumeArgs(p.Args, "0199-thread")
+	args, _, _ = codexAdapter{}.BuildArgs(p, "follow-up")
+	if got := strings.Join(args, " "); got != "exec --full-auto resume 0199-thread --json follow-up" {
+		t.Errorf("codex resume args = %q", got)
+	}
+}
+
+func TestAdapters_MCPArgs(t *testing.T) {
+	if !canServeMCP("claude") || !canServeMCP("codex") || canServeMCP("amp") {
+		t.Error("only claude and codex should support provider.mcp")
+	}
+	servers := map[string]MCPServerConfig{"ralph": {Command: "/usr/bin/ralph", Args: []string{"mcp-serve", "--socket", "/tmp/r.sock"}}}
+
+	dir := filepath.Join(t.TempDir(), "logs")
+	args, configFile, err := claudeAdapter{}.mcpArgs([]string{"--print"}, servers, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 2 || args[0] != "--mcp-config="+configFile || args[1] != "--print" {
+		t.Fatalf("claude mcp args = %q", args)
+	}
+	if filepath.Dir(configFile) != dir {
+		t.Errorf("expected the config in %s, got %s", dir, configFile)
+	}
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"mcpServers":{"ralph":{"command":"/usr/bin/ralph","args":["mcp-serve","--socket","/tmp/r.sock"]}}}` {
+		t.Errorf("claude mcp config = %s", data)
+	}
+	if info, _ := os.Stat(configFile); info.Mode().Perm() != 0600 {
+		t.Errorf("expected the config to be private, got %v", info.Mode().Perm())
+	}
+
+	args, configFile, _ = codexAdapter{}.mcpArgs([]string{"exec", "--full-auto"}, servers, dir)
+	want := `exec -c mcp_servers.ralph.command="/usr/bin/ralph" -c mcp_servers.ralph.args=["mcp-serve","--socket","/tmp/r.sock"] --full-auto`
+	if got := strings.Join(args, " "); got != want {
+		t.Errorf("codex mcp args = %q, want %q", got, want)
+	}
+	if configFile != "" {
+		t.Errorf("codex takes its servers on the command line, got config file %s", configFile)
+	}
+}
+
+func TestWithMCPServers(t *testing.T) {
+	configured := map[string]MCPServerConfig{
+		"github": {Command: "npx", Args: []string{"-y", "@modelcontextprotocol/server-github"}, Env: map[string]string{"GITHUB_TOKEN": "t0k", "A": "b"}},
+	}
+	extra := map[string]MCPServerConfig{"ralph": {Command: "ralph", Args: []string{"mcp-serve"}}}
+
+	codex := ProviderConfig{Command: "codex", Args: []string{"exec", "--full-auto"}}
+	dir := t.TempDir()
+	if args, _, err := withMCPServers(codex, configured, extra, dir); err == nil || !strings.Contains(err.Error(), "mcpServers.github.env") {
+		t.Errorf("codex should refuse a server with env, got %q, %v", args, err)
+	}
+	withoutEnv := map[string]MCPServerConfig{"github": {Command: "npx", Args: []string{"-y", "@modelcontextprotocol/server-github"}}}
+	args, _, err := withMCPServers(codex, withoutEnv, extra, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(args, " ")
+	want := `exec -c mcp_servers.github.command="npx" -c mcp_servers.github.args=["-y","@modelcontextprotocol/server-github"] ` +
+		`-c mcp_servers.ralph.command="ralph" -c mcp_servers.ralph.args=["mcp-serve"] --full-auto`
+	if got != want {
+		t.Errorf("codex args =\n%s\nwant\n%s", got, want)
+	}
+
+	claude := ProviderConfig{Command: "claude", Args: []string{"--print"}}
+	args, configFile, err := withMCPServers(claude, configured, nil, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(strings.Join(args, " "), "t0k") {
+		t.Errorf("env values must not be on the command line, got %q", args)
+	}
+	data, _ := os.ReadFile(configFile)
+	if !strings.Contains(string(data), `"github":{"command":"npx"`) || !strings.Contains(string(data), `"env":{"A":"b","GITHUB_TOKEN":"t0k"}`) {
+		t.Errorf("claude config = %s", data)
+	}
+	if len(claude.Args) != 1 {
+		t.Errorf("withMCPServers must not modify the config args, got %v", claude.Args)
+	}
+
+	aider := ProviderConfig{Command: "aider", Args: []string{"--yes-always"}}
+	if got, file, _ := withMCPServers(aider, configured, nil, dir); strings.Join(got, " ") != "--yes-always" || file != "" {
+		t.Errorf("CLIs without MCP support keep their args, got %q", got)
+	}
+	if got, file, _ := withMCPServers(claude, nil, nil, dir); strings.Join(got, " ") != "--print" || file != "" {
+		t.Errorf("no servers should leave args unchanged, got %q", got)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"sort"
 	"strings"
+	"time"
 )
 
 // --- v3 definition types (on-disk format, AI-authored, immutable during runs) ---
@@ -21,33 +22,148 @@ type PRDDefinition struct {
 
 // StoryDefinition contains only AI-authored story fields.
 type StoryDefinition struct {
-	ID                 string        `json:"id"`
-	Title              string        `json:"title"`
-	Description        string        `json:"description"`
-	AcceptanceCriteria []string      `json:"acceptanceCriteria"`
-	Tags               []string      `json:"tags,omitempty"`
-	Priority           int           `json:"priority"`
+	ID                 string   `json:"id"`
+	Title              string   `json:"title"`
+	Description        string   `json:"description"`
+	AcceptanceCriteria []string `json:"acceptanceCriteria"`
+	Tags               []string `json:"tags,omitempty"`
+	Priority           int      `json:"priority"`
+	DependsOn          []string `json:"dependsOn,omitempty"`  // story IDs that must pass first
+	Complexity         string   `json:"complexity,omitempty"` // "low", "medium", or "high"; used by provider routes
+	Verify             []string `json:"verify,omitempty"`     // extra verify commands for this story only
+}
+
+// storyComplexities are the allowed values of StoryDefinition.Complexity.
+var storyComplexities = []string{"low", "medium", "high"}
+
+// isStoryComplexity reports whether c is an allowed complexity value.
+func isStoryComplexity(c string) bool {
+	for _, v := range storyComplexities {
+		if c == v {
+			return true
+		}
+	}
+	return false
 }
 
 // --- Flat execution state (on-disk, CLI-managed) ---
 
 // RunState is the flat execution state file written exclusively by the CLI.
 type RunState struct {
-	Passed      []string          `json:"passed"`
-	Skipped     []string          `json:"skipped"`
-	Retries     map[string]int    `json:"retries,omitempty"`
-	LastFailure map[string]string `json:"lastFailure,omitempty"`
-	Learnings   []string          `json:"learnings,omitempty"`
-	Attempted   []string          `json:"attempted,omitempty"`
+	Passed      []string                   `json:"passed"`
+	Skipped     []string                   `json:"skipped"`
+	Attempts    map[string][]AttemptRecord `json:"attempts,omitempty"`    // story ID → attempt history, oldest first
+	SkipReasons map[string]string          `json:"skipReasons,omitempty"` // story ID → reason for an explicit skip
+	Learnings   []string                   `json:"learnings,omitempty"`
+	Attempted   []string                   `json:"attempted,omitempty"`
+	Blocked     map[string]string          `json:"blocked,omitempty"`   // story ID → reason (unmet dependency)
+	Baseline    *VerifyBaseline            `json:"baseline,omitempty"`  // verify.default results before the first story
+	Cost        float64                    `json:"cost,omitempty"`      // provider cost spent on this feature
+	Questions   map[string][]StoryQuestion `json:"questions,omitempty"` // story ID → questions for a human, oldest first
+
+	// Legacy fields from before attempt history; migrated into Attempts on load.
+	LegacyRetries     map[string]int    `json:"retries,omitempty"`
+	LegacyLastFailure map[string]string `json:"lastFailure,omitempty"`
+}
+
+// FailureClass categorizes why an attempt failed.
+type FailureClass string
+
+const (
+	FailureCompile   FailureClass = "compile"   // typecheck/build errors
+	FailureTest      FailureClass = "test"      // test suite failures
+	FailureLint      FailureClass = "lint"      // linter/vet/format failures
+	FailureVerify    FailureClass = "verify"    // verify command failed, type unknown
+	FailureTimeout   FailureClass = "timeout"   // provider or verify command timed out
+	FailureService   FailureClass = "service"   // service restart or health check failed
+	FailureNoCommit  FailureClass = "no-commit" // DONE without a new commit
+	FailureNoSignal  FailureClass = "no-signal" // provider exited without DONE or STUCK
+	FailureStuck     FailureClass = "stuck"     // provider signaled STUCK
+	FailureStalled   FailureClass = "stalled"   // provider killed after provider.idleTimeout without output
+	FailureConflict  FailureClass = "conflict"  // parallel merge conflict
+	FailureProvider  FailureClass = "provider"  // provider process failed to run
+	FailureTransient FailureClass = "transient" // rate limit or other error expected to clear up; no retry charged
+	FailureQuestion  FailureClass = "question"  // provider asked a human a question; no retry charged
+)
+
+// StoryQuestion is a question a provider asked a human about a story, and its answer.
+type StoryQuestion struct {
+	Question   string    `json:"question"`
+	AskedAt    time.Time `json:"askedAt"`
+	Answer     string    `json:"answer,omitempty"`
+	AnsweredAt time.Time `json:"answeredAt,omitzero"`
+}
+
+// AttemptRecord is one provider attempt at a story, from spawn through verification.
+type AttemptRecord struct {
+	Number        int           `json:"number"`
+	StartedAt     time.Time     `json:"startedAt"`
+	EndedAt       time.Time     `json:"endedAt"`
+	ExitCode      int           `json:"exitCode"`
+	Provider      string        `json:"provider,omitempty"` // provider command that ran the attempt
+	Session       string        `json:"session,omitempty"`  // provider session id, when the adapter reports one
+	Resumed       bool          `json:"resumed,omitempty"`  // continued the previous attempt's session
+	Cost          float64       `json:"cost,omitempty"`     // provider cost: budget.costPattern, else reported by the CLI
+	Markers       []string      `json:"markers,omitempty"`
+	PreCommit     string        `json:"preCommit,omitempty"`
+	PostCommit    string        `json:"postCommit,omitempty"`
+	Passed        bool          `json:"passed"`
+	Failure       FailureClass  `json:"failure,omitempty"`
+	FailedCommand string        `json:"failedCommand,omitempty"` // verify command that failed
+	FailedTests   []TestFailure `json:"failedTests,omitempty"`   // failing tests parsed from the verify output
+	Reason        string        `json:"reason,omitempty"`
+	Uncharged     bool          `json:"uncharged,omitempty"` // failure did not consume a retry
+	Patch         string        `json:"patch,omitempty"`     // saved diff of rolled-back commits, relative to the feature dir
+}
+
+// countsAsRetry reports whether the attempt consumed one of the story's retries.
+func (a *AttemptRecord) countsAsRetry() bool {
+	return !a.Passed && !a.Uncharged
+}
+
+// Duration returns how long the attempt took (0 if it never finished).
+func (a *AttemptRecord) Duration() time.Duration {
+	if a.StartedAt.IsZero() || a.EndedAt.IsZero() {
+		return 0
+	}
+	return a.EndedAt.Sub(a.StartedAt)
+}
+
+// VerifyBaseline records which verify.default commands were already failing on the
+// branch before any story was implemented.
+type VerifyBaseline struct {
+	Commit    string            `json:"commit"`
+	CheckedAt time.Time         `json:"checkedAt"`
+	Failures  []BaselineFailure `json:"failures,omitempty"`
+}
+
+// BaselineFailure is a verify command that failed in the baseline run.
+type BaselineFailure struct {
+	Command string       `json:"command"`
+	Failure FailureClass `json:"failure"`
+	Output  string       `json:"output,omitempty"` // tail of the command output
+}
+
+// FailureFor returns the baseline failure for a command, or nil if it passed (or no
+// baseline was recorded).
+func (b *VerifyBaseline) FailureFor(cmd string) *BaselineFailure {
+	if b == nil {
+		return nil
+	}
+	for i := range b.Failures {
+		if b.Failures[i].Command == cmd {
+			return &b.Failures[i]
+		}
+	}
+	return nil
 }
 
 // NewRunState creates an empty RunState.
 func NewRunState() *RunState {
 	return &RunState{
-		Passed:      []string{},
-		Skipped:     []string{},
-		Retries:     make(map[string]int),
-		LastFailure: make(map[string]string),
+		Passed:   []string{},
+		Skipped:  []string{},
+		Attempts: make(map[string][]AttemptRecord),
 	}
 }
 
@@ -81,28 +197,32 @@ func (s *RunState) MarkPassed(id string) {
 	s.removeFromSkipped(id)
 }
 
-// MarkFailed records a failed attempt. Increments retries and auto-skips at threshold.
-func (s *RunState) MarkFailed(id, reason string, maxRetries int) {
-	if s.Retries == nil {
-		s.Retries = make(map[string]int)
+// RecordAttempt appends a finished attempt to a story's history and numbers it.
+// A passed attempt marks the story passed; a failed one removes it from passed
+// (regression) and auto-skips it once charged failures reach maxRetries.
+func (s *RunState) RecordAttempt(id string, a AttemptRecord, maxRetries int) {
+	if s.Attempts == nil {
+		s.Attempts = make(map[string][]AttemptRecord)
 	}
-	if s.LastFailure == nil {
-		s.LastFailure = make(map[string]string)
-	}
-	s.Retries[id]++
-	if reason != "" {
-		s.LastFailure[id] = reason
+	a.Number = len(s.Attempts[id]) + 1
+	s.Attempts[id] = append(s.Attempts[id], a)
+
+	if a.Passed {
+		s.MarkPassed(id)
+		return
 	}
-	// Remove from passed if it was there (regression)
 	s.removeFromPassed(id)
-	// Auto-skip at threshold
-	if s.Retries[id] >= maxRetries {
-		if !s.IsSkipped(id) {
-			s.Skipped = append(s.Skipped, id)
-		}
+	if s.GetRetries(id) >= maxRetries && !s.IsSkipped(id) {
+		s.Skipped = append(s.Skipped, id)
 	}
 }
 
+// MarkFailed records an unclassified failed attempt. Increments retries and auto-skips at threshold.
+func (s *RunState) MarkFailed(id, reason string, maxRetries int) {
+	now := time.Now()
+	s.RecordAttempt(id, AttemptRecord{StartedAt: now, EndedAt: now, Reason: reason}, maxRetries)
+}
+
 // MarkSkipped explicitly skips a story (e.g., exceeded maxRetries).
 func (s *RunState) MarkSkipped(id, reason string) {
 	if s.IsSkipped(id) {
@@ -111,27 +231,74 @@ func (s *RunState) MarkSkipped(id, reason string) {
 	s.Skipped = append(s.Skipped, id)
 	s.removeFromPassed(id)
 	if reason != "" {
-		if s.LastFailure == nil {
-			s.LastFailure = make(map[string]string)
+		if s.SkipReasons == nil {
+			s.SkipReasons = make(map[string]string)
 		}
-		s.LastFailure[id] = reason
+		s.SkipReasons[id] = reason
 	}
 }
 
+// IsBlocked returns true if the story is blocked by a skipped or blocked dependency.
+func (s *RunState) IsBlocked(id string) bool {
+	_, ok := s.Blocked[id]
+	return ok
+}
+
+// GetBlockedReason returns why a story is blocked ("" if not blocked).
+func (s *RunState) GetBlockedReason(id string) string {
+	return s.Blocked[id]
+}
+
 // UnmarkPassed removes a story from passed (e.g., regression detected by verify-at-top).
 // Does NOT increment retries.
 func (s *RunState) UnmarkPassed(id string) {
 	s.removeFromPassed(id)
 }
 
-// GetRetries returns the retry count for a story (0 if never retried).
+// GetRetries returns the number of failed attempts that consumed a retry (0 if none).
 func (s *RunState) GetRetries(id string) int {
-	return s.Retries[id]
+	n := 0
+	for i := range s.Attempts[id] {
+		if s.Attempts[id][i].countsAsRetry() {
+			n++
+		}
+	}
+	return n
 }
 
-// GetLastFailure returns the last failure reason for a story ("" if none).
+// GetAttempts returns a story's attempt history, oldest first.
+func (s *RunState) GetAttempts(id string) []AttemptRecord {
+	return s.Attempts[id]
+}
+
+// StoryBase returns the commit a story's work is measured from: where its first recorded
+// attempt started, or fallback when none did. Earlier attempts' commits stay on the
+// branch unless rolled back, so they count as the story's changes too.
+func (s *RunState) StoryBase(id, fallback string) string {
+	for _, a := range s.Attempts[id] {
+		if a.PreCommit != "" {
+			return a.PreCommit
+		}
+	}
+	return fallback
+}
+
+// GetLastFailure returns the most recent failure reason for a story ("" if none).
+// An explicit skip reason takes precedence over attempt history.
 func (s *RunState) GetLastFailure(id string) string {
-	return s.LastFailure[id]
+	if reason := s.SkipReasons[id]; reason != "" {
+		return reason
+	}
+	attempts := s.Attempts[id]
+	for i := len(attempts) - 1; i >= 0; i-- {
+		if attempts[i].Passed {
+			return ""
+		}
+		if attempts[i].Reason != "" {
+			return attempts[i].Reason
+		}
+	}
+	return ""
 }
 
 // IsAttempted returns true if the story has been given to a provider at least once.
@@ -152,6 +319,48 @@ func (s *RunState) MarkAttempted(id string) {
 	s.Attempted = append(s.Attempted, id)
 }
 
+// AskQuestion records a question for a human. The story waits for input until it is answered.
+func (s *RunState) AskQuestion(id, question string) {
+	if s.Questions == nil {
+		s.Questions = make(map[string][]StoryQuestion)
+	}
+	s.Questions[id] = append(s.Questions[id], StoryQuestion{Question: question, AskedAt: time.Now()})
+}
+
+// GetQuestions returns a story's questions, oldest first.
+func (s *RunState) GetQuestions(id string) []StoryQuestion {
+	return s.Questions[id]
+}
+
+// PendingQuestions returns a story's unanswered questions, oldest first.
+func (s *RunState) PendingQuestions(id string) []StoryQuestion {
+	var pending []StoryQuestion
+	for _, q := range s.Questions[id] {
+		if q.Answer == "" {
+			pending = append(pending, q)
+		}
+	}
+	return pending
+}
+
+// IsAwaitingInput returns true if the story has a question nobody has answered yet.
+func (s *RunState) IsAwaitingInput(id string) bool {
+	return len(s.PendingQuestions(id)) > 0
+}
+
+// AnswerQuestion answers a story's oldest unanswered question.
+func (s *RunState) AnswerQuestion(id, answer string) error {
+	for i := range s.Questions[id] {
+		q := &s.Questions[id][i]
+		if q.Answer == "" {
+			q.Answer = answer
+			q.AnsweredAt = time.Now()
+			return nil
+		}
+	}
+	return fmt.Errorf("%s has no unanswered question", id)
+}
+
 // normalizeLearning normalizes a learning string for deduplication comparison.
 func normalizeLearning(s string) string {
 	s = strings.TrimSpace(s)
@@ -190,48 +399,120 @@ func (s *RunState) removeFromSkipped(id string) {
 
 // --- Query functions (take definition + state pair) ---
 
-// GetNextStory returns the next story to work on (not passed, not skipped, by priority).
+// GetNextStory returns the next story to work on: not passed, not skipped, not blocked,
+// with all dependencies passed. Ties are broken by priority.
 func GetNextStory(def *PRDDefinition, state *RunState) *StoryDefinition {
-	var indices []int
-	for i, s := range def.UserStories {
-		if !state.IsPassed(s.ID) && !state.IsSkipped(s.ID) {
-			indices = append(indices, i)
-		}
+	ready := GetReadyStories(def, state)
+	if len(ready) == 0 {
+		return nil
 	}
+	return ready[0]
+}
 
-	if len(indices) == 0 {
-		return nil
+// GetReadyStories returns every story that could be worked on right now (open, not
+// awaiting input, with all dependencies passed), sorted by priority. Stories at the same
+// priority keep PRD order.
+func GetReadyStories(def *PRDDefinition, state *RunState) []*StoryDefinition {
+	var ready []*StoryDefinition
+	for i := range def.UserStories {
+		s := &def.UserStories[i]
+		if isOpen(s, state) && !state.IsAwaitingInput(s.ID) && DependenciesMet(s, state) {
+			ready = append(ready, s)
+		}
 	}
 
 	// Sort by priority (lower = higher priority)
-	sort.Slice(indices, func(a, b int) bool {
-		return def.UserStories[indices[a]].Priority < def.UserStories[indices[b]].Priority
+	sort.SliceStable(ready, func(a, b int) bool {
+		return ready[a].Priority < ready[b].Priority
 	})
 
-	return &def.UserStories[indices[0]]
+	return ready
 }
 
-// GetPendingStories returns all stories that are neither passed nor skipped.
+// GetPendingStories returns all stories that are neither passed, skipped, nor blocked.
 func GetPendingStories(def *PRDDefinition, state *RunState) []StoryDefinition {
 	var pending []StoryDefinition
 	for _, s := range def.UserStories {
-		if !state.IsPassed(s.ID) && !state.IsSkipped(s.ID) {
+		if isOpen(&s, state) {
 			pending = append(pending, s)
 		}
 	}
 	return pending
 }
 
-// AllComplete returns true if every story is either passed or skipped.
+// AllComplete returns true if every story is passed, skipped, or blocked.
 func AllComplete(def *PRDDefinition, state *RunState) bool {
 	for _, s := range def.UserStories {
-		if !state.IsPassed(s.ID) && !state.IsSkipped(s.ID) {
+		if isOpen(&s, state) {
+			return false
+		}
+	}
+	return true
+}
+
+// isOpen returns true if the story still needs work (not passed, skipped, or blocked).
+func isOpen(story *StoryDefinition, state *RunState) bool {
+	return !state.IsPassed(story.ID) && !state.IsSkipped(story.ID) && !state.IsBlocked(story.ID)
+}
+
+// DependenciesMet returns true if every story in dependsOn has passed.
+func DependenciesMet(story *StoryDefinition, state *RunState) bool {
+	for _, dep := range story.DependsOn {
+		if !state.IsPassed(dep) {
 			return false
 		}
 	}
 	return true
 }
 
+// RefreshBlocked recomputes the blocked set from the dependency graph.
+// A story is blocked when any dependency is skipped or itself blocked; passed stories
+// are never blocked. Blocked is derived state, so stories unblock automatically once
+// their dependencies are un-skipped. Returns the IDs that became newly blocked.
+func RefreshBlocked(def *PRDDefinition, state *RunState) []string {
+	previous := state.Blocked
+	blocked := make(map[string]string)
+
+	// Iterate to a fixpoint so blocking propagates transitively down the graph.
+	for changed := true; changed; {
+		changed = false
+		for _, s := range def.UserStories {
+			if state.IsPassed(s.ID) || state.IsSkipped(s.ID) {
+				continue
+			}
+			if _, done := blocked[s.ID]; done {
+				continue
+			}
+			for _, dep := range s.DependsOn {
+				if state.IsSkipped(dep) {
+					blocked[s.ID] = fmt.Sprintf("dependency %s was skipped", dep)
+				} else if _, ok := blocked[dep]; ok {
+					blocked[s.ID] = fmt.Sprintf("dependency %s is blocked", dep)
+				} else {
+					continue
+				}
+				changed = true
+				break
+			}
+		}
+	}
+
+	var newlyBlocked []string
+	for _, s := range def.UserStories {
+		if _, ok := blocked[s.ID]; ok {
+			if _, was := previous[s.ID]; !was {
+				newlyBlocked = append(newlyBlocked, s.ID)
+			}
+		}
+	}
+
+	if len(blocked) == 0 {
+		blocked = nil
+	}
+	state.Blocked = blocked
+	return newlyBlocked
+}
+
 // CountPassed returns the number of passed stories.
 func CountPassed(state *RunState) int {
 	return len(state.Passed)
@@ -242,10 +523,31 @@ func CountSkipped(state *RunState) int {
 	return len(state.Skipped)
 }
 
+// CountBlocked returns the number of stories blocked by unmet dependencies.
+func CountBlocked(state *RunState) int {
+	return len(state.Blocked)
+}
+
+// GetAwaitingInput returns the IDs of open stories with unanswered questions, in PRD order.
+func GetAwaitingInput(def *PRDDefinition, state *RunState) []string {
+	var ids []string
+	for _, s := range def.UserStories {
+		if isOpen(&s, state) && state.IsAwaitingInput(s.ID) {
+			ids = append(ids, s.ID)
+		}
+	}
+	return ids
+}
+
 // IsUIStory returns true if the story has the "ui" tag.
 func IsUIStory(story *StoryDefinition) bool {
-	for _, tag := range story.Tags {
-		if tag == "ui" {
+	return HasTag(story, "ui")
+}
+
+// HasTag reports whether a story carries the given tag.
+func HasTag(story *StoryDefinition, tag string) bool {
+	for _, t := range story.Tags {
+		if t == tag {
 			return true
 		}
 	}
@@ -311,6 +613,73 @@ func ValidatePRDDefinition(def *PRDDefinition) error {
 		if len(story.AcceptanceCriteria) == 0 {
 			return fmt.Errorf("userStories[%d]: missing acceptanceCriteria", i)
 		}
+		if story.Complexity != "" && !isStoryComplexity(story.Complexity) {
+			return fmt.Errorf("userStories[%d]: complexity must be one of %s (got: %s)", i, strings.Join(storyComplexities, ", "), story.Complexity)
+		}
+		for j, cmd := range story.Verify {
+			if strings.TrimSpace(cmd) == "" {
+				return fmt.Errorf("userStories[%d]: verify[%d] is empty", i, j)
+			}
+		}
+	}
+	return validateDependencies(def)
+}
+
+// validateDependencies rejects dependsOn entries that reference unknown stories
+// and dependency cycles (including self-dependencies).
+func validateDependencies(def *PRDDefinition) error {
+	byID := make(map[string]*StoryDefinition, len(def.UserStories))
+	for i := range def.UserStories {
+		byID[def.UserStories[i].ID] = &def.UserStories[i]
+	}
+	for i, story := range def.UserStories {
+		for _, dep := range story.DependsOn {
+			if _, ok := byID[dep]; !ok {
+				return fmt.Errorf("userStories[%d]: dependsOn references unknown story %q", i, dep)
+			}
+		}
+	}
+
+	// Depth-first search with three colors: unvisited, on the current path, finished.
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	color := make(map[string]int, len(def.UserStories))
+	var path []string
+	var visit func(id string) error
+	visit = func(id string) error {
+		color[id] = visiting
+		path = append(path, id)
+		for _, dep := range byID[id].DependsOn {
+			switch color[dep] {
+			case visiting:
+				start := 0
+				for j, p := range path {
+					if p == dep {
+						start = j
+						break
+					}
+				}
+				cycle := append(append([]string{}, path[start:]...), dep)
+				return fmt.Errorf("dependency cycle: %s", strings.Join(cycle, " → "))
+			case unvisited:
+				if err := visit(dep); err != nil {
+					return err
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		color[id] = visited
+		return nil
+	}
+	for _, story := range def.UserStories {
+		if color[story.ID] == unvisited {
+			if err := visit(story.ID); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
@@ -335,15 +704,42 @@ func LoadRunState(path string) (*RunState, error) {
 	if state.Skipped == nil {
 		state.Skipped = []string{}
 	}
-	if state.Retries == nil {
-		state.Retries = make(map[string]int)
-	}
-	if state.LastFailure == nil {
-		state.LastFailure = make(map[string]string)
+	if state.Attempts == nil {
+		state.Attempts = make(map[string][]AttemptRecord)
 	}
+	migrateLegacyRetries(&state)
 	return &state, nil
 }
 
+// migrateLegacyRetries converts the old retries/lastFailure maps into attempt records.
+// The old format only kept a count and the latest reason, so earlier attempts are
+// recorded without details.
+func migrateLegacyRetries(state *RunState) {
+	for id, n := range state.LegacyRetries {
+		if len(state.Attempts[id]) > 0 {
+			continue
+		}
+		for i := 1; i <= n; i++ {
+			a := AttemptRecord{Number: i}
+			if i == n {
+				a.Reason = state.LegacyLastFailure[id]
+			}
+			state.Attempts[id] = append(state.Attempts[id], a)
+		}
+	}
+	// A last failure without retries came from an explicit skip
+	for id, reason := range state.LegacyLastFailure {
+		if state.LegacyRetries[id] == 0 && reason != "" {
+			if state.SkipReasons == nil {
+				state.SkipReasons = make(map[string]string)
+			}
+			state.SkipReasons[id] = reason
+		}
+	}
+	state.LegacyRetries = nil
+	state.LegacyLastFailure = nil
+}
+
 // SaveRunState writes execution state atomically.
 func SaveRunState(path string, state *RunState) error {
 	return AtomicWriteJSON(path, state)
@@ -83,6 +83,183 @@ func TestGetNextStory_ReturnsNilWhenAllPass(t *testing.T) {
 	}
 }
 
+func TestGetNextStory_WaitsForDependencies(t *testing.T) {
+	def := &PRDDefinition{
+		UserStories: []StoryDefinition{
+			{ID: "US-001", Priority: 2},
+			{ID: "US-002", Priority: 1, DependsOn: []string{"US-001"}},
+		},
+	}
+	state := NewRunState()
+
+	next := GetNextStory(def, state)
+	if next == nil || next.ID != "US-001" {
+		t.Fatalf("expected US-001 (US-002 has unmet dependency), got %v", next)
+	}
+
+	state.MarkPassed("US-001")
+	next = GetNextStory(def, state)
+	if next == nil || next.ID != "US-002" {
+		t.Fatalf("expected US-002 after dependency passed, got %v", next)
+	}
+}
+
+func TestGetReadyStories(t *testing.T) {
+	def := &PRDDefinition{
+		UserStories: []StoryDefinition{
+			{ID: "US-001", Priority: 2},
+			{ID: "US-002", Priority: 1},
+			{ID: "US-003", Priority: 1, DependsOn: []string{"US-002"}},
+			{ID: "US-004", Priority: 2},
+			{ID: "US-005", Priority: 3},
+		},
+	}
+	state := NewRunState()
+	state.MarkPassed("US-005")
+
+	var ids []string
+	for _, s := range GetReadyStories(def, state) {
+		ids = append(ids, s.ID)
+	}
+	// Priority order, PRD order within equal priority, unmet dependencies excluded
+	if got := strings.Join(ids, ","); got != "US-002,US-001,US-004" {
+		t.Errorf("expected US-002,US-001,US-004, got %s", got)
+	}
+}
+
+func TestGetReadyStories_WaitsForAnswers(t *testing.T) {
+	def := &PRDDefinition{
+		UserStories: []StoryDefinition{
+			{ID: "US-001", Priority: 1},
+			{ID: "US-002", Priority: 2},
+		},
+	}
+	state := NewRunState()
+	state.AskQuestion("US-001", "Soft or hard delete?")
+
+	if next := GetNextStory(def, state); next == nil || next.ID != "US-002" {
+		t.Fatalf("a story awaiting input should be passed over, got %v", next)
+	}
+	if got := GetAwaitingInput(def, state); len(got) != 1 || got[0] != "US-001" {
+		t.Errorf("awaiting = %v", got)
+	}
+	state.MarkPassed("US-002")
+	if AllComplete(def, state) {
+		t.Error("a story awaiting input is not complete")
+	}
+
+	if err := state.AnswerQuestion("US-001", "Soft delete"); err != nil {
+		t.Fatal(err)
+	}
+	if next := GetNextStory(def, state); next == nil || next.ID != "US-001" {
+		t.Errorf("an answered story should be ready again, got %v", next)
+	}
+}
+
+func TestAnswerQuestion(t *testing.T) {
+	state := NewRunState()
+	if state.IsAwaitingInput("US-001") {
+		t.Error("no questions asked yet")
+	}
+	if err := state.AnswerQuestion("US-001", "yes"); err == nil {
+		t.Error("expected an error without an open question")
+	}
+
+	state.AskQuestion("US-001", "First?")
+	state.AskQuestion("US-001", "Second?")
+	if got := state.PendingQuestions("US-001"); len(got) != 2 {
+		t.Fatalf("expected 2 pending questions, got %v", got)
+	}
+
+	// Oldest first
+	state.AnswerQuestion("US-001", "one")
+	qs := state.GetQuestions("US-001")
+	if qs[0].Answer != "one" || qs[0].AnsweredAt.IsZero() || qs[1].Answer != "" {
+		t.Errorf("expected the first question answered, got %+v", qs)
+	}
+	if !state.IsAwaitingInput("US-001") {
+		t.Error("still awaiting the second answer")
+	}
+	state.AnswerQuestion("US-001", "two")
+	if state.IsAwaitingInput("US-001") {
+		t.Error("all questions are answered")
+	}
+}
+
+func TestGetNextStory_SkipsBlockedStories(t *testing.T) {
+	def := &PRDDefinition{
+		UserStories: []StoryDefinition{
+			{ID: "US-001", Priority: 1},
+			{ID: "US-002", Priority: 2, DependsOn: []string{"US-001"}},
+			{ID: "US-003", Priority: 3},
+		},
+	}
+	state := NewRunState()
+	state.MarkSkipped("US-001", "too hard")
+	RefreshBlocked(def, state)
+
+	next := GetNextStory(def, state)
+	if next == nil || next.ID != "US-003" {
+		t.Fatalf("expected US-003, got %v", next)
+	}
+}
+
+func TestRefreshBlocked_Transitive(t *testing.T) {
+	def := &PRDDefinition{
+		UserStories: []StoryDefinition{
+			{ID: "US-001"},
+			{ID: "US-002", DependsOn: []string{"US-001"}},
+			{ID: "US-003", DependsOn: []string{"US-002"}},
+			{ID: "US-004"},
+		},
+	}
+	state := NewRunState()
+	state.MarkSkipped("US-001", "too hard")
+
+	newly := RefreshBlocked(def, state)
+	if len(newly) != 2 || newly[0] != "US-002" || newly[1] != "US-003" {
+		t.Fatalf("expected [US-002 US-003] newly blocked, got %v", newly)
+	}
+	if got := state.GetBlockedReason("US-002"); got != "dependency US-001 was skipped" {
+		t.Errorf("unexpected reason for US-002: %q", got)
+	}
+	if got := state.GetBlockedReason("US-003"); got != "dependency US-002 is blocked" {
+		t.Errorf("unexpected reason for US-003: %q", got)
+	}
+	if state.IsBlocked("US-004") {
+		t.Error("independent story should not be blocked")
+	}
+	if !AllComplete(&PRDDefinition{UserStories: def.UserStories[:3]}, state) {
+		t.Error("skipped + blocked stories should count as complete")
+	}
+
+	// Second refresh reports nothing new
+	if newly := RefreshBlocked(def, state); len(newly) != 0 {
+		t.Errorf("expected no newly blocked stories, got %v", newly)
+	}
+}
+
+func TestRefreshBlocked_UnblocksWhenDependencyUnskipped(t *testing.T) {
+	def := &PRDDefinition{
+		UserStories: []StoryDefinition{
+			{ID: "US-001"},
+			{ID: "US-002", DependsOn: []string{"US-001"}},
+		},
+	}
+	state := NewRunState()
+	state.MarkSkipped("US-001", "too hard")
+	RefreshBlocked(def, state)
+
+	state.MarkPassed("US-001")
+	RefreshBlocked(def, state)
+	if state.IsBlocked("US-002") {
+		t.Error("expected US-002 unblocked after dependency passed")
+	}
+	if state.Blocked != nil {
+		t.Errorf("expected nil Blocked map when nothing is blocked, got %v", state.Blocked)
+	}
+}
+
 func TestAllComplete_True(t *testing.T) {
 	def := &PRDDefinition{
 		UserStories: []StoryDefinition{
@@ -164,7 +341,8 @@ func TestMarkFailed(t *testing.T) {
 
 func TestMarkFailed_AutoSkip(t *testing.T) {
 	state := NewRunState()
-	state.Retries["US-001"] = 2
+	state.MarkFailed("US-001", "first failure", 3)
+	state.MarkFailed("US-001", "second failure", 3)
 
 	state.MarkFailed("US-001", "third failure", 3)
 
@@ -211,6 +389,74 @@ func TestMarkSkipped(t *testing.T) {
 	}
 }
 
+func TestRecordAttempt_History(t *testing.T) {
+	state := NewRunState()
+	state.RecordAttempt("US-001", AttemptRecord{Failure: FailureCompile, FailedCommand: "tsc", Reason: "tsc failed"}, 3)
+	state.RecordAttempt("US-001", AttemptRecord{Failure: FailureTimeout, Reason: "provider timed out", Uncharged: true}, 3)
+	state.RecordAttempt("US-001", AttemptRecord{Failure: FailureTest, FailedCommand: "npm test", Reason: "npm test failed"}, 3)
+
+	attempts := state.GetAttempts("US-001")
+	if len(attempts) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(attempts))
+	}
+	for i, a := range attempts {
+		if a.Number != i+1 {
+			t.Errorf("attempt %d: expected number %d, got %d", i, i+1, a.Number)
+		}
+	}
+	// Uncharged attempts don't count toward retries
+	if state.GetRetries("US-001") != 2 {
+		t.Errorf("expected retries=2, got %d", state.GetRetries("US-001"))
+	}
+	if state.GetLastFailure("US-001") != "npm test failed" {
+		t.Errorf("expected last failure from latest attempt, got %q", state.GetLastFailure("US-001"))
+	}
+	if state.IsSkipped("US-001") {
+		t.Error("should not be skipped below maxRetries")
+	}
+
+	state.RecordAttempt("US-001", AttemptRecord{Passed: true}, 3)
+	if !state.IsPassed("US-001") {
+		t.Error("expected passed after a passing attempt")
+	}
+	if state.GetLastFailure("US-001") != "" {
+		t.Errorf("expected no last failure after pass, got %q", state.GetLastFailure("US-001"))
+	}
+}
+
+func TestLoadRunState_MigratesLegacyRetries(t *testing.T) {
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "run-state.json")
+	legacy := `{
+  "passed": [],
+  "skipped": ["US-002"],
+  "retries": {"US-001": 2},
+  "lastFailure": {"US-001": "lint failed", "US-002": "manual skip"}
+}`
+	os.WriteFile(statePath, []byte(legacy), 0644)
+
+	state, err := LoadRunState(statePath)
+	if err != nil {
+		t.Fatalf("LoadRunState failed: %v", err)
+	}
+	if state.GetRetries("US-001") != 2 {
+		t.Errorf("expected 2 retries migrated, got %d", state.GetRetries("US-001"))
+	}
+	if state.GetLastFailure("US-001") != "lint failed" {
+		t.Errorf("expected last failure migrated, got %q", state.GetLastFailure("US-001"))
+	}
+	if state.GetLastFailure("US-002") != "manual skip" {
+		t.Errorf("expected skip reason migrated, got %q", state.GetLastFailure("US-002"))
+	}
+
+	// Legacy keys are not written back
+	SaveRunState(statePath, state)
+	data, _ := os.ReadFile(statePath)
+	if strings.Contains(string(data), `"retries"`) || strings.Contains(string(data), `"lastFailure"`) {
+		t.Errorf("legacy fields should not be saved, got:\n%s", data)
+	}
+}
+
 func TestMarkSkipped_RemovesFromPassed(t *testing.T) {
 	state := NewRunState()
 	state.MarkPassed("US-001")
@@ -503,6 +749,59 @@ func TestValidatePRDDefinition_EmptyStories(t *testing.T) {
 	}
 }
 
+func validDefWithDeps(deps map[string][]string) *PRDDefinition {
+	def := &PRDDefinition{SchemaVersion: 3, Project: "Test", BranchName: "ralph/test"}
+	for _, id := range []string{"US-001", "US-002", "US-003"} {
+		def.UserStories = append(def.UserStories, StoryDefinition{
+			ID: id, Title: "Story", AcceptanceCriteria: []string{"x"}, DependsOn: deps[id],
+		})
+	}
+	return def
+}
+
+func TestValidatePRDDefinition_ValidDependencies(t *testing.T) {
+	def := validDefWithDeps(map[string][]string{
+		"US-002": {"US-001"},
+		"US-003": {"US-001", "US-002"},
+	})
+	if err := ValidatePRDDefinition(def); err != nil {
+		t.Errorf("expected valid DAG, got: %v", err)
+	}
+}
+
+func TestValidatePRDDefinition_UnknownDependency(t *testing.T) {
+	def := validDefWithDeps(map[string][]string{"US-002": {"US-999"}})
+	err := ValidatePRDDefinition(def)
+	if err == nil {
+		t.Fatal("expected error for unknown dependency")
+	}
+	if !strings.Contains(err.Error(), "US-999") {
+		t.Errorf("error should name the unknown story, got: %v", err)
+	}
+}
+
+func TestValidatePRDDefinition_DependencyCycle(t *testing.T) {
+	def := validDefWithDeps(map[string][]string{
+		"US-001": {"US-003"},
+		"US-002": {"US-001"},
+		"US-003": {"US-002"},
+	})
+	err := ValidatePRDDefinition(def)
+	if err == nil {
+		t.Fatal("expected error for dependency cycle")
+	}
+	if !strings.Contains(err.Error(), "dependency cycle") {
+		t.Errorf("expected cycle error, got: %v", err)
+	}
+}
+
+func TestValidatePRDDefinition_SelfDependency(t *testing.T) {
+	def := validDefWithDeps(map[string][]string{"US-002": {"US-002"}})
+	if err := ValidatePRDDefinition(def); err == nil {
+		t.Error("expected error for self-dependency")
+	}
+}
+
 func TestLoadRunState_NotFound(t *testing.T) {
 	state, err := LoadRunState("/nonexistent/run-state.json")
 	if err != nil {
@@ -588,11 +887,8 @@ func TestNewRunState(t *testing.T) {
 	if state == nil {
 		t.Fatal("expected non-nil state")
 	}
-	if state.Retries == nil {
-		t.Error("expected non-nil Retries map")
-	}
-	if state.LastFailure == nil {
-		t.Error("expected non-nil LastFailure map")
+	if state.Attempts == nil {
+		t.Error("expected non-nil Attempts map")
 	}
 }
 
@@ -694,3 +990,46 @@ func TestGetLastFailure_EmptyForUnknown(t *testing.T) {
 		t.Errorf("expected empty lastFailure for unknown story")
 	}
 }
+
+func TestVerifyBaseline_FailureFor(t *testing.T) {
+	var nilBaseline *VerifyBaseline
+	if nilBaseline.FailureFor("npm test") != nil {
+		t.Error("nil baseline should report no failures")
+	}
+
+	b := &VerifyBaseline{Failures: []BaselineFailure{{Command: "npm test", Failure: FailureTest}}}
+	if f := b.FailureFor("npm test"); f == nil || f.Failure != FailureTest {
+		t.Errorf("expected npm test baseline failure, got %+v", f)
+	}
+	if b.FailureFor("npm run lint") != nil {
+		t.Error("npm run lint passed at baseline")
+	}
+}
+
+func TestValidatePRDDefinition_Complexity(t *testing.T) {
+	def := validDefWithDeps(nil)
+	def.UserStories[0].Complexity = "high"
+	if err := ValidatePRDDefinition(def); err != nil {
+		t.Errorf("expected valid complexity, got: %v", err)
+	}
+
+	def.UserStories[1].Complexity = "huge"
+	err := ValidatePRDDefinition(def)
+	if err == nil || !strings.Contains(err.Error(), "userStories[1]: complexity must be one of low, medium, high") {
+		t.Errorf("expected complexity error, got: %v", err)
+	}
+}
+
+func TestValidatePRDDefinition_StoryVerify(t *testing.T) {
+	def := validDefWithDeps(nil)
+	def.UserStories[0].Verify = []string{"make migrate-check"}
+	if err := ValidatePRDDefinition(def); err != nil {
+		t.Errorf("expected valid verify list, got: %v", err)
+	}
+
+	def.UserStories[1].Verify = []string{"make contract-test", ""}
+	err := ValidatePRDDefinition(def)
+	if err == nil || !strings.Contains(err.Error(), "userStories[1]: verify[1] is empty") {
+		t.Errorf("expected empty verify command error, got: %v", err)
+	}
+}
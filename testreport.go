@@ -0,0 +1,488 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TestFailure is one failing test extracted from a test runner's structured output.
+type TestFailure struct {
+	Name    string `json:"name"`
+	Suite   string `json:"suite,omitempty"` // package, class, or test file the test belongs to
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Location returns "file:line", "file", or "" when the runner reported no location.
+func (f TestFailure) Location() string {
+	if f.File == "" {
+		return ""
+	}
+	if f.Line > 0 {
+		return fmt.Sprintf("%s:%d", f.File, f.Line)
+	}
+	return f.File
+}
+
+// maxTestMessageLines and maxTestMessageLen keep one failure's message to the assertion,
+// not the whole stack.
+const (
+	maxTestMessageLines = 8
+	maxTestMessageLen   = 600
+)
+
+// maxTestsListed caps the failing tests listed in a prompt or on the console.
+const maxTestsListed = 20
+
+var (
+	ansiPattern     = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+	goFileLine      = regexp.MustCompile(`^\s*([\w./-]+\.go):(\d+): ?(.*)$`)
+	fileLinePattern = regexp.MustCompile(`([\w@./-]+\.[A-Za-z]{1,5}):(\d+)`)
+	tapNotOK        = regexp.MustCompile(`^\s*not ok\s+\d*\s*(?:- )?(.*)$`)
+)
+
+// parseTestFailures extracts failing tests from a failed verify command. Report files
+// matching verify.reports that were written since the command started come first; then
+// the command output is read as go test -json, jest/vitest JSON, or TAP. Returns nil when
+// nothing structured was found.
+func parseTestFailures(projectRoot string, reports []string, since time.Time, output string) []TestFailure {
+	var failures []TestFailure
+	for _, file := range freshReportFiles(projectRoot, reports, since) {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		failures = append(failures, parseReport(projectRoot, string(data))...)
+	}
+	if len(failures) > 0 {
+		return failures
+	}
+	return parseReport(projectRoot, output)
+}
+
+// freshReportFiles returns the files matching the report globs that were modified at or
+// after since, so a stale report from an earlier run isn't read as this run's result.
+func freshReportFiles(projectRoot string, globs []string, since time.Time) []string {
+	var files []string
+	for _, g := range globs {
+		if !filepath.IsAbs(g) {
+			g = filepath.Join(projectRoot, g)
+		}
+		matches, _ := filepath.Glob(g)
+		for _, m := range matches {
+			if info, err := os.Stat(m); err == nil && !info.IsDir() && !info.ModTime().Before(since.Truncate(time.Second)) {
+				files = append(files, m)
+			}
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// parseReport tries each supported format in turn.
+func parseReport(projectRoot, content string) []TestFailure {
+	trimmed := strings.TrimSpace(content)
+	if strings.HasPrefix(trimmed, "<") {
+		return parseJUnitXML(trimmed)
+	}
+	if failures := parseGoTestJSON(content); failures != nil {
+		return failures
+	}
+	if failures := parseJestJSON(projectRoot, content); failures != nil {
+		return failures
+	}
+	return parseTAP(content)
+}
+
+// goTestEvent is one line of `go test -json` output.
+type goTestEvent struct {
+	Action      string `json:"Action"`
+	Package     string `json:"Package"`
+	Test        string `json:"Test"`
+	Output      string `json:"Output"`
+	ImportPath  string `json:"ImportPath"`  // set on build-output events
+	FailedBuild string `json:"FailedBuild"` // on a package's fail event, the build that failed
+}
+
+// parseGoTestJSON reads `go test -json` events. Only the innermost failing subtests are
+// reported; a package that failed without a failing test (a build error or a panic in
+// TestMain) is reported as the package. Returns nil when the output has no events.
+func parseGoTestJSON(output string) []TestFailure {
+	type key struct{ pkg, test string }
+	outputs := make(map[key][]string)
+	var failed []key
+	failedBuild := make(map[string]string) // package → ImportPath of its failed build
+	seen := false
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "{") {
+			continue
+		}
+		var ev goTestEvent
+		if json.Unmarshal([]byte(line), &ev) != nil || ev.Action == "" {
+			continue
+		}
+		seen = true
+		k := key{ev.Package, ev.Test}
+		switch ev.Action {
+		case "output":
+			outputs[k] = append(outputs[k], ev.Output)
+		case "build-output":
+			outputs[key{ev.ImportPath, ""}] = append(outputs[key{ev.ImportPath, ""}], ev.Output)
+		case "fail":
+			failed = append(failed, k)
+			if ev.FailedBuild != "" {
+				failedBuild[ev.Package] = ev.FailedBuild
+			}
+		}
+	}
+	if !seen {
+		return nil
+	}
+
+	failures := []TestFailure{}
+	testFailed := make(map[string]bool) // packages with at least one failing test
+	for _, k := range failed {
+		if k.test == "" {
+			continue
+		}
+		testFailed[k.pkg] = true
+		// A parent test fails when a subtest does; report the subtest
+		parent := false
+		for _, other := range failed {
+			if other.pkg == k.pkg && strings.HasPrefix(other.test, k.test+"/") {
+				parent = true
+				break
+			}
+		}
+		if parent {
+			continue
+		}
+		f := TestFailure{Name: k.test, Suite: k.pkg}
+		var msg []string
+		for _, l := range outputs[k] {
+			l = strings.TrimRight(l, "\n")
+			trimmed := strings.TrimSpace(l)
+			if trimmed == "" || strings.HasPrefix(trimmed, "=== ") || strings.HasPrefix(trimmed, "--- FAIL") || strings.HasPrefix(trimmed, "--- PASS") {
+				continue
+			}
+			if m := goFileLine.FindStringSubmatch(l); m != nil && f.File == "" {
+				f.File = m[1]
+				f.Line, _ = strconv.Atoi(m[2])
+				trimmed = m[3]
+			}
+			msg = append(msg, trimmed)
+		}
+		f.Message = clipMessage(strings.Join(msg, "\n"))
+		failures = append(failures, f)
+	}
+	for _, k := range failed {
+		if k.test != "" || testFailed[k.pkg] {
+			continue
+		}
+		f := TestFailure{Name: k.pkg, Suite: k.pkg}
+		lines := outputs[k]
+		if build, ok := failedBuild[k.pkg]; ok {
+			lines = append(outputs[key{build, ""}], lines...)
+		}
+		var msg []string
+		for _, l := range lines {
+			trimmed := strings.TrimSpace(l)
+			if trimmed == "" || trimmed == "FAIL" || strings.HasPrefix(trimmed, "FAIL\t") || strings.HasPrefix(trimmed, "# ") {
+				continue
+			}
+			if loc := fileLinePattern.FindStringSubmatch(trimmed); loc != nil && f.File == "" {
+				f.File = strings.TrimPrefix(loc[1], "./")
+				f.Line, _ = strconv.Atoi(loc[2])
+			}
+			msg = append(msg, trimmed)
+		}
+		f.Message = clipMessage(strings.Join(msg, "\n"))
+		failures = append(failures, f)
+	}
+	return failures
+}
+
+// jestReport is the part of jest's --json (and vitest's json reporter) output ralph reads.
+type jestReport struct {
+	NumFailedTests *int `json:"numFailedTests"`
+	TestResults    []struct {
+		Name             string `json:"name"`
+		Status           string `json:"status"`
+		Message          string `json:"message"`
+		AssertionResults []struct {
+			FullName        string   `json:"fullName"`
+			Status          string   `json:"status"`
+			FailureMessages []string `json:"failureMessages"`
+			Location        *struct {
+				Line int `json:"line"`
+			} `json:"location"`
+		} `json:"assertionResults"`
+	} `json:"testResults"`
+}
+
+// parseJestJSON finds a jest/vitest JSON report in the output, which may be surrounded by
+// other logging. Returns nil when there is none.
+func parseJestJSON(projectRoot, output string) []TestFailure {
+	for i := strings.Index(output, "{"); i >= 0; {
+		var report jestReport
+		if json.NewDecoder(strings.NewReader(output[i:])).Decode(&report) == nil && report.NumFailedTests != nil {
+			return jestFailures(projectRoot, &report)
+		}
+		next := strings.Index(output[i+1:], "\n{")
+		if next < 0 {
+			break
+		}
+		i += next + 2
+	}
+	return nil
+}
+
+func jestFailures(projectRoot string, report *jestReport) []TestFailure {
+	failures := []TestFailure{}
+	for _, file := range report.TestResults {
+		name := relativeTo(projectRoot, file.Name)
+		found := false
+		for _, a := range file.AssertionResults {
+			if a.Status != "failed" {
+				continue
+			}
+			found = true
+			f := TestFailure{Name: a.FullName, Suite: name, File: name}
+			msg := ansiPattern.ReplaceAllString(strings.Join(a.FailureMessages, "\n"), "")
+			if a.Location != nil {
+				f.Line = a.Location.Line
+			} else if line := lineInFile(msg, path.Base(name)); line > 0 {
+				f.Line = line
+			}
+			f.Message = clipMessage(msg)
+			failures = append(failures, f)
+		}
+		// A test file that failed to load has no assertion results
+		if !found && file.Status == "failed" {
+			failures = append(failures, TestFailure{Name: name, Suite: name, File: name, Message: clipMessage(ansiPattern.ReplaceAllString(file.Message, ""))})
+		}
+	}
+	return failures
+}
+
+// parseTAP reads "not ok" lines of TAP output, with the message and location from the
+// YAML diagnostic block that may follow. TODO tests are expected to fail and are skipped.
+// Returns nil when there are no failures.
+func parseTAP(output string) []TestFailure {
+	lines := strings.Split(output, "\n")
+	var failures []TestFailure
+	for i := 0; i < len(lines); i++ {
+		m := tapNotOK.FindStringSubmatch(lines[i])
+		if m == nil || strings.Contains(strings.ToUpper(m[1]), "# TODO") {
+			continue
+		}
+		name := strings.TrimSpace(strings.SplitN(m[1], " # ", 2)[0])
+		f := TestFailure{Name: name}
+
+		// The YAML block is indented and delimited by --- and ...
+		if i+1 < len(lines) && strings.TrimSpace(lines[i+1]) == "---" {
+			var yaml []string
+			for i += 2; i < len(lines) && strings.TrimSpace(lines[i]) != "..."; i++ {
+				yaml = append(yaml, strings.TrimSpace(lines[i]))
+			}
+			for j, y := range yaml {
+				k, v, ok := strings.Cut(y, ":")
+				if !ok {
+					continue
+				}
+				v = strings.Trim(strings.TrimSpace(v), `'"`)
+				switch k {
+				case "message", "error":
+					if v == "|-" || v == "|" || v == ">-" {
+						v = ""
+						for _, more := range yaml[j+1:] {
+							if _, _, isKey := strings.Cut(more, ": "); isKey && !strings.HasPrefix(more, " ") {
+								break
+							}
+							v += more + "\n"
+						}
+					}
+					if f.Message == "" {
+						f.Message = clipMessage(v)
+					}
+				case "at", "location":
+					if loc := fileLinePattern.FindStringSubmatch(v); loc != nil {
+						f.File = loc[1]
+						f.Line, _ = strconv.Atoi(loc[2])
+					}
+				case "file":
+					f.File = v
+				case "line":
+					f.Line, _ = strconv.Atoi(v)
+				}
+			}
+		}
+		failures = append(failures, f)
+	}
+	return failures
+}
+
+// junitCase is a <testcase> in a JUnit XML report.
+type junitCase struct {
+	Name      string `xml:"name,attr"`
+	Classname string `xml:"classname,attr"`
+	File      string `xml:"file,attr"`
+	Line      int    `xml:"line,attr"`
+	Failures  []struct {
+		Message string `xml:"message,attr"`
+		Text    string `xml:",chardata"`
+	} `xml:"failure"`
+	Errors []struct {
+		Message string `xml:"message,attr"`
+		Text    string `xml:",chardata"`
+	} `xml:"error"`
+}
+
+// junitSuite is a <testsuite>, which may nest further suites.
+type junitSuite struct {
+	Name   string       `xml:"name,attr"`
+	File   string       `xml:"file,attr"`
+	Cases  []junitCase  `xml:"testcase"`
+	Suites []junitSuite `xml:"testsuite"`
+}
+
+// parseJUnitXML reads a JUnit XML report (including pytest's --junitxml), with either
+// <testsuites> or a single <testsuite> at the root.
+func parseJUnitXML(content string) []TestFailure {
+	var root struct {
+		XMLName xml.Name
+		junitSuite
+	}
+	if xml.Unmarshal([]byte(content), &root) != nil {
+		return nil
+	}
+	suites := root.Suites
+	if root.XMLName.Local == "testsuite" {
+		suites = []junitSuite{root.junitSuite}
+	}
+
+	failures := []TestFailure{}
+	var walk func(s junitSuite)
+	walk = func(s junitSuite) {
+		for _, c := range s.Cases {
+			var message, text string
+			switch {
+			case len(c.Failures) > 0:
+				message, text = c.Failures[0].Message, c.Failures[0].Text
+			case len(c.Errors) > 0:
+				message, text = c.Errors[0].Message, c.Errors[0].Text
+			default:
+				continue
+			}
+			f := TestFailure{Name: c.Name, Suite: c.Classname, File: c.File, Line: c.Line}
+			if f.Suite == "" {
+				f.Suite = s.Name
+			}
+			if f.File == "" {
+				f.File = s.File
+			}
+			if f.File == "" {
+				if loc := fileLinePattern.FindStringSubmatch(text); loc != nil {
+					f.File = loc[1]
+					f.Line, _ = strconv.Atoi(loc[2])
+				}
+			} else if f.Line == 0 {
+				f.Line = lineInFile(text, path.Base(f.File))
+			}
+			msg := strings.TrimSpace(message)
+			if detail := strings.TrimSpace(text); msg == "" || (detail != "" && !strings.Contains(msg, "\n") && len(detail) < maxTestMessageLen) {
+				if msg != "" && !strings.HasPrefix(detail, msg) {
+					msg += "\n" + detail
+				} else if detail != "" {
+					msg = detail
+				}
+			}
+			f.Message = clipMessage(msg)
+			failures = append(failures, f)
+		}
+		for _, child := range s.Suites {
+			walk(child)
+		}
+	}
+	for _, s := range suites {
+		walk(s)
+	}
+	return failures
+}
+
+// lineInFile returns the first line number mentioned for file in text, e.g. from a stack
+// frame "(src/cart.test.ts:42:7)".
+func lineInFile(text, file string) int {
+	for _, m := range fileLinePattern.FindAllStringSubmatch(text, -1) {
+		if path.Base(m[1]) == file {
+			n, _ := strconv.Atoi(m[2])
+			return n
+		}
+	}
+	return 0
+}
+
+// relativeTo shortens an absolute path under root to a relative one.
+func relativeTo(root, p string) string {
+	if filepath.IsAbs(p) {
+		if rel, err := filepath.Rel(root, p); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return p
+}
+
+// clipMessage trims a failure message to its first lines.
+func clipMessage(msg string) string {
+	msg = strings.TrimSpace(msg)
+	if lines := strings.Split(msg, "\n"); len(lines) > maxTestMessageLines {
+		msg = strings.Join(lines[:maxTestMessageLines], "\n") + "\n..."
+	}
+	if len(msg) > maxTestMessageLen {
+		msg = msg[:maxTestMessageLen-3] + "..."
+	}
+	return msg
+}
+
+// testFailureSection appends parsed failing tests to a command's output tail ("" if none).
+func testFailureSection(failures []TestFailure) string {
+	if len(failures) == 0 {
+		return ""
+	}
+	return "\n--- Failing tests ---\n" + strings.TrimRight(formatTestFailures(failures, maxTestsListed), "\n")
+}
+
+// formatTestFailures lists failing tests for prompts and the console, at most max of them.
+func formatTestFailures(failures []TestFailure, max int) string {
+	var b strings.Builder
+	for i, f := range failures {
+		if i == max {
+			fmt.Fprintf(&b, "- ... and %d more\n", len(failures)-max)
+			break
+		}
+		b.WriteString("- " + f.Name)
+		if loc := f.Location(); loc != "" {
+			b.WriteString(" (" + loc + ")")
+		}
+		if f.Message != "" {
+			b.WriteString(": " + strings.ReplaceAll(f.Message, "\n", "\n  "))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseGoTestJSON(t *testing.T) {
+	output := `{"Action":"start","Package":"example.com/app"}
+{"Action":"run","Package":"example.com/app","Test":"TestAdd"}
+{"Action":"output","Package":"example.com/app","Test":"TestAdd/negative","Output":"=== RUN   TestAdd/negative\n"}
+{"Action":"output","Package":"example.com/app","Test":"TestAdd/negative","Output":"    add_test.go:7: got 1, want 2\n"}
+{"Action":"output","Package":"example.com/app","Test":"TestAdd/negative","Output":"--- FAIL: TestAdd/negative (0.00s)\n"}
+{"Action":"fail","Package":"example.com/app","Test":"TestAdd/negative"}
+{"Action":"pass","Package":"example.com/app","Test":"TestAdd/ok"}
+{"Action":"output","Package":"example.com/app","Test":"TestAdd","Output":"--- FAIL: TestAdd (0.00s)\n"}
+{"Action":"fail","Package":"example.com/app","Test":"TestAdd"}
+{"Action":"output","Package":"example.com/app","Output":"FAIL\texample.com/app\t0.002s\n"}
+{"Action":"fail","Package":"example.com/app"}
+{"ImportPath":"example.com/app/store [example.com/app/store.test]","Action":"build-output","Output":"# example.com/app/store [example.com/app/store.test]\n"}
+{"ImportPath":"example.com/app/store [example.com/app/store.test]","Action":"build-output","Output":"./store.go:2:12: undefined: missing\n"}
+{"ImportPath":"example.com/app/store [example.com/app/store.test]","Action":"build-fail"}
+{"Action":"output","Package":"example.com/app/store","Output":"FAIL\texample.com/app/store [build failed]\n"}
+{"Action":"fail","Package":"example.com/app/store","FailedBuild":"example.com/app/store [example.com/app/store.test]"}
+`
+	got := parseGoTestJSON(output)
+	if len(got) != 2 {
+		t.Fatalf("expected the subtest and the package that failed to build, got %+v", got)
+	}
+	want := TestFailure{Name: "TestAdd/negative", Suite: "example.com/app", File: "add_test.go", Line: 7, Message: "got 1, want 2"}
+	if got[0] != want {
+		t.Errorf("got %+v, want %+v", got[0], want)
+	}
+	if f := got[1]; f.Name != "example.com/app/store" || f.Location() != "store.go:2" || !strings.Contains(f.Message, "undefined: missing") {
+		t.Errorf("expected the build error, got %+v", f)
+	}
+
+	if parseGoTestJSON("ok  \texample.com/app\t0.1s\n") != nil {
+		t.Error("plain go test output should not parse as -json")
+	}
+	if got := parseGoTestJSON(`{"Action":"pass","Package":"example.com/app"}`); got == nil || len(got) != 0 {
+		t.Errorf("a passing run should parse to no failures, got %v", got)
+	}
+}
+
+func TestParseJestJSON(t *testing.T) {
+	root := "/work/app"
+	output := "> jest --json\n" + `{"numFailedTests":2,"numPassedTests":1,"testResults":[
+  {"name":"/work/app/src/cart.test.ts","status":"failed","message":"","assertionResults":[
+    {"fullName":"cart applies discount","status":"failed","failureMessages":["Error: \u001b[31mexpected 90 to be 80\u001b[39m\n    at Object.<anonymous> (/work/app/src/cart.test.ts:42:7)"]},
+    {"fullName":"cart adds items","status":"passed","failureMessages":[]},
+    {"fullName":"cart empties","status":"failed","failureMessages":["AssertionError: expected [] to have length 0"],"location":{"line":12,"column":3}}
+  ]},
+  {"name":"/work/app/src/broken.test.ts","status":"failed","message":"Cannot find module './gone'","assertionResults":[]}
+]}
+`
+	got := parseJestJSON(root, output)
+	if len(got) != 3 {
+		t.Fatalf("expected two failing tests and a file that failed to load, got %+v", got)
+	}
+	if f := got[0]; f.Name != "cart applies discount" || f.Location() != "src/cart.test.ts:42" || !strings.HasPrefix(f.Message, "Error: expected 90 to be 80") {
+		t.Errorf("expected the line from the stack and an uncolored message, got %+v", f)
+	}
+	if f := got[1]; f.Location() != "src/cart.test.ts:12" {
+		t.Errorf("expected the reported location, got %+v", f)
+	}
+	if f := got[2]; f.Name != "src/broken.test.ts" || f.Message != "Cannot find module './gone'" {
+		t.Errorf("expected the load failure, got %+v", f)
+	}
+
+	if parseJestJSON(root, `{"level":"info","msg":"not a report"}`) != nil {
+		t.Error("other JSON should not parse as a jest report")
+	}
+}
+
+func TestParseTAP(t *testing.T) {
+	output := `TAP version 13
+ok 1 - adds numbers
+not ok 2 - rejects negative input
+  ---
+  message: 'expected error to be thrown'
+  at: test/math.test.js:18:5
+  ...
+not ok 3 - handles overflow # TODO not implemented yet
+not ok 4 parses dates
+1..4
+`
+	got := parseTAP(output)
+	if len(got) != 2 {
+		t.Fatalf("expected two failures (TODO ignored), got %+v", got)
+	}
+	want := TestFailure{Name: "rejects negative input", File: "test/math.test.js", Line: 18, Message: "expected error to be thrown"}
+	if got[0] != want {
+		t.Errorf("got %+v, want %+v", got[0], want)
+	}
+	if got[1].Name != "parses dates" {
+		t.Errorf("expected a failure without a YAML block, got %+v", got[1])
+	}
+	if parseTAP("all good\n") != nil {
+		t.Error("output without failures should parse to nil")
+	}
+}
+
+func TestParseJUnitXML(t *testing.T) {
+	// pytest --junitxml
+	pytest := `<?xml version="1.0" encoding="utf-8"?>
+<testsuites><testsuite name="pytest" errors="1" failures="1" tests="3">
+  <testcase classname="tests.test_users" name="test_create" file="tests/test_users.py" line="11" time="0.01">
+    <failure message="assert 404 == 201">def test_create(client):
+&gt;       assert resp.status_code == 201
+E       assert 404 == 201
+
+tests/test_users.py:14: AssertionError</failure>
+  </testcase>
+  <testcase classname="tests.test_users" name="test_list" time="0.01"/>
+  <testcase classname="tests.test_orders" name="test_total" time="0.01">
+    <error message="fixture 'db' not found">file /app/tests/test_orders.py, line 3</error>
+  </testcase>
+</testsuite></testsuites>`
+	got := parseJUnitXML(pytest)
+	if len(got) != 2 {
+		t.Fatalf("expected a failure and an error, got %+v", got)
+	}
+	if f := got[0]; f.Name != "test_create" || f.Suite != "tests.test_users" || f.Location() != "tests/test_users.py:11" || !strings.HasPrefix(f.Message, "assert 404 == 201") {
+		t.Errorf("unexpected failure: %+v", f)
+	}
+	if f := got[1]; f.Name != "test_total" || f.Message != "fixture 'db' not found\nfile /app/tests/test_orders.py, line 3" {
+		t.Errorf("unexpected error: %+v", f)
+	}
+
+	// A single <testsuite> root, as vitest's junit reporter and many others write
+	vitest := `<testsuite name="src/cart.test.ts" file="src/cart.test.ts">
+  <testcase classname="src/cart.test.ts" name="cart &gt; applies discount">
+    <failure message="expected 90 to be 80">AssertionError: expected 90 to be 80
+ ❯ src/cart.test.ts:42:7</failure>
+  </testcase>
+</testsuite>`
+	got = parseJUnitXML(vitest)
+	if len(got) != 1 || got[0].Name != "cart > applies discount" || got[0].Location() != "src/cart.test.ts:42" {
+		t.Errorf("unexpected failures: %+v", got)
+	}
+}
+
+func TestParseTestFailures_Reports(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "reports"), 0755)
+	report := `<testsuite name="pytest"><testcase classname="t" name="test_new"><failure message="boom"/></testcase></testsuite>`
+	stale := filepath.Join(root, "reports", "old.xml")
+	os.WriteFile(stale, []byte(`<testsuite><testcase name="test_old"><failure message="old"/></testcase></testsuite>`), 0644)
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(stale, old, old)
+
+	start := time.Now()
+	os.WriteFile(filepath.Join(root, "reports", "pytest.xml"), []byte(report), 0644)
+
+	got := parseTestFailures(root, []string{"reports/*.xml"}, start, "FAILED tests/test_new.py\n")
+	if len(got) != 1 || got[0].Name != "test_new" {
+		t.Errorf("expected only the report written during the command, got %+v", got)
+	}
+
+	// Falls back to the command's own output
+	got = parseTestFailures(root, []string{"missing/*.xml"}, start, "not ok 1 - fails\n")
+	if len(got) != 1 || got[0].Name != "fails" {
+		t.Errorf("expected the TAP output to be parsed, got %+v", got)
+	}
+	if got := parseTestFailures(root, nil, start, "Error: something broke\n"); len(got) != 0 {
+		t.Errorf("unstructured output should have no failing tests, got %+v", got)
+	}
+}
+
+func TestFormatTestFailures(t *testing.T) {
+	failures := []TestFailure{
+		{Name: "TestA", File: "a_test.go", Line: 3, Message: "got 1\nwant 2"},
+		{Name: "TestB"},
+		{Name: "TestC"},
+	}
+	got := formatTestFailures(failures, 2)
+	want := "- TestA (a_test.go:3): got 1\n  want 2\n- TestB\n- ... and 1 more\n"
+	if got != want {
+		t.Errorf("formatTestFailures() = %q, want %q", got, want)
+	}
+
+	long := strings.Repeat("line\n", 20)
+	if msg := clipMessage(long); strings.Count(msg, "\n") != maxTestMessageLines || !strings.HasSuffix(msg, "...") {
+		t.Errorf("expected the message clipped to %d lines, got %q", maxTestMessageLines, msg)
+	}
+}
+
+func TestRunStoryVerification_FailedTests(t *testing.T) {
+	logger, err := NewRunLogger(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	// More output than the 50-line tail, so the failure is only found in the full output
+	tap := `printf 'not ok 1 - rejects expired tokens\n  ---\n  message: token accepted\n  at: test/auth.test.js:9:3\n  ...\n'; seq 1 80; exit 1`
+	cfg := &ResolvedConfig{ProjectRoot: t.TempDir(), Config: RalphConfig{Verify: VerifyConfig{
+		Default: []string{tap, "echo lint; exit 1", "echo ok"},
+		Timeout: 30,
+	}}}
+
+	result, err := runStoryVerification(cfg, nil, &StoryDefinition{ID: "US-001"}, nil, nil, "", logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.passed {
+		t.Fatal("expected verification to fail")
+	}
+	want := []TestFailure{{Name: "rejects expired tokens", File: "test/auth.test.js", Line: 9, Message: "token accepted"}}
+	if len(result.failedTests) != 1 || result.failedTests[0] != want[0] {
+		t.Errorf("failedTests = %+v, want %+v", result.failedTests, want)
+	}
+	if result.commands[1].tests != nil {
+		t.Errorf("unstructured output should have no tests, got %+v", result.commands[1].tests)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// TransientConfig controls how a run rides out provider errors that clear up on their
+// own, such as rate limits and overloaded APIs. Zero values use the defaults.
+type TransientConfig struct {
+	Patterns   []string `json:"patterns,omitempty"`   // extra regexes that mark the provider's errors as a transient error
+	Backoff    int      `json:"backoff,omitempty"`    // seconds to wait after the first transient error (default 30)
+	MaxBackoff int      `json:"maxBackoff,omitempty"` // longest single wait in seconds (default 600)
+	MaxWait    int      `json:"maxWait,omitempty"`    // total seconds to wait out consecutive errors before stopping the run (default 3600)
+}
+
+// defaultTransientPatterns recognize rate limiting, overload, and dropped connections in
+// the error a provider CLI prints before exiting. Status codes count only next to a word
+// that makes them one, so a port or a line number doesn't.
+var defaultTransientPatterns = []string{
+	`(?i)rate[ _-]?limit`,
+	`(?i)too many requests`,
+	`(?i)overloaded`,
+	`(?i)(status|error|http)[^0-9]{0,10}(429|529|503)\b`,
+	`(?i)service unavailable`,
+	`(?i)ECONNRESET|ETIMEDOUT|connection reset`,
+}
+
+// transientTailLines is how many of the provider's last error lines are searched.
+const transientTailLines = 20
+
+// validateTransient checks the transient section of the config.
+func validateTransient(t *TransientConfig) error {
+	if t == nil {
+		return nil
+	}
+	if t.Backoff < 0 || t.MaxBackoff < 0 || t.MaxWait < 0 {
+		return fmt.Errorf("transient backoff values must not be negative")
+	}
+	for i, p := range t.Patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("transient.patterns[%d] is not a valid regex: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// transientBackoff tracks consecutive transient provider errors during one `ralph run`.
+type transientBackoff struct {
+	patterns   []*regexp.Regexp
+	backoff    time.Duration
+	maxBackoff time.Duration
+	maxWait    time.Duration
+
+	failures int           // consecutive transient errors
+	waited   time.Duration // backoff since the last other outcome
+	sleep    func(time.Duration)
+}
+
+// newTransientBackoff applies defaults to the config's transient section.
+func newTransientBackoff(cfg *TransientConfig) *transientBackoff {
+	b := &transientBackoff{
+		backoff:    30 * time.Second,
+		maxBackoff: 10 * time.Minute,
+		maxWait:    time.Hour,
+		sleep:      time.Sleep,
+	}
+	patterns := defaultTransientPatterns
+	if cfg != nil {
+		patterns = append(append([]string{}, patterns...), cfg.Patterns...)
+		if cfg.Backoff > 0 {
+			b.backoff = time.Duration(cfg.Backoff) * time.Second
+		}
+		if cfg.MaxBackoff > 0 {
+			b.maxBackoff = time.Duration(cfg.MaxBackoff) * time.Second
+		}
+		if cfg.MaxWait > 0 {
+			b.maxWait = time.Duration(cfg.MaxWait) * time.Second
+		}
+	}
+	for _, p := range patterns {
+		b.patterns = append(b.patterns, regexp.MustCompile(p)) // validated in LoadConfig
+	}
+	return b
+}
+
+// classify returns what made a provider run fail transiently, or "" when it didn't.
+// Only the provider's errors are searched: its stderr and the error events its adapter
+// parsed, never the agent's own text, which may discuss rate limits as part of the work.
+// They count when the provider failed (non-zero exit, timeout, or an error event) without
+// signaling DONE, STUCK, or QUESTION. An error running the provider counts only when it
+// matches a pattern too: a missing binary or a failed pipe won't clear up by waiting, and
+// a timeout used the provider's whole iteration.
+func (b *transientBackoff) classify(result *ProviderResult, err error) string {
+	if result != nil && !result.Done && !result.Stuck && len(result.Questions) == 0 && result.failed() {
+		if line := b.match(result.Errors); line != "" {
+			return line
+		}
+	}
+	if err != nil && (result == nil || !result.TimedOut) {
+		return b.match(strings.Split(err.Error(), "\n"))
+	}
+	return ""
+}
+
+// failed reports whether the provider session ended in failure rather than just
+// without a signal.
+func (r *ProviderResult) failed() bool {
+	return r.ExitCode != 0 || r.TimedOut || r.ErrorReported
+}
+
+// addError keeps one line of stderr or a reported error for classify.
+func (r *ProviderResult) addError(line string) {
+	r.Errors = append(r.Errors, line)
+	if len(r.Errors) > transientTailLines {
+		r.Errors = r.Errors[1:]
+	}
+}
+
+// match returns the last of the final lines that matches a transient pattern.
+func (b *transientBackoff) match(lines []string) string {
+	if len(lines) > transientTailLines {
+		lines = lines[len(lines)-transientTailLines:]
+	}
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		for _, re := range b.patterns {
+			if re.MatchString(line) {
+				if len(line) > 200 {
+					line = line[:197] + "..."
+				}
+				return line
+			}
+		}
+	}
+	return ""
+}
+
+// next records a transient error and returns how long to wait before retrying, doubling
+// from backoff up to maxBackoff. ok is false once the waits would exceed maxWait.
+func (b *transientBackoff) next() (delay time.Duration, ok bool) {
+	delay = b.backoff << b.failures
+	if delay > b.maxBackoff || delay <= 0 {
+		delay = b.maxBackoff
+	}
+	if b.waited+delay > b.maxWait {
+		return 0, false
+	}
+	b.failures++
+	b.waited += delay
+	return delay, true
+}
+
+// reset clears the backoff after a provider run that didn't fail transiently.
+func (b *transientBackoff) reset() {
+	b.failures = 0
+	b.waited = 0
+}
+
+// waitTransient backs off after a transient provider error, without waiting past the
+// run's deadline. It returns an error once the errors have outlasted transient.maxWait.
+func waitTransient(b *transientBackoff, budget *runBudget, reason string, logger *RunLogger) error {
+	delay, ok := b.next()
+	if !ok {
+		return fmt.Errorf("provider error: transient errors persisted after waiting %s: %s", FormatDuration(b.waited), reason)
+	}
+	if !budget.deadline.IsZero() {
+		if left := time.Until(budget.deadline); left < delay {
+			delay = max(left, 0)
+		}
+	}
+	logger.LogPrint("Waiting %s before retrying (transient error %d in a row, no retry charged)\n", FormatDuration(delay), b.failures)
+	logger.ProviderBackoff(reason, b.failures, delay)
+	b.sleep(delay)
+	return nil
+}
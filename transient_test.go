@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateTransient(t *testing.T) {
+	if err := validateTransient(nil); err != nil {
+		t.Errorf("nil config should be valid, got %v", err)
+	}
+	if err := validateTransient(&TransientConfig{Patterns: []string{`quota exceeded`}, Backoff: 10}); err != nil {
+		t.Errorf("expected valid, got %v", err)
+	}
+	if err := validateTransient(&TransientConfig{MaxWait: -1}); err == nil {
+		t.Error("expected an error for a negative maxWait")
+	}
+	err := validateTransient(&TransientConfig{Patterns: []string{"ok", "(unclosed"}})
+	if err == nil || !strings.Contains(err.Error(), "transient.patterns[1]") {
+		t.Errorf("expected an invalid regex error for patterns[1], got %v", err)
+	}
+}
+
+func TestTransientBackoff_Classify(t *testing.T) {
+	b := newTransientBackoff(&TransientConfig{Patterns: []string{`(?i)quota exceeded`}})
+
+	tests := []struct {
+		name   string
+		result *ProviderResult
+		err    error
+		want   string
+	}{
+		{"rate limited", &ProviderResult{Errors: []string{"working...", "API Error: 429 Too Many Requests"}, ExitCode: 1}, nil, "API Error: 429 Too Many Requests"},
+		{"overloaded event", &ProviderResult{Errors: []string{`API Error: 529 {"type":"error","error":{"type":"overloaded_error"}}`}, ErrorReported: true}, nil, `API Error: 529 {"type":"error","error":{"type":"overloaded_error"}}`},
+		{"configured pattern", &ProviderResult{Errors: []string{"Error: Quota exceeded for today"}, ExitCode: 1}, nil, "Error: Quota exceeded for today"},
+		{"exited cleanly", &ProviderResult{Errors: []string{"warning: rate limit is close"}}, nil, ""},
+		{"done despite mention", &ProviderResult{Errors: []string{"Error: 429 Too Many Requests"}, ExitCode: 1, Done: true}, nil, ""},
+		{"stuck despite mention", &ProviderResult{Errors: []string{"rate limit tests need redis"}, ExitCode: 1, Stuck: true}, nil, ""},
+		{"question despite mention", &ProviderResult{Errors: []string{"Which rate limit applies?"}, ExitCode: 1, Questions: []string{"Which rate limit applies?"}}, nil, ""},
+		{"ordinary failure", &ProviderResult{Errors: []string{"panic: nil map"}, ExitCode: 1}, nil, ""},
+		{"status code without context", &ProviderResult{Errors: []string{"listening on :5030, retried 429 times"}, ExitCode: 1}, nil, ""},
+		{"agent text is not searched", &ProviderResult{Output: "The API now returns HTTP 429 when rate limited\n", ExitCode: 1}, nil, ""},
+		{"failed to start", nil, errors.New("failed to start provider: exec format error"), ""},
+		{"failed pipe", nil, errors.New("failed to create stdout pipe: too many open files"), ""},
+		{"error matching a pattern", nil, errors.New("mcp bridge: read: connection reset by peer"), "mcp bridge: read: connection reset by peer"},
+		{"timeout", &ProviderResult{Errors: []string{"still going"}, TimedOut: true}, errors.New("provider timed out after 30m0s"), ""},
+		{"timeout while overloaded", &ProviderResult{Errors: []string{"retrying: overloaded"}, TimedOut: true}, errors.New("provider timed out after 30m0s"), "retrying: overloaded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := b.classify(tt.result, tt.err); got != tt.want {
+				t.Errorf("classify() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransientBackoff_MissingProvider(t *testing.T) {
+	cfg := &ResolvedConfig{
+		ProjectRoot: t.TempDir(),
+		Config: RalphConfig{Provider: ProviderConfig{
+			Command:    "ralph-test-no-such-provider",
+			PromptMode: "stdin",
+			Timeout:    30,
+		}},
+	}
+	result, err := runProvider(cfg, "prompt", nil, nil, nil)
+	if err == nil {
+		t.Fatal("expected an error starting a missing provider")
+	}
+	if got := newTransientBackoff(nil).classify(result, err); got != "" {
+		t.Errorf("a missing provider binary should fail the run right away, got transient %q", got)
+	}
+}
+
+func TestTransientBackoff_OnlySearchesTail(t *testing.T) {
+	b := newTransientBackoff(nil)
+	result := &ProviderResult{ExitCode: 1}
+	result.addError("Error: rate_limit_error")
+	for i := 0; i < transientTailLines; i++ {
+		result.addError("retrying")
+	}
+	if got := b.classify(result, nil); got != "" {
+		t.Errorf("an error before the last %d lines should not match, got %q", transientTailLines, got)
+	}
+	result.addError("Error: rate_limit_error")
+	if got := b.classify(result, nil); got != "Error: rate_limit_error" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestTransientBackoff_StreamOutput(t *testing.T) {
+	b := newTransientBackoff(nil)
+	parse := func(adapter ProviderAdapter, exitCode int, lines ...string) *ProviderResult {
+		result := &ProviderResult{ExitCode: exitCode}
+		for _, line := range lines {
+			processOutputLine(adapter, line, result, nil)
+		}
+		return result
+	}
+
+	// Tool calls, their results, and the agent's text mention rate limits as part of the work
+	toolUse := `{"type":"assistant","message":{"content":[{"type":"tool_use","name":"Bash","input":{"command":"curl -i localhost:3000/api # expect 429 rate limit"}}]}}`
+	toolResult := `{"type":"user","message":{"content":[{"type":"tool_result","content":"HTTP/1.1 503 Service Unavailable\nRetry-After: 1"}]}}`
+	text := `{"type":"assistant","message":{"content":[{"type":"text","text":"The endpoint returns HTTP 429 when rate limited."}]}}`
+	if got := b.classify(parse(claudeAdapter{}, 1, toolUse, toolResult, text), nil); got != "" {
+		t.Errorf("tool calls, results and text should not count as transient, got %q", got)
+	}
+
+	apiError := `{"type":"result","subtype":"success","is_error":true,"result":"API Error: 529 {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\"}}"}`
+	if got := b.classify(parse(claudeAdapter{}, 0, toolUse, toolResult, apiError), nil); !strings.HasPrefix(got, "API Error: 529") {
+		t.Errorf("expected the error result to be transient, got %q", got)
+	}
+
+	command := `{"type":"item.completed","item":{"type":"command_execution","command":"grep -rn 'rate limit' src"}}`
+	failed := `{"type":"turn.failed","error":{"message":"stream error: 429 Too Many Requests"}}`
+	if got := b.classify(parse(codexAdapter{}, 1, command), nil); got != "" {
+		t.Errorf("codex commands should not count as transient, got %q", got)
+	}
+	if got := b.classify(parse(codexAdapter{}, 0, command, failed), nil); got != "stream error: 429 Too Many Requests" {
+		t.Errorf("expected codex's error event to be transient, got %q", got)
+	}
+}
+
+func TestRunProvider_TransientStderr(t *testing.T) {
+	b := newTransientBackoff(nil)
+	run := func(script string) *ProviderResult {
+		cfg := &ResolvedConfig{
+			ProjectRoot: t.TempDir(),
+			Config: RalphConfig{Provider: ProviderConfig{
+				Command:    "sh",
+				Args:       []string{"-c", script},
+				PromptMode: "stdin",
+				Timeout:    30,
+			}},
+		}
+		result, err := runProvider(cfg, "prompt", nil, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	if got := b.classify(run("echo 'Handler returns HTTP 429 when rate limited'; exit 1"), nil); got != "" {
+		t.Errorf("a text provider's stdout should not count as transient, got %q", got)
+	}
+	if got := b.classify(run("echo 'Error: 429 Too Many Requests' >&2; exit 0"), nil); got != "" {
+		t.Errorf("a provider that exited cleanly should not count as transient, got %q", got)
+	}
+	if got := b.classify(run("echo 'Error: 429 Too Many Requests' >&2; exit 1"), nil); got != "Error: 429 Too Many Requests" {
+		t.Errorf("expected the failed provider's stderr to be transient, got %q", got)
+	}
+}
+
+func TestTransientBackoff_Next(t *testing.T) {
+	b := newTransientBackoff(&TransientConfig{Backoff: 10, MaxBackoff: 35, MaxWait: 100})
+
+	var delays []time.Duration
+	for {
+		delay, ok := b.next()
+		if !ok {
+			break
+		}
+		delays = append(delays, delay)
+	}
+	// 10 + 20 + 35 + 35 = 100; one more would go past maxWait
+	want := []time.Duration{10 * time.Second, 20 * time.Second, 35 * time.Second, 35 * time.Second}
+	if len(delays) != len(want) {
+		t.Fatalf("delays = %v, want %v", delays, want)
+	}
+	for i := range want {
+		if delays[i] != want[i] {
+			t.Errorf("delays = %v, want %v", delays, want)
+			break
+		}
+	}
+
+	b.reset()
+	if delay, ok := b.next(); !ok || delay != 10*time.Second {
+		t.Errorf("after reset expected the initial backoff, got %v %v", delay, ok)
+	}
+}
+
+func TestTransientBackoff_Defaults(t *testing.T) {
+	b := newTransientBackoff(nil)
+	if b.backoff != 30*time.Second || b.maxBackoff != 10*time.Minute || b.maxWait != time.Hour {
+		t.Errorf("unexpected defaults: %v %v %v", b.backoff, b.maxBackoff, b.maxWait)
+	}
+	if len(b.patterns) != len(defaultTransientPatterns) {
+		t.Errorf("expected the default patterns, got %d", len(b.patterns))
+	}
+	if got := len(newTransientBackoff(&TransientConfig{Patterns: []string{"x"}}).patterns); got != len(defaultTransientPatterns)+1 {
+		t.Errorf("configured patterns should add to the defaults, got %d", got)
+	}
+}
+
+func TestWaitTransient(t *testing.T) {
+	b := newTransientBackoff(&TransientConfig{Backoff: 60, MaxWait: 90})
+	var slept []time.Duration
+	b.sleep = func(d time.Duration) { slept = append(slept, d) }
+	logger, err := NewRunLogger(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	if err := waitTransient(b, &runBudget{}, "429", logger); err != nil {
+		t.Fatal(err)
+	}
+	if len(slept) != 1 || slept[0] != time.Minute {
+		t.Errorf("slept %v, want 1m", slept)
+	}
+
+	events, _ := ReadEvents(logger.LogPath(), nil)
+	if len(events) != 1 || events[0].Type != EventBackoff || events[0].Message != "429" || events[0].Data["failures"] != float64(1) {
+		t.Errorf("expected a provider_backoff event, got %+v", events)
+	}
+
+	// The next wait would be 2m, past maxWait
+	err = waitTransient(b, &runBudget{}, "429 again", logger)
+	if err == nil || !strings.Contains(err.Error(), "429 again") {
+		t.Errorf("expected the run to stop, got %v", err)
+	}
+
+	// Never wait past the run's deadline
+	b.reset()
+	slept = nil
+	if err := waitTransient(b, &runBudget{deadline: time.Now().Add(5 * time.Second)}, "overloaded", logger); err != nil {
+		t.Fatal(err)
+	}
+	if len(slept) != 1 || slept[0] > 5*time.Second {
+		t.Errorf("expected a wait capped at the deadline, got %v", slept)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// verifyCacheMaxEntries bounds verify-cache.json; the oldest passes are dropped first.
+const verifyCacheMaxEntries = 200
+
+// verifyCache remembers verify commands that passed, keyed by the content of the tracked
+// tree and the command string, so verifying an unchanged tree again reuses the passes.
+// Only a clean tree is cached: uncommitted changes aren't part of the key.
+type verifyCache struct {
+	path string
+	git  *GitOps
+	tree string // key of the tree the cache was opened on
+
+	mu      sync.Mutex
+	entries []verifyCacheEntry
+	added   bool
+}
+
+// verifyCacheEntry is one passing verify command on one tree.
+type verifyCacheEntry struct {
+	Tree     string    `json:"tree"`
+	Command  string    `json:"command"`
+	PassedAt time.Time `json:"passedAt"`
+	Duration int64     `json:"durationMs"`
+}
+
+// openVerifyCache loads the feature's verify cache for the current tree. It returns nil,
+// which caches nothing, when caching is off, there is no feature dir, or the tree is
+// dirty.
+func openVerifyCache(cfg *ResolvedConfig, featureDir *FeatureDir) *verifyCache {
+	if featureDir == nil || !cfg.Config.Verify.CacheEnabled() {
+		return nil
+	}
+	git := NewGitOps(cfg.ProjectRoot)
+	tree, ok := cleanTreeKey(git)
+	if !ok {
+		return nil
+	}
+	c := &verifyCache{path: featureDir.VerifyCachePath(), git: git, tree: tree}
+	if data, err := os.ReadFile(c.path); err == nil {
+		var file struct {
+			Entries []verifyCacheEntry `json:"entries"`
+		}
+		if json.Unmarshal(data, &file) == nil {
+			c.entries = file.Entries
+		}
+	}
+	return c
+}
+
+// passed returns when cmd last passed on this tree.
+func (c *verifyCache) passed(cmd string) (verifyCacheEntry, bool) {
+	if c == nil {
+		return verifyCacheEntry{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := len(c.entries) - 1; i >= 0; i-- {
+		if e := c.entries[i]; e.Tree == c.tree && e.Command == cmd {
+			return e, true
+		}
+	}
+	return verifyCacheEntry{}, false
+}
+
+// recordPass remembers that cmd passed on this tree. Safe for concurrent use.
+func (c *verifyCache) recordPass(cmd string, d time.Duration) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = append(c.entries, verifyCacheEntry{Tree: c.tree, Command: cmd, PassedAt: time.Now(), Duration: d.Milliseconds()})
+	c.added = true
+}
+
+// save writes new passes to disk, unless the tree changed while the commands ran, since
+// the passes may then belong to neither tree.
+func (c *verifyCache) save() error {
+	if c == nil || !c.added {
+		return nil
+	}
+	if tree, ok := cleanTreeKey(c.git); !ok || tree != c.tree {
+		return nil
+	}
+	c.mu.Lock()
+	entries := c.entries
+	c.mu.Unlock()
+	if len(entries) > verifyCacheMaxEntries {
+		entries = entries[len(entries)-verifyCacheMaxEntries:]
+	}
+	data, err := json.MarshalIndent(map[string]interface{}{"entries": entries}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return AtomicWriteFile(c.path, append(data, '\n'))
+}
+
+// saveVerifyCache saves the cache, warning rather than failing verification on error.
+func saveVerifyCache(c *verifyCache, logger *RunLogger) {
+	if err := c.save(); err != nil {
+		logger.Warning("failed to save verify cache: " + err.Error())
+	}
+}
+
+// cleanTreeKey hashes the tracked content at HEAD, leaving out ralph's own .ralph
+// directory so state commits don't change the key. ok is false when there are
+// uncommitted or untracked changes outside .ralph.
+func cleanTreeKey(git *GitOps) (string, bool) {
+	prefix, err := git.run("rev-parse", "--show-prefix")
+	if err != nil {
+		return "", false
+	}
+	ralphDir := strings.TrimSpace(prefix) + ".ralph"
+
+	status, err := git.run("status", "--porcelain", "--untracked-files=all")
+	if err != nil {
+		return "", false
+	}
+	for _, line := range strings.Split(status, "\n") {
+		if len(line) > 3 && !strings.HasPrefix(line[3:], ralphDir+"/") {
+			return "", false
+		}
+	}
+
+	// List each directory on the way down to .ralph, minus the next step, so the key
+	// covers every tracked file in the repo except those under .ralph
+	h := sha256.New()
+	dir := ""
+	for _, name := range strings.Split(ralphDir, "/") {
+		args := []string{"ls-tree", "--full-tree", "HEAD"}
+		if dir != "" {
+			args = append(args, dir+"/")
+		}
+		out, err := git.run(args...)
+		if err != nil {
+			return "", false
+		}
+		next := path.Join(dir, name)
+		for _, line := range strings.Split(out, "\n") {
+			if _, entry, found := strings.Cut(line, "\t"); found && entry != next {
+				h.Write([]byte(line + "\n"))
+			}
+		}
+		dir = next
+	}
+	return hex.EncodeToString(h.Sum(nil)), true
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCleanTreeKey(t *testing.T) {
+	dir, git := initTestRepo(t)
+	key, ok := cleanTreeKey(git)
+	if !ok || key == "" {
+		t.Fatalf("expected a key for a clean tree, got %q %v", key, ok)
+	}
+
+	// Ralph's own state commits and files don't change the key
+	commitFiles(t, dir, git, map[string]string{".ralph/2024-01-15-auth/run-state.json": `{"passed":[]}`})
+	os.WriteFile(filepath.Join(dir, ".ralph", "2024-01-15-auth", "verify-cache.json"), []byte("{}"), 0644)
+	if got, ok := cleanTreeKey(git); !ok || got != key {
+		t.Errorf("a .ralph change should keep the key, got %q %v", got, ok)
+	}
+
+	os.WriteFile(filepath.Join(dir, "README.md"), []byte("# edited"), 0644)
+	if _, ok := cleanTreeKey(git); ok {
+		t.Error("an uncommitted change should make the tree dirty")
+	}
+	commitFiles(t, dir, git, nil)
+	got, ok := cleanTreeKey(git)
+	if !ok || got == key {
+		t.Errorf("a committed change should change the key, got %q %v", got, ok)
+	}
+
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("scratch"), 0644)
+	if _, ok := cleanTreeKey(git); ok {
+		t.Error("an untracked file should make the tree dirty")
+	}
+}
+
+func TestCleanTreeKey_ProjectInSubdirectory(t *testing.T) {
+	dir, git := initTestRepo(t)
+	commitFiles(t, dir, git, map[string]string{"app/main.go": "package main\n", "lib/lib.go": "package lib\n"})
+	sub := NewGitOps(filepath.Join(dir, "app"))
+	key, _ := cleanTreeKey(sub)
+
+	commitFiles(t, dir, git, map[string]string{"app/.ralph/auth/run-state.json": "{}"})
+	if got, _ := cleanTreeKey(sub); got != key {
+		t.Error("the project's .ralph should be left out of the key")
+	}
+	commitFiles(t, dir, git, map[string]string{"lib/lib.go": "package lib\n\nvar X = 1\n"})
+	if got, _ := cleanTreeKey(sub); got == key {
+		t.Error("changes outside the project directory should change the key")
+	}
+}
+
+func TestVerifyCache(t *testing.T) {
+	dir, git := initTestRepo(t)
+	cfg := &ResolvedConfig{ProjectRoot: dir}
+	featureDir := &FeatureDir{Feature: "auth", Path: filepath.Join(dir, ".ralph", "2024-01-15-auth")}
+	os.MkdirAll(featureDir.Path, 0755)
+
+	c := openVerifyCache(cfg, featureDir)
+	if _, ok := c.passed("go test ./..."); ok {
+		t.Fatal("an empty cache should have no passes")
+	}
+	c.recordPass("go test ./...", 2*time.Second)
+	if err := c.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	c = openVerifyCache(cfg, featureDir)
+	if e, ok := c.passed("go test ./..."); !ok || e.Duration != 2000 {
+		t.Errorf("expected the saved pass, got %+v %v", e, ok)
+	}
+	if _, ok := c.passed("go vet ./..."); ok {
+		t.Error("other commands should not be cached")
+	}
+
+	// A pass recorded while the tree changed is not saved
+	c.recordPass("go vet ./...", time.Second)
+	commitFiles(t, dir, git, map[string]string{"main.go": "package main\n"})
+	c.save()
+	if data, _ := os.ReadFile(featureDir.VerifyCachePath()); strings.Contains(string(data), "go vet") {
+		t.Error("a pass recorded across a tree change should not be saved")
+	}
+	if _, ok := openVerifyCache(cfg, featureDir).passed("go test ./..."); ok {
+		t.Error("passes from another tree should not be reused")
+	}
+
+	os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main // wip\n"), 0644)
+	if openVerifyCache(cfg, featureDir) != nil {
+		t.Error("a dirty tree should not be cached")
+	}
+
+	off := false
+	cfg.Config.Verify.Cache = &off
+	os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0644)
+	if openVerifyCache(cfg, featureDir) != nil {
+		t.Error("verify.cache false should disable the cache")
+	}
+}
+
+func TestRunStoryVerification_Cached(t *testing.T) {
+	dir, _ := initTestRepo(t)
+	featureDir := &FeatureDir{Feature: "auth", Path: filepath.Join(dir, ".ralph", "2024-01-15-auth")}
+	os.MkdirAll(featureDir.Path, 0755)
+	counter := filepath.Join(t.TempDir(), "runs")
+
+	logger, err := NewRunLogger(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	cfg := &ResolvedConfig{ProjectRoot: dir, Config: RalphConfig{Verify: VerifyConfig{
+		Default: []string{"echo pass >> " + counter, "echo fail >> " + counter + "; exit 1"},
+		Timeout: 30,
+	}}}
+	story := &StoryDefinition{ID: "US-001"}
+
+	for i := 0; i < 2; i++ {
+		result, err := runStoryVerification(cfg, featureDir, story, nil, nil, "", logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.passed {
+			t.Fatal("the failing command should fail every time")
+		}
+		if i == 1 && !result.commands[0].cached {
+			t.Errorf("expected the pass to come from the cache, got %+v", result.commands[0])
+		}
+	}
+
+	data, _ := os.ReadFile(counter)
+	if got := strings.Fields(string(data)); strings.Join(got, " ") != "pass fail fail" {
+		t.Errorf("expected the passing command to run once and the failing one twice, got %v", got)
+	}
+}